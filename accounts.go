@@ -24,7 +24,11 @@ type AccountsClient interface {
 
 	PostTransaction(requestID, userID string, lines []transactionLine) (*accounts.Transaction, error)
 	SearchAccounts(requestID, userID string, dep *Depository) (*accounts.Account, error)
-	ReverseTransaction(requestID, userID string, transactionID string) error
+	// ReverseTransaction posts a transaction undoing transactionID and returns it.
+	ReverseTransaction(requestID, userID string, transactionID string) (*accounts.Transaction, error)
+
+	// GetAccountTransactions returns the most recent transactions (up to limit) posted against accountID.
+	GetAccountTransactions(requestID, userID string, accountID string, limit int) ([]accounts.Transaction, error)
 }
 
 type moovAccountsClient struct {
@@ -110,7 +114,7 @@ func (c *moovAccountsClient) SearchAccounts(requestID, userID string, dep *Depos
 	return &accounts[0], nil
 }
 
-func (c *moovAccountsClient) ReverseTransaction(requestID, userID string, transactionID string) error {
+func (c *moovAccountsClient) ReverseTransaction(requestID, userID string, transactionID string) (*accounts.Transaction, error) {
 	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
 	defer cancelFn()
 
@@ -119,14 +123,32 @@ func (c *moovAccountsClient) ReverseTransaction(requestID, userID string, transa
 	opts := &accounts.ReverseTransactionOpts{
 		XRequestID: optional.NewString(requestID),
 	}
-	_, resp, err := c.underlying.AccountsApi.ReverseTransaction(ctx, transactionID, userID, opts)
+	reversal, resp, err := c.underlying.AccountsApi.ReverseTransaction(ctx, transactionID, userID, opts)
+	if resp != nil && resp.Body != nil {
+		resp.Body.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("accounts: ReverseTransaction: transaction=%s: %v", transactionID, err)
+	}
+	return &reversal, nil
+}
+
+func (c *moovAccountsClient) GetAccountTransactions(requestID, userID string, accountID string, limit int) ([]accounts.Transaction, error) {
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	opts := &accounts.GetAccountTransactionsOpts{
+		Limit:      optional.NewFloat32(float32(limit)),
+		XRequestID: optional.NewString(requestID),
+	}
+	transactions, resp, err := c.underlying.AccountsApi.GetAccountTransactions(ctx, accountID, userID, opts)
 	if resp != nil && resp.Body != nil {
 		resp.Body.Close()
 	}
 	if err != nil {
-		return fmt.Errorf("accounts: ReverseTransaction: transaction=%s: %v", transactionID, err)
+		return nil, fmt.Errorf("accounts: GetAccountTransactions: account=%s: %v", accountID, err)
 	}
-	return nil
+	return transactions, nil
 }
 
 // CreateAccountsClient returns an AccountsClient used to make HTTP calls over to a Account instance.
@@ -0,0 +1,394 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/base"
+	"github.com/moov-io/base/admin"
+	moovhttp "github.com/moov-io/base/http"
+	"github.com/moov-io/paygate/internal/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+const (
+	ledgerCredit = "ACHCredit"
+	ledgerDebit  = "ACHDebit"
+)
+
+var (
+	errLedgerUnbalanced = errors.New("ledger: transaction debits and credits don't balance")
+
+	// errLedgerReversed is returned when reversing a transaction which has already been reversed (or is a reversal).
+	errLedgerReversed = errors.New("ledger: transaction is already reversed")
+)
+
+// SQLLedger is an AccountsClient which keeps a double-entry ledger in paygate's database. It's used when calls to the
+// Accounts service are disabled so Transfers and micro-deposits are still posted (and reversed) against balances.
+//
+// Each Depository gets an account the first time it's searched for. Accounts without a Depository (like the ODFI's)
+// are shared across users.
+type SQLLedger struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func NewLedger(logger log.Logger, db *sql.DB) *SQLLedger {
+	return &SQLLedger{db: db, logger: logger}
+}
+
+func (l *SQLLedger) Ping() error {
+	if l == nil || l.db == nil {
+		return errors.New("ledger: nil database")
+	}
+	return l.db.Ping()
+}
+
+// ledgerAccountKey identifies the account for dep. Depositories are found by their ID so account numbers aren't
+// stored in the ledger, other accounts (like the ODFI's) by a hash of their routing number, account number and type.
+func ledgerAccountKey(dep *Depository) string {
+	if dep.ID != "" {
+		return string(dep.ID)
+	}
+	ss := sha256.Sum256([]byte(fmt.Sprintf("%s/%s/%s", dep.RoutingNumber, dep.AccountNumber, dep.Type)))
+	return hex.EncodeToString(ss[:])
+}
+
+// SearchAccounts returns the account for dep and creates it when it doesn't exist yet.
+func (l *SQLLedger) SearchAccounts(requestID, userID string, dep *Depository) (*accounts.Account, error) {
+	if dep == nil {
+		return nil, errors.New("ledger: nil Depository")
+	}
+	owner, key := userID, ledgerAccountKey(dep)
+	if dep.ID == "" {
+		owner = "" // shared
+	}
+	acct, err := l.findAccount(owner, key)
+	if err != nil || acct != nil {
+		return acct, err
+	}
+
+	now := time.Now()
+	query := `insert into ledger_accounts (account_id, user_id, depository_id, account_key, routing_number, account_number_masked, type, created_at) values (?, ?, ?, ?, ?, ?, ?, ?)`
+	stmt, err := l.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	accountID := base.ID()
+	if _, err := stmt.Exec(accountID, owner, dep.ID, key, dep.RoutingNumber, maskAccountNumber(dep.AccountNumber), dep.Type, now); err != nil {
+		if database.UniqueViolation(err) {
+			return l.findAccount(owner, key) // created concurrently
+		}
+		return nil, fmt.Errorf("ledger: SearchAccounts: depository=%s: %v", dep.ID, err)
+	}
+	l.logger.Log("ledger", fmt.Sprintf("created account=%s for depository=%s", accountID, dep.ID), "requestID", requestID, "userID", userID)
+	return l.getAccount(accountID)
+}
+
+const ledgerAccountColumns = `a.account_id, a.user_id, a.routing_number, a.account_number_masked, a.type, a.created_at,
+coalesce((select sum(case when l.purpose = 'ACHCredit' then l.amount else -l.amount end) from ledger_transaction_lines l where l.account_id = a.account_id), 0)`
+
+func (l *SQLLedger) scanAccount(query string, args ...interface{}) (*accounts.Account, error) {
+	stmt, err := l.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var acct accounts.Account
+	var balance int64
+	err = stmt.QueryRow(args...).Scan(&acct.ID, &acct.CustomerID, &acct.RoutingNumber, &acct.AccountNumberMasked, &acct.Type, &acct.CreatedAt, &balance)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	acct.Status = "open"
+	acct.LastModified = acct.CreatedAt
+	acct.Balance = int32(balance)
+	acct.BalanceAvailable = acct.Balance
+	return &acct, nil
+}
+
+func (l *SQLLedger) findAccount(userID, key string) (*accounts.Account, error) {
+	query := fmt.Sprintf(`select %s from ledger_accounts a where a.user_id = ? and a.account_key = ? limit 1`, ledgerAccountColumns)
+	return l.scanAccount(query, userID, key)
+}
+
+// getAccount returns the account with its current balance, or nil if it doesn't exist.
+func (l *SQLLedger) getAccount(accountID string) (*accounts.Account, error) {
+	query := fmt.Sprintf(`select %s from ledger_accounts a where a.account_id = ? limit 1`, ledgerAccountColumns)
+	return l.scanAccount(query, accountID)
+}
+
+func validateLedgerLines(lines []transactionLine) error {
+	if len(lines) == 0 {
+		return errors.New("ledger: no transactionLine's")
+	}
+	var debits, credits int64
+	for i := range lines {
+		if lines[i].Amount <= 0 {
+			return fmt.Errorf("ledger: invalid amount %d for account=%s", lines[i].Amount, lines[i].AccountID)
+		}
+		switch lines[i].Purpose {
+		case ledgerCredit:
+			credits += int64(lines[i].Amount)
+		case ledgerDebit:
+			debits += int64(lines[i].Amount)
+		default:
+			return fmt.Errorf("ledger: unknown purpose %q", lines[i].Purpose)
+		}
+	}
+	if debits != credits {
+		return errLedgerUnbalanced
+	}
+	return nil
+}
+
+// PostTransaction writes a balanced transaction. Every account needs to belong to userID or be shared.
+func (l *SQLLedger) PostTransaction(requestID, userID string, lines []transactionLine) (*accounts.Transaction, error) {
+	if err := validateLedgerLines(lines); err != nil {
+		return nil, err
+	}
+	for i := range lines {
+		acct, err := l.getAccount(lines[i].AccountID)
+		if err != nil {
+			return nil, fmt.Errorf("ledger: PostTransaction: %v", err)
+		}
+		if acct == nil || (acct.CustomerID != "" && acct.CustomerID != userID) {
+			return nil, fmt.Errorf("ledger: account=%s not found", lines[i].AccountID)
+		}
+	}
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	transaction, err := insertLedgerTransaction(tx, userID, "", lines)
+	if err != nil {
+		return nil, fmt.Errorf("ledger: PostTransaction: error=%v rollback=%v", err, tx.Rollback())
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	l.logger.Log("ledger", fmt.Sprintf("posted transaction=%s", transaction.ID), "requestID", requestID, "userID", userID)
+	return transaction, nil
+}
+
+func insertLedgerTransaction(tx *sql.Tx, userID, reversalOf string, lines []transactionLine) (*accounts.Transaction, error) {
+	transaction := &accounts.Transaction{
+		ID:        base.ID(),
+		Timestamp: time.Now(),
+	}
+	query := `insert into ledger_transactions (transaction_id, user_id, reversal_of, created_at) values (?, ?, ?, ?)`
+	if _, err := tx.Exec(query, transaction.ID, userID, reversalOf, transaction.Timestamp); err != nil {
+		return nil, err
+	}
+
+	query = `insert into ledger_transaction_lines (transaction_id, account_id, purpose, amount, created_at) values (?, ?, ?, ?, ?)`
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	for i := range lines {
+		if _, err := stmt.Exec(transaction.ID, lines[i].AccountID, lines[i].Purpose, lines[i].Amount, transaction.Timestamp); err != nil {
+			return nil, err
+		}
+		transaction.Lines = append(transaction.Lines, accounts.TransactionLine{
+			AccountID: lines[i].AccountID,
+			Purpose:   lines[i].Purpose,
+			Amount:    float32(lines[i].Amount),
+		})
+	}
+	return transaction, nil
+}
+
+// ReverseTransaction posts a transaction with each line's purpose swapped. Transactions are only reversed once.
+func (l *SQLLedger) ReverseTransaction(requestID, userID string, transactionID string) (*accounts.Transaction, error) {
+	lines, err := l.getTransactionLines(transactionID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("ledger: ReverseTransaction: transaction=%s: %v", transactionID, err)
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("ledger: transaction=%s not found", transactionID)
+	}
+	for i := range lines {
+		if lines[i].Purpose == ledgerCredit {
+			lines[i].Purpose = ledgerDebit
+		} else {
+			lines[i].Purpose = ledgerCredit
+		}
+	}
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	reversal, err := insertLedgerTransaction(tx, userID, transactionID, lines)
+	if err != nil {
+		return nil, fmt.Errorf("ledger: ReverseTransaction: error=%v rollback=%v", err, tx.Rollback())
+	}
+
+	// Claim the original transaction, which fails if it's a reversal or was already reversed.
+	query := `update ledger_transactions set reversed_by = ? where transaction_id = ? and user_id = ? and reversed_by is null and reversal_of = ''`
+	res, err := tx.Exec(query, reversal.ID, transactionID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("ledger: ReverseTransaction: error=%v rollback=%v", err, tx.Rollback())
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		tx.Rollback()
+		return nil, errLedgerReversed
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	l.logger.Log("ledger", fmt.Sprintf("reversed transaction=%s with transaction=%s", transactionID, reversal.ID), "requestID", requestID, "userID", userID)
+	return reversal, nil
+}
+
+func (l *SQLLedger) getTransactionLines(transactionID, userID string) ([]transactionLine, error) {
+	query := `select l.account_id, l.purpose, l.amount from ledger_transaction_lines l
+inner join ledger_transactions t on t.transaction_id = l.transaction_id
+where t.transaction_id = ? and t.user_id = ?`
+	rows, err := l.db.Query(query, transactionID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []transactionLine
+	for rows.Next() {
+		var line transactionLine
+		if err := rows.Scan(&line.AccountID, &line.Purpose, &line.Amount); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+func (l *SQLLedger) GetAccountTransactions(requestID, userID string, accountID string, limit int) ([]accounts.Transaction, error) {
+	transactions, err := l.getAccountTransactions(accountID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ledger: GetAccountTransactions: account=%s: %v", accountID, err)
+	}
+	out := make([]accounts.Transaction, len(transactions))
+	for i := range transactions {
+		out[i] = *transactions[i]
+	}
+	return out, nil
+}
+
+// getAccountTransactions returns the transactions with lines against accountID, newest first.
+func (l *SQLLedger) getAccountTransactions(accountID string, limit int) ([]*accounts.Transaction, error) {
+	query := `select t.transaction_id, t.created_at from ledger_transactions t
+where t.transaction_id in (select l.transaction_id from ledger_transaction_lines l where l.account_id = ?)
+order by t.created_at desc limit ?`
+	rows, err := l.db.Query(query, accountID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transactions []*accounts.Transaction
+	for rows.Next() {
+		var transaction accounts.Transaction
+		if err := rows.Scan(&transaction.ID, &transaction.Timestamp); err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, &transaction)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range transactions {
+		lines, err := l.db.Query(`select account_id, purpose, amount from ledger_transaction_lines where transaction_id = ?`, transactions[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		for lines.Next() {
+			var line accounts.TransactionLine
+			if err := lines.Scan(&line.AccountID, &line.Purpose, &line.Amount); err != nil {
+				lines.Close()
+				return nil, err
+			}
+			transactions[i].Lines = append(transactions[i].Lines, line)
+		}
+		lines.Close()
+	}
+	return transactions, nil
+}
+
+// AddLedgerAdminRoutes registers the admin HTTP routes for reading ledger balances and transactions.
+func AddLedgerAdminRoutes(logger log.Logger, svc *admin.Server, ledger *SQLLedger) {
+	svc.AddHandler("/ledger/accounts/{accountId}", getLedgerAccount(logger, ledger))
+	svc.AddHandler("/ledger/accounts/{accountId}/transactions", getLedgerAccountTransactions(logger, ledger))
+}
+
+func getLedgerAccountID(r *http.Request) string {
+	return mux.Vars(r)["accountId"]
+}
+
+func getLedgerAccount(logger log.Logger, ledger *SQLLedger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w = wrap(logger, w, r)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		if r.Method != "GET" {
+			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb: %s", r.Method))
+			return
+		}
+
+		acct, err := ledger.getAccount(getLedgerAccountID(r))
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if acct == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "account not found"}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(acct)
+	}
+}
+
+func getLedgerAccountTransactions(logger log.Logger, ledger *SQLLedger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w = wrap(logger, w, r)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		if r.Method != "GET" {
+			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb: %s", r.Method))
+			return
+		}
+
+		transactions, err := ledger.getAccountTransactions(getLedgerAccountID(r), 100)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(transactions)
+	}
+}
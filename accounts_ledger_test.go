@@ -0,0 +1,215 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/base"
+	"github.com/moov-io/paygate/internal/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestLedger__validateLedgerLines(t *testing.T) {
+	if err := validateLedgerLines(nil); err == nil {
+		t.Error("expected error")
+	}
+	lines := []transactionLine{
+		{AccountID: "a", Purpose: ledgerDebit, Amount: 100},
+		{AccountID: "b", Purpose: ledgerCredit, Amount: 100},
+	}
+	if err := validateLedgerLines(lines); err != nil {
+		t.Error(err)
+	}
+	lines[1].Amount = 99
+	if err := validateLedgerLines(lines); err != errLedgerUnbalanced {
+		t.Errorf("unexpected error: %v", err)
+	}
+	lines[1].Amount = -100
+	if err := validateLedgerLines(lines); err == nil {
+		t.Error("expected error")
+	}
+	lines[1].Amount, lines[1].Purpose = 100, "other"
+	if err := validateLedgerLines(lines); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestLedger(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, ledger *SQLLedger) {
+		if err := ledger.Ping(); err != nil {
+			t.Fatal(err)
+		}
+
+		userID := base.ID()
+		dep := &Depository{ID: DepositoryID(base.ID()), RoutingNumber: "121042882", AccountNumber: "151", Type: Checking}
+		acct, err := ledger.SearchAccounts("", userID, dep)
+		if err != nil || acct == nil {
+			t.Fatalf("account=%v error=%v", acct, err)
+		}
+		if acct.AccountNumberMasked == dep.AccountNumber || acct.Balance != 0 {
+			t.Errorf("unexpected account: %#v", acct)
+		}
+		if again, err := ledger.SearchAccounts("", userID, dep); err != nil || again.ID != acct.ID {
+			t.Errorf("account=%v error=%v", again, err)
+		}
+
+		// ODFI accounts are shared
+		odfi := &Depository{RoutingNumber: "121042882", AccountNumber: "123", Type: Savings}
+		odfiAcct, err := ledger.SearchAccounts("", base.ID(), odfi)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again, err := ledger.SearchAccounts("", userID, odfi); err != nil || again.ID != odfiAcct.ID {
+			t.Errorf("account=%v error=%v", again, err)
+		}
+
+		// post a transaction
+		tx, err := ledger.PostTransaction("", userID, []transactionLine{
+			{AccountID: acct.ID, Purpose: ledgerCredit, Amount: 1250},
+			{AccountID: odfiAcct.ID, Purpose: ledgerDebit, Amount: 1250},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tx.ID == "" || len(tx.Lines) != 2 {
+			t.Errorf("unexpected transaction: %#v", tx)
+		}
+		balance := func(accountID string) int32 {
+			t.Helper()
+			acct, err := ledger.getAccount(accountID)
+			if err != nil || acct == nil {
+				t.Fatalf("account=%v error=%v", acct, err)
+			}
+			return acct.Balance
+		}
+		if b := balance(acct.ID); b != 1250 {
+			t.Errorf("balance=%d", b)
+		}
+		if b := balance(odfiAcct.ID); b != -1250 {
+			t.Errorf("balance=%d", b)
+		}
+
+		// unbalanced transactions and other users' accounts are rejected
+		if _, err := ledger.PostTransaction("", userID, []transactionLine{{AccountID: acct.ID, Purpose: ledgerCredit, Amount: 1}}); err != errLedgerUnbalanced {
+			t.Errorf("unexpected error: %v", err)
+		}
+		_, err = ledger.PostTransaction("", base.ID(), []transactionLine{
+			{AccountID: acct.ID, Purpose: ledgerCredit, Amount: 1},
+			{AccountID: odfiAcct.ID, Purpose: ledgerDebit, Amount: 1},
+		})
+		if err == nil {
+			t.Error("expected error")
+		}
+
+		// reverse the transaction, only once
+		if _, err := ledger.ReverseTransaction("", base.ID(), tx.ID); err == nil {
+			t.Error("expected error")
+		}
+		if _, err := ledger.ReverseTransaction("", userID, tx.ID); err != nil {
+			t.Fatal(err)
+		}
+		if b := balance(acct.ID); b != 0 {
+			t.Errorf("balance=%d", b)
+		}
+		if b := balance(odfiAcct.ID); b != 0 {
+			t.Errorf("balance=%d", b)
+		}
+		if _, err := ledger.ReverseTransaction("", userID, tx.ID); err != errLedgerReversed {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		transactions, err := ledger.getAccountTransactions(acct.ID, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(transactions) != 2 || len(transactions[0].Lines) != 2 {
+			t.Errorf("unexpected transactions: %#v", transactions)
+		}
+		for i := range transactions {
+			if transactions[i].ID == tx.ID {
+				continue
+			}
+			// reversals can't be reversed
+			if _, err := ledger.ReverseTransaction("", userID, transactions[i].ID); err != errLedgerReversed {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+	}
+
+	// SQLite tests
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, NewLedger(log.NewNopLogger(), sqliteDB.DB))
+
+	// MySQL tests
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, NewLedger(log.NewNopLogger(), mysqlDB.DB))
+}
+
+func TestLedger__adminRoutes(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	ledger := NewLedger(log.NewNopLogger(), db.DB)
+	userID := base.ID()
+	acct, _ := ledger.SearchAccounts("", userID, &Depository{ID: DepositoryID(base.ID())})
+	other, _ := ledger.SearchAccounts("", userID, &Depository{ID: DepositoryID(base.ID())})
+	_, err := ledger.PostTransaction("", userID, []transactionLine{
+		{AccountID: acct.ID, Purpose: ledgerCredit, Amount: 500},
+		{AccountID: other.ID, Purpose: ledgerDebit, Amount: 500},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/ledger/accounts/{accountId}", getLedgerAccount(log.NewNopLogger(), ledger))
+	router.HandleFunc("/ledger/accounts/{accountId}/transactions", getLedgerAccountTransactions(log.NewNopLogger(), ledger))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/ledger/accounts/"+acct.ID, nil)
+	router.ServeHTTP(w, req)
+	w.Flush()
+
+	var account accounts.Account
+	if err := json.NewDecoder(w.Body).Decode(&account); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || account.Balance != 500 {
+		t.Errorf("got %d: %#v", w.Code, account)
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/ledger/accounts/"+acct.ID+"/transactions", nil)
+	router.ServeHTTP(w, req)
+	w.Flush()
+
+	var transactions []accounts.Transaction
+	if err := json.NewDecoder(w.Body).Decode(&transactions); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(transactions) != 1 {
+		t.Errorf("got %d: %#v", w.Code, transactions)
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/ledger/accounts/missing", nil)
+	router.ServeHTTP(w, req)
+	w.Flush()
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got %d", w.Code)
+	}
+}
@@ -22,8 +22,14 @@ type testAccountsClient struct {
 	accounts    []accounts.Account
 	transaction *accounts.Transaction
 
+	// transactions are returned from GetAccountTransactions
+	transactions []accounts.Transaction
+
 	postedTransactions []accountsTransaction
 
+	// reversedTransactions are the transaction IDs passed to ReverseTransaction
+	reversedTransactions []string
+
 	err error
 }
 
@@ -58,8 +64,19 @@ func (c *testAccountsClient) SearchAccounts(_, _ string, _ *Depository) (*accoun
 	return nil, nil
 }
 
-func (c *testAccountsClient) ReverseTransaction(requestID, userID string, transactionID string) error {
-	return c.err
+func (c *testAccountsClient) ReverseTransaction(requestID, userID string, transactionID string) (*accounts.Transaction, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	c.reversedTransactions = append(c.reversedTransactions, transactionID)
+	return &accounts.Transaction{ID: "reversal-" + transactionID}, nil
+}
+
+func (c *testAccountsClient) GetAccountTransactions(requestID, userID string, accountID string, limit int) ([]accounts.Transaction, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.transactions, nil
 }
 
 type accountsDeployment struct {
@@ -229,7 +246,7 @@ func TestAccounts__ReverseTransaction(t *testing.T) {
 	}
 
 	// Reverse the posted Transaction
-	if err := client.ReverseTransaction("", userID, tx.ID); err != nil {
+	if _, err := client.ReverseTransaction("", userID, tx.ID); err != nil {
 		t.Fatal(err)
 	}
 
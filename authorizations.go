@@ -0,0 +1,575 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/moov-io/ach"
+	"github.com/moov-io/base"
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+type AuthorizationID string
+
+// Authorization is the proof a Receiver agreed to have their Depository debited (a mandate). NACHA requires
+// Originators keep authorizations for consumer debits and produce them within 10 banking days when asked.
+//
+// Authorizations are never deleted, only revoked, so they can be produced after the fact.
+type Authorization struct {
+	// ID is a unique string representing this Authorization.
+	ID AuthorizationID `json:"id"`
+
+	// Receiver is the Receiver who authorized debits
+	Receiver ReceiverID `json:"receiver"`
+
+	// Depository is the Receiver's account which can be debited
+	Depository DepositoryID `json:"depository"`
+
+	// Type is how the Receiver gave their authorization
+	Type AuthorizationType `json:"type"`
+
+	// Authorized is when the Receiver gave their authorization
+	Authorized base.Time `json:"authorized"`
+
+	// IPAddress is the Receiver's IP address when authorizing online
+	IPAddress string `json:"ipAddress,omitempty"`
+
+	// UserAgent is the Receiver's browser when authorizing online
+	UserAgent string `json:"userAgent,omitempty"`
+
+	// Terms is the authorization language agreed to (or the telephone script read) by the Receiver
+	Terms string `json:"terms"`
+
+	// MaxAmount is the largest debit allowed under this authorization, no limit is enforced when empty
+	MaxAmount *Amount `json:"maxAmount,omitempty"`
+
+	// Status is either active or revoked. Only active authorizations allow debits.
+	Status AuthorizationStatus `json:"status"`
+
+	// Revoked is when the authorization was revoked
+	Revoked *base.Time `json:"revoked,omitempty"`
+
+	// RevocationReason is why the authorization was revoked, i.e. a return code
+	RevocationReason string `json:"revocationReason,omitempty"`
+
+	// Created a timestamp representing the initial creation date of the object in ISO 8601
+	Created base.Time `json:"created"`
+
+	// Updated is a timestamp when the object was last modified in ISO8601 format
+	Updated base.Time `json:"updated"`
+}
+
+func (a *Authorization) validate() error {
+	if a == nil {
+		return errors.New("nil Authorization")
+	}
+	if a.Receiver == "" {
+		return errors.New("missing Authorization.Receiver")
+	}
+	if a.Depository.empty() {
+		return errors.New("missing Authorization.Depository")
+	}
+	if err := a.Type.validate(); err != nil {
+		return err
+	}
+	if strings.TrimSpace(a.Terms) == "" {
+		return errors.New("missing Authorization.Terms")
+	}
+	if a.Authorized.After(time.Now()) {
+		return errors.New("Authorization.Authorized is in the future")
+	}
+	if a.IPAddress != "" && net.ParseIP(a.IPAddress) == nil {
+		return fmt.Errorf("invalid Authorization.IPAddress %q", a.IPAddress)
+	}
+	if a.Type == WebAuthorization && (a.IPAddress == "" || a.UserAgent == "") {
+		return errors.New("web authorizations require IPAddress and UserAgent")
+	}
+	if a.MaxAmount != nil {
+		if err := a.MaxAmount.Validate(); err != nil {
+			return fmt.Errorf("Authorization.MaxAmount: %v", err)
+		}
+	}
+	return nil
+}
+
+// allows returns an error if the Authorization can't be used for the given SEC code and amount.
+func (a *Authorization) allows(sec string, amount Amount) error {
+	if a.Status != AuthorizationActive {
+		return fmt.Errorf("authorization=%s is %s", a.ID, a.Status)
+	}
+	if expected := authorizationTypeForSEC(sec); expected != "" && a.Type != expected {
+		return fmt.Errorf("authorization=%s is %s, but %s debits require a %s authorization", a.ID, a.Type, sec, expected)
+	}
+	if a.MaxAmount != nil && amount.Int() > a.MaxAmount.Int() {
+		return fmt.Errorf("amount %s is over authorization=%s limit of %s", amount.String(), a.ID, a.MaxAmount.String())
+	}
+	return nil
+}
+
+type AuthorizationType string
+
+const (
+	// WrittenAuthorization is a signed (or similarly authenticated) authorization, used for PPD debits.
+	WrittenAuthorization AuthorizationType = "written"
+
+	// WebAuthorization is given over the internet, used for WEB debits.
+	WebAuthorization AuthorizationType = "web"
+
+	// TelephoneAuthorization is an oral authorization which was recorded, used for TEL debits.
+	TelephoneAuthorization AuthorizationType = "telephone"
+)
+
+func (at AuthorizationType) validate() error {
+	switch at {
+	case WrittenAuthorization, WebAuthorization, TelephoneAuthorization:
+		return nil
+	default:
+		return fmt.Errorf("AuthorizationType(%s) is invalid", at)
+	}
+}
+
+func (at *AuthorizationType) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	*at = AuthorizationType(strings.ToLower(s))
+	return at.validate()
+}
+
+// authorizationTypeForSEC returns the AuthorizationType required to debit a consumer with the given SEC code.
+// An empty value is returned for SEC codes which don't require an Authorization in paygate.
+func authorizationTypeForSEC(sec string) AuthorizationType {
+	switch strings.ToUpper(sec) {
+	case ach.PPD:
+		return WrittenAuthorization
+	case ach.WEB:
+		return WebAuthorization
+	case ach.TEL:
+		return TelephoneAuthorization
+	}
+	return ""
+}
+
+type AuthorizationStatus string
+
+const (
+	AuthorizationActive  AuthorizationStatus = "active"
+	AuthorizationRevoked AuthorizationStatus = "revoked"
+)
+
+type authorizationRequest struct {
+	Receiver   ReceiverID        `json:"receiver"`
+	Depository DepositoryID      `json:"depository"`
+	Type       AuthorizationType `json:"type"`
+	Authorized *time.Time        `json:"authorized,omitempty"`
+	IPAddress  string            `json:"ipAddress,omitempty"`
+	UserAgent  string            `json:"userAgent,omitempty"`
+	Terms      string            `json:"terms"`
+	MaxAmount  *Amount           `json:"maxAmount,omitempty"`
+}
+
+func (r authorizationRequest) missingFields() error {
+	if r.Receiver == "" {
+		return errors.New("missing authorizationRequest.Receiver")
+	}
+	if r.Depository.empty() {
+		return errors.New("missing authorizationRequest.Depository")
+	}
+	if r.Type == "" {
+		return errors.New("missing authorizationRequest.Type")
+	}
+	if r.Terms == "" {
+		return errors.New("missing authorizationRequest.Terms")
+	}
+	return nil
+}
+
+func (r authorizationRequest) asAuthorization() *Authorization {
+	now := time.Now()
+	auth := &Authorization{
+		ID:         AuthorizationID(base.ID()),
+		Receiver:   r.Receiver,
+		Depository: r.Depository,
+		Type:       r.Type,
+		Authorized: base.NewTime(now),
+		IPAddress:  r.IPAddress,
+		UserAgent:  r.UserAgent,
+		Terms:      r.Terms,
+		MaxAmount:  r.MaxAmount,
+		Status:     AuthorizationActive,
+		Created:    base.NewTime(now),
+		Updated:    base.NewTime(now),
+	}
+	if r.Authorized != nil && !r.Authorized.IsZero() {
+		auth.Authorized = base.NewTime(*r.Authorized)
+	}
+	return auth
+}
+
+func AddAuthorizationRoutes(logger log.Logger, r *mux.Router, authorizationRepo authorizationRepository, receiverRepo receiverRepository, depositoryRepo DepositoryRepository) {
+	r.Methods("GET").Path("/authorizations").HandlerFunc(getUserAuthorizations(logger, authorizationRepo))
+	r.Methods("POST").Path("/authorizations").HandlerFunc(createUserAuthorization(logger, authorizationRepo, receiverRepo, depositoryRepo))
+
+	r.Methods("GET").Path("/authorizations/{authorizationId}").HandlerFunc(getUserAuthorization(logger, authorizationRepo))
+	r.Methods("POST").Path("/authorizations/{authorizationId}/revoke").HandlerFunc(revokeUserAuthorization(logger, authorizationRepo))
+}
+
+func getAuthorizationID(r *http.Request) AuthorizationID {
+	vars := mux.Vars(r)
+	v, ok := vars["authorizationId"]
+	if ok {
+		return AuthorizationID(v)
+	}
+	return AuthorizationID("")
+}
+
+func getUserAuthorizations(logger log.Logger, authorizationRepo authorizationRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		requestID, userID := moovhttp.GetRequestID(r), moovhttp.GetUserID(r)
+		auths, err := authorizationRepo.getUserAuthorizations(userID)
+		if err != nil {
+			logger.Log("authorizations", fmt.Sprintf("problem reading user authorizations: %v", err), "requestID", requestID, "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(auths)
+	}
+}
+
+func createUserAuthorization(logger log.Logger, authorizationRepo authorizationRepository, receiverRepo receiverRepository, depositoryRepo DepositoryRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		bs, err := read(r.Body)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		var req authorizationRequest
+		if err := json.Unmarshal(bs, &req); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if err := req.missingFields(); err != nil {
+			moovhttp.Problem(w, fmt.Errorf("%v: %v", errMissingRequiredJson, err))
+			return
+		}
+
+		userID, requestID := moovhttp.GetUserID(r), moovhttp.GetRequestID(r)
+
+		// Verify the Receiver and Depository belong to the user
+		receiver, err := receiverRepo.getUserReceiver(req.Receiver, userID)
+		if err != nil || receiver == nil {
+			moovhttp.Problem(w, fmt.Errorf("receiver %s does not exist", req.Receiver))
+			return
+		}
+		dep, err := depositoryRepo.getUserDepository(req.Depository, userID)
+		if err != nil || dep == nil {
+			moovhttp.Problem(w, fmt.Errorf("depository %s does not exist", req.Depository))
+			return
+		}
+
+		auth := req.asAuthorization()
+		if err := auth.validate(); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if err := authorizationRepo.createUserAuthorization(userID, auth); err != nil {
+			logger.Log("authorizations", fmt.Sprintf("problem creating authorization: %v", err), "requestID", requestID, "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		logger.Log("authorizations", fmt.Sprintf("created %s authorization=%s for receiver=%s depository=%s", auth.Type, auth.ID, auth.Receiver, auth.Depository), "requestID", requestID, "userID", userID)
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(auth)
+	}
+}
+
+func getUserAuthorization(logger log.Logger, authorizationRepo authorizationRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		id, userID := getAuthorizationID(r), moovhttp.GetUserID(r)
+		requestID := moovhttp.GetRequestID(r)
+		auth, err := authorizationRepo.getUserAuthorization(id, userID)
+		if err != nil {
+			logger.Log("authorizations", fmt.Sprintf("problem reading authorization=%s: %v", id, err), "requestID", requestID, "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		if auth == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(auth)
+	}
+}
+
+type revokeAuthorizationRequest struct {
+	Reason string `json:"reason"`
+}
+
+// revokeUserAuthorization stops any further debits under an Authorization, i.e. when the Receiver revokes it.
+func revokeUserAuthorization(logger log.Logger, authorizationRepo authorizationRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		var req revokeAuthorizationRequest
+		if bs, err := read(r.Body); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		} else if len(bs) > 0 {
+			if err := json.Unmarshal(bs, &req); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+		}
+		if req.Reason == "" {
+			req.Reason = "revoked by originator"
+		}
+
+		id, userID := getAuthorizationID(r), moovhttp.GetUserID(r)
+		requestID := moovhttp.GetRequestID(r)
+
+		auth, err := authorizationRepo.getUserAuthorization(id, userID)
+		if err != nil {
+			logger.Log("authorizations", fmt.Sprintf("problem reading authorization=%s: %v", id, err), "requestID", requestID, "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		if auth == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if auth.Status != AuthorizationActive {
+			moovhttp.Problem(w, fmt.Errorf("authorization=%s is %s", auth.ID, auth.Status))
+			return
+		}
+
+		if err := authorizationRepo.revokeUserAuthorization(id, userID, req.Reason); err != nil {
+			logger.Log("authorizations", fmt.Sprintf("problem revoking authorization=%s: %v", id, err), "requestID", requestID, "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		logger.Log("authorizations", fmt.Sprintf("revoked authorization=%s: %s", id, req.Reason), "requestID", requestID, "userID", userID)
+
+		if auth, err = authorizationRepo.getUserAuthorization(id, userID); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(auth)
+	}
+}
+
+// checkDebitAuthorization returns an error when a pull transfer for a consumer SEC code (PPD, WEB, TEL) doesn't
+// have an active Authorization from its Receiver for the Depository, type and amount.
+func checkDebitAuthorization(authorizationRepo authorizationRepository, userID string, req *transferRequest) error {
+	if req.Type != PullTransfer || authorizationTypeForSEC(req.StandardEntryClassCode) == "" {
+		return nil
+	}
+	auths, err := authorizationRepo.getActiveAuthorizations(req.Receiver, req.ReceiverDepository, userID)
+	if err != nil {
+		return fmt.Errorf("problem reading authorizations: %v", err)
+	}
+	var problems []string
+	for i := range auths {
+		err := auths[i].allows(req.StandardEntryClassCode, req.Amount)
+		if err == nil {
+			return nil
+		}
+		problems = append(problems, err.Error())
+	}
+	if len(problems) == 0 {
+		return fmt.Errorf("no active authorization for receiver=%s depository=%s", req.Receiver, req.ReceiverDepository)
+	}
+	return fmt.Errorf("no usable authorization for receiver=%s depository=%s: %s", req.Receiver, req.ReceiverDepository, strings.Join(problems, ", "))
+}
+
+type authorizationRepository interface {
+	getUserAuthorizations(userID string) ([]*Authorization, error)
+	getUserAuthorization(id AuthorizationID, userID string) (*Authorization, error)
+
+	createUserAuthorization(userID string, auth *Authorization) error
+	revokeUserAuthorization(id AuthorizationID, userID string, reason string) error
+
+	// getActiveAuthorizations returns the active Authorizations from a Receiver for one of their Depositories.
+	getActiveAuthorizations(receiverID ReceiverID, depID DepositoryID, userID string) ([]*Authorization, error)
+
+	// revokeAuthorizations revokes every active Authorization from a Receiver for one of their Depositories
+	// and returns how many were revoked.
+	revokeAuthorizations(receiverID ReceiverID, depID DepositoryID, userID string, reason string) (int, error)
+}
+
+func NewAuthorizationRepo(logger log.Logger, db *sql.DB) *SQLAuthorizationRepo {
+	return &SQLAuthorizationRepo{log: logger, db: db}
+}
+
+type SQLAuthorizationRepo struct {
+	db  *sql.DB
+	log log.Logger
+}
+
+func (r *SQLAuthorizationRepo) Close() error {
+	return r.db.Close()
+}
+
+const authorizationColumns = `authorization_id, receiver_id, depository_id, type, authorized_at, ip_address, user_agent, terms, max_amount, status, revoked_at, revocation_reason, created_at, last_updated_at`
+
+func (r *SQLAuthorizationRepo) queryAuthorizations(query string, args ...interface{}) ([]*Authorization, error) {
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var auths []*Authorization
+	for rows.Next() {
+		auth := &Authorization{}
+		var (
+			maxAmount                    string
+			revoked                      *time.Time
+			authorized, created, updated time.Time
+		)
+		err := rows.Scan(&auth.ID, &auth.Receiver, &auth.Depository, &auth.Type, &authorized, &auth.IPAddress, &auth.UserAgent, &auth.Terms, &maxAmount, &auth.Status, &revoked, &auth.RevocationReason, &created, &updated)
+		if err != nil {
+			return nil, err
+		}
+		if maxAmount != "" {
+			auth.MaxAmount = &Amount{}
+			if err := auth.MaxAmount.FromString(maxAmount); err != nil {
+				return nil, fmt.Errorf("authorization=%s: %v", auth.ID, err)
+			}
+		}
+		if revoked != nil {
+			t := base.NewTime(*revoked)
+			auth.Revoked = &t
+		}
+		auth.Authorized = base.NewTime(authorized)
+		auth.Created = base.NewTime(created)
+		auth.Updated = base.NewTime(updated)
+		auths = append(auths, auth)
+	}
+	return auths, rows.Err()
+}
+
+func (r *SQLAuthorizationRepo) getUserAuthorizations(userID string) ([]*Authorization, error) {
+	query := `select ` + authorizationColumns + ` from authorizations where user_id = ? order by created_at desc`
+	return r.queryAuthorizations(query, userID)
+}
+
+func (r *SQLAuthorizationRepo) getUserAuthorization(id AuthorizationID, userID string) (*Authorization, error) {
+	query := `select ` + authorizationColumns + ` from authorizations where authorization_id = ? and user_id = ? limit 1`
+	auths, err := r.queryAuthorizations(query, id, userID)
+	if err != nil || len(auths) == 0 {
+		return nil, err
+	}
+	return auths[0], nil
+}
+
+func (r *SQLAuthorizationRepo) getActiveAuthorizations(receiverID ReceiverID, depID DepositoryID, userID string) ([]*Authorization, error) {
+	query := `select ` + authorizationColumns + ` from authorizations where receiver_id = ? and depository_id = ? and user_id = ? and status = ?`
+	return r.queryAuthorizations(query, receiverID, depID, userID, AuthorizationActive)
+}
+
+func (r *SQLAuthorizationRepo) createUserAuthorization(userID string, auth *Authorization) error {
+	if err := auth.validate(); err != nil {
+		return err
+	}
+	var maxAmount string
+	if auth.MaxAmount != nil {
+		maxAmount = auth.MaxAmount.String()
+	}
+
+	query := `insert into authorizations (authorization_id, user_id, receiver_id, depository_id, type, authorized_at, ip_address, user_agent, terms, max_amount, status, revocation_reason, created_at, last_updated_at)
+values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, '', ?, ?)`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(auth.ID, userID, auth.Receiver, auth.Depository, auth.Type, auth.Authorized.Time, auth.IPAddress, auth.UserAgent, auth.Terms, maxAmount, auth.Status, auth.Created.Time, auth.Updated.Time)
+	return err
+}
+
+func (r *SQLAuthorizationRepo) revokeUserAuthorization(id AuthorizationID, userID string, reason string) error {
+	query := `update authorizations set status = ?, revoked_at = ?, revocation_reason = ?, last_updated_at = ?
+where authorization_id = ? and user_id = ? and status = ?`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	res, err := stmt.Exec(AuthorizationRevoked, now, reason, now, id, userID, AuthorizationActive)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("active authorization=%s not found", id)
+	}
+	return nil
+}
+
+func (r *SQLAuthorizationRepo) revokeAuthorizations(receiverID ReceiverID, depID DepositoryID, userID string, reason string) (int, error) {
+	query := `update authorizations set status = ?, revoked_at = ?, revocation_reason = ?, last_updated_at = ?
+where receiver_id = ? and depository_id = ? and user_id = ? and status = ?`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	res, err := stmt.Exec(AuthorizationRevoked, now, reason, now, receiverID, depID, userID, AuthorizationActive)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,357 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moov-io/base"
+	"github.com/moov-io/paygate/internal/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+type mockAuthorizationRepository struct {
+	auths []*Authorization
+	err   error
+
+	revoked int
+}
+
+func (r *mockAuthorizationRepository) getUserAuthorizations(userID string) ([]*Authorization, error) {
+	return r.auths, r.err
+}
+
+func (r *mockAuthorizationRepository) getUserAuthorization(id AuthorizationID, userID string) (*Authorization, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	for i := range r.auths {
+		if r.auths[i].ID == id {
+			return r.auths[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *mockAuthorizationRepository) createUserAuthorization(userID string, auth *Authorization) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.auths = append(r.auths, auth)
+	return nil
+}
+
+func (r *mockAuthorizationRepository) revokeUserAuthorization(id AuthorizationID, userID string, reason string) error {
+	auth, err := r.getUserAuthorization(id, userID)
+	if err != nil {
+		return err
+	}
+	auth.Status = AuthorizationRevoked
+	auth.RevocationReason = reason
+	return nil
+}
+
+func (r *mockAuthorizationRepository) getActiveAuthorizations(receiverID ReceiverID, depID DepositoryID, userID string) ([]*Authorization, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	var out []*Authorization
+	for i := range r.auths {
+		if r.auths[i].Receiver == receiverID && r.auths[i].Depository == depID && r.auths[i].Status == AuthorizationActive {
+			out = append(out, r.auths[i])
+		}
+	}
+	return out, nil
+}
+
+func (r *mockAuthorizationRepository) revokeAuthorizations(receiverID ReceiverID, depID DepositoryID, userID string, reason string) (int, error) {
+	auths, err := r.getActiveAuthorizations(receiverID, depID, userID)
+	for i := range auths {
+		auths[i].Status = AuthorizationRevoked
+		auths[i].RevocationReason = reason
+	}
+	r.revoked += len(auths)
+	return len(auths), err
+}
+
+func testAuthorization(receiverID ReceiverID, depID DepositoryID) *Authorization {
+	return authorizationRequest{
+		Receiver:   receiverID,
+		Depository: depID,
+		Type:       WrittenAuthorization,
+		Terms:      "I authorize Acme to debit my account monthly",
+	}.asAuthorization()
+}
+
+func TestAuthorizationType__json(t *testing.T) {
+	var at AuthorizationType
+	if err := json.Unmarshal([]byte(`"WEB"`), &at); err != nil || at != WebAuthorization {
+		t.Errorf("at=%s error=%v", at, err)
+	}
+	if err := json.Unmarshal([]byte(`"fax"`), &at); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestAuthorization__validate(t *testing.T) {
+	auth := testAuthorization("receiver", "depository")
+	if err := auth.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	auth.Type = WebAuthorization
+	if err := auth.validate(); err == nil {
+		t.Error("expected error without IPAddress and UserAgent")
+	}
+	auth.IPAddress, auth.UserAgent = "not an ip", "Mozilla/5.0"
+	if err := auth.validate(); err == nil {
+		t.Error("expected error")
+	}
+	auth.IPAddress = "2001:db8::1"
+	if err := auth.validate(); err != nil {
+		t.Error(err)
+	}
+
+	auth.Authorized = base.NewTime(time.Now().Add(time.Hour))
+	if err := auth.validate(); err == nil {
+		t.Error("expected error")
+	}
+	auth.Authorized = base.NewTime(time.Now())
+
+	auth.Terms = " "
+	if err := auth.validate(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestAuthorization__allows(t *testing.T) {
+	auth := testAuthorization("receiver", "depository")
+	auth.MaxAmount, _ = NewAmount("USD", "100.00")
+
+	under, _ := NewAmount("USD", "100.00")
+	over, _ := NewAmount("USD", "100.01")
+
+	if err := auth.allows("PPD", *under); err != nil {
+		t.Error(err)
+	}
+	if err := auth.allows("PPD", *over); err == nil || !strings.Contains(err.Error(), "limit of USD 100.00") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := auth.allows("WEB", *under); err == nil {
+		t.Error("expected error: WEB debits need web authorizations")
+	}
+	auth.Status = AuthorizationRevoked
+	if err := auth.allows("PPD", *under); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestAuthorizations__checkDebitAuthorization(t *testing.T) {
+	amt, _ := NewAmount("USD", "12.00")
+	req := &transferRequest{
+		Type:                   PullTransfer,
+		Amount:                 *amt,
+		Receiver:               ReceiverID("receiver"),
+		ReceiverDepository:     DepositoryID("depository"),
+		StandardEntryClassCode: "PPD",
+	}
+	repo := &mockAuthorizationRepository{}
+
+	if err := checkDebitAuthorization(repo, "userID", req); err == nil || !strings.Contains(err.Error(), "no active authorization") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// pushes and business debits don't need an Authorization
+	req.Type = PushTransfer
+	if err := checkDebitAuthorization(repo, "userID", req); err != nil {
+		t.Error(err)
+	}
+	req.Type, req.StandardEntryClassCode = PullTransfer, "CCD"
+	if err := checkDebitAuthorization(repo, "userID", req); err != nil {
+		t.Error(err)
+	}
+	req.StandardEntryClassCode = "PPD"
+
+	// an Authorization for a different Depository doesn't count
+	repo.auths = append(repo.auths, testAuthorization("receiver", "other"))
+	if err := checkDebitAuthorization(repo, "userID", req); err == nil {
+		t.Error("expected error")
+	}
+
+	auth := testAuthorization("receiver", "depository")
+	auth.MaxAmount, _ = NewAmount("USD", "10.00")
+	repo.auths = append(repo.auths, auth)
+	if err := checkDebitAuthorization(repo, "userID", req); err == nil || !strings.Contains(err.Error(), "no usable authorization") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	auth.MaxAmount = nil
+	if err := checkDebitAuthorization(repo, "userID", req); err != nil {
+		t.Error(err)
+	}
+
+	repo.err = errors.New("bad error")
+	if err := checkDebitAuthorization(repo, "userID", req); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestAuthorizations__repository(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *SQLAuthorizationRepo) {
+		userID := base.ID()
+		receiverID, depID := ReceiverID(base.ID()), DepositoryID(base.ID())
+
+		auth := testAuthorization(receiverID, depID)
+		auth.Type = TelephoneAuthorization
+		auth.MaxAmount, _ = NewAmount("USD", "250.00")
+		if err := repo.createUserAuthorization(userID, auth); err != nil {
+			t.Fatal(err)
+		}
+		other := testAuthorization(receiverID, DepositoryID(base.ID()))
+		if err := repo.createUserAuthorization(userID, other); err != nil {
+			t.Fatal(err)
+		}
+
+		auths, err := repo.getUserAuthorizations(userID)
+		if err != nil || len(auths) != 2 {
+			t.Fatalf("got %d authorizations (error=%v)", len(auths), err)
+		}
+		found, err := repo.getUserAuthorization(auth.ID, userID)
+		if err != nil || found == nil {
+			t.Fatalf("authorization=%v error=%v", found, err)
+		}
+		if found.Type != TelephoneAuthorization || found.MaxAmount.String() != "USD 250.00" || found.Status != AuthorizationActive || found.Revoked != nil {
+			t.Errorf("unexpected authorization: %#v", found)
+		}
+		if found, err := repo.getUserAuthorization(auth.ID, base.ID()); err != nil || found != nil {
+			t.Errorf("other user: authorization=%v error=%v", found, err)
+		}
+
+		active, err := repo.getActiveAuthorizations(receiverID, depID, userID)
+		if err != nil || len(active) != 1 || active[0].ID != auth.ID {
+			t.Errorf("active=%v error=%v", active, err)
+		}
+
+		// revoke from a return
+		if n, err := repo.revokeAuthorizations(receiverID, depID, userID, "R10: Customer Advises Not Authorized"); n != 1 || err != nil {
+			t.Errorf("n=%d error=%v", n, err)
+		}
+		found, _ = repo.getUserAuthorization(auth.ID, userID)
+		if found.Status != AuthorizationRevoked || found.Revoked == nil || !strings.HasPrefix(found.RevocationReason, "R10") {
+			t.Errorf("unexpected authorization: %#v", found)
+		}
+		if active, _ := repo.getActiveAuthorizations(receiverID, depID, userID); len(active) != 0 {
+			t.Errorf("unexpected active authorizations: %v", active)
+		}
+
+		// revoke directly
+		if err := repo.revokeUserAuthorization(other.ID, userID, "customer called"); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.revokeUserAuthorization(other.ID, userID, "customer called"); err == nil {
+			t.Error("expected error, authorization is already revoked")
+		}
+	}
+
+	// SQLite tests
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, NewAuthorizationRepo(log.NewNopLogger(), sqliteDB.DB))
+
+	// MySQL tests
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, NewAuthorizationRepo(log.NewNopLogger(), mysqlDB.DB))
+}
+
+func TestAuthorizations__HTTP(t *testing.T) {
+	receiverRepo := &mockReceiverRepository{
+		receivers: []*Receiver{{ID: ReceiverID("receiver")}},
+	}
+	depRepo := &mockDepositoryRepository{
+		depositories: []*Depository{{ID: DepositoryID("depository")}},
+	}
+	repo := &mockAuthorizationRepository{}
+
+	router := mux.NewRouter()
+	AddAuthorizationRoutes(log.NewNopLogger(), router, repo, receiverRepo, depRepo)
+
+	call := func(method, path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("x-user-id", base.ID())
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	// missing fields
+	if w := call("POST", "/authorizations", `{"receiver": "receiver"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	// web authorizations need the Receiver's IP address
+	if w := call("POST", "/authorizations", `{"receiver": "receiver", "depository": "depository", "type": "web", "terms": "ok"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+
+	var buf bytes.Buffer
+	json.NewEncoder(&buf).Encode(authorizationRequest{
+		Receiver:   "receiver",
+		Depository: "depository",
+		Type:       WebAuthorization,
+		IPAddress:  "10.1.2.3",
+		UserAgent:  "Mozilla/5.0",
+		Terms:      "I authorize Acme to debit my account",
+	})
+	w := call("POST", "/authorizations", buf.String())
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var auth Authorization
+	if err := json.NewDecoder(w.Body).Decode(&auth); err != nil {
+		t.Fatal(err)
+	}
+	if auth.ID == "" || auth.Status != AuthorizationActive || auth.IPAddress != "10.1.2.3" {
+		t.Errorf("unexpected authorization: %#v", auth)
+	}
+
+	if w := call("GET", "/authorizations", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), string(auth.ID)) {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if w := call("GET", fmt.Sprintf("/authorizations/%s", auth.ID), ""); w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if w := call("GET", "/authorizations/missing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+
+	w = call("POST", fmt.Sprintf("/authorizations/%s/revoke", auth.ID), `{"reason": "customer called"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if repo.auths[0].Status != AuthorizationRevoked || repo.auths[0].RevocationReason != "customer called" {
+		t.Errorf("unexpected authorization: %#v", repo.auths[0])
+	}
+	if w := call("POST", fmt.Sprintf("/authorizations/%s/revoke", auth.ID), ""); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+
+	// unknown Receiver
+	receiverRepo.receivers = nil
+	if w := call("POST", "/authorizations", buf.String()); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+}
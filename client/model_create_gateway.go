@@ -10,7 +10,7 @@
 package openapi
 
 type CreateGateway struct {
-	// Routing Number - four digit Federal Reserve Routing Symbol and the four digit ABA Institution Identifier
+	// Routing Number - four digit Federal Reserve Routing Symbol and the four digit ABA Institution Identifier. A cutoff time must be configured for this routing number.
 	Origin string `json:"origin"`
 	// Legal name associated with the origin routing number.
 	OriginName string `json:"originName"`
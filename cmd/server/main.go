@@ -7,6 +7,7 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"database/sql"
 	"flag"
 	"fmt"
 	"net/http"
@@ -89,6 +90,12 @@ func main() {
 	}()
 	defer adminServer.Shutdown()
 
+	// Setup encryption of account numbers
+	if err := paygate.SetupEncryptionKeys(logger, os.Getenv("ENCRYPTION_KEYS"), os.Getenv("ENCRYPTION_HASH_KEY"), util.Yes(os.Getenv("ENCRYPTION_DEVELOPMENT_KEYS"))); err != nil {
+		panic(fmt.Sprintf("ERROR: setting up encryption keys: %v", err))
+	}
+	paygate.SetupReceiverVerificationSecret(logger, os.Getenv("RECEIVER_VERIFICATION_SECRET"))
+
 	// Setup repositories
 	receiverRepo := paygate.NewReceiverRepo(logger, db)
 	defer receiverRepo.Close()
@@ -96,7 +103,10 @@ func main() {
 	depositoryRepo := paygate.NewDepositoryRepo(logger, db)
 	defer depositoryRepo.Close()
 
-	eventRepo := paygate.NewEventRepo(logger, db)
+	webhookRepo := paygate.NewWebhookRepo(logger, db)
+	defer webhookRepo.Close()
+
+	eventRepo := paygate.NewEventRepo(logger, db, webhookRepo)
 	defer eventRepo.Close()
 
 	gatewaysRepo := paygate.NewGatewayRepo(logger, db)
@@ -108,6 +118,27 @@ func main() {
 	transferRepo := paygate.NewTransferRepo(logger, db)
 	defer transferRepo.Close()
 
+	transferLimitRepo := paygate.NewTransferLimitRepo(logger, db)
+	defer transferLimitRepo.Close()
+
+	transferApproverRepo := paygate.NewTransferApproverRepo(logger, db)
+	defer transferApproverRepo.Close()
+
+	idempotencyKeyRepo := paygate.NewIdempotencyKeyRepo(logger, db)
+	defer idempotencyKeyRepo.Close()
+
+	authorizationRepo := paygate.NewAuthorizationRepo(logger, db)
+	defer authorizationRepo.Close()
+
+	fileApprovalRepo := paygate.NewFileApprovalRepo(logger, db)
+	defer fileApprovalRepo.Close()
+
+	processedFileRepo := paygate.NewProcessedFileRepo(logger, db)
+	defer processedFileRepo.Close()
+
+	fileUploadRepo := paygate.NewFileUploadRepo(logger, db)
+	defer fileUploadRepo.Close()
+
 	httpClient, err := paygate.TLSHttpClient(os.Getenv("HTTP_CLIENT_CAFILE"))
 	if err != nil {
 		panic(fmt.Sprintf("problem creating TLS ready *http.Client: %v", err))
@@ -128,8 +159,7 @@ func main() {
 	adminServer.AddLivenessCheck("fed", fedClient.Ping)
 
 	// Create Accounts client
-	accountsClient := setupAccountsClient(logger, adminServer, httpClient, os.Getenv("ACCOUNTS_ENDPOINT"), os.Getenv("ACCOUNTS_CALLS_DISABLED"))
-	accountsCallsDisabled := accountsClient == nil
+	accountsClient := setupAccountsClient(logger, adminServer, httpClient, db, os.Getenv("ACCOUNTS_ENDPOINT"), os.Getenv("ACCOUNTS_CALLS_DISABLED"))
 
 	// Setup ODFI Account
 	odfiAccountType := paygate.Savings
@@ -148,6 +178,26 @@ func main() {
 	}
 	adminServer.AddLivenessCheck("ofac", ofacClient.Ping)
 
+	// Queue OFAC hits for review by compliance officers
+	ofacReviewRepo := paygate.NewOFACReviewRepo(logger, db)
+	defer ofacReviewRepo.Close()
+	paygate.AddOFACReviewRoutes(logger, adminServer, ofacReviewRepo)
+
+	// Start periodic OFAC rescreening of existing Receivers, Originators and Depositories
+	ofacRescreenRepo := paygate.NewOFACRescreenRepo(logger, db)
+	defer ofacRescreenRepo.Close()
+	ofacRescreener := paygate.NewOFACRescreener(logger, ofacClient, ofacRescreenRepo, ofacReviewRepo, receiverRepo, depositoryRepo, originatorsRepo, transferRepo, eventRepo, accountsClient)
+	rescreenCtx, cancelRescreens := context.WithCancel(context.Background())
+	defer cancelRescreens()
+	go ofacRescreener.Start(rescreenCtx)
+	paygate.AddOFACRescreenRoutes(logger, adminServer, ofacRescreener)
+
+	// Start delivering Events to user webhooks
+	webhookDispatcher := paygate.NewWebhookDispatcher(logger, webhookRepo, nil)
+	webhookCtx, cancelWebhooks := context.WithCancel(context.Background())
+	defer cancelWebhooks()
+	go webhookDispatcher.Start(webhookCtx)
+
 	// Start periodic ACH file sync
 	achStorageDir := filepath.Dir(os.Getenv("ACH_FILE_STORAGE_DIR"))
 	if achStorageDir == "." {
@@ -161,7 +211,7 @@ func main() {
 	fileTransferRepo := filetransfer.NewRepository(db, os.Getenv("DATABASE_TYPE"))
 	defer fileTransferRepo.Close()
 
-	fileTransferController, err := paygate.NewFileTransferController(logger, achStorageDir, fileTransferRepo, achClient, accountsClient, accountsCallsDisabled)
+	fileTransferController, err := paygate.NewFileTransferController(logger, achStorageDir, fileTransferRepo, achClient, accountsClient, odfiAccount, fileApprovalRepo, processedFileRepo, authorizationRepo, eventRepo, gatewaysRepo, fileUploadRepo)
 	if err != nil {
 		panic(fmt.Sprintf("ERROR: creating ACH file transfer controller: %v", err))
 	}
@@ -174,31 +224,68 @@ func main() {
 		go fileTransferController.StartPeriodicFileOperations(ctx, forceFileUplaods, depositoryRepo, transferRepo)
 
 		paygate.AddFileTransferSyncRoute(logger, adminServer, forceFileUplaods)
+		paygate.AddFileApprovalRoutes(logger, adminServer, fileApprovalRepo, depositoryRepo, transferRepo, eventRepo, accountsClient)
+		paygate.AddProcessedFileRoutes(logger, adminServer, fileTransferController, depositoryRepo, transferRepo)
 
 		// side-effect register HTTP routes
 		filetransfer.AddFileTransferConfigRoutes(logger, adminServer, fileTransferRepo)
 	}
 
+	// Reconcile each business day's Transfers against Accounts and uploaded files
+	reconciliationRepo := paygate.NewReconciliationRepo(logger, db)
+	defer reconciliationRepo.Close()
+	reconciler := paygate.NewReconciler(logger, accountsClient, reconciliationRepo, fileUploadRepo, depositoryRepo)
+	reconcileCtx, cancelReconciliations := context.WithCancel(context.Background())
+	defer cancelReconciliations()
+	go reconciler.Start(reconcileCtx)
+	paygate.AddReconciliationRoutes(logger, adminServer, reconciler)
+
+	// Cancel Transfers which weren't approved within TRANSFER_APPROVAL_EXPIRY
+	transferApprovalExpirer := paygate.NewTransferApprovalExpirer(logger, accountsClient, transferRepo, eventRepo)
+	expirerCtx, cancelExpirer := context.WithCancel(context.Background())
+	defer cancelExpirer()
+	go transferApprovalExpirer.Start(expirerCtx)
+
 	// Register the micro-deposit admin route
 	paygate.AddMicroDepositAdminRoutes(logger, adminServer, depositoryRepo)
+	paygate.AddDepositoryAdminRoutes(logger, adminServer, depositoryRepo)
+	paygate.AddEncryptionKeyRoutes(logger, adminServer, depositoryRepo, originatorsRepo, webhookRepo)
+	paygate.AddTransferLimitRoutes(logger, adminServer, transferLimitRepo)
+	paygate.AddTransferApproverRoutes(logger, adminServer, transferApproverRepo)
+
+	// Setup notifications (i.e. Receiver email verification)
+	notifier, err := paygate.NewNotifier(logger)
+	if err != nil {
+		panic(fmt.Sprintf("ERROR: creating notifier: %v", err))
+	}
 
 	// Create HTTP handler
 	handler := mux.NewRouter()
-	paygate.AddReceiverRoutes(logger, handler, ofacClient, receiverRepo, depositoryRepo)
+	paygate.AddReceiverRoutes(logger, handler, ofacClient, ofacReviewRepo, receiverRepo, depositoryRepo)
+	paygate.AddReceiverVerificationRoutes(logger, handler, notifier, receiverRepo, eventRepo)
+	paygate.AddReceiverAdminRoutes(logger, adminServer, receiverRepo, eventRepo)
+	paygate.AddAuthorizationRoutes(logger, handler, authorizationRepo, receiverRepo, depositoryRepo)
 	paygate.AddEventRoutes(logger, handler, eventRepo)
-	paygate.AddGatewayRoutes(logger, handler, gatewaysRepo)
-	paygate.AddOriginatorRoutes(logger, handler, accountsCallsDisabled, accountsClient, ofacClient, depositoryRepo, originatorsRepo)
+	paygate.AddWebhookRoutes(logger, handler, webhookRepo)
+	paygate.AddGatewayRoutes(logger, handler, gatewaysRepo, fileTransferRepo)
+	paygate.AddOriginatorRoutes(logger, handler, accountsClient, ofacClient, ofacReviewRepo, depositoryRepo, originatorsRepo)
 	paygate.AddPingRoute(logger, handler)
 
+	// Setup instant account verification
+	accountVerifier, err := paygate.NewAccountVerifier(logger, os.Getenv("ACCOUNT_VERIFICATION_PROVIDER"), util.Yes(os.Getenv("ACCOUNT_VERIFICATION_ALLOW_MOCK")))
+	if err != nil {
+		panic(fmt.Sprintf("ERROR: creating account verification provider: %v", err))
+	}
+
 	// Depository HTTP routes
-	depositoryRouter := paygate.NewDepositoryRouter(logger, odfiAccount, accountsClient, achClient, fedClient, ofacClient, depositoryRepo, eventRepo)
-	depositoryRouter.RegisterRoutes(handler, accountsCallsDisabled)
+	depositoryRouter := paygate.NewDepositoryRouter(logger, odfiAccount, accountsClient, achClient, fedClient, ofacClient, ofacReviewRepo, accountVerifier, depositoryRepo, transferRepo, eventRepo)
+	depositoryRouter.RegisterRoutes(handler)
 
 	// Transfer HTTP routes
 	achClientFactory := func(userId string) *achclient.ACH {
 		return achclient.New(logger, userId, httpClient)
 	}
-	xferRouter := paygate.NewTransferRouter(logger, depositoryRepo, eventRepo, receiverRepo, originatorsRepo, transferRepo, achClientFactory, accountsClient, accountsCallsDisabled)
+	xferRouter := paygate.NewTransferRouter(logger, depositoryRepo, eventRepo, receiverRepo, originatorsRepo, transferRepo, authorizationRepo, gatewaysRepo, transferApproverRepo, idempotencyKeyRepo, fileTransferRepo, ofacClient, ofacReviewRepo, achClientFactory, accountsClient)
 	xferRouter.RegisterRoutes(handler)
 
 	// Check to see if our -http.addr flag has been overridden
@@ -245,9 +332,14 @@ func main() {
 	}
 }
 
-func setupAccountsClient(logger log.Logger, svc *admin.Server, httpClient *http.Client, endpoint, disabled string) paygate.AccountsClient {
+// setupAccountsClient returns the client for posting transactions. Without an Accounts service paygate keeps its own ledger.
+func setupAccountsClient(logger log.Logger, svc *admin.Server, httpClient *http.Client, db *sql.DB, endpoint, disabled string) paygate.AccountsClient {
 	if util.Yes(disabled) {
-		return nil
+		logger.Log("accounts", "Accounts calls disabled, using internal ledger")
+		ledger := paygate.NewLedger(logger, db)
+		paygate.AddLedgerAdminRoutes(logger, svc, ledger)
+		svc.AddLivenessCheck("ledger", ledger.Ping)
+		return ledger
 	}
 	accountsClient := paygate.CreateAccountsClient(logger, endpoint, httpClient)
 	if accountsClient == nil {
@@ -9,6 +9,8 @@ import (
 	"testing"
 
 	"github.com/moov-io/base/admin"
+	"github.com/moov-io/paygate"
+	"github.com/moov-io/paygate/internal/database"
 
 	"github.com/go-kit/kit/log"
 )
@@ -18,11 +20,14 @@ func TestMain__setupAccountsClient(t *testing.T) {
 	svc := admin.NewServer(":0")
 	httpClient := &http.Client{}
 
-	client := setupAccountsClient(logger, svc, httpClient, "", "yes")
-	if client != nil {
-		t.Errorf("expected disabled (nil) AccountsClient: %v", client)
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	client := setupAccountsClient(logger, svc, httpClient, db.DB, "", "yes")
+	if _, ok := client.(*paygate.SQLLedger); !ok {
+		t.Errorf("expected internal ledger AccountsClient: %T", client)
 	}
-	client = setupAccountsClient(logger, svc, httpClient, "", "")
+	client = setupAccountsClient(logger, svc, httpClient, db.DB, "", "")
 	if client == nil {
 		t.Error("expected non-nil AccountsClient")
 	}
@@ -81,6 +81,15 @@ func NewAmountFromInt(symbol string, number int) (*Amount, error) {
 	return NewAmount(symbol, fmt.Sprintf("%.2f", float64(number)/100.0))
 }
 
+// amountFromCents returns cents as a USD Amount. The zero Amount (which formats as "USD 0.00") is returned
+// for zero or negative cents.
+func amountFromCents(cents int) Amount {
+	if amt, err := NewAmountFromInt("USD", cents); err == nil {
+		return *amt
+	}
+	return Amount{}
+}
+
 // NewAmount returns an Amount object after validating the ISO 4217 currency symbol.
 func NewAmount(symbol string, number string) (*Amount, error) {
 	var amt Amount
@@ -15,6 +15,7 @@ import (
 
 	"github.com/moov-io/ach"
 	"github.com/moov-io/base"
+	"github.com/moov-io/base/admin"
 	moovhttp "github.com/moov-io/base/http"
 	"github.com/moov-io/paygate/internal/database"
 	"github.com/moov-io/paygate/pkg/achclient"
@@ -48,7 +49,8 @@ type Depository struct {
 	// RoutingNumber is the ABA routing transit number for the depository account.
 	RoutingNumber string `json:"routingNumber"`
 
-	// AccountNumber is the account number for the depository account
+	// AccountNumber is the account number for the depository account. All but the last four digits are
+	// masked when encoded as JSON.
 	AccountNumber string `json:"accountNumber"`
 
 	// Status defines the current state of the Depository
@@ -64,6 +66,16 @@ type Depository struct {
 	Updated base.Time `json:"updated"`
 }
 
+// MarshalJSON masks all but the last four digits of the AccountNumber. See unmaskedDepository for encoding the full number.
+func (d Depository) MarshalJSON() ([]byte, error) {
+	dep := unmaskedDepository(d)
+	dep.AccountNumber = maskAccountNumber(d.AccountNumber)
+	return json.Marshal(dep)
+}
+
+// unmaskedDepository encodes a Depository with its full AccountNumber, only admin endpoints should return it.
+type unmaskedDepository Depository
+
 func (d *Depository) validate() error {
 	if d == nil {
 		return errors.New("nil Depository")
@@ -96,10 +108,8 @@ type depositoryRequest struct {
 	Metadata      string      `json:"metadata,omitempty"`
 }
 
+// missingFields returns an error for required fields which are missing. BankName is optional as it's filled in from the FED.
 func (r depositoryRequest) missingFields() error {
-	if r.BankName == "" {
-		return errors.New("missing depositoryRequest.BankName")
-	}
 	if r.Holder == "" {
 		return errors.New("missing depositoryRequest.Holder")
 	}
@@ -159,6 +169,10 @@ const (
 	DepositoryUnverified DepositoryStatus = "unverified"
 	DepositoryVerified   DepositoryStatus = "verified"
 	DepositoryRejected   DepositoryStatus = "rejected"
+
+	// DepositoryLocked is a Depository which failed micro-deposit confirmation too many times.
+	// An admin needs to reset its micro-deposits before it can be verified.
+	DepositoryLocked DepositoryStatus = "locked"
 )
 
 func (ds DepositoryStatus) empty() bool {
@@ -167,7 +181,7 @@ func (ds DepositoryStatus) empty() bool {
 
 func (ds DepositoryStatus) validate() error {
 	switch ds {
-	case DepositoryUnverified, DepositoryVerified, DepositoryRejected:
+	case DepositoryUnverified, DepositoryVerified, DepositoryRejected, DepositoryLocked:
 		return nil
 	default:
 		return fmt.Errorf("DepositoryStatus(%s) is invalid", ds)
@@ -204,8 +218,12 @@ type DepositoryRouter struct {
 	accountsClient AccountsClient
 	fedClient      FEDClient
 	ofacClient     OFACClient
+	ofacReviewRepo ofacReviewRepository
+
+	accountVerifier AccountVerifier
 
 	depositoryRepo DepositoryRepository
+	transferRepo   transferRepository
 	eventRepo      EventRepository
 }
 
@@ -216,22 +234,28 @@ func NewDepositoryRouter(
 	achClient *achclient.ACH,
 	fedClient FEDClient,
 	ofacClient OFACClient,
+	ofacReviewRepo ofacReviewRepository,
+	accountVerifier AccountVerifier,
 	depositoryRepo DepositoryRepository,
+	transferRepo transferRepository,
 	eventRepo EventRepository,
 ) *DepositoryRouter {
 	return &DepositoryRouter{
-		logger:         logger,
-		odfiAccount:    odfiAccount,
-		achClient:      achClient,
-		accountsClient: accountsClient,
-		fedClient:      fedClient,
-		ofacClient:     ofacClient,
-		depositoryRepo: depositoryRepo,
-		eventRepo:      eventRepo,
+		logger:          logger,
+		odfiAccount:     odfiAccount,
+		achClient:       achClient,
+		accountsClient:  accountsClient,
+		fedClient:       fedClient,
+		ofacClient:      ofacClient,
+		ofacReviewRepo:  ofacReviewRepo,
+		accountVerifier: accountVerifier,
+		depositoryRepo:  depositoryRepo,
+		transferRepo:    transferRepo,
+		eventRepo:       eventRepo,
 	}
 }
 
-func (r *DepositoryRouter) RegisterRoutes(router *mux.Router, accountsCallsDisabled bool) {
+func (r *DepositoryRouter) RegisterRoutes(router *mux.Router) {
 	router.Methods("GET").Path("/depositories").HandlerFunc(r.getUserDepositories())
 	router.Methods("POST").Path("/depositories").HandlerFunc(r.createUserDepository())
 
@@ -239,11 +263,10 @@ func (r *DepositoryRouter) RegisterRoutes(router *mux.Router, accountsCallsDisab
 	router.Methods("PATCH").Path("/depositories/{depositoryId}").HandlerFunc(r.updateUserDepository())
 	router.Methods("DELETE").Path("/depositories/{depositoryId}").HandlerFunc(r.deleteUserDepository())
 
-	if accountsCallsDisabled {
-		r.accountsClient = nil // zero out so micro-deposit route doesn't call it
-	}
 	router.Methods("POST").Path("/depositories/{depositoryId}/micro-deposits").HandlerFunc(r.initiateMicroDeposits())
 	router.Methods("POST").Path("/depositories/{depositoryId}/micro-deposits/confirm").HandlerFunc(r.confirmMicroDeposits())
+
+	router.Methods("POST").Path("/depositories/{depositoryId}/verify").HandlerFunc(r.verifyDepository())
 }
 
 // GET /depositories
@@ -329,14 +352,14 @@ func (r *DepositoryRouter) createUserDepository() http.HandlerFunc {
 		// TODO(adam): We should check and reject duplicate Depositories (by ABA and AccountNumber) on creation
 
 		// Check FED for the routing number
-		if err := r.fedClient.LookupRoutingNumber(req.RoutingNumber); err != nil {
+		if err := r.lookupBankName(depository); err != nil {
 			r.logger.Log("depositories", fmt.Sprintf("problem with FED routing number lookup %q: %v", req.RoutingNumber, err.Error()), "requestID", requestID, "userID", userID)
 			moovhttp.Problem(w, err)
 			return
 		}
 
 		// Check OFAC for customer/company data
-		if err := rejectViaOFACMatch(r.logger, r.ofacClient, depository.Holder, userID, requestID); err != nil {
+		if err := rejectViaOFACMatch(r.logger, r.ofacClient, r.ofacReviewRepo, depository.Holder, userID, requestID); err != nil {
 			r.logger.Log("depositories", err.Error(), "requestID", requestID, "userID", userID)
 			moovhttp.Problem(w, err)
 			return
@@ -353,6 +376,81 @@ func (r *DepositoryRouter) createUserDepository() http.HandlerFunc {
 	}
 }
 
+// accountDetailChanges returns a description of each change to the routing number, account number or account type
+// between two versions of a Depository. Account numbers are masked as the descriptions are shown to users.
+func accountDetailChanges(previous, dep *Depository) []string {
+	var changes []string
+	if previous.RoutingNumber != dep.RoutingNumber {
+		changes = append(changes, fmt.Sprintf("routingNumber: %s -> %s", previous.RoutingNumber, dep.RoutingNumber))
+	}
+	if previous.AccountNumber != dep.AccountNumber {
+		changes = append(changes, fmt.Sprintf("accountNumber: %s -> %s", maskAccountNumber(previous.AccountNumber), maskAccountNumber(dep.AccountNumber)))
+	}
+	if !strings.EqualFold(string(previous.Type), string(dep.Type)) {
+		changes = append(changes, fmt.Sprintf("type: %s -> %s", previous.Type, dep.Type))
+	}
+	return changes
+}
+
+// resetDepositoryVerification fails the pending Transfers of a Depository whose account details changed, they were
+// created against the previous account, and reverses their postings. An audit event records the changes.
+//
+// The Depository's micro-deposits were already removed by updateDepositoryAccount.
+func (r *DepositoryRouter) resetDepositoryVerification(dep *Depository, userID string, previous *Depository, changes []string) error {
+	transferIDs, err := r.transferRepo.failPendingDepositoryTransfers(dep.ID, userID)
+	if err != nil {
+		return fmt.Errorf("problem blocking pending transfers: %v", err)
+	}
+	for i := range transferIDs {
+		xfer, err := r.transferRepo.getTransfer(transferIDs[i])
+		if err == nil {
+			err = reverseTransferTransaction(r.accountsClient, r.transferRepo, "", xfer)
+		}
+		if err != nil {
+			r.logger.Log("depositories", fmt.Sprintf("problem reversing transfer=%s: %v", transferIDs[i], err), "userID", userID)
+		}
+	}
+
+	message := fmt.Sprintf("changed %s (status: %s -> %s)", strings.Join(changes, ", "), previous.Status, dep.Status)
+	if len(transferIDs) > 0 {
+		ids := make([]string, len(transferIDs))
+		for i := range transferIDs {
+			ids[i] = string(transferIDs[i])
+		}
+		message += fmt.Sprintf(", failed pending transfers: %s", strings.Join(ids, ", "))
+	}
+	r.logger.Log("depositories", fmt.Sprintf("depository=%s account details changed: %s", dep.ID, message), "userID", userID)
+
+	return r.eventRepo.writeEvent(userID, &Event{
+		ID:      EventID(base.ID()),
+		Topic:   fmt.Sprintf("depository %s account details changed", dep.ID),
+		Message: message,
+		Type:    DepositoryEvent,
+		Metadata: map[string]string{
+			eventDepositoryID: string(dep.ID),
+		},
+	})
+}
+
+// lookupBankName checks the FED for the Depository's routing number, rejecting financial institutions which can't receive
+// ACH entries. If the Depository has no BankName the institution's name is used.
+func (r *DepositoryRouter) lookupBankName(dep *Depository) error {
+	details, err := r.fedClient.LookupRoutingNumber(dep.RoutingNumber)
+	if err != nil {
+		return err
+	}
+	if details == nil || !details.ACHParticipant {
+		return fmt.Errorf("routing number %s is not an ACH participant", dep.RoutingNumber)
+	}
+	if dep.BankName == "" {
+		dep.BankName = details.Name
+	}
+	if dep.BankName == "" {
+		return fmt.Errorf("missing BankName and none found for routing number %s", dep.RoutingNumber)
+	}
+	return nil
+}
+
 func (r *DepositoryRouter) getUserDepository() http.HandlerFunc {
 	return func(w http.ResponseWriter, httpReq *http.Request) {
 		w, err := wrapResponseWriter(r.logger, w, httpReq)
@@ -410,7 +508,7 @@ func (r *DepositoryRouter) updateUserDepository() http.HandlerFunc {
 		}
 
 		// Update model
-		var requireValidation bool
+		previous := *depository
 		if req.BankName != "" {
 			depository.BankName = req.BankName
 		}
@@ -428,11 +526,9 @@ func (r *DepositoryRouter) updateUserDepository() http.HandlerFunc {
 				moovhttp.Problem(w, err)
 				return
 			}
-			requireValidation = true
 			depository.RoutingNumber = req.RoutingNumber
 		}
 		if req.AccountNumber != "" {
-			requireValidation = true
 			depository.AccountNumber = req.AccountNumber
 		}
 		if req.Metadata != "" {
@@ -440,21 +536,59 @@ func (r *DepositoryRouter) updateUserDepository() http.HandlerFunc {
 		}
 		depository.Updated = base.NewTime(time.Now())
 
-		if requireValidation {
+		// Verification only applies to the account it was performed against, so changing the account requires it again.
+		// Locked Depositories only get another round of micro-deposits through an explicit reset.
+		changes := accountDetailChanges(&previous, depository)
+		if len(changes) > 0 {
+			if previous.Status == DepositoryLocked {
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte(fmt.Sprintf(`{"error": %q}`, errDepositoryLocked.Error())))
+				return
+			}
 			depository.Status = DepositoryUnverified
 		}
 
+		// Check FED for a changed routing number and fill in its BankName unless one was given
+		if req.RoutingNumber != "" {
+			if req.BankName == "" {
+				depository.BankName = ""
+			}
+			if err := r.lookupBankName(depository); err != nil {
+				r.logger.Log("depositories", fmt.Sprintf("problem with FED routing number lookup %q: %v", req.RoutingNumber, err.Error()), "requestID", moovhttp.GetRequestID(httpReq), "userID", userID)
+				moovhttp.Problem(w, err)
+				return
+			}
+		}
+
 		if err := depository.validate(); err != nil {
 			moovhttp.Problem(w, err)
 			return
 		}
 
-		if err := r.depositoryRepo.upsertUserDepository(userID, depository); err != nil {
+		if len(changes) > 0 {
+			err = r.depositoryRepo.updateDepositoryAccount(userID, depository)
+		} else {
+			err = r.depositoryRepo.upsertUserDepository(userID, depository)
+		}
+		if err != nil {
 			r.logger.Log("depositories", err.Error(), "requestID", moovhttp.GetRequestID(httpReq), "userID", userID)
+			if err == errDepositoryLocked {
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte(fmt.Sprintf(`{"error": %q}`, err.Error())))
+				return
+			}
 			moovhttp.Problem(w, err)
 			return
 		}
 
+		if len(changes) > 0 {
+			if err := r.resetDepositoryVerification(depository, userID, &previous, changes); err != nil {
+				r.logger.Log("depositories", fmt.Sprintf("problem resetting verification of depository=%s: %v", depository.ID, err), "requestID", moovhttp.GetRequestID(httpReq), "userID", userID)
+				moovhttp.Problem(w, err)
+				return
+			}
+		}
+
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(depository)
 	}
@@ -502,11 +636,52 @@ func markDepositoryVerified(repo DepositoryRepository, id DepositoryID, userID s
 	return repo.upsertUserDepository(userID, dep)
 }
 
+func AddDepositoryAdminRoutes(logger log.Logger, svc *admin.Server, depRepo DepositoryRepository) {
+	svc.AddHandler("/depositories/{depositoryId}", getDepository(logger, depRepo))
+}
+
+// getDepository is an http.HandlerFunc for paygate's admin server to return a Depository with its full account number.
+func getDepository(logger log.Logger, depositoryRepo DepositoryRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w = wrap(logger, w, r)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		if r.Method != "GET" {
+			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb: %s", r.Method))
+			return
+		}
+
+		id, requestID := getDepositoryID(r), moovhttp.GetRequestID(r)
+		dep, err := depositoryRepo.getDepository(id)
+		if err != nil {
+			logger.Log("depositories", fmt.Sprintf("admin: problem reading depository=%s: %v", id, err), "requestID", requestID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		if dep == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "depository not found"}`))
+			return
+		}
+		logger.Log("depositories", fmt.Sprintf("admin: read unmasked depository=%s", id), "requestID", requestID)
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode((*unmaskedDepository)(dep))
+	}
+}
+
 type DepositoryRepository interface {
 	getUserDepositories(userID string) ([]*Depository, error)
 	getUserDepository(id DepositoryID, userID string) (*Depository, error)
+	getDepository(id DepositoryID) (*Depository, error) // admin endpoint
+
+	// lookupDepositoryFromReturn finds a Depository by its routing and account number
+	lookupDepositoryFromReturn(userID string, routingNumber string, accountNumber string) (*Depository, error)
 
 	upsertUserDepository(userID string, dep *Depository) error
+	// updateDepositoryAccount saves a Depository whose account details changed, marking it Unverified and removing its
+	// micro-deposits together. Locked Depositories return errDepositoryLocked.
+	updateDepositoryAccount(userID string, dep *Depository) error
 	updateDepositoryStatus(id DepositoryID, status DepositoryStatus) error
 	deleteUserDepository(id DepositoryID, userID string) error
 
@@ -514,7 +689,16 @@ type DepositoryRepository interface {
 	getMicroDepositsForUser(id DepositoryID, userID string) ([]microDeposit, error)
 
 	initiateMicroDeposits(id DepositoryID, userID string, microDeposit []microDeposit) error
-	confirmMicroDeposits(id DepositoryID, userID string, amounts []Amount) error
+	// confirmMicroDeposits records an attempt, checks amounts and marks the Depository Verified (or Locked) together.
+	// It returns the count of failed attempts since micro-deposits were last reset.
+	confirmMicroDeposits(id DepositoryID, userID string, amounts []Amount) (int, error)
+
+	// resetMicroDeposits removes a Depository's micro-deposits and failed attempts and unlocks it (admin endpoint)
+	resetMicroDeposits(id DepositoryID) error
+
+	// getMergedMicroDeposits returns the micro-deposits which were merged into filename and haven't been removed.
+	getMergedMicroDeposits(filename string) ([]uploadableMicroDeposit, error)
+
 	getMicroDepositCursor(batchSize int) *microDepositCursor
 }
 
@@ -565,7 +749,7 @@ func (r *SQLDepositoryRepo) getUserDepositories(userID string) ([]*Depository, e
 }
 
 func (r *SQLDepositoryRepo) getUserDepository(id DepositoryID, userID string) (*Depository, error) {
-	query := `select depository_id, bank_name, holder, holder_type, type, routing_number, account_number, status, metadata, created_at, last_updated_at
+	query := `select depository_id, bank_name, holder, holder_type, type, routing_number, account_number, encrypted_account_number, status, metadata, created_at, last_updated_at
 from depositories
 where depository_id = ? and user_id = ? and deleted_at is null
 limit 1`
@@ -575,20 +759,63 @@ limit 1`
 	}
 	defer stmt.Close()
 
-	row := stmt.QueryRow(id, userID)
+	return scanDepository(stmt.QueryRow(id, userID))
+}
+
+// getDepository returns a Depository regardless of which user it belongs to. This is designed for paygate's admin endpoints.
+func (r *SQLDepositoryRepo) getDepository(id DepositoryID) (*Depository, error) {
+	query := `select depository_id, bank_name, holder, holder_type, type, routing_number, account_number, encrypted_account_number, status, metadata, created_at, last_updated_at
+from depositories
+where depository_id = ? and deleted_at is null
+limit 1`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	return scanDepository(stmt.QueryRow(id))
+}
+
+// lookupDepositoryFromReturn finds a user's Depository by routing and account number. Account numbers are matched
+// against their hash, or the plaintext column for Depositories which haven't been encrypted yet.
+func (r *SQLDepositoryRepo) lookupDepositoryFromReturn(userID string, routingNumber string, accountNumber string) (*Depository, error) {
+	query := `select depository_id, bank_name, holder, holder_type, type, routing_number, account_number, encrypted_account_number, status, metadata, created_at, last_updated_at
+from depositories
+where user_id = ? and routing_number = ? and (account_number_hash = ? or (account_number <> '' and account_number = ?)) and deleted_at is null
+limit 1`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	accountNumber = strings.TrimSpace(accountNumber)
+	return scanDepository(stmt.QueryRow(userID, routingNumber, hashAccountNumber(accountNumber), accountNumber))
+}
 
+func scanDepository(row *sql.Row) (*Depository, error) {
 	dep := &Depository{}
 	var (
-		created time.Time
-		updated time.Time
+		plaintext string
+		encrypted *string
+		created   time.Time
+		updated   time.Time
 	)
-	err = row.Scan(&dep.ID, &dep.BankName, &dep.Holder, &dep.HolderType, &dep.Type, &dep.RoutingNumber, &dep.AccountNumber, &dep.Status, &dep.Metadata, &created, &updated)
+	err := row.Scan(&dep.ID, &dep.BankName, &dep.Holder, &dep.HolderType, &dep.Type, &dep.RoutingNumber, &plaintext, &encrypted, &dep.Status, &dep.Metadata, &created, &updated)
 	if err != nil {
 		if strings.Contains(err.Error(), "no rows in result set") {
 			return nil, nil
 		}
 		return nil, err
 	}
+	if encrypted == nil {
+		encrypted = new(string)
+	}
+	dep.AccountNumber, err = decryptAccountNumber(*encrypted, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("depository=%s: %v", dep.ID, err)
+	}
 	dep.Created = base.NewTime(created)
 	dep.Updated = base.NewTime(updated)
 	if dep.ID == "" || dep.BankName == "" {
@@ -609,14 +836,20 @@ func (r *SQLDepositoryRepo) upsertUserDepository(userID string, dep *Depository)
 		dep.Updated = now
 	}
 
-	query := `insert into depositories (depository_id, user_id, bank_name, holder, holder_type, type, routing_number, account_number, status, metadata, created_at, last_updated_at)
-values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	// Account numbers are only stored encrypted, the plaintext column is left empty
+	encrypted, hash, err := encryptAccountNumber(dep.AccountNumber)
+	if err != nil {
+		return fmt.Errorf("upsertUserDepository: depository=%q: %v rollback=%v", dep.ID, err, tx.Rollback())
+	}
+
+	query := `insert into depositories (depository_id, user_id, bank_name, holder, holder_type, type, routing_number, account_number, encrypted_account_number, account_number_hash, status, metadata, created_at, last_updated_at)
+values (?, ?, ?, ?, ?, ?, ?, '', ?, ?, ?, ?, ?, ?);`
 	stmt, err := tx.Prepare(query)
 	if err != nil {
 		return err
 	}
 
-	res, err := stmt.Exec(dep.ID, userID, dep.BankName, dep.Holder, dep.HolderType, dep.Type, dep.RoutingNumber, dep.AccountNumber, dep.Status, dep.Metadata, dep.Created.Time, dep.Updated.Time)
+	res, err := stmt.Exec(dep.ID, userID, dep.BankName, dep.Holder, dep.HolderType, dep.Type, dep.RoutingNumber, encrypted, hash, dep.Status, dep.Metadata, dep.Created.Time, dep.Updated.Time)
 	stmt.Close()
 	if err != nil && !database.UniqueViolation(err) {
 		return fmt.Errorf("problem upserting depository=%q, userID=%q: %v", dep.ID, userID, err)
@@ -635,7 +868,7 @@ values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
 update:
 	query = `update depositories
 set bank_name = ?, holder = ?, holder_type = ?, type = ?, routing_number = ?,
-account_number = ?, status = ?, metadata = ?, last_updated_at = ?
+account_number = '', encrypted_account_number = ?, account_number_hash = ?, status = ?, metadata = ?, last_updated_at = ?
 where depository_id = ? and user_id = ? and deleted_at is null`
 	stmt, err = tx.Prepare(query)
 	if err != nil {
@@ -643,7 +876,7 @@ where depository_id = ? and user_id = ? and deleted_at is null`
 	}
 	_, err = stmt.Exec(
 		dep.BankName, dep.Holder, dep.HolderType, dep.Type, dep.RoutingNumber,
-		dep.AccountNumber, dep.Status, dep.Metadata, time.Now(), dep.ID, userID)
+		encrypted, hash, dep.Status, dep.Metadata, time.Now(), dep.ID, userID)
 	stmt.Close()
 	if err != nil {
 		return fmt.Errorf("upsertUserDepository: exec error=%v rollback=%v", err, tx.Rollback())
@@ -651,6 +884,49 @@ where depository_id = ? and user_id = ? and deleted_at is null`
 	return tx.Commit()
 }
 
+func (r *SQLDepositoryRepo) updateDepositoryAccount(userID string, dep *Depository) error {
+	encrypted, hash, err := encryptAccountNumber(dep.AccountNumber)
+	if err != nil {
+		return fmt.Errorf("updateDepositoryAccount: depository=%q: %v", dep.ID, err)
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	dep.Status = DepositoryUnverified
+	query := `update depositories
+set bank_name = ?, holder = ?, holder_type = ?, type = ?, routing_number = ?,
+account_number = '', encrypted_account_number = ?, account_number_hash = ?, status = ?, metadata = ?, last_updated_at = ?
+where depository_id = ? and user_id = ? and status <> ? and deleted_at is null`
+	res, err := tx.Exec(query,
+		dep.BankName, dep.Holder, dep.HolderType, dep.Type, dep.RoutingNumber,
+		encrypted, hash, dep.Status, dep.Metadata, now, dep.ID, userID, DepositoryLocked)
+	if err != nil {
+		return fmt.Errorf("updateDepositoryAccount: exec error=%v rollback=%v", err, tx.Rollback())
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// The Depository was locked (or removed) after it was read
+		if err := tx.Rollback(); err != nil {
+			return fmt.Errorf("updateDepositoryAccount: rollback=%v", err)
+		}
+		return errDepositoryLocked
+	}
+
+	queries := []string{
+		`update micro_deposits set deleted_at = ? where depository_id = ? and deleted_at is null`,
+		`update micro_deposit_attempts set deleted_at = ? where depository_id = ? and deleted_at is null`,
+	}
+	for i := range queries {
+		if _, err := tx.Exec(queries[i], now, dep.ID); err != nil {
+			return fmt.Errorf("updateDepositoryAccount: exec error=%v rollback=%v", err, tx.Rollback())
+		}
+	}
+	return tx.Commit()
+}
+
 func (r *SQLDepositoryRepo) updateDepositoryStatus(id DepositoryID, status DepositoryStatus) error {
 	query := `update depositories set status = ?, last_updated_at = ? where depository_id = ? and deleted_at is null`
 	stmt, err := r.db.Prepare(query)
@@ -678,3 +954,82 @@ func (r *SQLDepositoryRepo) deleteUserDepository(id DepositoryID, userID string)
 	}
 	return nil
 }
+
+// rotateEncryptionKeys re-wraps every account number with the primary key-encryption key and encrypts account
+// numbers stored before encryption was added. Deleted Depositories are included so older keys can be removed.
+func (r *SQLDepositoryRepo) rotateEncryptionKeys() (int, error) {
+	query := `select depository_id, account_number, encrypted_account_number from depositories`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	type row struct {
+		id                   string
+		plaintext, encrypted string
+	}
+	var depositories []row
+	for rows.Next() {
+		var dep row
+		var encrypted *string
+		if err := rows.Scan(&dep.id, &dep.plaintext, &encrypted); err != nil {
+			return 0, err
+		}
+		if encrypted != nil {
+			dep.encrypted = *encrypted
+		}
+		depositories = append(depositories, dep)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	encrypt, err := r.db.Prepare(`update depositories set account_number = '', encrypted_account_number = ?, account_number_hash = ? where depository_id = ?`)
+	if err != nil {
+		return 0, err
+	}
+	defer encrypt.Close()
+	rewrap, err := r.db.Prepare(`update depositories set encrypted_account_number = ? where depository_id = ?`)
+	if err != nil {
+		return 0, err
+	}
+	defer rewrap.Close()
+
+	rotated := 0
+	for i := range depositories {
+		dep := depositories[i]
+		if dep.encrypted == "" {
+			if dep.plaintext == "" {
+				continue
+			}
+			encrypted, hash, err := encryptAccountNumber(dep.plaintext)
+			if err != nil {
+				return rotated, fmt.Errorf("depository=%s: %v", dep.id, err)
+			}
+			if _, err := encrypt.Exec(encrypted, hash, dep.id); err != nil {
+				return rotated, fmt.Errorf("problem encrypting depository=%s: %v", dep.id, err)
+			}
+		} else {
+			encrypted, changed, err := accountNumberKeeper.Rewrap(dep.encrypted)
+			if err != nil {
+				return rotated, fmt.Errorf("depository=%s: %v", dep.id, err)
+			}
+			if !changed {
+				continue
+			}
+			if _, err := rewrap.Exec(encrypted, dep.id); err != nil {
+				return rotated, fmt.Errorf("problem rotating depository=%s: %v", dep.id, err)
+			}
+		}
+		rotated++
+	}
+	return rotated, nil
+}
@@ -30,6 +30,9 @@ type mockDepositoryRepository struct {
 
 	cur *microDepositCursor
 
+	confirmErr error // returned from confirmMicroDeposits when err is nil
+	attempts   int
+
 	// Updated fields
 	status DepositoryStatus
 }
@@ -51,10 +54,35 @@ func (r *mockDepositoryRepository) getUserDepository(id DepositoryID, userID str
 	return nil, nil
 }
 
+func (r *mockDepositoryRepository) getDepository(id DepositoryID) (*Depository, error) {
+	return r.getUserDepository(id, "")
+}
+
+func (r *mockDepositoryRepository) lookupDepositoryFromReturn(userID string, routingNumber string, accountNumber string) (*Depository, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	for i := range r.depositories {
+		dep := r.depositories[i]
+		if dep.RoutingNumber == routingNumber && strings.TrimSpace(dep.AccountNumber) == strings.TrimSpace(accountNumber) {
+			return dep, nil
+		}
+	}
+	return nil, nil
+}
+
 func (r *mockDepositoryRepository) upsertUserDepository(userID string, dep *Depository) error {
 	return r.err
 }
 
+func (r *mockDepositoryRepository) updateDepositoryAccount(userID string, dep *Depository) error {
+	if r.err == nil {
+		r.attempts = 0
+		r.status = DepositoryUnverified
+	}
+	return r.err
+}
+
 func (r *mockDepositoryRepository) updateDepositoryStatus(id DepositoryID, status DepositoryStatus) error {
 	r.status = status
 	return r.err
@@ -82,10 +110,35 @@ func (r *mockDepositoryRepository) initiateMicroDeposits(id DepositoryID, userID
 	return r.err
 }
 
-func (r *mockDepositoryRepository) confirmMicroDeposits(id DepositoryID, userID string, amounts []Amount) error {
+func (r *mockDepositoryRepository) confirmMicroDeposits(id DepositoryID, userID string, amounts []Amount) (int, error) {
+	if r.err != nil {
+		return r.attempts, r.err
+	}
+	if r.attempts >= microDepositMaxAttempts {
+		r.status = DepositoryLocked
+		return r.attempts, errMicroDepositsLocked
+	}
+	if _, ok := r.confirmErr.(*microDepositGuessError); ok {
+		r.attempts++
+		if r.attempts >= microDepositMaxAttempts {
+			r.status = DepositoryLocked
+		}
+	}
+	return r.attempts, r.confirmErr
+}
+
+func (r *mockDepositoryRepository) resetMicroDeposits(id DepositoryID) error {
+	if r.err == nil {
+		r.attempts = 0
+		r.status = DepositoryUnverified
+	}
 	return r.err
 }
 
+func (r *mockDepositoryRepository) getMergedMicroDeposits(filename string) ([]uploadableMicroDeposit, error) {
+	return nil, r.err
+}
+
 func (r *mockDepositoryRepository) getMicroDepositCursor(batchSize int) *microDepositCursor {
 	return r.cur
 }
@@ -560,7 +613,7 @@ func TestDepositories__HTTPCreate(t *testing.T) {
 		depositoryRepo: repo,
 	}
 	r := mux.NewRouter()
-	router.RegisterRoutes(r, false)
+	router.RegisterRoutes(r)
 
 	req := depositoryRequest{
 		BankName:   "bank",
@@ -607,6 +660,39 @@ func TestDepositories__HTTPCreate(t *testing.T) {
 	if depository.Status != DepositoryUnverified {
 		t.Errorf("unexpected status: %s", depository.Status)
 	}
+	if depository.BankName != "bank" {
+		t.Errorf("unexpected BankName: %s", depository.BankName)
+	}
+
+	// BankName is filled in from the FED
+	req.BankName = ""
+	json.NewEncoder(&body).Encode(req)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, request)
+	w.Flush()
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if err := json.NewDecoder(w.Body).Decode(&depository); err != nil {
+		t.Error(err)
+	}
+	if depository.BankName != "Test Bank" {
+		t.Errorf("unexpected BankName: %s", depository.BankName)
+	}
+
+	// reject routing numbers which aren't ACH participants
+	fedClient.details = &RoutingNumberDetails{RoutingNumber: "121421212", Name: "Test Bank"}
+	json.NewEncoder(&body).Encode(req)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, request)
+	w.Flush()
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
 }
 
 func TestDepositories__HTTPUpdate(t *testing.T) {
@@ -624,7 +710,7 @@ func TestDepositories__HTTPUpdate(t *testing.T) {
 		Type:          Checking,
 		RoutingNumber: "121421212",
 		AccountNumber: "1321",
-		Status:        DepositoryUnverified,
+		Status:        DepositoryVerified,
 		Metadata:      "metadata",
 		Created:       base.NewTime(now),
 		Updated:       base.NewTime(now),
@@ -639,14 +725,20 @@ func TestDepositories__HTTPUpdate(t *testing.T) {
 	accountsClient := &testAccountsClient{}
 	testODFIAccount := makeTestODFIAccount()
 
+	transferRepo := &mockTransferRepository{xfer: &Transfer{ID: TransferID(base.ID()), userID: userID, transactionID: "transaction"}}
+	eventRepo := &SQLEventRepo{db.DB, log.NewNopLogger(), nil}
+
 	router := &DepositoryRouter{
 		logger:         log.NewNopLogger(),
 		odfiAccount:    testODFIAccount,
 		accountsClient: accountsClient,
+		fedClient:      &testFEDClient{},
 		depositoryRepo: repo,
+		transferRepo:   transferRepo,
+		eventRepo:      eventRepo,
 	}
 	r := mux.NewRouter()
-	router.RegisterRoutes(r, false)
+	router.RegisterRoutes(r)
 
 	body := strings.NewReader(`{"accountNumber": "251i5219", "bankName": "bar", "holder": "foo", "holderType": "business", "metadata": "updated"}`)
 	req := httptest.NewRequest("PATCH", fmt.Sprintf("/depositories/%s", dep.ID), body)
@@ -671,6 +763,41 @@ func TestDepositories__HTTPUpdate(t *testing.T) {
 		t.Errorf("unexpected Depository metadata: %s", depository.Metadata)
 	}
 
+	// the account number changed, so pending transfers were failed (and reversed) and an audit event written
+	if transferRepo.status != TransferFailed {
+		t.Errorf("unexpected transfer status: %s", transferRepo.status)
+	}
+	if len(accountsClient.reversedTransactions) != 1 || accountsClient.reversedTransactions[0] != "transaction" {
+		t.Errorf("reversed transactions: %v", accountsClient.reversedTransactions)
+	}
+	events, err := eventRepo.getUserEvents(userID)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("events=%#v error=%v", events, err)
+	}
+	if events[0].Type != DepositoryEvent || !strings.Contains(events[0].Message, "accountNumber: **** -> ****5219") {
+		t.Errorf("unexpected event: %#v", events[0])
+	}
+	if !strings.Contains(events[0].Message, "status: verified -> unverified") || !strings.Contains(events[0].Message, string(transferRepo.xfer.ID)) {
+		t.Errorf("unexpected event: %#v", events[0])
+	}
+
+	// metadata changes don't require verification again
+	transferRepo.status = ""
+	if err := repo.updateDepositoryStatus(dep.ID, DepositoryVerified); err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest("PATCH", fmt.Sprintf("/depositories/%s", dep.ID), strings.NewReader(`{"metadata": "other", "accountNumber": "251i5219"}`))
+	req.Header.Set("x-user-id", userID)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	w.Flush()
+	if err := json.NewDecoder(w.Body).Decode(&depository); err != nil {
+		t.Error(err)
+	}
+	if depository.Status != DepositoryVerified || transferRepo.status != "" {
+		t.Errorf("status=%s transfer status=%s", depository.Status, transferRepo.status)
+	}
+
 	// make another request
 	body = strings.NewReader(`{"routingNumber": "231380104", "type": "savings"}`)
 	req = httptest.NewRequest("PATCH", fmt.Sprintf("/depositories/%s", dep.ID), body)
@@ -689,6 +816,103 @@ func TestDepositories__HTTPUpdate(t *testing.T) {
 	if depository.RoutingNumber != "231380104" {
 		t.Errorf("depository.RoutingNumber=%s", depository.RoutingNumber)
 	}
+	if depository.BankName != "Test Bank" {
+		t.Errorf("depository.BankName=%s", depository.BankName)
+	}
+
+	// routing numbers which aren't ACH participants are rejected
+	router.fedClient = &testFEDClient{details: &RoutingNumberDetails{RoutingNumber: "121042882"}}
+	body = strings.NewReader(`{"routingNumber": "121042882"}`)
+	req = httptest.NewRequest("PATCH", fmt.Sprintf("/depositories/%s", dep.ID), body)
+	req.Header.Set("x-user-id", userID)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	w.Flush()
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+
+	// locked Depositories can't change account details, but other fields can be updated
+	if err := repo.updateDepositoryStatus(dep.ID, DepositoryLocked); err != nil {
+		t.Fatal(err)
+	}
+	for body, code := range map[string]int{`{"type": "checking"}`: http.StatusConflict, `{"metadata": "locked"}`: http.StatusOK} {
+		req = httptest.NewRequest("PATCH", fmt.Sprintf("/depositories/%s", dep.ID), strings.NewReader(body))
+		req.Header.Set("x-user-id", userID)
+
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		w.Flush()
+
+		if w.Code != code {
+			t.Errorf("%s: bogus HTTP status: %d: %s", body, w.Code, w.Body.String())
+		}
+	}
+	if d, err := repo.getUserDepository(dep.ID, userID); err != nil || d.Status != DepositoryLocked || d.Type != Savings {
+		t.Errorf("depository=%#v error=%v", d, err)
+	}
+}
+
+func TestDepositories__updateDepositoryAccount(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *SQLDepositoryRepo) {
+		userID := base.ID()
+		dep := &Depository{
+			ID:            DepositoryID(base.ID()),
+			BankName:      "bank name",
+			Holder:        "holder",
+			HolderType:    Individual,
+			Type:          Checking,
+			RoutingNumber: "121421212",
+			AccountNumber: "1321",
+			Status:        DepositoryVerified,
+			Created:       base.NewTime(time.Now()),
+		}
+		if err := repo.upsertUserDepository(userID, dep); err != nil {
+			t.Fatal(err)
+		}
+		amt, _ := NewAmount("USD", "0.11")
+		if err := repo.initiateMicroDeposits(dep.ID, userID, []microDeposit{{amount: *amt, fileID: base.ID()}}); err != nil {
+			t.Fatal(err)
+		}
+
+		dep.AccountNumber = "4321"
+		if err := repo.updateDepositoryAccount(userID, dep); err != nil {
+			t.Fatal(err)
+		}
+		d, err := repo.getUserDepository(dep.ID, userID)
+		if err != nil || d.Status != DepositoryUnverified || d.AccountNumber != "4321" {
+			t.Errorf("depository=%#v error=%v", d, err)
+		}
+		if mds, err := repo.getMicroDepositsForUser(dep.ID, userID); len(mds) != 0 || err != nil {
+			t.Errorf("micro-deposits=%#v error=%v", mds, err)
+		}
+
+		// locked Depositories are left alone
+		if err := repo.updateDepositoryStatus(dep.ID, DepositoryLocked); err != nil {
+			t.Fatal(err)
+		}
+		dep.AccountNumber = "5321"
+		if err := repo.updateDepositoryAccount(userID, dep); err != errDepositoryLocked {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if d, err := repo.getUserDepository(dep.ID, userID); err != nil || d.Status != DepositoryLocked || d.AccountNumber != "4321" {
+			t.Errorf("depository=%#v error=%v", d, err)
+		}
+	}
+
+	// SQLite tests
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &SQLDepositoryRepo{sqliteDB.DB, log.NewNopLogger()})
+
+	// MySQL tests
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &SQLDepositoryRepo{mysqlDB.DB, log.NewNopLogger()})
 }
 
 func TestDepositories__HTTPGet(t *testing.T) {
@@ -720,7 +944,7 @@ func TestDepositories__HTTPGet(t *testing.T) {
 		depositoryRepo: repo,
 	}
 	r := mux.NewRouter()
-	router.RegisterRoutes(r, false)
+	router.RegisterRoutes(r)
 
 	req := httptest.NewRequest("GET", fmt.Sprintf("/depositories/%s", dep.ID), nil)
 	req.Header.Set("x-user-id", userID)
@@ -743,6 +967,9 @@ func TestDepositories__HTTPGet(t *testing.T) {
 	if depository.Status != DepositoryUnverified {
 		t.Errorf("unexpected status: %s", depository.Status)
 	}
+	if depository.AccountNumber != "****" {
+		t.Errorf("account number wasn't masked: %s", depository.AccountNumber)
+	}
 }
 
 func TestDepositoriesHTTP__delete(t *testing.T) {
@@ -752,7 +979,7 @@ func TestDepositoriesHTTP__delete(t *testing.T) {
 		depositoryRepo: repo,
 	}
 	r := mux.NewRouter()
-	router.RegisterRoutes(r, true) // disable Accounts service calls
+	router.RegisterRoutes(r)
 
 	req := httptest.NewRequest("DELETE", "/depositories/foo", nil)
 	req.Header.Set("x-user-id", "user")
@@ -775,3 +1002,103 @@ func TestDepositoriesHTTP__delete(t *testing.T) {
 		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
 	}
 }
+
+func TestDepositories__lookupDepositoryFromReturn(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *SQLDepositoryRepo) {
+		userID := base.ID()
+		dep := &Depository{
+			ID:            DepositoryID(base.ID()),
+			BankName:      "bank name",
+			Holder:        "holder",
+			HolderType:    Individual,
+			Type:          Checking,
+			RoutingNumber: "121042882",
+			AccountNumber: "151",
+			Status:        DepositoryVerified,
+			Created:       base.NewTime(time.Now()),
+		}
+		if err := repo.upsertUserDepository(userID, dep); err != nil {
+			t.Fatal(err)
+		}
+
+		// the account number is only stored encrypted
+		var plaintext, encrypted, hash string
+		row := repo.db.QueryRow(`select account_number, encrypted_account_number, account_number_hash from depositories where depository_id = ?`, dep.ID)
+		if err := row.Scan(&plaintext, &encrypted, &hash); err != nil {
+			t.Fatal(err)
+		}
+		if plaintext != "" || encrypted == "" || strings.Contains(encrypted, "151") || hash != hashAccountNumber("151") {
+			t.Errorf("plaintext=%q encrypted=%q hash=%q", plaintext, encrypted, hash)
+		}
+
+		// DFIAccountNumber is padded with spaces in ACH files
+		d, err := repo.lookupDepositoryFromReturn(userID, "121042882", "151              ")
+		if err != nil || d == nil {
+			t.Fatalf("depository=%#v error=%v", d, err)
+		}
+		if d.ID != dep.ID || d.AccountNumber != "151" {
+			t.Errorf("unexpected depository: %#v", d)
+		}
+
+		// other account numbers and users don't match
+		if d, err := repo.lookupDepositoryFromReturn(userID, "121042882", "152"); err != nil || d != nil {
+			t.Errorf("depository=%#v error=%v", d, err)
+		}
+		if d, err := repo.lookupDepositoryFromReturn(base.ID(), "121042882", "151"); err != nil || d != nil {
+			t.Errorf("depository=%#v error=%v", d, err)
+		}
+
+		// admin lookup ignores the user
+		if d, err := repo.getDepository(dep.ID); err != nil || d == nil || d.AccountNumber != "151" {
+			t.Errorf("depository=%#v error=%v", d, err)
+		}
+	}
+
+	// SQLite
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &SQLDepositoryRepo{sqliteDB.DB, log.NewNopLogger()})
+
+	// MySQL
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &SQLDepositoryRepo{mysqlDB.DB, log.NewNopLogger()})
+}
+
+func TestDepositories__adminGetDepository(t *testing.T) {
+	id := DepositoryID(base.ID())
+	repo := &mockDepositoryRepository{
+		depositories: []*Depository{
+			{
+				ID:            id,
+				BankName:      "bank name",
+				RoutingNumber: "121042882",
+				AccountNumber: "123456789",
+				Status:        DepositoryVerified,
+			},
+		},
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/depositories/{depositoryId}", getDepository(log.NewNopLogger(), repo))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/depositories/%s", id), nil))
+	w.Flush()
+	if w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"accountNumber":"123456789"`) {
+		t.Errorf("unexpected body: %s", w.Body.String())
+	}
+
+	// not found
+	repo.depositories = nil
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/depositories/%s", id), nil))
+	w.Flush()
+	if w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+}
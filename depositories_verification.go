@@ -0,0 +1,159 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+)
+
+// AccountVerifier is an instant account verification provider. Users link their bank account with the
+// provider (outside of paygate) which hands back a token. The provider exchanges that token for the
+// account details it verified the user owns.
+type AccountVerifier interface {
+	// VerifyAccount returns the account details the provider confirmed are owned by the holder of token.
+	VerifyAccount(requestID, userID string, token string) (*VerifiedAccount, error)
+}
+
+// VerifiedAccount is an account whose ownership was confirmed by an AccountVerifier.
+type VerifiedAccount struct {
+	Holder        string
+	RoutingNumber string
+	AccountNumber string
+}
+
+// matches returns an error if the verified account isn't the Depository's account or the holder names differ.
+func (acct *VerifiedAccount) matches(dep *Depository) error {
+	if acct == nil || dep == nil {
+		return errors.New("missing verified account or depository")
+	}
+	if acct.RoutingNumber != dep.RoutingNumber || acct.AccountNumber != dep.AccountNumber {
+		return errors.New("verified account does not match depository")
+	}
+	if normalizeHolderName(acct.Holder) != normalizeHolderName(dep.Holder) {
+		return errors.New("verified account holder does not match depository")
+	}
+	return nil
+}
+
+func normalizeHolderName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// NewAccountVerifier returns the AccountVerifier for provider, a nil AccountVerifier is returned if
+// provider is empty which disables instant account verification.
+//
+// The mock provider verifies any account it's handed, so it's refused unless allowMock is set.
+func NewAccountVerifier(logger log.Logger, provider string, allowMock bool) (AccountVerifier, error) {
+	switch strings.ToLower(provider) {
+	case "":
+		return nil, nil
+	case "mock":
+		if !allowMock {
+			return nil, errors.New("mock account verification provider verifies any account, set ACCOUNT_VERIFICATION_ALLOW_MOCK=yes to use it for development or testing")
+		}
+		logger.Log("depositories", "WARNING: using mock account verification provider, any account can be verified. Never use in production.")
+		return &mockAccountVerifier{}, nil
+	}
+	return nil, fmt.Errorf("unknown account verification provider %q", provider)
+}
+
+// mockAccountVerifier is an AccountVerifier which runs locally for development and testing.
+//
+// Tokens are the account details the provider would have verified: "routingNumber:accountNumber:holder"
+type mockAccountVerifier struct{}
+
+func (v *mockAccountVerifier) VerifyAccount(requestID, userID string, token string) (*VerifiedAccount, error) {
+	parts := strings.SplitN(token, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return nil, errors.New("mock: invalid token")
+	}
+	return &VerifiedAccount{
+		RoutingNumber: parts[0],
+		AccountNumber: parts[1],
+		Holder:        parts[2],
+	}, nil
+}
+
+type verifyDepositoryRequest struct {
+	Token string `json:"token"`
+}
+
+// verifyDepository confirms ownership of a Depository with the configured AccountVerifier and if successful
+// changes the Depository status to DepositoryVerified.
+func (r *DepositoryRouter) verifyDepository() http.HandlerFunc {
+	return func(w http.ResponseWriter, httpReq *http.Request) {
+		w, err := wrapResponseWriter(r.logger, w, httpReq)
+		if err != nil {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		requestID, userID := moovhttp.GetRequestID(httpReq), moovhttp.GetUserID(httpReq)
+		if r.accountVerifier == nil {
+			moovhttp.Problem(w, errors.New("account verification is disabled"))
+			return
+		}
+
+		var req verifyDepositoryRequest
+		if err := json.NewDecoder(io.LimitReader(httpReq.Body, maxReadBytes)).Decode(&req); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if req.Token == "" {
+			moovhttp.Problem(w, errors.New("missing token"))
+			return
+		}
+
+		id := getDepositoryID(httpReq)
+		dep, err := r.depositoryRepo.getUserDepository(id, userID)
+		if err != nil {
+			r.logger.Log("verifyDepository", err, "requestID", requestID, "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		if dep == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if dep.Status != DepositoryUnverified {
+			err = fmt.Errorf("depository %s in bogus status %s", dep.ID, dep.Status)
+			r.logger.Log("verifyDepository", err, "requestID", requestID, "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		acct, err := r.accountVerifier.VerifyAccount(requestID, userID, req.Token)
+		if err != nil {
+			r.logger.Log("verifyDepository", fmt.Sprintf("problem verifying depository=%s: %v", dep.ID, err), "requestID", requestID, "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		if err := acct.matches(dep); err != nil {
+			r.logger.Log("verifyDepository", fmt.Sprintf("depository=%s: %v", dep.ID, err), "requestID", requestID, "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		if err := r.depositoryRepo.updateDepositoryStatus(dep.ID, DepositoryVerified); err != nil {
+			r.logger.Log("verifyDepository", fmt.Sprintf("problem marking depository as Verified: %v", err), "requestID", requestID, "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		dep.Status = DepositoryVerified
+		r.logger.Log("verifyDepository", fmt.Sprintf("verified depository=%s", dep.ID), "requestID", requestID, "userID", userID)
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(dep)
+	}
+}
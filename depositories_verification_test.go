@@ -0,0 +1,136 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestAccountVerifier__new(t *testing.T) {
+	logger := log.NewNopLogger()
+
+	if v, err := NewAccountVerifier(logger, "", false); v != nil || err != nil {
+		t.Errorf("verifier=%#v error=%v", v, err)
+	}
+	if v, err := NewAccountVerifier(logger, "Mock", true); v == nil || err != nil {
+		t.Errorf("verifier=%#v error=%v", v, err)
+	}
+	if v, err := NewAccountVerifier(logger, "mock", false); v != nil || err == nil {
+		t.Errorf("expected error, verifier=%#v", v)
+	}
+	if _, err := NewAccountVerifier(logger, "other", true); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestAccountVerifier__mock(t *testing.T) {
+	v := &mockAccountVerifier{}
+
+	acct, err := v.VerifyAccount(base.ID(), base.ID(), "121042882:151:John Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acct.RoutingNumber != "121042882" || acct.AccountNumber != "151" || acct.Holder != "John Doe" {
+		t.Errorf("unexpected account: %#v", acct)
+	}
+
+	for _, token := range []string{"", "foo", "121042882::John Doe"} {
+		if _, err := v.VerifyAccount(base.ID(), base.ID(), token); err == nil {
+			t.Errorf("%q: expected error", token)
+		}
+	}
+}
+
+func TestVerifiedAccount__matches(t *testing.T) {
+	dep := &Depository{Holder: "John Doe", RoutingNumber: "121042882", AccountNumber: "151"}
+
+	acct := &VerifiedAccount{Holder: "  john   DOE", RoutingNumber: "121042882", AccountNumber: "151"}
+	if err := acct.matches(dep); err != nil {
+		t.Error(err)
+	}
+
+	acct.Holder = "Jane Doe"
+	if err := acct.matches(dep); err == nil {
+		t.Error("expected error")
+	}
+
+	acct.Holder, acct.AccountNumber = "John Doe", "152"
+	if err := acct.matches(dep); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestDepositories__verifyDepository(t *testing.T) {
+	id, userID := DepositoryID(base.ID()), base.ID()
+	depRepo := &mockDepositoryRepository{
+		depositories: []*Depository{
+			{
+				ID:            id,
+				Holder:        "John Doe",
+				RoutingNumber: "121042882",
+				AccountNumber: "151",
+				Status:        DepositoryUnverified,
+			},
+		},
+	}
+	router := &DepositoryRouter{
+		logger:          log.NewNopLogger(),
+		accountVerifier: &mockAccountVerifier{},
+		depositoryRepo:  depRepo,
+	}
+	r := mux.NewRouter()
+	router.RegisterRoutes(r)
+
+	verify := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", fmt.Sprintf("/depositories/%s/verify", id), strings.NewReader(body))
+		req.Header.Set("x-user-id", userID)
+		r.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	// holder doesn't match
+	if w := verify(`{"token": "121042882:151:Jane Doe"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status %d: %s", w.Code, w.Body.String())
+	}
+	if depRepo.status != "" {
+		t.Errorf("unexpected status: %s", depRepo.status)
+	}
+
+	// missing token
+	if w := verify(`{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status %d: %s", w.Code, w.Body.String())
+	}
+
+	w := verify(`{"token": "121042882:151:John Doe"}`)
+	if w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status %d: %s", w.Code, w.Body.String())
+	}
+	if depRepo.status != DepositoryVerified || !strings.Contains(w.Body.String(), `"status":"verified"`) {
+		t.Errorf("status=%s body=%s", depRepo.status, w.Body.String())
+	}
+
+	// not found
+	depRepo.depositories = nil
+	if w := verify(`{"token": "121042882:151:John Doe"}`); w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status %d: %s", w.Code, w.Body.String())
+	}
+
+	// disabled
+	router.accountVerifier = nil
+	if w := verify(`{"token": "121042882:151:John Doe"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status %d: %s", w.Code, w.Body.String())
+	}
+}
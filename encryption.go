@@ -0,0 +1,165 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/moov-io/base/admin"
+	moovhttp "github.com/moov-io/base/http"
+	"github.com/moov-io/paygate/internal/secrets"
+
+	"github.com/go-kit/kit/log"
+)
+
+const (
+	// developmentEncryptionKeys and developmentHashKey are used when ENCRYPTION_KEYS and ENCRYPTION_HASH_KEY
+	// aren't set and ENCRYPTION_DEVELOPMENT_KEYS=yes. They are public and must not be used in production.
+	developmentEncryptionKeys = "dev:bW9vdi1pby1wYXlnYXRlLWRldmVsb3BtZW50LWtleSE="
+	developmentHashKey        = "bW9vdi1pby1wYXlnYXRlLWRldmVsb3BtZW50LWhhc2g="
+)
+
+// accountNumberKeeper encrypts account numbers (and Originator Identification values) before they're stored.
+var accountNumberKeeper = func() *secrets.Keeper {
+	k, err := secrets.NewKeeper(developmentEncryptionKeys, developmentHashKey)
+	if err != nil {
+		panic(fmt.Sprintf("development encryption keys: %v", err))
+	}
+	return k
+}()
+
+// SetupEncryptionKeys configures the key-encryption keys (KEK) used to encrypt account numbers stored in the database.
+//
+// keys is a comma separated list of keyID:base64(key) values where the first key encrypts new values and the others
+// are kept to decrypt values until they're rotated. hashKey is used for deterministic hashes of account numbers so
+// they can be looked up without decrypting every row. Changing hashKey requires every value be hashed again.
+//
+// An error is returned when keys and hashKey are empty unless allowDevelopmentKeys is true, in which case the
+// public development keys are used.
+func SetupEncryptionKeys(logger log.Logger, keys string, hashKey string, allowDevelopmentKeys bool) error {
+	if keys == "" && hashKey == "" {
+		if !allowDevelopmentKeys {
+			return errors.New("ENCRYPTION_KEYS and ENCRYPTION_HASH_KEY are required, set ENCRYPTION_DEVELOPMENT_KEYS=yes to use insecure development keys")
+		}
+		logger.Log("encryption", "WARNING: using development encryption keys, set ENCRYPTION_KEYS and ENCRYPTION_HASH_KEY")
+		return nil
+	}
+	k, err := secrets.NewKeeper(keys, hashKey)
+	if err != nil {
+		return err
+	}
+	logger.Log("encryption", fmt.Sprintf("encrypting account numbers with key %s", k.PrimaryKeyID()))
+	accountNumberKeeper = k
+	return nil
+}
+
+// encryptAccountNumber returns the encrypted form and lookup hash of an account number.
+func encryptAccountNumber(accountNumber string) (string, string, error) {
+	encrypted, err := accountNumberKeeper.Encrypt(accountNumber)
+	if err != nil {
+		return "", "", fmt.Errorf("problem encrypting account number: %v", err)
+	}
+	return encrypted, hashAccountNumber(accountNumber), nil
+}
+
+func hashAccountNumber(accountNumber string) string {
+	return accountNumberKeeper.Hash(strings.TrimSpace(accountNumber))
+}
+
+// decryptAccountNumber returns the plaintext of an encrypted column. Rows written before encryption was added
+// only have the plaintext column, which is returned instead.
+func decryptAccountNumber(encrypted string, plaintext string) (string, error) {
+	if encrypted == "" {
+		return plaintext, nil
+	}
+	return accountNumberKeeper.Decrypt(encrypted)
+}
+
+// encryptFile returns the encrypted contents of an ACH file so files kept on disk (i.e. merged files) don't hold
+// account numbers in the clear.
+func encryptFile(contents []byte) ([]byte, error) {
+	encrypted, err := accountNumberKeeper.Encrypt(string(contents))
+	if err != nil {
+		return nil, fmt.Errorf("problem encrypting file: %v", err)
+	}
+	return []byte(encrypted), nil
+}
+
+// decryptFile returns the plaintext of contents from encryptFile. Files which aren't encrypted (downloaded files
+// or merged files written before encryption was added) are returned as-is.
+func decryptFile(contents []byte) ([]byte, error) {
+	if !secrets.Encrypted(string(contents)) {
+		return contents, nil
+	}
+	plaintext, err := accountNumberKeeper.Decrypt(string(contents))
+	if err != nil {
+		return nil, fmt.Errorf("problem decrypting file: %v", err)
+	}
+	return []byte(plaintext), nil
+}
+
+// maskAccountNumber replaces all but the last four characters of an account number with '*'.
+func maskAccountNumber(s string) string {
+	if len(s) <= 4 {
+		return strings.Repeat("*", len(s))
+	}
+	return strings.Repeat("*", len(s)-4) + s[len(s)-4:]
+}
+
+// keyRotator re-encrypts stored values with the primary key-encryption key, returning how many rows changed.
+type keyRotator interface {
+	rotateEncryptionKeys() (int, error)
+}
+
+func AddEncryptionKeyRoutes(logger log.Logger, svc *admin.Server, depRepo *SQLDepositoryRepo, originatorRepo *SQLOriginatorRepo, webhookRepo *SQLWebhookRepo) {
+	svc.AddHandler("/keys/rotate", rotateEncryptionKeys(logger, map[string]keyRotator{
+		"depositories": depRepo,
+		"originators":  originatorRepo,
+		"webhooks":     webhookRepo,
+	}))
+}
+
+type rotateEncryptionKeysResponse struct {
+	KeyID   string         `json:"keyId"`
+	Rotated map[string]int `json:"rotated"`
+}
+
+// rotateEncryptionKeys is an http.HandlerFunc for paygate's admin server to re-wrap every stored value under the primary
+// key-encryption key. Plaintext values from before encryption was added are encrypted. After this completes older keys can
+// be removed from ENCRYPTION_KEYS.
+func rotateEncryptionKeys(logger log.Logger, rotators map[string]keyRotator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w = wrap(logger, w, r)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		if r.Method != "POST" {
+			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb: %s", r.Method))
+			return
+		}
+
+		requestID := moovhttp.GetRequestID(r)
+		resp := rotateEncryptionKeysResponse{
+			KeyID:   accountNumberKeeper.PrimaryKeyID(),
+			Rotated: make(map[string]int),
+		}
+		for name, rotator := range rotators {
+			n, err := rotator.rotateEncryptionKeys()
+			if err != nil {
+				logger.Log("encryption", fmt.Sprintf("admin: problem rotating %s encryption keys: %v", name, err), "requestID", requestID)
+				moovhttp.Problem(w, err)
+				return
+			}
+			resp.Rotated[name] = n
+		}
+		logger.Log("encryption", fmt.Sprintf("admin: rotated encryption keys to %s: %v", resp.KeyID, resp.Rotated), "requestID", requestID)
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
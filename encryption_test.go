@@ -0,0 +1,238 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moov-io/base"
+	"github.com/moov-io/paygate/internal/database"
+	"github.com/moov-io/paygate/internal/secrets"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// setupTestEncryptionKeys replaces accountNumberKeeper, callers should defer the returned func to restore it
+func setupTestEncryptionKeys(t *testing.T, keys string) func() {
+	t.Helper()
+
+	hashKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{9}, 32))
+	k, err := secrets.NewKeeper(keys, hashKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := accountNumberKeeper
+	accountNumberKeeper = k
+	return func() {
+		accountNumberKeeper = prev
+	}
+}
+
+func testEncryptionKey(id string, b byte) string {
+	return id + ":" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func TestEncryption__setup(t *testing.T) {
+	defer setupTestEncryptionKeys(t, developmentEncryptionKeys)()
+
+	logger := log.NewNopLogger()
+	if err := SetupEncryptionKeys(logger, "", "", false); err == nil {
+		t.Error("development keys need to be allowed")
+	}
+	if err := SetupEncryptionKeys(logger, "", "", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetupEncryptionKeys(logger, "foo", "", true); err == nil {
+		t.Error("expected error")
+	}
+	hashKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{9}, 32))
+	if err := SetupEncryptionKeys(logger, testEncryptionKey("other", 1), hashKey, false); err != nil {
+		t.Fatal(err)
+	}
+	if id := accountNumberKeeper.PrimaryKeyID(); id != "other" {
+		t.Errorf("unexpected key: %s", id)
+	}
+}
+
+func TestEncryption__maskAccountNumber(t *testing.T) {
+	cases := map[string]string{
+		"":          "",
+		"151":       "***",
+		"1234":      "****",
+		"123456789": "*****6789",
+	}
+	for input, expected := range cases {
+		if v := maskAccountNumber(input); v != expected {
+			t.Errorf("%q: got %q", input, v)
+		}
+	}
+}
+
+func TestEncryption__files(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "encryption-files")
+	defer os.RemoveAll(dir)
+
+	// plaintext files (i.e. downloaded from the ODFI) are read as-is
+	file, err := parseACHFilepath(filepath.Join("testdata", "ppd-debit.ach"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	accountNumber := file.Batches[0].GetEntries()[0].DFIAccountNumber
+
+	f := &achFile{File: file, filepath: filepath.Join(dir, "merged.ach")}
+	if err := f.write(); err != nil {
+		t.Fatal(err)
+	}
+	bs, err := ioutil.ReadFile(f.filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !secrets.Encrypted(string(bs)) || bytes.Contains(bs, []byte(accountNumber)) {
+		t.Errorf("merged file isn't encrypted: %s", string(bs))
+	}
+
+	file, err = parseACHFilepath(f.filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := file.Batches[0].GetEntries()[0].DFIAccountNumber; v != accountNumber {
+		t.Errorf("DFIAccountNumber=%q", v)
+	}
+}
+
+func TestEncryption__JSON(t *testing.T) {
+	dep := &Depository{ID: DepositoryID(base.ID()), AccountNumber: "123456789"}
+	bs, err := json.Marshal(dep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(bs), `"accountNumber":"*****6789"`) {
+		t.Errorf("unexpected JSON: %s", string(bs))
+	}
+	if dep.AccountNumber != "123456789" {
+		t.Errorf("AccountNumber was modified: %s", dep.AccountNumber)
+	}
+	bs, _ = json.Marshal((*unmaskedDepository)(dep))
+	if !strings.Contains(string(bs), `"accountNumber":"123456789"`) {
+		t.Errorf("unexpected JSON: %s", string(bs))
+	}
+
+	orig := Originator{ID: OriginatorID(base.ID()), Identification: "987654321"}
+	bs, _ = json.Marshal([]Originator{orig})
+	if !strings.Contains(string(bs), `"identification":"*****4321"`) {
+		t.Errorf("unexpected JSON: %s", string(bs))
+	}
+}
+
+func TestEncryption__rotateEncryptionKeys(t *testing.T) {
+	defer setupTestEncryptionKeys(t, testEncryptionKey("one", 1))()
+
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	logger := log.NewNopLogger()
+	depRepo, origRepo := &SQLDepositoryRepo{db.DB, logger}, NewOriginatorRepo(logger, db.DB)
+
+	userID := base.ID()
+	dep := &Depository{
+		ID:            DepositoryID(base.ID()),
+		BankName:      "bank name",
+		Holder:        "holder",
+		HolderType:    Individual,
+		Type:          Checking,
+		RoutingNumber: "121042882",
+		AccountNumber: "151",
+		Status:        DepositoryVerified,
+		Created:       base.NewTime(time.Now()),
+	}
+	if err := depRepo.upsertUserDepository(userID, dep); err != nil {
+		t.Fatal(err)
+	}
+	orig, err := origRepo.createUserOriginator(userID, originatorRequest{DefaultDepository: dep.ID, Identification: "123456789"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// write a Depository from before encryption was added
+	legacyID := DepositoryID(base.ID())
+	query := `insert into depositories (depository_id, user_id, bank_name, holder, holder_type, type, routing_number, account_number, status, metadata, created_at, last_updated_at)
+values (?, ?, 'bank', 'holder', 'individual', 'checking', '121042882', '456', 'verified', '', ?, ?)`
+	if _, err := db.DB.Exec(query, legacyID, userID, time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// rotate to a new key, keeping the old one to decrypt
+	defer setupTestEncryptionKeys(t, testEncryptionKey("two", 2)+","+testEncryptionKey("one", 1))()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/keys/rotate", rotateEncryptionKeys(logger, map[string]keyRotator{
+		"depositories": depRepo,
+		"originators":  origRepo,
+	}))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/keys/rotate", nil))
+	w.Flush()
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var resp rotateEncryptionKeysResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.KeyID != "two" || resp.Rotated["depositories"] != 2 || resp.Rotated["originators"] != 1 {
+		t.Errorf("unexpected response: %#v", resp)
+	}
+
+	// nothing is left in plaintext
+	var plaintext, encrypted string
+	row := db.DB.QueryRow(`select account_number, encrypted_account_number from depositories where depository_id = ?`, legacyID)
+	if err := row.Scan(&plaintext, &encrypted); err != nil {
+		t.Fatal(err)
+	}
+	if plaintext != "" || !strings.HasPrefix(encrypted, "v1:two:") {
+		t.Errorf("plaintext=%q encrypted=%q", plaintext, encrypted)
+	}
+
+	// drop the old key and read everything back
+	defer setupTestEncryptionKeys(t, testEncryptionKey("two", 2))()
+	for id, accountNumber := range map[DepositoryID]string{dep.ID: "151", legacyID: "456"} {
+		d, err := depRepo.getUserDepository(id, userID)
+		if err != nil || d == nil {
+			t.Fatalf("depository=%#v error=%v", d, err)
+		}
+		if d.AccountNumber != accountNumber {
+			t.Errorf("got %q", d.AccountNumber)
+		}
+	}
+	o, err := origRepo.getUserOriginator(orig.ID, userID)
+	if err != nil || o.Identification != "123456789" {
+		t.Errorf("originator=%#v error=%v", o, err)
+	}
+
+	// the legacy Depository can be found by its hash
+	d, err := depRepo.lookupDepositoryFromReturn(userID, "121042882", "456              ")
+	if err != nil || d == nil || d.ID != legacyID {
+		t.Errorf("depository=%#v error=%v", d, err)
+	}
+
+	// rotating again is a no-op
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/keys/rotate", nil))
+	w.Flush()
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Rotated["depositories"] != 0 {
+		t.Errorf("resp=%#v error=%v", resp, err)
+	}
+}
@@ -7,9 +7,13 @@ package paygate
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/moov-io/base"
 	moovhttp "github.com/moov-io/base/http"
 
 	"github.com/go-kit/kit/log"
@@ -24,25 +28,118 @@ type Event struct {
 	Message string    `json:"message"`
 	Type    EventType `json:"type"`
 
-	// TODO(adam): We might need to inspect/filter events by metadata
-	// map[string]string "transferId" -> "..."
+	// Payload is a structured copy of what changed, often the resource itself.
+	Payload json.RawMessage `json:"payload,omitempty"`
+
+	// Metadata references the resources this Event is about (e.g. "transferId" -> "...").
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	Created base.Time `json:"created"`
 }
 
 type EventType string
 
 const (
-	// TODO(adam): more EventType values?
-	// ReceiverEvent   EventType = "Receiver"
-	// DepositoryEvent EventType = "Depository"
-	// OriginatorEvent EventType = "Originator"
-	TransferEvent EventType = "Transfer"
+	OriginatorEvent   EventType = "Originator"
+	DepositoryEvent   EventType = "Depository"
+	ReceiverEvent     EventType = "Receiver"
+	TransferEvent     EventType = "Transfer"
+	MicroDepositEvent EventType = "MicroDeposit"
+	FileEvent         EventType = "File"
+	ReturnEvent       EventType = "Return"
 )
 
+func (t EventType) validate() error {
+	switch t {
+	case OriginatorEvent, DepositoryEvent, ReceiverEvent, TransferEvent, MicroDepositEvent, FileEvent, ReturnEvent:
+		return nil
+	default:
+		return fmt.Errorf("EventType(%s) is invalid", t)
+	}
+}
+
+// Metadata keys which reference the resource an Event is about.
+const (
+	eventTransferID     = "transferId"
+	eventDepositoryID   = "depositoryId"
+	eventReceiverID     = "receiverId"
+	eventOriginatorID   = "originatorId"
+	eventFileID         = "fileId"
+	eventMicroDepositID = "microDepositId"
+)
+
+// eventPayload encodes v as an Event's Payload. A value which can't be encoded is left off the Event.
+func eventPayload(v interface{}) json.RawMessage {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return json.RawMessage(bs)
+}
+
 func AddEventRoutes(logger log.Logger, r *mux.Router, eventRepo EventRepository) {
 	r.Methods("GET").Path("/events").HandlerFunc(getUserEvents(logger, eventRepo))
+	r.Methods("GET").Path("/events/stream").HandlerFunc(streamUserEvents(logger, eventRepo, eventStreams, eventStreamPollInterval, eventStreamDuration))
 	r.Methods("GET").Path("/events/{eventID}").HandlerFunc(getEventHandler(logger, eventRepo))
 }
 
+// eventSearchParams filters and paginates a user's Events, newest first.
+type eventSearchParams struct {
+	Type       EventType
+	ResourceID string
+	StartDate  time.Time
+	EndDate    time.Time
+	Offset     int
+	Limit      int
+}
+
+// readEventSearchParams reads the type, resourceId, startDate, endDate, offset and limit query parameters.
+func readEventSearchParams(r *http.Request) (eventSearchParams, error) {
+	params := eventSearchParams{Limit: 25}
+	q := r.URL.Query()
+	if v := q.Get("type"); v != "" {
+		params.Type = EventType(v)
+		if err := params.Type.validate(); err != nil {
+			return params, err
+		}
+	}
+	params.ResourceID = strings.TrimSpace(q.Get("resourceId"))
+	for _, d := range []struct {
+		name string
+		t    *time.Time
+	}{{"startDate", &params.StartDate}, {"endDate", &params.EndDate}} {
+		v := q.Get(d.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			if t, err = time.Parse("2006-01-02", v); err != nil {
+				return params, fmt.Errorf("invalid %s: %q", d.name, v)
+			}
+		}
+		*d.t = t
+	}
+	if !params.StartDate.IsZero() && !params.EndDate.IsZero() && params.EndDate.Before(params.StartDate) {
+		return params, fmt.Errorf("endDate %v is before startDate %v", params.EndDate, params.StartDate)
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return params, fmt.Errorf("invalid offset: %q", v)
+		}
+		params.Offset = n
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 100 {
+			return params, fmt.Errorf("invalid limit: %q", v)
+		}
+		params.Limit = n
+	}
+	return params, nil
+}
+
 func getUserEvents(logger log.Logger, eventRepo EventRepository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w, err := wrapResponseWriter(logger, w, r)
@@ -50,14 +147,22 @@ func getUserEvents(logger log.Logger, eventRepo EventRepository) http.HandlerFun
 			return
 		}
 
+		params, err := readEventSearchParams(r)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+
 		userID := moovhttp.GetUserID(r)
-		events, err := eventRepo.getUserEvents(userID)
+		events, total, err := eventRepo.searchEvents(userID, params)
 		if err != nil {
+			logger.Log("events", fmt.Sprintf("problem searching events: %v", err), "requestID", moovhttp.GetRequestID(r), "userID", userID)
 			moovhttp.Problem(w, err)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(events)
 	}
@@ -82,6 +187,10 @@ func getEventHandler(logger log.Logger, eventRepo EventRepository) http.HandlerF
 			moovhttp.Problem(w, err)
 			return
 		}
+		if event == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		w.WriteHeader(http.StatusOK)
@@ -103,18 +212,27 @@ type EventRepository interface {
 	getEvent(eventID EventID, userID string) (*Event, error)
 	getUserEvents(userID string) ([]*Event, error)
 
+	// searchEvents returns a page of the user's Events matching params along with how many Events matched in total.
+	searchEvents(userID string, params eventSearchParams) ([]*Event, int, error)
+
+	// getEventsSince returns the user's Events created at or after since, oldest first.
+	getEventsSince(userID string, since time.Time, limit int) ([]*Event, error)
+
 	writeEvent(userID string, event *Event) error
 
 	getUserTransferEvents(userID string, transferID TransferID) ([]*Event, error)
 }
 
-func NewEventRepo(logger log.Logger, db *sql.DB) *SQLEventRepo {
-	return &SQLEventRepo{log: logger, db: db}
+// NewEventRepo returns a SQLEventRepo which queues each Event it writes for the user's webhooks in webhookRepo.
+func NewEventRepo(logger log.Logger, db *sql.DB, webhookRepo webhookRepository) *SQLEventRepo {
+	return &SQLEventRepo{log: logger, db: db, webhookRepo: webhookRepo}
 }
 
 type SQLEventRepo struct {
 	db  *sql.DB
 	log log.Logger
+
+	webhookRepo webhookRepository
 }
 
 func (r *SQLEventRepo) Close() error {
@@ -122,22 +240,64 @@ func (r *SQLEventRepo) Close() error {
 }
 
 func (r *SQLEventRepo) writeEvent(userID string, event *Event) error {
-	query := `insert into events (event_id, user_id, topic, message, type, created_at) values (?, ?, ?, ?, ?, ?)`
-	stmt, err := r.db.Prepare(query)
+	if event.Created.IsZero() {
+		event.Created = base.NewTime(time.Now())
+	}
+	var payload *string
+	if len(event.Payload) > 0 {
+		p := string(event.Payload)
+		payload = &p
+	}
+
+	tx, err := r.db.Begin()
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(event.ID, userID, event.Topic, event.Message, event.Type, time.Now())
+	query := `insert into events (event_id, user_id, topic, message, type, payload, created_at) values (?, ?, ?, ?, ?, ?, ?)`
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("writeEvent: prepare: %v: rollback=%v", err, tx.Rollback())
+	}
+	_, err = stmt.Exec(event.ID, userID, event.Topic, event.Message, event.Type, payload, event.Created.Time)
+	stmt.Close()
 	if err != nil {
+		return fmt.Errorf("writeEvent: insert: %v: rollback=%v", err, tx.Rollback())
+	}
+
+	if len(event.Metadata) > 0 {
+		query = `insert into event_metadata (event_id, user_id, name, value) values (?, ?, ?, ?)`
+		stmt, err = tx.Prepare(query)
+		if err != nil {
+			return fmt.Errorf("writeEvent: prepare metadata: %v: rollback=%v", err, tx.Rollback())
+		}
+		for k, v := range event.Metadata {
+			if v == "" {
+				continue
+			}
+			if _, err := stmt.Exec(event.ID, userID, k, v); err != nil {
+				stmt.Close()
+				return fmt.Errorf("writeEvent: insert metadata: %v: rollback=%v", err, tx.Rollback())
+			}
+		}
+		stmt.Close()
+	}
+
+	// Queue the event for each of the user's webhooks, WebhookDispatcher sends them.
+	if r.webhookRepo != nil {
+		if err := r.webhookRepo.enqueueDeliveries(tx, userID, event); err != nil {
+			return fmt.Errorf("writeEvent: problem queueing webhooks: %v: rollback=%v", err, tx.Rollback())
+		}
+	}
+	if err := tx.Commit(); err != nil {
 		return err
 	}
+	eventStreams.publish(userID, event)
 	return nil
 }
 
 func (r *SQLEventRepo) getEvent(eventID EventID, userID string) (*Event, error) {
-	query := `select event_id, topic, message, type from events
+	query := `select event_id, topic, message, type, payload, created_at from events
 where event_id = ? and user_id = ?
 limit 1`
 	stmt, err := r.db.Prepare(query)
@@ -148,26 +308,70 @@ limit 1`
 
 	row := stmt.QueryRow(eventID, userID)
 
-	event := &Event{}
-	err = row.Scan(&event.ID, &event.Topic, &event.Message, &event.Type)
+	var (
+		event   = &Event{}
+		payload *string
+		created *time.Time
+	)
+	err = row.Scan(&event.ID, &event.Topic, &event.Message, &event.Type, &payload, &created)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
 		return nil, err
 	}
 	if event.ID == "" {
 		return nil, nil // event not found
 	}
+	if payload != nil && *payload != "" {
+		event.Payload = json.RawMessage(*payload)
+	}
+	if created != nil {
+		event.Created = base.NewTime(*created)
+	}
+	if event.Metadata, err = r.getEventMetadata(event.ID); err != nil {
+		return nil, err
+	}
 	return event, nil
 }
 
-func (r *SQLEventRepo) getUserEvents(userID string) ([]*Event, error) {
-	query := `select event_id from events where user_id = ?`
+func (r *SQLEventRepo) getEventMetadata(eventID EventID) (map[string]string, error) {
+	query := `select name, value from event_metadata where event_id = ?`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metadata map[string]string
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, err
+		}
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[k] = v
+	}
+	return metadata, rows.Err()
+}
+
+// getEvents reads each Event from a query which selects event_id.
+func (r *SQLEventRepo) getEvents(userID string, query string, args ...interface{}) ([]*Event, error) {
 	stmt, err := r.db.Prepare(query)
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(userID)
+	rows, err := stmt.Query(args...)
 	if err != nil {
 		return nil, err
 	}
@@ -181,6 +385,11 @@ func (r *SQLEventRepo) getUserEvents(userID string) ([]*Event, error) {
 			eventIDs = append(eventIDs, row)
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
 	var events []*Event
 	for i := range eventIDs {
 		event, err := r.getEvent(EventID(eventIDs[i]), userID)
@@ -188,11 +397,58 @@ func (r *SQLEventRepo) getUserEvents(userID string) ([]*Event, error) {
 			events = append(events, event)
 		}
 	}
-	return events, rows.Err()
+	return events, nil
+}
+
+func (r *SQLEventRepo) getUserEvents(userID string) ([]*Event, error) {
+	return r.getEvents(userID, `select event_id from events where user_id = ? order by created_at asc`, userID)
+}
+
+func (r *SQLEventRepo) searchEvents(userID string, params eventSearchParams) ([]*Event, int, error) {
+	where, args := []string{"user_id = ?"}, []interface{}{userID}
+	if params.Type != "" {
+		where = append(where, "type = ?")
+		args = append(args, params.Type)
+	}
+	if params.ResourceID != "" {
+		where = append(where, "event_id in (select event_id from event_metadata where user_id = ? and value = ?)")
+		args = append(args, userID, params.ResourceID)
+	}
+	if !params.StartDate.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, params.StartDate)
+	}
+	if !params.EndDate.IsZero() {
+		where = append(where, "created_at <= ?")
+		args = append(args, params.EndDate)
+	}
+	conditions := strings.Join(where, " and ")
+
+	stmt, err := r.db.Prepare(`select count(*) from events where ` + conditions)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer stmt.Close()
+
+	var total int
+	if err := stmt.QueryRow(args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `select event_id from events where ` + conditions + ` order by created_at desc limit ? offset ?`
+	events, err := r.getEvents(userID, query, append(args, params.Limit, params.Offset)...)
+	return events, total, err
+}
+
+func (r *SQLEventRepo) getEventsSince(userID string, since time.Time, limit int) ([]*Event, error) {
+	query := `select event_id from events where user_id = ? and created_at >= ? order by created_at asc limit ?`
+	return r.getEvents(userID, query, userID, since, limit)
 }
 
 func (r *SQLEventRepo) getUserTransferEvents(userID string, id TransferID) ([]*Event, error) {
-	// TODO(adam): need to store transferID alongside in some arbitrary json
-	// Scan on Type == TransferEvent ?
-	return nil, nil
+	query := `select e.event_id from events as e
+inner join event_metadata as m on e.event_id = m.event_id
+where e.user_id = ? and m.name = ? and m.value = ?
+order by e.created_at asc`
+	return r.getEvents(userID, query, userID, eventTransferID, string(id))
 }
@@ -0,0 +1,246 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+)
+
+var (
+	// eventStreamPollInterval is how often streams read the events table for Events written by other paygate instances.
+	eventStreamPollInterval = readEventStreamDuration("EVENT_STREAM_POLL_INTERVAL", 2*time.Second)
+
+	// eventStreamDuration is how long a stream is held open. It needs to be shorter than the HTTP server's write timeout,
+	// clients reconnect with Last-Event-ID to continue where they left off.
+	eventStreamDuration = readEventStreamDuration("EVENT_STREAM_DURATION", 25*time.Second)
+
+	// eventStreams receives every Event written through SQLEventRepo in this process.
+	eventStreams = newEventBroadcaster()
+)
+
+func readEventStreamDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	dur, err := time.ParseDuration(v)
+	if err != nil || dur <= 0 {
+		panic(fmt.Sprintf("invalid %s=%q: %v", name, v, err))
+	}
+	return dur
+}
+
+// eventBroadcaster fans out written Events to each stream open for their user.
+type eventBroadcaster struct {
+	mu   sync.Mutex
+	subs map[string]map[chan *Event]struct{} // userID -> streams
+}
+
+func newEventBroadcaster() *eventBroadcaster {
+	return &eventBroadcaster{
+		subs: make(map[string]map[chan *Event]struct{}),
+	}
+}
+
+// subscribe returns a channel of the user's Events and a func to stop receiving them.
+func (b *eventBroadcaster) subscribe(userID string) (chan *Event, func()) {
+	ch := make(chan *Event, 100)
+
+	b.mu.Lock()
+	if b.subs[userID] == nil {
+		b.subs[userID] = make(map[chan *Event]struct{})
+	}
+	b.subs[userID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs[userID], ch)
+		if len(b.subs[userID]) == 0 {
+			delete(b.subs, userID)
+		}
+	}
+}
+
+// publish sends event to each of the user's streams. Slow streams are skipped, they'll read the event when polling.
+func (b *eventBroadcaster) publish(userID string, event *Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs[userID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// streamUserEvents is an http.HandlerFunc which writes the user's new Events as Server-Sent Events. Events come from
+// streams (written in this process) and from polling the events table (written by any process). Clients can resume
+// after the Event in their Last-Event-ID header and only receive the types given in "type" query parameters.
+func streamUserEvents(logger log.Logger, eventRepo EventRepository, streams *eventBroadcaster, pollInterval, duration time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		if !ok {
+			moovhttp.Problem(w, fmt.Errorf("streaming is unsupported"))
+			return
+		}
+
+		types := make(map[EventType]bool)
+		for _, v := range r.URL.Query()["type"] {
+			t := EventType(v)
+			if err := t.validate(); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			types[t] = true
+		}
+
+		userID, requestID := moovhttp.GetUserID(r), moovhttp.GetRequestID(r)
+
+		// Subscribe before reading the events table so nothing written in between is missed.
+		events, unsubscribe := streams.subscribe(userID)
+		defer unsubscribe()
+
+		// New streams start with Events written from now on. After the first poll Events written by other instances
+		// might commit after newer Events were read, so polling looks back a bit and skips Events already sent.
+		cursor := newEventStreamCursor(time.Now())
+		if id := r.Header.Get("Last-Event-ID"); id != "" {
+			last, err := eventRepo.getEvent(EventID(id), userID)
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if last == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			cursor = newEventStreamCursor(last.Created.Time)
+			cursor.seen[last.ID] = last.Created.Time
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "retry: 1000\n\n")
+		flusher.Flush()
+
+		send := func(event *Event) error {
+			// Filtered Events still move the cursor, otherwise polling reads them again
+			if !cursor.next(event) {
+				return nil
+			}
+			if len(types) > 0 && !types[event.Type] {
+				return nil
+			}
+			bs, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, bs); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		}
+		poll := func() error {
+			found, err := eventRepo.getEventsSince(userID, cursor.since.Add(-1*cursor.lookback), 500)
+			if err != nil {
+				return err
+			}
+			for i := range found {
+				if err := send(found[i]); err != nil {
+					return err
+				}
+			}
+			cursor.lookback = pollInterval
+			cursor.prune()
+			return nil
+		}
+		if err := poll(); err != nil {
+			logger.Log("events", fmt.Sprintf("problem streaming events: %v", err), "requestID", requestID, "userID", userID)
+			return
+		}
+
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		timeout := time.NewTimer(duration)
+		defer timeout.Stop()
+
+		for {
+			select {
+			case event := <-events:
+				err = send(event)
+			case <-ticker.C:
+				err = poll()
+			case <-timeout.C:
+				return
+			case <-r.Context().Done():
+				return
+			}
+			if err != nil {
+				logger.Log("events", fmt.Sprintf("problem streaming events: %v", err), "requestID", requestID, "userID", userID)
+				return
+			}
+		}
+	}
+}
+
+// eventStreamCursor tracks which Events a stream has sent.
+type eventStreamCursor struct {
+	// start is when the stream begins, older Events are never sent
+	start time.Time
+
+	since    time.Time
+	lookback time.Duration
+	seen     map[EventID]time.Time
+}
+
+func newEventStreamCursor(start time.Time) *eventStreamCursor {
+	return &eventStreamCursor{
+		start: start,
+		since: start,
+		seen:  make(map[EventID]time.Time),
+	}
+}
+
+// next returns true if event hasn't been sent yet, and marks it sent.
+func (c *eventStreamCursor) next(event *Event) bool {
+	if _, exists := c.seen[event.ID]; exists {
+		return false
+	}
+	if event.Created.Before(c.start) || event.Created.Before(c.since.Add(-1*c.lookback)) {
+		return false
+	}
+	c.seen[event.ID] = event.Created.Time
+	if event.Created.After(c.since) {
+		c.since = event.Created.Time
+	}
+	return true
+}
+
+// prune forgets sent Events which polling won't read again.
+func (c *eventStreamCursor) prune() {
+	for id, created := range c.seen {
+		if created.Before(c.since.Add(-1 * c.lookback)) {
+			delete(c.seen, id)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moov-io/base"
+	"github.com/moov-io/paygate/internal/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+type sseMessage struct {
+	id, event string
+	data      Event
+}
+
+// readSSE returns each message read from an event stream until it's closed.
+func readSSE(t *testing.T, resp *http.Response) chan sseMessage {
+	out := make(chan sseMessage, 10)
+	go func() {
+		defer close(out)
+		scanner := bufio.NewScanner(resp.Body)
+		var msg sseMessage
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				msg.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				msg.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &msg.data); err != nil {
+					t.Errorf("invalid data: %v", err)
+				}
+			case line == "" && msg.id != "":
+				out <- msg
+				msg = sseMessage{}
+			}
+		}
+	}()
+	return out
+}
+
+func nextSSE(t *testing.T, messages chan sseMessage) sseMessage {
+	t.Helper()
+	select {
+	case msg, ok := <-messages:
+		if !ok {
+			t.Fatal("stream closed")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	return sseMessage{}
+}
+
+func TestEventStream__broadcaster(t *testing.T) {
+	b := newEventBroadcaster()
+	events, unsubscribe := b.subscribe("user")
+
+	b.publish("other", &Event{ID: "1"})
+	b.publish("user", &Event{ID: "2"})
+	if e := <-events; e.ID != "2" {
+		t.Errorf("unexpected event: %#v", e)
+	}
+
+	// full streams don't block writers
+	for i := 0; i < 200; i++ {
+		b.publish("user", &Event{ID: "3"})
+	}
+	unsubscribe()
+	if len(b.subs) != 0 {
+		t.Errorf("unexpected subscriptions: %#v", b.subs)
+	}
+}
+
+func TestEventStream__HTTP(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	logger := log.NewNopLogger()
+	repo := &SQLEventRepo{db.DB, logger, nil}
+	userID := base.ID()
+
+	first := &Event{ID: EventID(base.ID()), Topic: "first", Type: TransferEvent}
+	if err := repo.writeEvent(userID, first); err != nil {
+		t.Fatal(err)
+	}
+	second := &Event{ID: EventID(base.ID()), Topic: "second", Type: ReceiverEvent}
+	if err := repo.writeEvent(userID, second); err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	router.Methods("GET").Path("/events/stream").HandlerFunc(streamUserEvents(logger, repo, eventStreams, 50*time.Millisecond, time.Minute))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	open := func(query, lastEventID string) *http.Response {
+		req, _ := http.NewRequest("GET", server.URL+"/events/stream"+query, nil)
+		req.Header.Set("x-user-id", userID)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// resume after the first event
+	resp := open("", string(first.ID))
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("bogus HTTP status: %d", resp.StatusCode)
+	}
+	messages := readSSE(t, resp)
+	if msg := nextSSE(t, messages); msg.id != string(second.ID) || msg.event != "Receiver" || msg.data.Topic != "second" {
+		t.Errorf("unexpected message: %#v", msg)
+	}
+
+	// only Transfer events
+	filtered := open("?type=Transfer", "")
+	defer filtered.Body.Close()
+	transfers := readSSE(t, filtered)
+
+	// written in this process
+	third := &Event{ID: EventID(base.ID()), Topic: "third", Type: DepositoryEvent}
+	if err := repo.writeEvent(userID, third); err != nil {
+		t.Fatal(err)
+	}
+	if msg := nextSSE(t, messages); msg.id != string(third.ID) {
+		t.Errorf("unexpected message: %#v", msg)
+	}
+
+	// written by another instance, only read by polling
+	fourth := EventID(base.ID())
+	_, err := db.DB.Exec(`insert into events (event_id, user_id, topic, message, type, created_at) values (?, ?, 'fourth', '', 'Transfer', ?)`, fourth, userID, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := nextSSE(t, messages); msg.id != string(fourth) || msg.data.Topic != "fourth" {
+		t.Errorf("unexpected message: %#v", msg)
+	}
+	if msg := nextSSE(t, transfers); msg.id != string(fourth) {
+		t.Errorf("unexpected message: %#v", msg)
+	}
+
+	// nothing is sent twice
+	select {
+	case msg := <-messages:
+		t.Errorf("unexpected message: %#v", msg)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// errors
+	if resp := open("?type=other", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", resp.StatusCode)
+	}
+	if resp := open("", "missing"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", resp.StatusCode)
+	}
+}
+
+func TestEventStream__filteredCatchUp(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	logger := log.NewNopLogger()
+	repo := &SQLEventRepo{db.DB, logger, nil}
+	userID := base.ID()
+
+	last := &Event{ID: EventID(base.ID()), Topic: "last", Type: TransferEvent, Created: base.NewTime(time.Now().Add(-1 * time.Hour))}
+	if err := repo.writeEvent(userID, last); err != nil {
+		t.Fatal(err)
+	}
+	// more filtered Events than a poll reads, followed by one the stream wants
+	for i := 1; i <= 600; i++ {
+		_, err := db.DB.Exec(`insert into events (event_id, user_id, topic, message, type, created_at) values (?, ?, '', '', 'Receiver', ?)`, base.ID(), userID, last.Created.Add(time.Duration(i)*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	wanted := &Event{ID: EventID(base.ID()), Topic: "wanted", Type: TransferEvent, Created: base.NewTime(last.Created.Add(time.Hour / 2))}
+	if err := repo.writeEvent(userID, wanted); err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	router.Methods("GET").Path("/events/stream").HandlerFunc(streamUserEvents(logger, repo, eventStreams, 50*time.Millisecond, time.Minute))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, _ := http.NewRequest("GET", server.URL+"/events/stream?type=Transfer", nil)
+	req.Header.Set("x-user-id", userID)
+	req.Header.Set("Last-Event-ID", string(last.ID))
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if msg := nextSSE(t, readSSE(t, resp)); msg.id != string(wanted.ID) {
+		t.Errorf("unexpected message: %#v", msg)
+	}
+}
+
+func TestEventStream__cursor(t *testing.T) {
+	now := time.Now()
+	cursor := newEventStreamCursor(now.Add(-1 * time.Second))
+	cursor.since, cursor.lookback = now, time.Second
+
+	if !cursor.next(&Event{ID: "1", Created: base.NewTime(now.Add(-500 * time.Millisecond))}) {
+		t.Error("expected late event within the lookback")
+	}
+	if cursor.next(&Event{ID: "1", Created: base.NewTime(now)}) {
+		t.Error("sent twice")
+	}
+	if cursor.next(&Event{ID: "2", Created: base.NewTime(now.Add(-1 * time.Minute))}) {
+		t.Error("expected old event to be skipped")
+	}
+	cursor.lookback = time.Hour
+	if cursor.next(&Event{ID: "2", Created: base.NewTime(now.Add(-1 * time.Minute))}) {
+		t.Error("expected event from before the stream to be skipped")
+	}
+	cursor.lookback = time.Second
+	if !cursor.next(&Event{ID: "3", Created: base.NewTime(now.Add(time.Minute))}) || !cursor.since.After(now) {
+		t.Errorf("since=%v", cursor.since)
+	}
+	cursor.prune()
+	if _, exists := cursor.seen["1"]; exists || len(cursor.seen) != 1 {
+		t.Errorf("unexpected seen: %#v", cursor.seen)
+	}
+}
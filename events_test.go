@@ -6,8 +6,11 @@ package paygate
 
 import (
 	"encoding/json"
+	"fmt"
+	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/moov-io/base"
 	"github.com/moov-io/paygate/internal/database"
@@ -52,15 +55,138 @@ func TestEvents__getUserEvents(t *testing.T) {
 		if events[0].ID == "" {
 			t.Errorf("events[0]=%v", events[0])
 		}
+		if v := w.Header().Get("X-Total-Count"); v != "1" {
+			t.Errorf("X-Total-Count: %q", v)
+		}
 	}
 
 	// SQLite tests
 	sqliteDB := database.CreateTestSqliteDB(t)
 	defer sqliteDB.Close()
-	check(t, &SQLEventRepo{sqliteDB.DB, log.NewNopLogger()})
+	check(t, &SQLEventRepo{sqliteDB.DB, log.NewNopLogger(), nil})
 
 	// MySQL tests
 	mysqlDB := database.CreateTestMySQLDB(t)
 	defer mysqlDB.Close()
-	check(t, &SQLEventRepo{mysqlDB.DB, log.NewNopLogger()})
+	check(t, &SQLEventRepo{mysqlDB.DB, log.NewNopLogger(), nil})
+}
+
+func TestEvents__searchEvents(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *SQLEventRepo) {
+		userID, transferID := base.ID(), TransferID(base.ID())
+		start := time.Now().Add(-1 * time.Hour)
+
+		for i := 0; i < 3; i++ {
+			event := &Event{
+				ID:       EventID(base.ID()),
+				Topic:    fmt.Sprintf("transfer %d", i),
+				Type:     TransferEvent,
+				Payload:  eventPayload(map[string]int{"n": i}),
+				Metadata: map[string]string{eventTransferID: string(transferID)},
+				Created:  base.NewTime(start.Add(time.Duration(i) * time.Minute)),
+			}
+			if err := repo.writeEvent(userID, event); err != nil {
+				t.Fatal(err)
+			}
+		}
+		receiverEvent := &Event{
+			ID:       EventID(base.ID()),
+			Topic:    "receiver",
+			Type:     ReceiverEvent,
+			Metadata: map[string]string{eventReceiverID: "receiver", eventFileID: ""},
+		}
+		if err := repo.writeEvent(userID, receiverEvent); err != nil {
+			t.Fatal(err)
+		}
+
+		found, err := repo.getEvent(receiverEvent.ID, userID)
+		if err != nil || found == nil {
+			t.Fatalf("event=%#v error=%v", found, err)
+		}
+		if found.Created.IsZero() || len(found.Metadata) != 1 || found.Metadata[eventReceiverID] != "receiver" || found.Payload != nil {
+			t.Errorf("unexpected event: %#v", found)
+		}
+		if found, err := repo.getEvent(EventID(base.ID()), userID); err != nil || found != nil {
+			t.Errorf("event=%#v error=%v", found, err)
+		}
+
+		events, total, err := repo.searchEvents(userID, eventSearchParams{Limit: 25})
+		if err != nil || total != 4 || len(events) != 4 {
+			t.Fatalf("total=%d events=%#v error=%v", total, events, err)
+		}
+		if events[0].ID != receiverEvent.ID {
+			t.Errorf("expected newest first: %#v", events[0])
+		}
+
+		events, total, err = repo.searchEvents(userID, eventSearchParams{Type: TransferEvent, Offset: 1, Limit: 1})
+		if err != nil || total != 3 || len(events) != 1 {
+			t.Fatalf("total=%d events=%#v error=%v", total, events, err)
+		}
+		if events[0].Topic != "transfer 1" || string(events[0].Payload) != `{"n":1}` {
+			t.Errorf("unexpected event: %#v", events[0])
+		}
+
+		events, total, err = repo.searchEvents(userID, eventSearchParams{ResourceID: "receiver", Limit: 25})
+		if err != nil || total != 1 || len(events) != 1 || events[0].ID != receiverEvent.ID {
+			t.Errorf("total=%d events=%#v error=%v", total, events, err)
+		}
+
+		params := eventSearchParams{StartDate: start.Add(30 * time.Second), EndDate: start.Add(90 * time.Second), Limit: 25}
+		events, total, err = repo.searchEvents(userID, params)
+		if err != nil || total != 1 || len(events) != 1 || events[0].Topic != "transfer 1" {
+			t.Errorf("total=%d events=%#v error=%v", total, events, err)
+		}
+
+		events, err = repo.getUserTransferEvents(userID, transferID)
+		if err != nil || len(events) != 3 || events[0].Topic != "transfer 0" {
+			t.Errorf("events=%#v error=%v", events, err)
+		}
+		if events, _, _ := repo.searchEvents(base.ID(), eventSearchParams{Limit: 25}); len(events) != 0 {
+			t.Errorf("other user: %#v", events)
+		}
+	}
+
+	// SQLite tests
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &SQLEventRepo{sqliteDB.DB, log.NewNopLogger(), nil})
+
+	// MySQL tests
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &SQLEventRepo{mysqlDB.DB, log.NewNopLogger(), nil})
+}
+
+func TestEvents__readEventSearchParams(t *testing.T) {
+	r := httptest.NewRequest("GET", "/events?type=Return&resourceId=abc&startDate=2019-01-02&endDate=2019-01-03T12:00:00Z&offset=10&limit=50", nil)
+	params, err := readEventSearchParams(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Type != ReturnEvent || params.ResourceID != "abc" || params.Offset != 10 || params.Limit != 50 {
+		t.Errorf("unexpected params: %#v", params)
+	}
+	if params.StartDate.Day() != 2 || params.EndDate.Hour() != 12 {
+		t.Errorf("unexpected dates: %v - %v", params.StartDate, params.EndDate)
+	}
+
+	if params, err := readEventSearchParams(httptest.NewRequest("GET", "/events", nil)); err != nil || params.Limit != 25 {
+		t.Errorf("params=%#v error=%v", params, err)
+	}
+	for _, q := range []string{"type=other", "startDate=yesterday", "startDate=2019-01-03&endDate=2019-01-02", "offset=-1", "limit=0", "limit=101"} {
+		if _, err := readEventSearchParams(httptest.NewRequest("GET", "/events?"+q, nil)); err == nil {
+			t.Errorf("%s: expected error", q)
+		}
+	}
+
+	// errors are returned from the HTTP handler
+	w := httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/events?limit=abc", nil)
+	r.Header.Set("x-user-id", base.ID())
+	getUserEvents(log.NewNopLogger(), &SQLEventRepo{})(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
 }
@@ -6,10 +6,10 @@ package paygate
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/moov-io/base/http/bind"
@@ -22,7 +22,31 @@ import (
 
 type FEDClient interface {
 	Ping() error
-	LookupRoutingNumber(routingNumber string) error
+
+	// LookupRoutingNumber returns the FED's data about a financial institution. Routing numbers which aren't
+	// in the FEDACH dictionary are returned with ACHParticipant set to false.
+	LookupRoutingNumber(routingNumber string) (*RoutingNumberDetails, error)
+}
+
+// RoutingNumberDetails is the FED's data about a financial institution for a routing number.
+type RoutingNumberDetails struct {
+	RoutingNumber string `json:"routingNumber"`
+
+	// Name is the financial institution's name
+	Name string `json:"name"`
+
+	// Address is the financial institution's FEDACH delivery address
+	Address    string `json:"address"`
+	City       string `json:"city"`
+	State      string `json:"state"`
+	PostalCode string `json:"postalCode"`
+
+	// ACHParticipant is true if the financial institution can receive ACH entries
+	ACHParticipant bool `json:"achParticipant"`
+
+	// SameDay is true if the financial institution can receive same-day ACH entries. NACHA requires every
+	// RDFI accept same-day entries so this follows ACHParticipant.
+	SameDay bool `json:"sameDay"`
 }
 
 type moovFEDClient struct {
@@ -48,7 +72,7 @@ func (c *moovFEDClient) Ping() error {
 	return err
 }
 
-func (c *moovFEDClient) LookupRoutingNumber(routingNumber string) error {
+func (c *moovFEDClient) LookupRoutingNumber(routingNumber string) (*RoutingNumberDetails, error) {
 	// create a context just for this so ping requests don't require the setup of one
 	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
 	defer cancelFn()
@@ -60,17 +84,90 @@ func (c *moovFEDClient) LookupRoutingNumber(routingNumber string) error {
 		resp.Body.Close()
 	}
 	if resp == nil {
-		return fmt.Errorf("FED ping failed: %v", err)
+		return nil, fmt.Errorf("FED lookup failed: %v", err)
 	}
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return fmt.Errorf("FED ping got status: %s", resp.Status)
+		return nil, fmt.Errorf("FED lookup got status: %s", resp.Status)
 	}
 	for i := range achDict.ACHParticipants {
-		if achDict.ACHParticipants[i].RoutingNumber == routingNumber {
-			return nil // found match
+		if p := achDict.ACHParticipants[i]; p.RoutingNumber == routingNumber {
+			details := &RoutingNumberDetails{
+				RoutingNumber:  routingNumber,
+				Name:           p.CustomerName,
+				ACHParticipant: true,
+				SameDay:        true,
+			}
+			if len(p.AchLocation) > 0 {
+				loc := p.AchLocation[0]
+				details.Address, details.City, details.State, details.PostalCode = loc.Address, loc.City, loc.State, loc.PostalCode
+			}
+			return details, nil // found match
+		}
+	}
+	return &RoutingNumberDetails{RoutingNumber: routingNumber}, nil
+}
+
+// fedCacheTTL is how long routing number lookups are cached for. The FED's data changes at most once a day.
+var fedCacheTTL = func() time.Duration {
+	if v := os.Getenv("FED_CACHE_TTL"); v != "" {
+		if dur, err := time.ParseDuration(v); err == nil && dur >= 0 {
+			return dur
+		}
+	}
+	return 24 * time.Hour
+}()
+
+// cachedFEDClient is a FEDClient which keeps successful routing number lookups for a TTL so every Depository
+// write doesn't call the FED service.
+type cachedFEDClient struct {
+	underlying FEDClient
+	ttl        time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachedRoutingNumber
+}
+
+type cachedRoutingNumber struct {
+	details *RoutingNumberDetails
+	expires time.Time
+}
+
+func newCachedFEDClient(underlying FEDClient, ttl time.Duration) *cachedFEDClient {
+	return &cachedFEDClient{
+		underlying: underlying,
+		ttl:        ttl,
+		entries:    make(map[string]cachedRoutingNumber),
+	}
+}
+
+func (c *cachedFEDClient) Ping() error {
+	return c.underlying.Ping()
+}
+
+func (c *cachedFEDClient) LookupRoutingNumber(routingNumber string) (*RoutingNumberDetails, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[routingNumber]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.details, nil
+	}
+
+	details, err := c.underlying.LookupRoutingNumber(routingNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, v := range c.entries {
+		if now.After(v.expires) {
+			delete(c.entries, k) // cleanup expired lookups
 		}
 	}
-	return errors.New("no ACH participants found")
+	c.entries[routingNumber] = cachedRoutingNumber{details: details, expires: now.Add(c.ttl)}
+	return details, nil
 }
 
 func CreateFEDClient(logger log.Logger, httpClient *http.Client) FEDClient {
@@ -90,8 +187,12 @@ func CreateFEDClient(logger log.Logger, httpClient *http.Client) FEDClient {
 
 	logger.Log("fed", fmt.Sprintf("using %s for FED address", conf.BasePath))
 
-	return &moovFEDClient{
+	var client FEDClient = &moovFEDClient{
 		underlying: fed.NewAPIClient(conf),
 		logger:     logger,
 	}
+	if fedCacheTTL > 0 {
+		client = newCachedFEDClient(client, fedCacheTTL)
+	}
+	return client
 }
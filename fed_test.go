@@ -5,24 +5,41 @@
 package paygate
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/go-kit/kit/log"
 )
 
 type testFEDClient struct {
-	err error
+	details *RoutingNumberDetails
+	err     error
+
+	lookups int
 }
 
 func (c *testFEDClient) Ping() error {
 	return c.err
 }
 
-func (c *testFEDClient) LookupRoutingNumber(routingNumber string) error {
-	return c.err
+func (c *testFEDClient) LookupRoutingNumber(routingNumber string) (*RoutingNumberDetails, error) {
+	c.lookups++
+	if c.err != nil {
+		return nil, c.err
+	}
+	if c.details != nil {
+		return c.details, nil
+	}
+	return &RoutingNumberDetails{
+		RoutingNumber:  routingNumber,
+		Name:           "Test Bank",
+		ACHParticipant: true,
+		SameDay:        true,
+	}, nil
 }
 
 func TestFED(t *testing.T) {
@@ -48,13 +65,60 @@ func TestFED(t *testing.T) {
 		}
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"achParticipants": [{"routingNumber": "121042882"}]}`)) // partial fed.AchDictionary response
+		w.Write([]byte(`{"achParticipants": [{"routingNumber": "121042882", "customerName": "Wells Fargo", "achLocation": [{"city": "San Francisco", "state": "CA"}]}]}`)) // partial fed.AchDictionary response
 	}))
 	os.Setenv("FED_ENDPOINT", svc.URL)
 
 	client = CreateFEDClient(log.NewNopLogger(), nil)
-	if err := client.LookupRoutingNumber("121042882"); err != nil {
+	details, err := client.LookupRoutingNumber("121042882")
+	if err != nil {
 		t.Fatal(err)
 	}
+	if details.Name != "Wells Fargo" || details.City != "San Francisco" || !details.ACHParticipant || !details.SameDay {
+		t.Errorf("unexpected details: %#v", details)
+	}
+	details, err = client.LookupRoutingNumber("231380104")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.ACHParticipant {
+		t.Errorf("unexpected details: %#v", details)
+	}
 	svc.Close()
 }
+
+func TestFED__cache(t *testing.T) {
+	underlying := &testFEDClient{}
+	client := newCachedFEDClient(underlying, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if details, err := client.LookupRoutingNumber("121042882"); err != nil || details.Name != "Test Bank" {
+			t.Fatalf("details=%#v error=%v", details, err)
+		}
+	}
+	if underlying.lookups != 1 {
+		t.Errorf("got %d lookups", underlying.lookups)
+	}
+
+	// errors aren't cached
+	underlying.err = errors.New("bad error")
+	if _, err := client.LookupRoutingNumber("231380104"); err == nil {
+		t.Error("expected error")
+	}
+	underlying.err = nil
+	if _, err := client.LookupRoutingNumber("231380104"); err != nil {
+		t.Error(err)
+	}
+	if underlying.lookups != 3 {
+		t.Errorf("got %d lookups", underlying.lookups)
+	}
+
+	// expired entries are looked up again
+	client.entries["121042882"] = cachedRoutingNumber{expires: time.Now().Add(-1 * time.Second)}
+	if details, err := client.LookupRoutingNumber("121042882"); err != nil || details == nil {
+		t.Fatalf("details=%#v error=%v", details, err)
+	}
+	if underlying.lookups != 4 {
+		t.Errorf("got %d lookups", underlying.lookups)
+	}
+}
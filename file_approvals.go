@@ -0,0 +1,639 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/moov-io/ach"
+	"github.com/moov-io/base"
+	"github.com/moov-io/base/admin"
+	moovhttp "github.com/moov-io/base/http"
+	"github.com/moov-io/paygate/internal/filetransfer"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/prometheus"
+	"github.com/gorilla/mux"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// fileApprovalThreshold is the total (debits and credits) of a merged file at or above which it needs
+	// to be manually approved before being uploaded. A nil value disables manual approvals.
+	fileApprovalThreshold = func() *Amount {
+		v := os.Getenv("ACH_FILE_APPROVAL_THRESHOLD")
+		if v == "" {
+			return nil
+		}
+		var amt Amount
+		if err := amt.FromString(v); err != nil {
+			panic(fmt.Sprintf("invalid ACH_FILE_APPROVAL_THRESHOLD=%q: %v", v, err))
+		}
+		return &amt
+	}()
+
+	filesAwaitingApproval = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Name: "ach_files_awaiting_approval",
+		Help: "Counter of merged ACH files held for manual approval",
+	}, []string{"destination", "origin"})
+)
+
+type FileApprovalID string
+
+type FileApprovalStatus string
+
+const (
+	FileAwaitingApproval FileApprovalStatus = "awaiting_approval"
+	FileApproved         FileApprovalStatus = "approved"
+	FileRejected         FileApprovalStatus = "rejected"
+	FileUploading        FileApprovalStatus = "uploading"
+	FileUploaded         FileApprovalStatus = "uploaded"
+)
+
+func (s FileApprovalStatus) validate() error {
+	switch s {
+	case FileAwaitingApproval, FileApproved, FileRejected, FileUploading, FileUploaded:
+		return nil
+	default:
+		return fmt.Errorf("FileApprovalStatus(%s) is invalid", s)
+	}
+}
+
+// FileApproval is a merged ACH file which was held from uploading because its total amount is over
+// ACH_FILE_APPROVAL_THRESHOLD. Only approved files are uploaded.
+type FileApproval struct {
+	ID          FileApprovalID     `json:"id"`
+	Filename    string             `json:"filename"`
+	Origin      string             `json:"origin"`
+	Destination string             `json:"destination"`
+	EntryCount  int                `json:"entryCount"`
+	TotalDebit  Amount             `json:"totalDebit"`
+	TotalCredit Amount             `json:"totalCredit"`
+	Status      FileApprovalStatus `json:"status"`
+	Reason      string             `json:"reason,omitempty"`
+	ReviewedBy  string             `json:"reviewedBy,omitempty"` // X-User-ID of who approved or rejected the file
+	Created     base.Time          `json:"created"`
+	Updated     base.Time          `json:"updated"`
+
+	// filepath is the location of the held file on disk
+	filepath string
+}
+
+// requiresApproval returns true if the total amount of file is at or over fileApprovalThreshold.
+func (c *fileTransferController) requiresApproval(file *achFile) bool {
+	if c.approvalRepo == nil || fileApprovalThreshold == nil {
+		return false
+	}
+	total := file.Control.TotalDebitEntryDollarAmountInFile + file.Control.TotalCreditEntryDollarAmountInFile
+	return total >= fileApprovalThreshold.Int()
+}
+
+// holdForApproval moves file into an 'awaiting' directory (so no more transfers are merged into it) and
+// records it as awaiting approval.
+func (c *fileTransferController) holdForApproval(file *achFile) error {
+	dir := filepath.Join(filepath.Dir(file.filepath), "awaiting")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, filepath.Base(file.filepath))
+	if err := os.Rename(file.filepath, path); err != nil {
+		return err
+	}
+
+	approval := newFileApproval(file.File, path)
+	if err := c.approvalRepo.createFileApproval(approval); err != nil {
+		// move the file back so we retry next time
+		return fmt.Errorf("%v rename=%v", err, os.Rename(path, file.filepath))
+	}
+	file.filepath = path
+
+	c.logger.Log("holdForApproval", fmt.Sprintf("file %s (%s) awaiting approval: debits=%s credits=%s", approval.Filename, approval.ID, approval.TotalDebit.String(), approval.TotalCredit.String()))
+	filesAwaitingApproval.With("origin", file.Header.ImmediateOrigin, "destination", file.Header.ImmediateDestination).Add(1)
+	return nil
+}
+
+func newFileApproval(file *ach.File, path string) *FileApproval {
+	approval := &FileApproval{
+		ID:          FileApprovalID(base.ID()),
+		Filename:    filepath.Base(path),
+		Origin:      file.Header.ImmediateOrigin,
+		Destination: file.Header.ImmediateDestination,
+		EntryCount:  file.Control.EntryAddendaCount,
+		Status:      FileAwaitingApproval,
+		Created:     base.NewTime(time.Now()),
+		filepath:    path,
+	}
+	approval.Updated = approval.Created
+	approval.TotalDebit = Amount{symbol: "USD", number: file.Control.TotalDebitEntryDollarAmountInFile}
+	approval.TotalCredit = Amount{symbol: "USD", number: file.Control.TotalCreditEntryDollarAmountInFile}
+	return approval
+}
+
+// uploadApprovedFiles uploads each approved file for its CutoffTime and marks it as uploaded. Each file is claimed
+// as uploading first so it's only uploaded once, files which fail are logged and released to retry on the next run.
+//
+// A file left as uploading (i.e. paygate crashed during the upload) needs to be checked against the remote server.
+func (c *fileTransferController) uploadApprovedFiles() error {
+	if c.approvalRepo == nil {
+		return nil
+	}
+	approvals, err := c.approvalRepo.getFileApprovals(FileApproved)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for i := range approvals {
+		if err := c.uploadApprovedFile(approvals[i]); err != nil {
+			lastErr = fmt.Errorf("approved file %s: %v", approvals[i].ID, err)
+			c.logger.Log("uploadApprovedFiles", lastErr.Error())
+		}
+	}
+	return lastErr
+}
+
+func (c *fileTransferController) uploadApprovedFile(approval *FileApproval) error {
+	file, err := parseACHFilepath(approval.filepath)
+	if err != nil {
+		return err
+	}
+	fileToUpload := &achFile{File: file, filepath: approval.filepath}
+
+	var cutoff *filetransfer.CutoffTime
+	for i := range c.cutoffTimes {
+		if fileToUpload.Header.ImmediateOrigin == c.cutoffTimes[i].RoutingNumber {
+			cutoff = c.cutoffTimes[i]
+			break
+		}
+	}
+	if cutoff == nil {
+		return fmt.Errorf("no cutoff time for routing number %s", fileToUpload.Header.ImmediateOrigin)
+	}
+
+	// Claim the file so another run (or paygate instance) doesn't upload it too
+	if err := c.approvalRepo.updateFileApprovalStatus(approval.ID, FileApproved, FileUploading, approval.Reason); err != nil {
+		return err
+	}
+	if err := c.uploadForCutoff(fileToUpload, cutoff); err != nil {
+		if e := c.approvalRepo.updateFileApprovalStatus(approval.ID, FileUploading, FileApproved, approval.Reason); e != nil {
+			return fmt.Errorf("%v: problem releasing file: %v", err, e)
+		}
+		return err
+	}
+	return c.approvalRepo.updateFileApprovalStatus(approval.ID, FileUploading, FileUploaded, approval.Reason)
+}
+
+type FileApprovalRepository interface {
+	getFileApprovals(status FileApprovalStatus) ([]*FileApproval, error)
+	getFileApproval(id FileApprovalID) (*FileApproval, error)
+	createFileApproval(approval *FileApproval) error
+
+	// updateFileApprovalStatus changes the status of a FileApproval, but only if it's currently in the 'from' status.
+	updateFileApprovalStatus(id FileApprovalID, from, to FileApprovalStatus, reason string) error
+
+	// reviewFileApproval approves or rejects a FileApproval which is awaiting approval and records who reviewed it.
+	reviewFileApproval(id FileApprovalID, to FileApprovalStatus, reason string, reviewedBy string) error
+}
+
+func NewFileApprovalRepo(logger log.Logger, db *sql.DB) *SQLFileApprovalRepo {
+	return &SQLFileApprovalRepo{log: logger, db: db}
+}
+
+type SQLFileApprovalRepo struct {
+	db  *sql.DB
+	log log.Logger
+}
+
+func (r *SQLFileApprovalRepo) Close() error {
+	return r.db.Close()
+}
+
+func (r *SQLFileApprovalRepo) getFileApprovals(status FileApprovalStatus) ([]*FileApproval, error) {
+	query := `select approval_id from file_approvals where status = ? order by created_at asc;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []FileApprovalID
+	for rows.Next() {
+		var id FileApprovalID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("getFileApprovals: scan: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var approvals []*FileApproval
+	for i := range ids {
+		approval, err := r.getFileApproval(ids[i])
+		if err == nil && approval != nil {
+			approvals = append(approvals, approval)
+		}
+	}
+	return approvals, nil
+}
+
+func (r *SQLFileApprovalRepo) getFileApproval(id FileApprovalID) (*FileApproval, error) {
+	query := `select approval_id, filename, filepath, origin, destination, entry_count, total_debit, total_credit, status, reason, coalesce(reviewed_by, ''), created_at, last_updated_at
+from file_approvals where approval_id = ? limit 1;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var (
+		approval         FileApproval
+		debit, credit    int
+		reason           *string
+		created, updated time.Time
+	)
+	err = stmt.QueryRow(id).Scan(&approval.ID, &approval.Filename, &approval.filepath, &approval.Origin, &approval.Destination, &approval.EntryCount, &debit, &credit, &approval.Status, &reason, &approval.ReviewedBy, &created, &updated)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	approval.TotalDebit = Amount{symbol: "USD", number: debit}
+	approval.TotalCredit = Amount{symbol: "USD", number: credit}
+	if reason != nil {
+		approval.Reason = *reason
+	}
+	approval.Created, approval.Updated = base.NewTime(created), base.NewTime(updated)
+	return &approval, nil
+}
+
+func (r *SQLFileApprovalRepo) createFileApproval(approval *FileApproval) error {
+	query := `insert into file_approvals (approval_id, filename, filepath, origin, destination, entry_count, total_debit, total_credit, status, created_at, last_updated_at)
+values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(approval.ID, approval.Filename, approval.filepath, approval.Origin, approval.Destination, approval.EntryCount,
+		approval.TotalDebit.Int(), approval.TotalCredit.Int(), approval.Status, approval.Created.Time, approval.Updated.Time)
+	return err
+}
+
+func (r *SQLFileApprovalRepo) updateFileApprovalStatus(id FileApprovalID, from, to FileApprovalStatus, reason string) error {
+	query := `update file_approvals set status = ?, reason = ?, last_updated_at = ? where approval_id = ? and status = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(to, reason, time.Now(), id, from)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("file approval %s is not %s", id, from)
+	}
+	return nil
+}
+
+func (r *SQLFileApprovalRepo) reviewFileApproval(id FileApprovalID, to FileApprovalStatus, reason string, reviewedBy string) error {
+	query := `update file_approvals set status = ?, reason = ?, reviewed_by = ?, last_updated_at = ? where approval_id = ? and status = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(to, reason, reviewedBy, time.Now(), id, FileAwaitingApproval)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("file approval %s is not %s", id, FileAwaitingApproval)
+	}
+	return nil
+}
+
+// AddFileApprovalRoutes registers the admin HTTP routes for viewing, approving and rejecting merged files
+// which are awaiting approval before upload.
+//
+// accountsClient can be nil when calls to Accounts are disabled.
+func AddFileApprovalRoutes(logger log.Logger, svc *admin.Server, approvalRepo FileApprovalRepository, depRepo DepositoryRepository, transferRepo transferRepository, eventRepo EventRepository, accountsClient AccountsClient) {
+	svc.AddHandler("/files/approvals", getFileApprovals(logger, approvalRepo))
+	svc.AddHandler("/files/approvals/{approvalId}", getFileApproval(logger, approvalRepo))
+	svc.AddHandler("/files/approvals/{approvalId}/approve", approveFile(logger, approvalRepo))
+	svc.AddHandler("/files/approvals/{approvalId}/reject", rejectFile(logger, approvalRepo, depRepo, transferRepo, eventRepo, accountsClient))
+}
+
+func getFileApprovalID(r *http.Request) FileApprovalID {
+	return FileApprovalID(mux.Vars(r)["approvalId"])
+}
+
+func getFileApprovals(logger log.Logger, approvalRepo FileApprovalRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w = wrap(logger, w, r)
+
+		if r.Method != "GET" {
+			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb: %s", r.Method))
+			return
+		}
+
+		status := FileAwaitingApproval
+		if v := r.URL.Query().Get("status"); v != "" {
+			status = FileApprovalStatus(strings.ToLower(v))
+			if err := status.validate(); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+		}
+		approvals, err := approvalRepo.getFileApprovals(status)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(approvals)
+	}
+}
+
+type fileApprovalResponse struct {
+	*FileApproval
+
+	File *ach.File `json:"file,omitempty"`
+}
+
+func getFileApproval(logger log.Logger, approvalRepo FileApprovalRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w = wrap(logger, w, r)
+
+		if r.Method != "GET" {
+			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb: %s", r.Method))
+			return
+		}
+
+		approval, err := approvalRepo.getFileApproval(getFileApprovalID(r))
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if approval == nil {
+			http.NotFound(w, r)
+			return
+		}
+		resp := fileApprovalResponse{FileApproval: approval}
+		if approval.Status == FileAwaitingApproval || approval.Status == FileApproved {
+			if resp.File, err = parseACHFilepath(approval.filepath); err != nil {
+				moovhttp.Problem(w, fmt.Errorf("problem reading file %s: %v", approval.Filename, err))
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func approveFile(logger log.Logger, approvalRepo FileApprovalRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w = wrap(logger, w, r)
+
+		if r.Method != "POST" {
+			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb: %s", r.Method))
+			return
+		}
+
+		id, reviewerID := getFileApprovalID(r), moovhttp.GetUserID(r)
+		if reviewerID == "" {
+			moovhttp.Problem(w, errors.New("missing X-User-ID"))
+			return
+		}
+		if err := approvalRepo.reviewFileApproval(id, FileApproved, "", reviewerID); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		logger.Log("file-approvals", fmt.Sprintf("approved file %s for upload", id), "requestID", moovhttp.GetRequestID(r), "userID", reviewerID)
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func rejectFile(logger log.Logger, approvalRepo FileApprovalRepository, depRepo DepositoryRepository, transferRepo transferRepository, eventRepo EventRepository, accountsClient AccountsClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w = wrap(logger, w, r)
+
+		if r.Method != "POST" {
+			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb: %s", r.Method))
+			return
+		}
+
+		type request struct {
+			Reason string `json:"reason"`
+		}
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if req.Reason == "" {
+			moovhttp.Problem(w, errors.New("missing reason"))
+			return
+		}
+
+		id, requestID, reviewerID := getFileApprovalID(r), moovhttp.GetRequestID(r), moovhttp.GetUserID(r)
+		if reviewerID == "" {
+			moovhttp.Problem(w, errors.New("missing X-User-ID"))
+			return
+		}
+		approval, err := approvalRepo.getFileApproval(id)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if approval == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if approval.Status != FileAwaitingApproval {
+			moovhttp.Problem(w, fmt.Errorf("file approval %s is not %s", id, FileAwaitingApproval))
+			return
+		}
+
+		// Everything in the file is failed before it's marked as rejected, so a failure here leaves the
+		// file awaiting approval and rejecting it again picks up where this left off.
+		rejected := &rejectedFile{
+			approval:       approval,
+			reason:         req.Reason,
+			requestID:      requestID,
+			depRepo:        depRepo,
+			transferRepo:   transferRepo,
+			eventRepo:      eventRepo,
+			accountsClient: accountsClient,
+		}
+		if err := rejected.failTransfers(); err != nil {
+			logger.Log("file-approvals", fmt.Sprintf("problem failing transfers in rejected file %s: %v", id, err), "requestID", requestID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		if err := rejected.resetMicroDeposits(); err != nil {
+			logger.Log("file-approvals", fmt.Sprintf("problem resetting micro-deposits in rejected file %s: %v", id, err), "requestID", requestID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		if err := approvalRepo.reviewFileApproval(id, FileRejected, req.Reason, reviewerID); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if err := os.Rename(approval.filepath, approval.filepath+".rejected"); err != nil {
+			logger.Log("file-approvals", fmt.Sprintf("problem renaming rejected file %s: %v", id, err), "requestID", requestID)
+		}
+		logger.Log("file-approvals", fmt.Sprintf("rejected file %s: %s", id, req.Reason), "requestID", requestID, "userID", reviewerID)
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// rejectedFile undoes the Transfers and micro-deposits merged into a rejected file.
+type rejectedFile struct {
+	approval  *FileApproval
+	reason    string
+	requestID string
+
+	depRepo        DepositoryRepository
+	transferRepo   transferRepository
+	eventRepo      EventRepository
+	accountsClient AccountsClient // nil when Accounts calls are disabled
+}
+
+// failTransfers reverses the transaction of each Transfer merged into the file, marks it as failed and writes an event
+// with the reason. Transfers which already failed are skipped so this can be retried.
+func (f *rejectedFile) failTransfers() error {
+	transfers, err := getMergedFileTransfers(f.approval.filepath, f.transferRepo)
+	if err != nil {
+		return err
+	}
+	failed := make(map[string][]TransferID) // userID -> transfers
+	for i := range transfers {
+		if transfers[i].Status == TransferFailed {
+			continue
+		}
+		// The reversal is recorded first, so a failure below doesn't reverse the Transfer again on the next run
+		if err := reverseTransferTransaction(f.accountsClient, f.transferRepo, f.requestID, transfers[i]); err != nil {
+			return err
+		}
+		if err := f.transferRepo.updateTransferStatus(transfers[i].ID, TransferFailed); err != nil {
+			return fmt.Errorf("transfer=%s: %v", transfers[i].ID, err)
+		}
+		err := f.eventRepo.writeEvent(transfers[i].userID, &Event{
+			ID:      EventID(base.ID()),
+			Topic:   fmt.Sprintf("transfer %s failed", transfers[i].ID),
+			Message: fmt.Sprintf("file %s was rejected: %s", f.approval.Filename, f.reason),
+			Type:    TransferEvent,
+			Metadata: map[string]string{
+				eventTransferID: string(transfers[i].ID),
+				eventFileID:     f.approval.Filename,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("transfer=%s event: %v", transfers[i].ID, err)
+		}
+		failed[transfers[i].userID] = append(failed[transfers[i].userID], transfers[i].ID)
+	}
+
+	// Each user with Transfers in the file is told about the rejection once
+	for userID, ids := range failed {
+		err := f.eventRepo.writeEvent(userID, &Event{
+			ID:      EventID(base.ID()),
+			Topic:   fmt.Sprintf("file %s rejected", f.approval.Filename),
+			Message: f.reason,
+			Type:    FileEvent,
+			Payload: eventPayload(map[string]interface{}{
+				"filename":        f.approval.Filename,
+				"reason":          f.reason,
+				"failedTransfers": ids,
+			}),
+			Metadata: map[string]string{
+				eventFileID: f.approval.Filename,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("file=%s event: %v", f.approval.Filename, err)
+		}
+	}
+	return nil
+}
+
+// resetMicroDeposits removes the micro-deposits merged into the file, since they'll never be sent, so they can be
+// initiated again. Each Depository's owner is sent an event.
+func (f *rejectedFile) resetMicroDeposits() error {
+	if f.depRepo == nil {
+		return nil
+	}
+	microDeposits, err := f.depRepo.getMergedMicroDeposits(f.approval.Filename)
+	if err != nil {
+		return err
+	}
+	reset := make(map[string]bool)
+	for i := range microDeposits {
+		depID := microDeposits[i].depositoryID
+		if reset[depID] {
+			continue
+		}
+		if err := f.depRepo.resetMicroDeposits(DepositoryID(depID)); err != nil {
+			return fmt.Errorf("depository=%s: %v", depID, err)
+		}
+		err := f.eventRepo.writeEvent(microDeposits[i].userID, &Event{
+			ID:      EventID(base.ID()),
+			Topic:   fmt.Sprintf("depository %s micro-deposits reset", depID),
+			Message: fmt.Sprintf("file %s with the micro-deposits was rejected, they need to be initiated again", f.approval.Filename),
+			Type:    DepositoryEvent,
+			Metadata: map[string]string{
+				eventDepositoryID: depID,
+				eventFileID:       f.approval.Filename,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("depository=%s event: %v", depID, err)
+		}
+		reset[depID] = true
+	}
+	return nil
+}
+
+// getMergedFileTransfers returns the Transfers which were merged into the ACH file at path.
+func getMergedFileTransfers(path string, transferRepo transferRepository) ([]*Transfer, error) {
+	file, err := parseACHFilepath(path)
+	if err != nil {
+		return nil, err
+	}
+	var traceNumbers []string
+	for i := range file.Batches {
+		entries := file.Batches[i].GetEntries()
+		for j := range entries {
+			traceNumbers = append(traceNumbers, entries[j].TraceNumber)
+		}
+	}
+	return transferRepo.getMergedTransfers(filepath.Base(path), traceNumbers)
+}
@@ -0,0 +1,394 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moov-io/base"
+	"github.com/moov-io/paygate/internal/database"
+	"github.com/moov-io/paygate/internal/filetransfer"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func copyACHFile(t *testing.T, src, dir string) *achFile {
+	t.Helper()
+
+	bs, err := ioutil.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, achFilename("076401251", 1))
+	if err := ioutil.WriteFile(path, bs, 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := parseACHFilepath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &achFile{File: file, filepath: path}
+}
+
+func TestFileApprovals__requiresApproval(t *testing.T) {
+	file, err := parseACHFilepath(filepath.Join("testdata", "ppd-debit.ach"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &achFile{File: file}
+
+	controller := &fileTransferController{}
+	if controller.requiresApproval(f) {
+		t.Error("no repository, so no approval needed")
+	}
+
+	controller.approvalRepo = &SQLFileApprovalRepo{}
+	if controller.requiresApproval(f) {
+		t.Error("no threshold, so no approval needed")
+	}
+
+	defer func(amt *Amount) { fileApprovalThreshold = amt }(fileApprovalThreshold)
+
+	fileApprovalThreshold, _ = NewAmount("USD", "105.00") // ppd-debit.ach is USD 105.00
+	if !controller.requiresApproval(f) {
+		t.Error("expected approval")
+	}
+	fileApprovalThreshold, _ = NewAmount("USD", "105.01")
+	if controller.requiresApproval(f) {
+		t.Error("under threshold")
+	}
+}
+
+func TestFileApprovals__repository(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *SQLFileApprovalRepo) {
+		file, err := parseACHFilepath(filepath.Join("testdata", "ppd-debit.ach"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		approval := newFileApproval(file, filepath.Join("storage", "merged", "awaiting", "20190823-076401251-1.ach"))
+		if err := repo.createFileApproval(approval); err != nil {
+			t.Fatal(err)
+		}
+
+		found, err := repo.getFileApproval(approval.ID)
+		if err != nil || found == nil {
+			t.Fatalf("approval=%#v error=%v", found, err)
+		}
+		if found.Filename != "20190823-076401251-1.ach" || found.filepath != approval.filepath {
+			t.Errorf("unexpected approval: %#v", found)
+		}
+		if found.TotalDebit.String() != "USD 105.00" || found.TotalCredit.String() != "USD 0.00" || found.EntryCount != 1 {
+			t.Errorf("unexpected totals: %#v", found)
+		}
+
+		approvals, err := repo.getFileApprovals(FileAwaitingApproval)
+		if err != nil || len(approvals) != 1 {
+			t.Fatalf("approvals=%#v error=%v", approvals, err)
+		}
+
+		// can't upload a file which isn't approved
+		if err := repo.updateFileApprovalStatus(approval.ID, FileApproved, FileUploaded, ""); err == nil {
+			t.Error("expected error")
+		}
+		if err := repo.reviewFileApproval(approval.ID, FileRejected, "too big", "reviewer"); err != nil {
+			t.Fatal(err)
+		}
+		found, _ = repo.getFileApproval(approval.ID)
+		if found.Status != FileRejected || found.Reason != "too big" || found.ReviewedBy != "reviewer" {
+			t.Errorf("unexpected approval: %#v", found)
+		}
+		if err := repo.reviewFileApproval(approval.ID, FileApproved, "", "reviewer"); err == nil {
+			t.Error("expected error")
+		}
+
+		if approvals, err := repo.getFileApprovals(FileAwaitingApproval); err != nil || len(approvals) != 0 {
+			t.Errorf("approvals=%#v error=%v", approvals, err)
+		}
+		if found, err := repo.getFileApproval(FileApprovalID(base.ID())); err != nil || found != nil {
+			t.Errorf("approval=%#v error=%v", found, err)
+		}
+	}
+
+	// SQLite tests
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, NewFileApprovalRepo(log.NewNopLogger(), sqliteDB.DB))
+
+	// MySQL tests
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, NewFileApprovalRepo(log.NewNopLogger(), mysqlDB.DB))
+}
+
+func TestFileApprovals__startUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fileApprovals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	defer func(amt *Amount) { fileApprovalThreshold = amt }(fileApprovalThreshold)
+	fileApprovalThreshold, _ = NewAmount("USD", "100.00")
+
+	repo := NewFileApprovalRepo(log.NewNopLogger(), db.DB)
+	nyc, _ := time.LoadLocation("America/New_York")
+	controller := &fileTransferController{
+		cutoffTimes: []*filetransfer.CutoffTime{
+			{RoutingNumber: "076401251", Cutoff: 1700, Loc: nyc},
+		},
+		approvalRepo: repo,
+		logger:       log.NewNopLogger(),
+	}
+
+	f := copyACHFile(t, filepath.Join("testdata", "ppd-debit.ach"), dir)
+	original := f.filepath
+	if err := controller.startUpload([]*achFile{f}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file was moved out of our merged directory
+	if _, err := os.Stat(original); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be moved: %v", original, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "awaiting", filepath.Base(original))); err != nil {
+		t.Error(err)
+	}
+	approvals, err := repo.getFileApprovals(FileAwaitingApproval)
+	if err != nil || len(approvals) != 1 {
+		t.Fatalf("approvals=%#v error=%v", approvals, err)
+	}
+
+	// nothing is approved, so nothing is uploaded
+	if err := controller.uploadApprovedFiles(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileApprovals__uploadApprovedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fileApprovals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	repo := NewFileApprovalRepo(log.NewNopLogger(), db.DB)
+	nyc, _ := time.LoadLocation("America/New_York")
+	controller := &fileTransferController{
+		cutoffTimes: []*filetransfer.CutoffTime{
+			{RoutingNumber: "076401251", Cutoff: 1700, Loc: nyc},
+		},
+		approvalRepo: repo,
+		logger:       log.NewNopLogger(),
+	}
+
+	// one file is missing and the other can't be uploaded as there's no FTP config
+	f := copyACHFile(t, filepath.Join("testdata", "ppd-debit.ach"), dir)
+	missing := newFileApproval(f.File, filepath.Join(dir, "missing.ach"))
+	approval := newFileApproval(f.File, f.filepath)
+	for _, a := range []*FileApproval{missing, approval} {
+		if err := repo.createFileApproval(a); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.reviewFileApproval(a.ID, FileApproved, "", "reviewer"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := controller.uploadApprovedFiles(); err == nil {
+		t.Error("expected error")
+	}
+
+	// both files were tried and are approved to retry
+	approvals, err := repo.getFileApprovals(FileApproved)
+	if err != nil || len(approvals) != 2 {
+		t.Errorf("approvals=%#v error=%v", approvals, err)
+	}
+
+	// a file claimed by another upload isn't uploaded again
+	if err := repo.updateFileApprovalStatus(approval.ID, FileApproved, FileUploading, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.uploadApprovedFile(approval); err == nil || !strings.Contains(err.Error(), "is not approved") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFileApprovals__HTTP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fileApprovals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	logger := log.NewNopLogger()
+	approvalRepo := NewFileApprovalRepo(logger, db.DB)
+	eventRepo := NewEventRepo(logger, db.DB, nil)
+
+	f := copyACHFile(t, filepath.Join("testdata", "ppd-debit.ach"), dir)
+	approval := newFileApproval(f.File, f.filepath)
+	if err := approvalRepo.createFileApproval(approval); err != nil {
+		t.Fatal(err)
+	}
+	other := newFileApproval(f.File, f.filepath)
+	if err := approvalRepo.createFileApproval(other); err != nil {
+		t.Fatal(err)
+	}
+
+	userID := base.ID()
+	xfer := &Transfer{ID: TransferID(base.ID()), Status: TransferProcessed, userID: userID, transactionID: "transaction"}
+	transferRepo := &mockTransferRepository{xfer: xfer}
+	accountsClient := &testAccountsClient{}
+
+	// a Depository's micro-deposits were merged into the file
+	depRepo := NewDepositoryRepo(logger, db.DB)
+	depID, amt := DepositoryID(base.ID()), Amount{symbol: "USD", number: 12}
+	if err := depRepo.initiateMicroDeposits(depID, userID, []microDeposit{{amount: amt, fileID: "file"}}); err != nil {
+		t.Fatal(err)
+	}
+	mc := uploadableMicroDeposit{depositoryID: string(depID), userID: userID, amount: &amt, fileID: "file"}
+	if err := depRepo.markMicroDepositAsMerged(approval.Filename, mc); err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/files/approvals", getFileApprovals(logger, approvalRepo))
+	router.HandleFunc("/files/approvals/{approvalId}", getFileApproval(logger, approvalRepo))
+	router.HandleFunc("/files/approvals/{approvalId}/approve", approveFile(logger, approvalRepo))
+	router.HandleFunc("/files/approvals/{approvalId}/reject", rejectFile(logger, approvalRepo, depRepo, transferRepo, eventRepo, accountsClient))
+
+	serve := func(method, path string, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, body)
+		req.Header.Set("x-user-id", "reviewer")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	// list
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/files/approvals", nil))
+	w.Flush()
+	if w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var approvals []map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&approvals); err != nil || len(approvals) != 2 {
+		t.Errorf("approvals=%#v error=%v", approvals, err)
+	}
+
+	// summary and entries
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/files/approvals/"+string(approval.ID), nil))
+	w.Flush()
+	if w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if body := w.Body.String(); !strings.Contains(body, `"totalDebit":"USD 105.00"`) || !strings.Contains(body, `"Bachman Eric`) {
+		t.Errorf("unexpected body: %s", body)
+	}
+
+	// reject, but without a reason
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/files/approvals/"+string(approval.ID)+"/reject", strings.NewReader(`{}`)))
+	w.Flush()
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+
+	// Accounts is down, so the file is still awaiting approval
+	accountsClient.err = errors.New("bad error")
+	if w := serve("POST", "/files/approvals/"+string(approval.ID)+"/reject", strings.NewReader(`{"reason": "unexpected debit"}`)); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if a, _ := approvalRepo.getFileApproval(approval.ID); a == nil || a.Status != FileAwaitingApproval || transferRepo.status != "" {
+		t.Errorf("unexpected approval: %#v (transfer status %s)", a, transferRepo.status)
+	}
+	accountsClient.err = nil
+
+	// reject
+	if w := serve("POST", "/files/approvals/"+string(approval.ID)+"/reject", strings.NewReader(`{"reason": "unexpected debit"}`)); w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if transferRepo.status != TransferFailed {
+		t.Errorf("transfer status: %s", transferRepo.status)
+	}
+	if len(accountsClient.reversedTransactions) != 1 || accountsClient.reversedTransactions[0] != "transaction" {
+		t.Errorf("reversed transactions: %v", accountsClient.reversedTransactions)
+	}
+	if !transferRepo.reversed[xfer.ID] {
+		t.Error("expected the reversal to be recorded")
+	}
+	if a, _ := approvalRepo.getFileApproval(approval.ID); a == nil || a.Status != FileRejected || a.ReviewedBy != "reviewer" {
+		t.Errorf("unexpected approval: %#v", a)
+	}
+	events, err := eventRepo.getUserEvents(userID)
+	if err != nil || len(events) != 3 {
+		t.Fatalf("events=%#v error=%v", events, err)
+	}
+	if !strings.Contains(events[0].Message, "unexpected debit") || events[0].Metadata[eventTransferID] == "" {
+		t.Errorf("event: %#v", events[0])
+	}
+	if events[1].Type != FileEvent || events[1].Metadata[eventFileID] == "" || len(events[1].Payload) == 0 {
+		t.Errorf("file event: %#v", events[1])
+	}
+	if events[2].Type != DepositoryEvent || events[2].Metadata[eventDepositoryID] != string(depID) {
+		t.Errorf("depository event: %#v", events[2])
+	}
+	if mds, err := depRepo.getMicroDepositsForUser(depID, userID); err != nil || len(mds) != 0 {
+		t.Errorf("micro-deposits=%#v error=%v", mds, err)
+	}
+	if _, err := os.Stat(f.filepath + ".rejected"); err != nil {
+		t.Error(err)
+	}
+
+	// approving a rejected file fails
+	if w := serve("POST", "/files/approvals/"+string(approval.ID)+"/approve", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+
+	// approve the other file, which needs to know who approved it
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/files/approvals/"+string(other.ID)+"/approve", nil))
+	w.Flush()
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve("POST", "/files/approvals/"+string(other.ID)+"/approve", nil); w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if a, _ := approvalRepo.getFileApproval(other.ID); a == nil || a.Status != FileApproved || a.ReviewedBy != "reviewer" {
+		t.Errorf("unexpected approval: %#v", a)
+	}
+
+	// unknown approval
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/files/approvals/foo", nil))
+	w.Flush()
+	if w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+}
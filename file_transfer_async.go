@@ -52,6 +52,17 @@ var (
 		return 5 * time.Minute
 	}()
 
+	// cutoffUploadAlertDelta is the duration before a cutoff time where any ACH file which still hasn't
+	// been uploaded (failed uploads or files awaiting approval) raises an alert.
+	cutoffUploadAlertDelta = func() time.Duration {
+		if v := os.Getenv("ACH_FILE_UPLOAD_ALERT_DELTA"); v != "" {
+			if dur, _ := time.ParseDuration(v); dur > 0 {
+				return dur
+			}
+		}
+		return 2 * time.Minute
+	}()
+
 	// Prometheus metrics
 
 	inboundFilesProcessed = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
@@ -77,6 +88,11 @@ var (
 		Name: "ach_files_uploaded",
 		Help: "Counter of ACH files uploaded to their destination",
 	}, []string{"destination", "origin"})
+
+	filesNotUploadedNearCutoff = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Name: "ach_files_not_uploaded_near_cutoff",
+		Help: "Counter of ACH files still waiting for upload close to their cutoff time",
+	}, []string{"routing_number"})
 )
 
 // fileTransferController is a controller which is responsible for periodic sync'ing of ACH files
@@ -96,6 +112,17 @@ type fileTransferController struct {
 
 	ach            *achclient.ACH
 	accountsClient AccountsClient
+	odfiAccount    *ODFIAccount
+
+	approvalRepo      FileApprovalRepository
+	processedFileRepo ProcessedFileRepository
+	authorizationRepo authorizationRepository
+	eventRepo         EventRepository
+	gatewayRepo       gatewayRepository
+	uploadRepo        fileUploadRepository
+
+	// alertedFiles holds the merged files an alert has been written for, so each file is only alerted once
+	alertedFiles map[string]bool
 
 	logger log.Logger
 }
@@ -104,7 +131,7 @@ type fileTransferController struct {
 // to their SFTP host for processing.
 //
 // To change the refresh duration set ACH_FILE_TRANSFER_INTERVAL with a Go time.Duration value. (i.e. 10m for 10 minutes)
-func NewFileTransferController(logger log.Logger, dir string, repo filetransfer.Repository, achClient *achclient.ACH, accountsClient AccountsClient, accountsCallsDisabled bool) (*fileTransferController, error) {
+func NewFileTransferController(logger log.Logger, dir string, repo filetransfer.Repository, achClient *achclient.ACH, accountsClient AccountsClient, odfiAccount *ODFIAccount, approvalRepo FileApprovalRepository, processedFileRepo ProcessedFileRepository, authorizationRepo authorizationRepository, eventRepo EventRepository, gatewayRepo gatewayRepository, uploadRepo fileUploadRepository) (*fileTransferController, error) {
 	if _, err := os.Stat(dir); dir == "" || err != nil {
 		return nil, fmt.Errorf("file-transfer-controller: problem with storage directory %q: %v", dir, err)
 	}
@@ -163,11 +190,16 @@ func NewFileTransferController(logger log.Logger, dir string, repo filetransfer.
 		sftpConfigs:         sftpConfigs,
 		fileTransferConfigs: fileTransferConfigs,
 		ach:                 achClient,
+		accountsClient:      accountsClient,
+		odfiAccount:         odfiAccount,
+		approvalRepo:        approvalRepo,
+		processedFileRepo:   processedFileRepo,
+		authorizationRepo:   authorizationRepo,
+		eventRepo:           eventRepo,
+		gatewayRepo:         gatewayRepo,
+		uploadRepo:          uploadRepo,
 		logger:              logger,
 	}
-	if !accountsCallsDisabled {
-		controller.accountsClient = accountsClient
-	}
 	return controller, nil
 }
 
@@ -258,8 +290,8 @@ func (c *fileTransferController) StartPeriodicFileOperations(ctx context.Context
 }
 
 // downloadAndProcessIncomingFiles will take each cutoffTime initialized with the controller and retrieve all files
-// on the remote server for them. After this method will call processInboundFiles and processReturnFiles on each
-// downloaded file.
+// on the remote server for them. After this method will process each downloaded inbound and returned file and
+// delete them from the remote server once processed.
 func (c *fileTransferController) downloadAndProcessIncomingFiles(depRepo DepositoryRepository, transferRepo transferRepository) error {
 	dir, err := ioutil.TempDir(c.rootDir, "downloaded")
 	if err != nil {
@@ -279,13 +311,10 @@ func (c *fileTransferController) downloadAndProcessIncomingFiles(depRepo Deposit
 			continue
 		}
 
-		// Read and process inbound and returned files
-		if err := c.processInboundFiles(filepath.Join(dir, fileTransferConf.InboundPath)); err != nil {
-			c.logger.Log("downloadAndProcessIncomingFiles", fmt.Sprintf("problem reading inbound files in %s", dir), "error", err)
-			continue
-		}
-		if err := c.processReturnFiles(filepath.Join(dir, fileTransferConf.ReturnPath), depRepo, transferRepo); err != nil {
-			c.logger.Log("downloadAndProcessIncomingFiles", fmt.Sprintf("problem reading return files in %s", dir), "error", err)
+		// Read and process inbound and returned files, only processed files are deleted from the remote server
+		processed := c.processDownloadedFiles(dir, fileTransferConf, depRepo, transferRepo)
+		if err := c.deleteProcessedFiles(fileTransferConf, processed); err != nil {
+			c.logger.Log("downloadAndProcessIncomingFiles", fmt.Sprintf("problem deleting processed files for %s", fileTransferConf.RoutingNumber), "error", err)
 			continue
 		}
 	}
@@ -308,62 +337,48 @@ func (c *fileTransferController) downloadAllFiles(dir string, fileTransferConf *
 	return nil
 }
 
-func (c *fileTransferController) processInboundFiles(dir string) error {
-	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if (err != nil && err != filepath.SkipDir) || info.IsDir() {
-			return nil // Ignore SkipDir and directories
-		}
-
-		file, err := parseACHFilepath(path)
-		if err != nil {
-			c.logger.Log("processInboundFiles", fmt.Sprintf("problem parsing inbound file %s", path), "error", err)
-			return nil
-		}
-		c.logger.Log("file-transfer-controller", fmt.Sprintf("processing inbound file %s from %s (%s)", info.Name(), file.Header.ImmediateOriginName, file.Header.ImmediateOrigin))
+func (c *fileTransferController) processInboundFile(path string) error {
+	file, err := parseACHFilepath(path)
+	if err != nil {
+		return fmt.Errorf("problem parsing inbound file %s: %v", path, err)
+	}
+	c.logger.Log("file-transfer-controller", fmt.Sprintf("processing inbound file %s from %s (%s)", filepath.Base(path), file.Header.ImmediateOriginName, file.Header.ImmediateOrigin))
 
-		inboundFilesProcessed.With("destination", file.Header.ImmediateDestination, "origin", file.Header.ImmediateOrigin).Add(1)
+	inboundFilesProcessed.With("destination", file.Header.ImmediateDestination, "origin", file.Header.ImmediateOrigin).Add(1)
 
-		// TODO(adam): read inbound files to update a status (or process, i.e. IAT)
+	// TODO(adam): read inbound files to update a status (or process, i.e. IAT)
 
-		return nil
-	})
+	return nil
 }
 
-func (c *fileTransferController) processReturnFiles(dir string, depRepo DepositoryRepository, transferRepo transferRepository) error {
-	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if (err != nil && err != filepath.SkipDir) || info.IsDir() {
-			return nil // Ignore SkipDir and directories
-		}
+func (c *fileTransferController) processReturnFile(path string, depRepo DepositoryRepository, transferRepo transferRepository) error {
+	file, err := parseACHFilepath(path)
+	if err != nil {
+		return fmt.Errorf("problem parsing return file %s: %v", path, err)
+	}
+	c.logger.Log("processReturnFiles", fmt.Sprintf("processing return file %s from %s (%s)", filepath.Base(path), file.Header.ImmediateOriginName, file.Header.ImmediateOrigin))
 
-		file, err := parseACHFilepath(path)
-		if err != nil {
-			c.logger.Log("processReturnFiles", fmt.Sprintf("problem parsing return file %s", path), "error", err)
-			return nil
-		}
-		c.logger.Log("processReturnFiles", fmt.Sprintf("processing return file %s from %s (%s)", info.Name(), file.Header.ImmediateOriginName, file.Header.ImmediateOrigin))
-
-		returnFilesProcessed.With("destination", file.Header.ImmediateDestination, "origin", file.Header.ImmediateOrigin).Add(1)
-
-		// Process each returned Batch and update their Transfer status
-		//
-		// We match the return file against transfers in our database and try to compare against fields
-		// that can't change (and if they do it's clearly a different transfer).
-		for i := range file.ReturnEntries {
-			entries := file.ReturnEntries[i].GetEntries()
-			for j := range entries {
-				// Skip if the ach.Batch is invalid (for returns)
-				if entries[j].Addenda99 == nil || entries[j].Addenda99.ReturnCodeField() == nil {
-					c.logger.Log("processReturnFiles", "empty Addenda99 (or ReturnCode)", "traceNumber", entries[j].TraceNumber)
-					continue
-				}
-				if err := c.processReturnEntry(file.Header, file.ReturnEntries[i].GetHeader(), entries[j], depRepo, transferRepo); err != nil {
-					c.logger.Log("processReturnFiles", "error processing EntryDetail", "traceNumber", entries[j].TraceNumber, "error", err)
-					continue
-				}
+	returnFilesProcessed.With("destination", file.Header.ImmediateDestination, "origin", file.Header.ImmediateOrigin).Add(1)
+
+	// Process each returned Batch and update their Transfer status
+	//
+	// We match the return file against transfers in our database and try to compare against fields
+	// that can't change (and if they do it's clearly a different transfer).
+	for i := range file.ReturnEntries {
+		entries := file.ReturnEntries[i].GetEntries()
+		for j := range entries {
+			// Skip if the ach.Batch is invalid (for returns)
+			if entries[j].Addenda99 == nil || entries[j].Addenda99.ReturnCodeField() == nil {
+				c.logger.Log("processReturnFiles", "empty Addenda99 (or ReturnCode)", "traceNumber", entries[j].TraceNumber)
+				continue
+			}
+			if err := c.processReturnEntry(file.Header, file.ReturnEntries[i].GetHeader(), entries[j], depRepo, transferRepo); err != nil {
+				c.logger.Log("processReturnFiles", "error processing EntryDetail", "traceNumber", entries[j].TraceNumber, "error", err)
+				continue
 			}
 		}
-		return nil
-	})
+	}
+	return nil
 }
 
 func (c *fileTransferController) processReturnEntry(fileHeader ach.FileHeader, header *ach.BatchHeader, entry *ach.EntryDetail, depRepo DepositoryRepository, transferRepo transferRepository) error {
@@ -392,19 +407,48 @@ func (c *fileTransferController) processReturnEntry(fileHeader ach.FileHeader, h
 	}
 
 	// Reverse the transaction against Accounts
-	if c.accountsClient != nil && transfer.transactionID != "" {
-		if err := c.accountsClient.ReverseTransaction(requestID, transfer.userID, transfer.transactionID); err != nil {
-			return fmt.Errorf("problem with accounts ReverseTransaction: %v", err)
+	if err := reverseTransferTransaction(c.accountsClient, transferRepo, requestID, transfer); err != nil {
+		return fmt.Errorf("problem with accounts ReverseTransaction: %v", err)
+	}
+
+	if c.eventRepo != nil {
+		err := c.eventRepo.writeEvent(transfer.userID, &Event{
+			ID:      EventID(base.ID()),
+			Topic:   fmt.Sprintf("transfer %s returned %s", transfer.ID, returnCode.Code),
+			Message: fmt.Sprintf("%s: %s", returnCode.Code, returnCode.Reason),
+			Type:    ReturnEvent,
+			Payload: eventPayload(map[string]interface{}{
+				"returnCode":  returnCode,
+				"traceNumber": entry.TraceNumber,
+				"amount":      amount,
+			}),
+			Metadata: map[string]string{
+				eventTransferID:        string(transfer.ID),
+				eventReceiverID:        string(transfer.Receiver),
+				"receiverDepositoryId": string(transfer.ReceiverDepository),
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("problem writing return event for transfer=%q: %v", transfer.ID, err)
 		}
 	}
 
+	// The Receiver has revoked (or never gave) their authorization for debits, so stop using it
+	if c.authorizationRepo != nil && (returnCode.Code == "R07" || returnCode.Code == "R10") {
+		reason := fmt.Sprintf("%s: %s", returnCode.Code, returnCode.Reason)
+		n, err := c.authorizationRepo.revokeAuthorizations(transfer.Receiver, transfer.ReceiverDepository, transfer.userID, reason)
+		if err != nil {
+			return fmt.Errorf("problem revoking authorizations for transfer=%q: %v", transfer.ID, err)
+		}
+		c.logger.Log("processReturnEntry", fmt.Sprintf("revoked %d authorizations for receiver=%s depository=%s from transfer=%s", n, transfer.Receiver, transfer.ReceiverDepository, transfer.ID), "requestID", requestID)
+	}
+
 	// Match user Depositories to our ACH file (the user needs to have Depositories verified for this file)
 	depositories, err := depRepo.getUserDepositories(transfer.userID)
 	if err != nil {
 		return fmt.Errorf("unable to find Depositories: %v", err)
 	}
 	var origDep *Depository
-	var recDep *Depository
 	for k := range depositories {
 		if depositories[k].Status != DepositoryVerified {
 			continue // We only allow Verified Depositories
@@ -412,9 +456,14 @@ func (c *fileTransferController) processReturnEntry(fileHeader ach.FileHeader, h
 		if fileHeader.ImmediateOrigin == depositories[k].RoutingNumber { // TODO(adam): Should we match the originator's account number?
 			origDep = depositories[k] // Originator Depository matched
 		}
-		if depositories[k].RoutingNumber == fileHeader.ImmediateDestination && depositories[k].AccountNumber == entry.DFIAccountNumber {
-			recDep = depositories[k] // Receiver Depository matched
-		}
+	}
+	// Account numbers are stored encrypted, so the Receiver Depository is found by the account number's hash
+	recDep, err := depRepo.lookupDepositoryFromReturn(transfer.userID, fileHeader.ImmediateDestination, entry.DFIAccountNumber)
+	if err != nil {
+		return fmt.Errorf("unable to find receiver Depository: %v", err)
+	}
+	if recDep != nil && recDep.Status != DepositoryVerified {
+		recDep = nil
 	}
 	if origDep == nil || recDep == nil {
 		p := func(d *Depository) string {
@@ -498,7 +547,8 @@ func (c *fileTransferController) writeFiles(files []filetransfer.File, dir strin
 	return nil
 }
 
-// saveRemoteFiles will write all inbound and return ACH files for a given routing number to the specified directory
+// saveRemoteFiles will write all inbound and return ACH files for a given routing number to the specified directory.
+// Files are left on the remote server until they've been processed.
 func (c *fileTransferController) saveRemoteFiles(agent filetransfer.Agent, dir string) error {
 	var errors []string
 
@@ -516,10 +566,6 @@ func (c *fileTransferController) saveRemoteFiles(agent filetransfer.Agent, dir s
 	}
 	for i := range files {
 		c.logger.Log("saveRemoteFiles", fmt.Sprintf("%T: copied down inbound file %s", agent, files[i].Filename))
-
-		if err := agent.Delete(filepath.Join(agent.InboundPath(), files[i].Filename)); err != nil {
-			errors = append(errors, fmt.Sprintf("%T: inbound Delete filename=%s error=%v", agent, files[i].Filename, err))
-		}
 	}
 
 	// Download and save returned files
@@ -536,10 +582,6 @@ func (c *fileTransferController) saveRemoteFiles(agent filetransfer.Agent, dir s
 	}
 	for i := range files {
 		c.logger.Log("saveRemoteFiles", fmt.Sprintf("%T: copied down return file %s", agent, files[i].Filename))
-
-		if err := agent.Delete(filepath.Join(agent.ReturnPath(), files[i].Filename)); err != nil {
-			errors = append(errors, fmt.Sprintf("%T: return Delete filename=%s error=%v", agent, files[i].Filename, err))
-		}
 	}
 
 	if len(errors) > 0 {
@@ -606,7 +648,7 @@ func (c *fileTransferController) mergeTransfer(file *ach.File, mergableFile *ach
 
 				// create a new mergableFile
 				dir, filename := filepath.Split(mergableFile.filepath)
-				filename = achFilename(file.Header.ImmediateDestination, achFilenameSeq(filename)+1)
+				filename = nextMergedFilename(dir, file.Header.ImmediateDestination, achFilenameSeq(filename)+1)
 				newMergableFile := &achFile{
 					File:     file,
 					filepath: filepath.Join(dir, filename),
@@ -684,8 +726,26 @@ func (c *fileTransferController) mergeAndUploadFiles(transferCur *transferCursor
 	filesToUpload = append(filesToUpload, toUpload...)
 
 	// Upload any merged files that are ready
-	if err := c.startUpload(filesToUpload); err != nil {
-		return fmt.Errorf("problem uploading ACH files: %v", err)
+	uploadErr := c.startUpload(filesToUpload)
+
+	// Alert on files which are still waiting to be uploaded right before their cutoff
+	if err := c.alertFilesNotUploaded(mergedDir, time.Now(), transferRepo); err != nil {
+		c.logger.Log("file-transfer-controller", fmt.Sprintf("problem checking for files not uploaded: %v", err))
+	}
+	if uploadErr != nil {
+		c.logger.Log("file-transfer-controller", fmt.Sprintf("problem uploading ACH files: %v", uploadErr))
+	}
+
+	// Upload any files which have been approved since our last run, even if merged files failed to upload
+	approvedErr := c.uploadApprovedFiles()
+
+	switch {
+	case uploadErr != nil && approvedErr != nil:
+		return fmt.Errorf("problem uploading ACH files: %v (and approved ACH files: %v)", uploadErr, approvedErr)
+	case uploadErr != nil:
+		return fmt.Errorf("problem uploading ACH files: %v", uploadErr)
+	case approvedErr != nil:
+		return fmt.Errorf("problem uploading approved ACH files: %v", approvedErr)
 	}
 	return nil
 }
@@ -720,6 +780,86 @@ func filesNearTheirCutoff(cutoffTimes []*filetransfer.CutoffTime, dir string) ([
 	return filesToUpload, nil
 }
 
+// alertFilesNotUploaded logs an alert (and increments a counter) for each merged file which hasn't been
+// uploaded within cutoffUploadAlertDelta of its cutoff time. Files held for approval are included.
+//
+// Each user with Transfers in the file is sent a File event the first time it's alerted on.
+func (c *fileTransferController) alertFilesNotUploaded(mergedDir string, now time.Time, transferRepo transferRepository) error {
+	if c.alertedFiles == nil {
+		c.alertedFiles = make(map[string]bool)
+	}
+	for path := range c.alertedFiles {
+		if _, err := os.Stat(path); err != nil {
+			delete(c.alertedFiles, path) // uploaded or moved
+		}
+	}
+	for i := range c.cutoffTimes {
+		diff := c.cutoffTimes[i].Diff(now.In(c.cutoffTimes[i].Loc))
+		if diff <= 0*time.Second || diff > cutoffUploadAlertDelta {
+			continue
+		}
+		var matches []string
+		for _, dir := range []string{mergedDir, filepath.Join(mergedDir, "awaiting")} {
+			ms, err := filepath.Glob(filepath.Join(dir, fmt.Sprintf("*-%s-*.ach", c.cutoffTimes[i].RoutingNumber)))
+			if err != nil {
+				return fmt.Errorf("dir=%s: %v", dir, err)
+			}
+			matches = append(matches, ms...)
+		}
+		for j := range matches {
+			c.logger.Log("file-transfer-controller", fmt.Sprintf("ALERT: %s has not been uploaded and cutoff for %s is in %v", matches[j], c.cutoffTimes[i].RoutingNumber, diff))
+			filesNotUploadedNearCutoff.With("routing_number", c.cutoffTimes[i].RoutingNumber).Add(1)
+
+			if c.alertedFiles[matches[j]] {
+				continue
+			}
+			if err := c.writeFileNotUploadedEvents(matches[j], c.cutoffTimes[i], now.Add(diff), transferRepo); err != nil {
+				c.logger.Log("file-transfer-controller", fmt.Sprintf("problem writing alert events for %s: %v", matches[j], err))
+				continue
+			}
+			c.alertedFiles[matches[j]] = true
+		}
+	}
+	return nil
+}
+
+// writeFileNotUploadedEvents writes a File event for each user with Transfers merged into the file at path.
+func (c *fileTransferController) writeFileNotUploadedEvents(path string, cutoff *filetransfer.CutoffTime, when time.Time, transferRepo transferRepository) error {
+	if c.eventRepo == nil || transferRepo == nil {
+		return nil
+	}
+	transfers, err := getMergedFileTransfers(path, transferRepo)
+	if err != nil {
+		return err
+	}
+	users := make(map[string][]TransferID) // userID -> transfers
+	for i := range transfers {
+		users[transfers[i].userID] = append(users[transfers[i].userID], transfers[i].ID)
+	}
+	filename := filepath.Base(path)
+	for userID, ids := range users {
+		err := c.eventRepo.writeEvent(userID, &Event{
+			ID:      EventID(base.ID()),
+			Topic:   fmt.Sprintf("file %s not uploaded", filename),
+			Message: fmt.Sprintf("file %s has not been uploaded and cutoff for %s is at %v", filename, cutoff.RoutingNumber, when.Format(time.RFC3339)),
+			Type:    FileEvent,
+			Payload: eventPayload(map[string]interface{}{
+				"filename":      filename,
+				"routingNumber": cutoff.RoutingNumber,
+				"cutoff":        when,
+				"transfers":     ids,
+			}),
+			Metadata: map[string]string{
+				eventFileID: filename,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("user=%s: %v", userID, err)
+		}
+	}
+	return nil
+}
+
 // mergeGroupableTransfer will inspect a Transfer, load the backing ACH file and attempt to merge that transfer into an existing merge file for upload.
 func (c *fileTransferController) mergeGroupableTransfer(mergedDir string, xfer *groupableTransfer, transferRepo transferRepository) *achFile {
 	fileId, err := transferRepo.getFileIDForTransfer(xfer.ID, xfer.userID)
@@ -732,8 +872,15 @@ func (c *fileTransferController) mergeGroupableTransfer(mergedDir string, xfer *
 		return nil
 	}
 
+	// Transfers created with a Gateway are merged into files with its header, which is only set when a file is created
+	origin := xfer.origin
+	if gateway := c.getTransferGateway(xfer); gateway != nil {
+		setGatewayFileHeader(&file.Header, gateway)
+		origin = gateway.Origin
+	}
+
 	// Find (or create) a mergable file for this transfer's destination
-	mergableFile, err := grabLatestMergedACHFile(xfer.origin, file, mergedDir)
+	mergableFile, err := grabLatestMergedACHFile(origin, file, mergedDir)
 	if err != nil {
 		c.logger.Log("mergeGroupableTransfer", fmt.Sprintf("unable to find mergable file for transfer %s", xfer.ID), "error", err)
 		return nil
@@ -765,6 +912,19 @@ func (c *fileTransferController) mergeGroupableTransfer(mergedDir string, xfer *
 	return nil
 }
 
+// getTransferGateway returns the Gateway a Transfer was created with, or nil when it doesn't have one (or it's since been deleted).
+func (c *fileTransferController) getTransferGateway(xfer *groupableTransfer) *Gateway {
+	if xfer.Gateway == "" || c.gatewayRepo == nil {
+		return nil
+	}
+	gateway, err := c.gatewayRepo.getUserGateway(xfer.Gateway, xfer.userID)
+	if err != nil {
+		c.logger.Log("mergeGroupableTransfer", fmt.Sprintf("problem reading gateway=%s for transfer %s: %v", xfer.Gateway, xfer.ID, err))
+		return nil
+	}
+	return gateway
+}
+
 // mergeMicroDeposit will grab the ACH file for a micro-deposit and merge it into a larger ACH file for upload to the ODFI.
 func (c *fileTransferController) mergeMicroDeposit(mergedDir string, mc uploadableMicroDeposit, depRepo *SQLDepositoryRepo) *achFile {
 	file, err := c.loadIncomingFile(mc.fileID)
@@ -808,22 +968,50 @@ func (c *fileTransferController) mergeMicroDeposit(mergedDir string, mc uploadab
 // to them (so we can find their upload configs).
 //
 // After uploading a file this method renames it to avoid uploading the file multiple times.
+//
+// Files for routing numbers whose filetransfer.Config has BalanceEntries set are balanced with offset
+// entries against our ODFIAccount prior to upload.
+//
+// A failure with one file doesn't stop the remaining files from being uploaded, the last error is returned.
 func (c *fileTransferController) startUpload(filesToUpload []*achFile) error {
+	var lastErr error
 	for i := range filesToUpload {
 		for j := range c.cutoffTimes {
 			if filesToUpload[i].Header.ImmediateOrigin == c.cutoffTimes[j].RoutingNumber {
-				if err := c.maybeUploadFile(filesToUpload[i], c.cutoffTimes[j]); err != nil {
-					return fmt.Errorf("problem uploading %s: %v", filesToUpload[i].filepath, err)
+				if c.requiresApproval(filesToUpload[i]) {
+					if err := c.holdForApproval(filesToUpload[i]); err != nil {
+						lastErr = fmt.Errorf("problem holding %s for approval: %v", filesToUpload[i].filepath, err)
+						c.logger.Log("startUpload", lastErr.Error())
+					}
+					continue
 				}
-				// rename the file so grabLatestMergedACHFile ignores it next time
-				if err := os.Rename(filesToUpload[i].filepath, filesToUpload[i].filepath+".uploaded"); err != nil {
-					// This is a bad error to run into as it means the file will likely be uploaded twice, but if
-					// the underlying FS is failing what other errors would paygate run into?
-					return fmt.Errorf("error renaming %s after upload: %v", filesToUpload[i].filepath, err)
+				if err := c.uploadForCutoff(filesToUpload[i], c.cutoffTimes[j]); err != nil {
+					lastErr = err
+					c.logger.Log("startUpload", err.Error())
 				}
 			}
 		}
 	}
+	return lastErr
+}
+
+// uploadForCutoff balances (if configured) and uploads a file for the given filetransfer.CutoffTime.
+// After uploading the file is renamed so it's not uploaded again.
+func (c *fileTransferController) uploadForCutoff(file *achFile, cutoffTime *filetransfer.CutoffTime) error {
+	if cfg := c.findFileTransferConfig(cutoffTime); cfg != nil && cfg.BalanceEntries {
+		if err := c.balanceFile(file); err != nil {
+			return fmt.Errorf("problem balancing %s: %v", file.filepath, err)
+		}
+	}
+	if err := c.maybeUploadFile(file, cutoffTime); err != nil {
+		return fmt.Errorf("problem uploading %s: %v", file.filepath, err)
+	}
+	// rename the file so grabLatestMergedACHFile ignores it next time
+	if err := os.Rename(file.filepath, file.filepath+".uploaded"); err != nil {
+		// This is a bad error to run into as it means the file will likely be uploaded twice, but if
+		// the underlying FS is failing what other errors would paygate run into?
+		return fmt.Errorf("error renaming %s after upload: %v", file.filepath, err)
+	}
 	return nil
 }
 
@@ -841,42 +1029,99 @@ func (c *fileTransferController) maybeUploadFile(fileToUpload *achFile, cutoffTi
 
 	c.logger.Log("maybeUploadFile", fmt.Sprintf("uploading %s for routing number %s", fileToUpload.filepath, cutoffTime.RoutingNumber))
 
-	// TODO(adam): I think we should have a DB table for tracking file uploads (?ach_file_uploads?)
-	// with the following fields: routing number, filename, timestamp.
-
-	return c.uploadFile(agent, fileToUpload)
+	return c.uploadFile(agent, cfg, fileToUpload)
 }
 
-func (c *fileTransferController) uploadFile(agent filetransfer.Agent, f *achFile) error {
-	fd, err := os.Open(f.filepath)
-	if err != nil {
-		return fmt.Errorf("problem opening %s for upload: %v", f.filepath, err)
+// uploadFile sends f to agent. If cfg has a FilenameTemplate or FileHeader overrides they're applied
+// to the uploaded file, otherwise f is uploaded as-is.
+func (c *fileTransferController) uploadFile(agent filetransfer.Agent, cfg *filetransfer.Config, f *achFile) error {
+	filename := filepath.Base(f.filepath)
+	if cfg != nil && cfg.FilenameTemplate != "" {
+		name, err := cfg.Filename(filetransfer.FilenameData{
+			RoutingNumber: cfg.RoutingNumber,
+			N:             achFilenameSeqToStr(achFilenameSeq(filename)),
+		})
+		if err != nil {
+			return fmt.Errorf("problem rendering filename for %s: %v", f.filepath, err)
+		}
+		filename = name
+	}
+
+	var contents io.ReadCloser
+	if cfg != nil && !cfg.FileHeader.Empty() {
+		var buf bytes.Buffer
+		if err := ach.NewWriter(&buf).Write(overrideFileHeader(f.File, cfg.FileHeader)); err != nil {
+			return fmt.Errorf("problem overriding FileHeader of %s: %v", f.filepath, err)
+		}
+		contents = ioutil.NopCloser(&buf)
+	} else {
+		bs, err := readACHFilepath(f.filepath)
+		if err != nil {
+			return fmt.Errorf("problem opening %s for upload: %v", f.filepath, err)
+		}
+		contents = ioutil.NopCloser(bytes.NewReader(bs))
 	}
-	defer fd.Close()
+	defer contents.Close()
 
-	if err := agent.UploadFile(filetransfer.File{Filename: filepath.Base(f.filepath), Contents: fd}); err != nil {
+	if err := agent.UploadFile(filetransfer.File{Filename: filename, Contents: contents}); err != nil {
 		return fmt.Errorf("problem uploading %s: %v", f.filepath, err)
 	}
-	c.logger.Log("uploadFile", fmt.Sprintf("merged: uploaded file %s", f.filepath))
+	c.logger.Log("uploadFile", fmt.Sprintf("merged: uploaded file %s as %s", f.filepath, filename))
 	filesUploaded.With("origin", f.Header.ImmediateOrigin, "destination", f.Header.ImmediateDestination).Add(1)
+
+	// Record the upload for reconciliation, the file was already sent so a failure here is only logged.
+	if c.uploadRepo != nil {
+		err := c.uploadRepo.recordFileUpload(&fileUpload{
+			Filename:    filepath.Base(f.filepath),
+			UploadedAs:  filename,
+			Origin:      f.Header.ImmediateOrigin,
+			Destination: f.Header.ImmediateDestination,
+			Uploaded:    base.NewTime(time.Now()),
+		})
+		if err != nil {
+			c.logger.Log("uploadFile", fmt.Sprintf("ERROR: problem recording upload of %s: %v", f.filepath, err))
+		}
+	}
 	return nil
 }
 
-// achFilename returns a filename for a given ACH file
-//
-// yyyy = Year of file creation
-// MM = Month of file creation
-// dd = Day of file creation
-// RTN . . . = 9-digit Routing Transit Number of the bank (ODFI or RDFI) (example: 301234567)
-// X = file sequence of the day, i.e., 1, 2, 3, ..., 9, A, B, ...
+// overrideFileHeader returns a copy of file with non-empty values from overrides written into its FileHeader.
+// The file on disk is left unchanged so it can still be matched against its CutoffTime.
+func overrideFileHeader(file *ach.File, overrides filetransfer.FileHeaderOverrides) *ach.File {
+	out := *file
+	if overrides.ImmediateOrigin != "" {
+		out.Header.ImmediateOrigin = overrides.ImmediateOrigin
+	}
+	if overrides.ImmediateOriginName != "" {
+		out.Header.ImmediateOriginName = overrides.ImmediateOriginName
+	}
+	if overrides.ImmediateDestination != "" {
+		out.Header.ImmediateDestination = overrides.ImmediateDestination
+	}
+	if overrides.ImmediateDestinationName != "" {
+		out.Header.ImmediateDestinationName = overrides.ImmediateDestinationName
+	}
+	if overrides.ReferenceCode != "" {
+		out.Header.ReferenceCode = overrides.ReferenceCode
+	}
+	if overrides.FileIDModifier != "" {
+		out.Header.FileIDModifier = overrides.FileIDModifier
+	}
+	return &out
+}
+
+// achFilename returns a filename for a given ACH file rendered from filetransfer.DefaultFilenameTemplate
 //
 // Full Example: 20181222-301234567-1.ach
+//
+// Merged files are always stored with this name (so grabLatestMergedACHFile can find them), but
+// are uploaded with the FilenameTemplate of their filetransfer.Config.
 func achFilename(routingNumber string, seq int) string {
-	s := fmt.Sprintf("%d", seq) // conver to string
-	if seq > 9 {
-		s = achFilenameSeqToStr(seq)
-	}
-	return fmt.Sprintf("%s-%s-%s.ach", time.Now().Format("20060102"), routingNumber, s)
+	filename, _ := filetransfer.RenderACHFilename(filetransfer.DefaultFilenameTemplate, filetransfer.FilenameData{
+		RoutingNumber: routingNumber,
+		N:             achFilenameSeqToStr(seq),
+	})
+	return filename
 }
 
 // achFilenameSeqToStr converts a sequence (int) to it's string value, which means 0-9 followed by A-Z
@@ -903,12 +1148,20 @@ func achFilenameSeq(filename string) int {
 }
 
 func parseACHFilepath(path string) (*ach.File, error) {
-	fd, err := os.Open(path)
+	bs, err := readACHFilepath(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseACHFile(bytes.NewReader(bs))
+}
+
+// readACHFilepath returns the plaintext contents of the ACH file at path, which is decrypted if needed.
+func readACHFilepath(path string) ([]byte, error) {
+	bs, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	defer fd.Close()
-	return parseACHFile(fd)
+	return decryptFile(bs)
 }
 
 func parseACHFile(r io.Reader) (*ach.File, error) {
@@ -952,16 +1205,26 @@ func (f *achFile) lineCount() int {
 	return lines
 }
 
-// write will overwrite f.filepath with the ach.File contents underlying achFile.
+// write will overwrite f.filepath with the encrypted ach.File contents underlying achFile.
 func (f *achFile) write() error {
+	var buf bytes.Buffer
+	if err := ach.NewWriter(&buf).Write(f.File); err != nil {
+		return err
+	}
+	bs, err := encryptFile(buf.Bytes())
+	if err != nil {
+		return err
+	}
 	fd, err := os.Create(f.filepath)
 	if err != nil {
 		return err
 	}
-	if err := ach.NewWriter(fd).Write(f.File); err != nil {
+	if _, err := fd.Write(bs); err != nil {
+		fd.Close()
 		return err
 	}
 	if err := fd.Sync(); err != nil {
+		fd.Close()
 		return err
 	}
 	return fd.Close()
@@ -970,6 +1233,10 @@ func (f *achFile) write() error {
 // grabLatestMergedACHFile will scan dir for the latest file which fits achFilename's pattern
 // for the provided routingNumber.
 //
+// Merged files are keyed by their origin and destination along with the names written in their header,
+// so Transfers for different Gateways (or destinations) aren't merged together. A new file is created
+// for incoming when no existing file has a matching header.
+//
 // grabLatestMergedACHFile will rollover files if they're at or beyond the 10k line limit
 // This function will ignore files that don't end with '*.ach'
 func grabLatestMergedACHFile(originRoutingNumber string, incoming *ach.File, dir string) (*achFile, error) {
@@ -978,41 +1245,61 @@ func grabLatestMergedACHFile(originRoutingNumber string, incoming *ach.File, dir
 		return nil, err
 	}
 
-	// Create a new mergable file if nothing was found (i.e. new routing number)
-	if len(matches) == 0 {
-		// Reset FileCreation date/time
-		now := time.Now()
-		incoming.Header.FileCreationDate = now.Format("060102") // YYMMDD
-		incoming.Header.FileCreationTime = now.Format("1504")   // HHMM
-
-		mergableFile := &achFile{
-			File:     incoming,
-			filepath: filepath.Join(dir, achFilename(originRoutingNumber, 1)),
+	// Find the latest file (by sequence number) for incoming's header
+	sort.Strings(matches) // ascending sorting
+	for i := len(matches) - 1; i >= 0; i-- {
+		file, err := parseACHFilepath(matches[i])
+		if err != nil {
+			return nil, err
+		}
+		if incoming == nil || mergableFileHeader(file.Header, incoming.Header) {
+			return &achFile{
+				File:     file,
+				filepath: matches[i],
+			}, nil
 		}
+	}
 
-		// We need to increment the FileIDModifier in the FileHeader when creating a new file.
-		mergableFile.Header.FileIDModifier = achFilenameSeqToStr(achFilenameSeq(filepath.Base(mergableFile.filepath))) // 0-9 followed by A-Z
+	// Create a new mergable file if nothing was found (i.e. new routing number or header)
+	// Reset FileCreation date/time
+	now := time.Now()
+	incoming.Header.FileCreationDate = now.Format("060102") // YYMMDD
+	incoming.Header.FileCreationTime = now.Format("1504")   // HHMM
 
-		// flush new file to disk
-		if err := mergableFile.Create(); err != nil {
-			return mergableFile, err
-		}
-		if err := mergableFile.write(); err != nil {
-			return mergableFile, err
-		}
-		return mergableFile, nil
+	mergableFile := &achFile{
+		File:     incoming,
+		filepath: filepath.Join(dir, nextMergedFilename(dir, originRoutingNumber, 1)),
 	}
 
-	// Find the latest file (by sequence number)
-	sort.Strings(matches) // ascending sorting
-	file, err := parseACHFilepath(matches[len(matches)-1])
-	if err != nil {
-		return nil, err
+	// We need to increment the FileIDModifier in the FileHeader when creating a new file.
+	mergableFile.Header.FileIDModifier = achFilenameSeqToStr(achFilenameSeq(filepath.Base(mergableFile.filepath))) // 0-9 followed by A-Z
+
+	// flush new file to disk
+	if err := mergableFile.Create(); err != nil {
+		return mergableFile, err
+	}
+	if err := mergableFile.write(); err != nil {
+		return mergableFile, err
+	}
+	return mergableFile, nil
+}
+
+// mergableFileHeader returns true if a file with the incoming header can be merged into a file with header.
+func mergableFileHeader(header, incoming ach.FileHeader) bool {
+	return header.ImmediateOrigin == incoming.ImmediateOrigin &&
+		header.ImmediateOriginName == incoming.ImmediateOriginName &&
+		header.ImmediateDestination == incoming.ImmediateDestination &&
+		header.ImmediateDestinationName == incoming.ImmediateDestinationName
+}
+
+// nextMergedFilename returns the first achFilename for routingNumber, starting at seq, which doesn't exist in dir.
+func nextMergedFilename(dir, routingNumber string, seq int) string {
+	for ; ; seq++ {
+		filename := achFilename(routingNumber, seq)
+		if _, err := os.Stat(filepath.Join(dir, filename)); os.IsNotExist(err) {
+			return filename
+		}
 	}
-	return &achFile{
-		File:     file,
-		filepath: matches[len(matches)-1],
-	}, nil
 }
 
 // groupTransfers will return groupableTransfers grouped according to their origin RoutingNumber
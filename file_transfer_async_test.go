@@ -38,7 +38,7 @@ func NestFileTransferController__newFileTransferController(t *testing.T) {
 
 	repo := filetransfer.NewRepository(nil, "local") // filetransfer.localFileTransferRepository
 
-	controller, err := NewFileTransferController(log.NewNopLogger(), dir, repo, nil, nil, true)
+	controller, err := NewFileTransferController(log.NewNopLogger(), dir, repo, nil, nil, nil, nil, nil, nil, nil, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -172,7 +172,7 @@ func TestFileTransferController__startPeriodicFileOperations(t *testing.T) {
 	defer achServer.Close()
 
 	// setuo transfer controller to start a manual merge and upload
-	controller, err := NewFileTransferController(logger, dir, repo, achClient, nil, false)
+	controller, err := NewFileTransferController(logger, dir, repo, achClient, nil, nil, nil, nil, nil, nil, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -314,9 +314,9 @@ func TestFileTransferController__saveRemoteFiles(t *testing.T) {
 		t.Errorf("SEC code found is %s", v)
 	}
 
-	// latest deleted file should be our return WEB
-	if !strings.Contains(agent.deletedFile, "return-WEB.ach") && !strings.Contains(agent.deletedFile, "ppd-debit.ach") {
-		t.Errorf("deleted file was %s", agent.deletedFile)
+	// files are only deleted after processing
+	if agent.deletedFile != "" {
+		t.Errorf("deleted file %s", agent.deletedFile)
 	}
 }
 func TestFileTransferController__filesNearTheirCutoff(t *testing.T) {
@@ -380,6 +380,77 @@ func TestFileTransferController__filesNearTheirCutoff(t *testing.T) {
 	}
 }
 
+func TestFileTransferController__alertFilesNotUploaded(t *testing.T) {
+	nyc, _ := time.LoadLocation("America/New_York")
+	now := time.Now().In(nyc)
+	if now.Hour() == 23 && now.Minute() == 59 {
+		t.Skip("cutoff would wrap into tomorrow")
+	}
+
+	dir, err := ioutil.TempDir("", "alertFilesNotUploaded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// one file waiting for upload and one held for approval
+	os.MkdirAll(filepath.Join(dir, "awaiting"), 0777)
+	copyACHFile(t, filepath.Join("testdata", "ppd-debit.ach"), dir)
+	copyACHFile(t, filepath.Join("testdata", "ppd-debit.ach"), filepath.Join(dir, "awaiting"))
+
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	eventRepo := NewEventRepo(log.NewNopLogger(), db.DB, nil)
+
+	userID := base.ID()
+	xfer := &Transfer{ID: TransferID(base.ID()), Status: TransferPending, userID: userID}
+	transferRepo := &mockTransferRepository{xfer: xfer}
+
+	next := now.Add(time.Minute)
+	var buf bytes.Buffer
+	controller := &fileTransferController{
+		cutoffTimes: []*filetransfer.CutoffTime{
+			{
+				RoutingNumber: "076401251",
+				Cutoff:        (next.Hour() * 100) + next.Minute(),
+				Loc:           nyc,
+			},
+		},
+		eventRepo: eventRepo,
+		logger:    log.NewLogfmtLogger(&buf),
+	}
+	if err := controller.alertFilesNotUploaded(dir, now, transferRepo); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(buf.String(), "ALERT"); n != 2 {
+		t.Errorf("got %d alerts: %s", n, buf.String())
+	}
+	events, err := eventRepo.getUserEvents(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Type != FileEvent || events[0].Metadata[eventFileID] != achFilename("076401251", 1) {
+		t.Errorf("unexpected events: %#v", events)
+	}
+
+	// files are only alerted on once
+	if err := controller.alertFilesNotUploaded(dir, now, transferRepo); err != nil {
+		t.Fatal(err)
+	}
+	if events, err := eventRepo.getUserEvents(userID); err != nil || len(events) != 2 {
+		t.Errorf("got %d events: %v", len(events), err)
+	}
+
+	// cutoff is far enough out that nothing is alerted
+	buf.Reset()
+	if err := controller.alertFilesNotUploaded(dir, now.Add(-1*time.Hour), transferRepo); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("unexpected alerts: %s", buf.String())
+	}
+}
+
 func TestFileTransferController__mergeTransfer(t *testing.T) {
 	// build a mergableFile from an example WEB entry
 	webFile, err := parseACHFilepath(filepath.Join("testdata", "return-WEB.ach"))
@@ -508,6 +579,97 @@ func TestFileTransferController__mergeGroupableTransfer(t *testing.T) {
 	}
 }
 
+func TestFileTransferController__mergeGroupableTransferGateway(t *testing.T) {
+	achClient, _, achServer := achclient.MockClientServer("mergeGroupableTransfer", func(r *mux.Router) {
+		achFileContentsRoute(r)
+	})
+	defer achServer.Close()
+
+	dir, err := ioutil.TempDir("", "mergeGroupableTransfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	gateway := &Gateway{
+		ID:              GatewayID(base.ID()),
+		Origin:          "231380104",
+		OriginName:      "gateway origin",
+		Destination:     "031300012",
+		DestinationName: "gateway destination",
+	}
+	controller := &fileTransferController{
+		ach:         achClient,
+		gatewayRepo: &mockGatewayRepository{gateways: []*Gateway{gateway}},
+		logger:      log.NewNopLogger(),
+	}
+
+	xfer := &groupableTransfer{
+		Transfer: &Transfer{
+			ID:      TransferID(base.ID()),
+			Gateway: gateway.ID,
+		},
+		origin: "076401251", // from testdata/ppd-debit.ach
+	}
+
+	repo := &mockTransferRepository{}
+	repo.fileID = "foo" // some non-empty value, our test ACH server doesn't care
+	if fileToUpload := controller.mergeGroupableTransfer(dir, xfer, repo); fileToUpload != nil {
+		t.Errorf("didn't expect fileToUpload=%v", fileToUpload)
+	}
+
+	// the transfer is merged into a file for the Gateway's origin
+	matches, err := filepath.Glob(filepath.Join(dir, "*-076401251-*.ach"))
+	if err != nil || len(matches) != 0 {
+		t.Errorf("unexpected files: %v (error=%v)", matches, err)
+	}
+	file, err := controller.loadIncomingFile("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setGatewayFileHeader(&file.Header, gateway)
+	mergableFile, err := grabLatestMergedACHFile(gateway.Origin, file, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mergableFile.Batches) != 1 {
+		t.Errorf("len(mergableFile.Batches)=%d", len(mergableFile.Batches))
+	}
+	header := mergableFile.Header
+	if header.ImmediateOrigin != gateway.Origin || header.ImmediateOriginName != gateway.OriginName {
+		t.Errorf("origin=%s name=%s", header.ImmediateOrigin, header.ImmediateOriginName)
+	}
+	if header.ImmediateDestination != gateway.Destination || header.ImmediateDestinationName != gateway.DestinationName {
+		t.Errorf("destination=%s name=%s", header.ImmediateDestination, header.ImmediateDestinationName)
+	}
+
+	// a Gateway with the same origin, but another destination, gets its own file
+	other := &Gateway{
+		ID:              GatewayID(base.ID()),
+		Origin:          gateway.Origin,
+		OriginName:      gateway.OriginName,
+		Destination:     "121042882",
+		DestinationName: "other destination",
+	}
+	controller.gatewayRepo = &mockGatewayRepository{gateways: []*Gateway{gateway, other}}
+	xfer.ID, xfer.Gateway = TransferID(base.ID()), other.ID
+	if fileToUpload := controller.mergeGroupableTransfer(dir, xfer, repo); fileToUpload != nil {
+		t.Errorf("didn't expect fileToUpload=%v", fileToUpload)
+	}
+	matches, err = filepath.Glob(filepath.Join(dir, "*-231380104-*.ach"))
+	if err != nil || len(matches) != 2 {
+		t.Fatalf("unexpected files: %v (error=%v)", matches, err)
+	}
+	first, err := parseACHFilepath(matches[0])
+	if err != nil || first.Header.ImmediateDestination != gateway.Destination {
+		t.Errorf("first file's header was changed: %#v (error=%v)", first.Header, err)
+	}
+	second, err := parseACHFilepath(matches[1])
+	if err != nil || second.Header.ImmediateDestination != other.Destination || second.Header.ImmediateDestinationName != other.DestinationName {
+		t.Errorf("unexpected header: %#v (error=%v)", second.Header, err)
+	}
+}
+
 func TestFileTransferController__mergeMicroDeposit(t *testing.T) {
 	achClient, _, achServer := achclient.MockClientServer("mergeMicroDeposit", func(r *mux.Router) {
 		achFileContentsRoute(r)
@@ -606,7 +768,7 @@ func TestFileTransferController__uploadFile(t *testing.T) {
 	controller := &fileTransferController{
 		logger: log.NewNopLogger(),
 	}
-	if err := controller.uploadFile(agent, &achFile{File: file, filepath: filepath.Join("testdata", "ppd-debit.ach")}); err != nil {
+	if err := controller.uploadFile(agent, nil, &achFile{File: file, filepath: filepath.Join("testdata", "ppd-debit.ach")}); err != nil {
 		t.Error(err)
 	}
 
@@ -621,6 +783,60 @@ func TestFileTransferController__uploadFile(t *testing.T) {
 	}
 }
 
+func TestFileTransferController__uploadFileOverrides(t *testing.T) {
+	agent := &mockFileTransferAgent{}
+	file, err := parseACHFilepath(filepath.Join("testdata", "ppd-debit.ach"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	controller := &fileTransferController{
+		uploadRepo: NewFileUploadRepo(log.NewNopLogger(), db.DB),
+		logger:     log.NewNopLogger(),
+	}
+	cfg := &filetransfer.Config{
+		RoutingNumber:    "076401251",
+		FilenameTemplate: "PAYGATE-{{ .RoutingNumber }}-{{ .N }}.txt",
+		FileHeader: filetransfer.FileHeaderOverrides{
+			ImmediateOriginName: "Moov Bank",
+			ReferenceCode:       "REF1",
+			FileIDModifier:      "C",
+		},
+	}
+	f := &achFile{File: file, filepath: filepath.Join("testdata", achFilename("076401251", 2))}
+	if err := controller.uploadFile(agent, cfg, f); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := agent.uploadedFile.Filename; v != "PAYGATE-076401251-2.txt" {
+		t.Errorf("got %v", v)
+	}
+	upload, err := controller.uploadRepo.getFileUpload(filepath.Base(f.filepath))
+	if err != nil || upload == nil {
+		t.Fatalf("upload=%v error=%v", upload, err)
+	}
+	if upload.UploadedAs != "PAYGATE-076401251-2.txt" || upload.Origin != file.Header.ImmediateOrigin {
+		t.Errorf("unexpected upload: %#v", upload)
+	}
+	uploaded, err := parseACHFile(agent.uploadedFile.Contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uploaded.Header.ImmediateOriginName != "Moov Bank" || uploaded.Header.ReferenceCode != "REF1" || uploaded.Header.FileIDModifier != "C" {
+		t.Errorf("unexpected FileHeader: %#v", uploaded.Header)
+	}
+	if uploaded.Header.ImmediateDestination != file.Header.ImmediateDestination {
+		t.Errorf("ImmediateDestination=%s", uploaded.Header.ImmediateDestination)
+	}
+
+	// our in-memory file is unchanged
+	if file.Header.ImmediateOriginName == "Moov Bank" {
+		t.Errorf("FileHeader was modified: %#v", file.Header)
+	}
+}
+
 func TestFileTransferController__achFilename(t *testing.T) {
 	now := time.Now().Format("20060102")
 
@@ -846,6 +1062,7 @@ func TestFileTransferController__processReturnEntry(t *testing.T) {
 	}
 	transferRepo := &mockTransferRepository{
 		xfer: &Transfer{
+			ID:                     TransferID(base.ID()),
 			Type:                   PushTransfer,
 			Amount:                 *amt,
 			Originator:             OriginatorID("originator"),
@@ -864,7 +1081,11 @@ func TestFileTransferController__processReturnEntry(t *testing.T) {
 
 	repo := filetransfer.NewRepository(nil, "local") // filetransfer.localFileTransferRepository
 
-	controller, err := NewFileTransferController(log.NewNopLogger(), dir, repo, nil, nil, true)
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	eventRepo := &SQLEventRepo{db.DB, log.NewNopLogger(), nil}
+
+	controller, err := NewFileTransferController(log.NewNopLogger(), dir, repo, nil, nil, nil, nil, nil, nil, eventRepo, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -883,6 +1104,13 @@ func TestFileTransferController__processReturnEntry(t *testing.T) {
 	if transferRepo.status != TransferReclaimed {
 		t.Errorf("unexpected status: %v", transferRepo.status)
 	}
+	events, err := eventRepo.getUserTransferEvents(userID, transferRepo.xfer.ID)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("events=%#v error=%v", events, err)
+	}
+	if events[0].Type != ReturnEvent || !strings.Contains(events[0].Message, "R02") || len(events[0].Payload) == 0 {
+		t.Errorf("unexpected event: %#v", events[0])
+	}
 
 	// Check quick error conditions
 	depRepo.err = errors.New("bad error")
@@ -898,6 +1126,70 @@ func TestFileTransferController__processReturnEntry(t *testing.T) {
 	transferRepo.err = nil
 }
 
+func TestFileTransferController__processReturnEntryRevokesAuthorizations(t *testing.T) {
+	file, err := parseACHFilepath(filepath.Join("testdata", "return-WEB.ach"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := file.Batches[0]
+	b.GetEntries()[0].Addenda99.ReturnCode = "R10" // "Customer Advises Not Authorized"
+
+	amt, _ := NewAmount("USD", "52.12")
+	depRepo := &mockDepositoryRepository{
+		depositories: []*Depository{
+			{
+				ID:            DepositoryID(base.ID()),
+				RoutingNumber: file.Header.ImmediateOrigin,
+				Status:        DepositoryVerified,
+			},
+			{
+				ID:            DepositoryID(base.ID()),
+				RoutingNumber: file.Header.ImmediateDestination,
+				AccountNumber: b.GetEntries()[0].DFIAccountNumber,
+				Status:        DepositoryVerified,
+			},
+		},
+	}
+	transferRepo := &mockTransferRepository{
+		xfer: &Transfer{
+			Type:                   PullTransfer,
+			Amount:                 *amt,
+			Receiver:               ReceiverID("receiver"),
+			ReceiverDepository:     DepositoryID("rec-depository"),
+			StandardEntryClassCode: "WEB",
+			userID:                 base.ID(),
+		},
+	}
+	auth := testAuthorization("receiver", "rec-depository")
+	authorizationRepo := &mockAuthorizationRepository{
+		auths: []*Authorization{auth, testAuthorization("receiver", "other-depository")},
+	}
+
+	controller := &fileTransferController{
+		authorizationRepo: authorizationRepo,
+		logger:            log.NewNopLogger(),
+	}
+	if err := controller.processReturnEntry(file.Header, b.GetHeader(), b.GetEntries()[0], depRepo, transferRepo); err != nil {
+		t.Error(err)
+	}
+	if authorizationRepo.revoked != 1 || auth.Status != AuthorizationRevoked {
+		t.Errorf("revoked=%d authorization=%#v", authorizationRepo.revoked, auth)
+	}
+	if !strings.HasPrefix(auth.RevocationReason, "R10: ") {
+		t.Errorf("unexpected reason: %q", auth.RevocationReason)
+	}
+
+	// other return codes don't revoke Authorizations
+	b.GetEntries()[0].Addenda99.ReturnCode = "R02"
+	authorizationRepo.revoked = 0
+	if err := controller.processReturnEntry(file.Header, b.GetHeader(), b.GetEntries()[0], depRepo, transferRepo); err != nil {
+		t.Error(err)
+	}
+	if authorizationRepo.revoked != 0 {
+		t.Errorf("revoked %d authorizations", authorizationRepo.revoked)
+	}
+}
+
 // depositoryReturnCode writes two Depository objects into a database and then calls updateDepositoryFromReturnCode
 // over the provided return code. The two Depository objects returned are re-read from the database after.
 func depositoryReturnCode(t *testing.T, code string) (*Depository, *Depository) {
@@ -0,0 +1,99 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/moov-io/ach"
+)
+
+// balanceFile adds offset EntryDetail records to each batch in f against our ODFIAccount, so every debit
+// batch has an offsetting credit (and every credit batch has an offsetting debit). The file is validated
+// and re-written to disk afterwards.
+//
+// Batches which already contain offset records are left untouched, so calling balanceFile multiple times
+// on the same file (i.e. after a failed upload) does not duplicate offsets.
+func (c *fileTransferController) balanceFile(f *achFile) error {
+	if c.odfiAccount == nil {
+		return errors.New("missing ODFIAccount for balanced file")
+	}
+	offset := c.odfiAccount.offset()
+
+	changed := false
+	for i := range f.Batches {
+		if !needsOffset(f.Batches[i], offset) {
+			continue
+		}
+		b, ok := f.Batches[i].(interface{ WithOffset(*ach.Offset) })
+		if !ok {
+			return fmt.Errorf("batch %s (%s) does not support offset records", f.Batches[i].ID(), f.Batches[i].GetHeader().StandardEntryClassCode)
+		}
+		// Entries read from disk don't always have their Category set, but our offsets are forward entries.
+		entries := f.Batches[i].GetEntries()
+		for j := range entries {
+			if entries[j].Category == "" {
+				entries[j].Category = ach.CategoryForward
+			}
+		}
+		b.WithOffset(offset)
+		f.Batches[i].GetHeader().ServiceClassCode = ach.MixedDebitsAndCredits // offsets make every batch mixed
+		if err := f.Batches[i].Create(); err != nil {
+			return fmt.Errorf("batch %s offset: %v", f.Batches[i].ID(), err)
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	if err := f.Create(); err != nil {
+		return fmt.Errorf("file create: %v", err)
+	}
+	if err := f.Validate(); err != nil {
+		return fmt.Errorf("file validate: %v", err)
+	}
+	return f.write()
+}
+
+// needsOffset returns true if batch has entries and none of them are offset records, which are entries
+// against offset's routing and account number.
+func needsOffset(batch ach.Batcher, offset *ach.Offset) bool {
+	if batch == nil || offset == nil {
+		return false
+	}
+	entries := batch.GetEntries()
+	if len(entries) == 0 {
+		return false
+	}
+	for i := range entries {
+		if isOffsetEntry(entries[i], offset) {
+			return false
+		}
+	}
+	return true
+}
+
+func isOffsetEntry(ed *ach.EntryDetail, offset *ach.Offset) bool {
+	if ed == nil {
+		return false
+	}
+	routingNumber := ed.RDFIIdentification + ed.CheckDigit
+	return routingNumber == offset.RoutingNumber && strings.TrimSpace(ed.DFIAccountNumber) == strings.TrimSpace(offset.AccountNumber)
+}
+
+// offset returns the ach.Offset for balancing files against our ODFI account.
+func (a *ODFIAccount) offset() *ach.Offset {
+	off := &ach.Offset{
+		RoutingNumber: a.routingNumber,
+		AccountNumber: a.accountNumber,
+		AccountType:   ach.OffsetChecking,
+	}
+	if a.accountType == Savings {
+		off.AccountType = ach.OffsetSavings
+	}
+	return off
+}
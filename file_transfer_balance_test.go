@@ -0,0 +1,112 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/moov-io/ach"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestFileTransferController__balanceFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "balanceFile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file, err := parseACHFilepath(filepath.Join("testdata", "ppd-debit.ach"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &achFile{File: file, filepath: filepath.Join(dir, "balanced.ach")}
+
+	controller := &fileTransferController{
+		odfiAccount: NewODFIAccount(nil, "123456789012", "121042882", Savings),
+		logger:      log.NewNopLogger(),
+	}
+	if err := controller.balanceFile(f); err != nil {
+		t.Fatal(err)
+	}
+
+	// read the file back from disk
+	file, err = parseACHFilepath(f.filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	entries := file.Batches[0].GetEntries()
+	offset := entries[len(entries)-1]
+	if !strings.EqualFold(strings.TrimSpace(offset.IndividualName), "OFFSET") {
+		t.Fatalf("unexpected last entry: %#v", offset)
+	}
+	if offset.TransactionCode != ach.SavingsCredit {
+		t.Errorf("offset.TransactionCode=%d", offset.TransactionCode)
+	}
+	if offset.RDFIIdentification != "12104288" || strings.TrimSpace(offset.DFIAccountNumber) != "123456789012" {
+		t.Errorf("offset.RDFIIdentification=%s offset.DFIAccountNumber=%s", offset.RDFIIdentification, offset.DFIAccountNumber)
+	}
+	if v := file.Batches[0].GetHeader().ServiceClassCode; v != ach.MixedDebitsAndCredits {
+		t.Errorf("ServiceClassCode=%d", v)
+	}
+	if file.Control.TotalCreditEntryDollarAmountInFile != file.Control.TotalDebitEntryDollarAmountInFile {
+		t.Errorf("unbalanced file: credits=%d debits=%d", file.Control.TotalCreditEntryDollarAmountInFile, file.Control.TotalDebitEntryDollarAmountInFile)
+	}
+
+	// balancing again shouldn't add more offsets
+	f = &achFile{File: file, filepath: f.filepath}
+	if err := controller.balanceFile(f); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(f.Batches[0].GetEntries()); n != len(entries) {
+		t.Errorf("got %d entries, expected %d", n, len(entries))
+	}
+}
+
+func TestFileTransferController__needsOffset(t *testing.T) {
+	file, err := parseACHFilepath(filepath.Join("testdata", "ppd-debit.ach"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := file.Batches[0]
+	offset := NewODFIAccount(nil, "123456789012", "121042882", Savings).offset()
+
+	// a Receiver named "OFFSET" isn't an offset record
+	batch.GetEntries()[0].IndividualName = "OFFSET"
+	if !needsOffset(batch, offset) {
+		t.Error("expected batch to need an offset")
+	}
+
+	// entries against the ODFI account are
+	ed := batch.GetEntries()[0]
+	ed.RDFIIdentification = "12104288"
+	ed.CheckDigit = "2"
+	ed.DFIAccountNumber = "123456789012     "
+	if needsOffset(batch, offset) {
+		t.Error("batch already has an offset")
+	}
+	if needsOffset(nil, offset) {
+		t.Error("nil batch")
+	}
+}
+
+func TestFileTransferController__balanceFileErr(t *testing.T) {
+	file, err := parseACHFilepath(filepath.Join("testdata", "ppd-debit.ach"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	controller := &fileTransferController{logger: log.NewNopLogger()}
+	if err := controller.balanceFile(&achFile{File: file}); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+)
+
+// fileUpload is a record of a merged ACH file which was uploaded to its ODFI.
+type fileUpload struct {
+	// Filename is the merged file's name on disk, which Transfers store as their merged_filename
+	Filename string
+	// UploadedAs is the name the file was uploaded with, which can differ when a FilenameTemplate is set
+	UploadedAs string
+
+	Origin      string
+	Destination string
+	Uploaded    base.Time
+}
+
+type fileUploadRepository interface {
+	recordFileUpload(upload *fileUpload) error
+
+	// getFileUpload returns the latest upload of filename, or nil if it was never uploaded.
+	getFileUpload(filename string) (*fileUpload, error)
+}
+
+func NewFileUploadRepo(logger log.Logger, db *sql.DB) *SQLFileUploadRepo {
+	return &SQLFileUploadRepo{log: logger, db: db}
+}
+
+type SQLFileUploadRepo struct {
+	db  *sql.DB
+	log log.Logger
+}
+
+func (r *SQLFileUploadRepo) Close() error {
+	return r.db.Close()
+}
+
+func (r *SQLFileUploadRepo) recordFileUpload(upload *fileUpload) error {
+	query := `insert into file_uploads (filename, uploaded_as, origin, destination, uploaded_at) values (?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(upload.Filename, upload.UploadedAs, upload.Origin, upload.Destination, upload.Uploaded.Time)
+	return err
+}
+
+func (r *SQLFileUploadRepo) getFileUpload(filename string) (*fileUpload, error) {
+	query := `select filename, uploaded_as, origin, destination, uploaded_at from file_uploads where filename = ? order by uploaded_at desc limit 1;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var (
+		upload   fileUpload
+		uploaded time.Time
+	)
+	err = stmt.QueryRow(filename).Scan(&upload.Filename, &upload.UploadedAs, &upload.Origin, &upload.Destination, &uploaded)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	upload.Uploaded = base.NewTime(uploaded)
+	return &upload, nil
+}
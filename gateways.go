@@ -10,13 +10,12 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/moov-io/ach"
 	"github.com/moov-io/base"
 	moovhttp "github.com/moov-io/base/http"
-	"github.com/moov-io/paygate/internal/database"
+	"github.com/moov-io/paygate/internal/filetransfer"
 
 	"github.com/go-kit/kit/log"
 	"github.com/gorilla/mux"
@@ -24,10 +23,15 @@ import (
 
 type GatewayID string
 
+// Gateway holds the routing numbers and names written in the header of ACH files created for a user's Transfers.
+// Users can have several Gateways, Transfers choose one by ID and otherwise use the user's default Gateway.
 type Gateway struct {
 	// ID is a unique string representing this Gateway.
 	ID GatewayID `json:"id"`
 
+	// Name is a short label for this Gateway.
+	Name string `json:"name,omitempty"`
+
 	// Origin is an ABA routing number
 	Origin string `json:"origin"`
 
@@ -40,8 +44,14 @@ type Gateway struct {
 	// DestinationName is the legal name associated with the destination routing number.
 	DestinationName string `json:"destinationName"`
 
+	// Default is true for the Gateway used by Transfers which don't specify one.
+	Default bool `json:"default"`
+
 	// Created a timestamp representing the initial creation date of the object in ISO 8601
 	Created base.Time `json:"created"`
+
+	// Updated is a timestamp when the object was last modified in ISO8601 format
+	Updated base.Time `json:"updated"`
 }
 
 func (g *Gateway) validate() error {
@@ -68,10 +78,12 @@ func (g *Gateway) validate() error {
 }
 
 type gatewayRequest struct {
+	Name            string `json:"name,omitempty"`
 	Origin          string `json:"origin"`
 	OriginName      string `json:"originName"`
 	Destination     string `json:"destination"`
 	DestinationName string `json:"destinationName"`
+	Default         bool   `json:"default,omitempty"`
 }
 
 func (r gatewayRequest) missingFields() error {
@@ -90,12 +102,56 @@ func (r gatewayRequest) missingFields() error {
 	return nil
 }
 
-func AddGatewayRoutes(logger log.Logger, r *mux.Router, gatewayRepo gatewayRepository) {
-	r.Methods("GET").Path("/gateways").HandlerFunc(getUserGateway(logger, gatewayRepo))
-	r.Methods("POST").Path("/gateways").HandlerFunc(createUserGateway(logger, gatewayRepo))
+func AddGatewayRoutes(logger log.Logger, r *mux.Router, gatewayRepo gatewayRepository, fileTransferRepo filetransfer.Repository) {
+	// GET /gateways has always returned one Gateway object, so the list is on /v2/gateways
+	r.Methods("GET").Path("/gateways").HandlerFunc(getDefaultGateway(logger, gatewayRepo))
+	r.Methods("GET").Path("/v2/gateways").HandlerFunc(getUserGateways(logger, gatewayRepo))
+	r.Methods("POST").Path("/gateways").HandlerFunc(createUserGateway(logger, gatewayRepo, fileTransferRepo))
+
+	r.Methods("GET").Path("/gateways/{gatewayId}").HandlerFunc(getUserGateway(logger, gatewayRepo))
+	r.Methods("PATCH").Path("/gateways/{gatewayId}").HandlerFunc(updateUserGateway(logger, gatewayRepo, fileTransferRepo))
+	r.Methods("DELETE").Path("/gateways/{gatewayId}").HandlerFunc(deleteUserGateway(logger, gatewayRepo))
 }
 
-func getUserGateway(logger log.Logger, gatewayRepo gatewayRepository) http.HandlerFunc {
+// getGatewayID extracts the GatewayID from the incoming request.
+func getGatewayID(r *http.Request) GatewayID {
+	v, ok := mux.Vars(r)["gatewayId"]
+	if !ok {
+		return GatewayID("")
+	}
+	return GatewayID(v)
+}
+
+// checkGatewayCutoffTime returns an error if origin has no cutoff time. Merged files are uploaded (and alerted on)
+// according to the cutoff time of their ImmediateOrigin, so files for such a Gateway would never be sent.
+func checkGatewayCutoffTime(fileTransferRepo filetransfer.Repository, origin string) error {
+	cutoffTimes, err := fileTransferRepo.GetCutoffTimes()
+	if err != nil {
+		return fmt.Errorf("problem reading cutoff times: %v", err)
+	}
+	for i := range cutoffTimes {
+		if cutoffTimes[i].RoutingNumber == origin {
+			return nil
+		}
+	}
+	return fmt.Errorf("no cutoff time for Gateway origin %s", origin)
+}
+
+func readGatewayRequest(r *http.Request) (gatewayRequest, error) {
+	var req gatewayRequest
+	bs, err := read(r.Body)
+	if err != nil {
+		return req, err
+	}
+	if err := json.Unmarshal(bs, &req); err != nil {
+		return req, err
+	}
+	return req, nil
+}
+
+// getDefaultGateway returns the user's default Gateway, which is what GET /gateways returned before users could have
+// several Gateways.
+func getDefaultGateway(logger log.Logger, gatewayRepo gatewayRepository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w, err := wrapResponseWriter(logger, w, r)
 		if err != nil {
@@ -103,11 +159,15 @@ func getUserGateway(logger log.Logger, gatewayRepo gatewayRepository) http.Handl
 		}
 
 		userID := moovhttp.GetUserID(r)
-		gateway, err := gatewayRepo.getUserGateway(userID)
+		gateway, err := gatewayRepo.getDefaultGateway(userID)
 		if err != nil {
 			moovhttp.Problem(w, err)
 			return
 		}
+		if gateway == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		w.WriteHeader(http.StatusOK)
@@ -115,28 +175,70 @@ func getUserGateway(logger log.Logger, gatewayRepo gatewayRepository) http.Handl
 	}
 }
 
-func createUserGateway(logger log.Logger, gatewayRepo gatewayRepository) http.HandlerFunc {
+func getUserGateways(logger log.Logger, gatewayRepo gatewayRepository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w, err := wrapResponseWriter(logger, w, r)
 		if err != nil {
 			return
 		}
 
-		bs, err := read(r.Body)
+		userID := moovhttp.GetUserID(r)
+		gateways, err := gatewayRepo.getUserGateways(userID)
 		if err != nil {
 			moovhttp.Problem(w, err)
 			return
 		}
-		var req gatewayRequest
-		if err := json.Unmarshal(bs, &req); err != nil {
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(gateways)
+	}
+}
+
+func getUserGateway(logger log.Logger, gatewayRepo gatewayRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		id, userID := getGatewayID(r), moovhttp.GetUserID(r)
+		gateway, err := gatewayRepo.getUserGateway(id, userID)
+		if err != nil {
 			moovhttp.Problem(w, err)
 			return
 		}
+		if gateway == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
 
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(gateway)
+	}
+}
+
+func createUserGateway(logger log.Logger, gatewayRepo gatewayRepository, fileTransferRepo filetransfer.Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		req, err := readGatewayRequest(r)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
 		if err := req.missingFields(); err != nil {
 			moovhttp.Problem(w, fmt.Errorf("%v: %v", errMissingRequiredJson, err))
 			return
 		}
+		if err := checkGatewayCutoffTime(fileTransferRepo, req.Origin); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
 
 		userID := moovhttp.GetUserID(r)
 		gateway, err := gatewayRepo.createUserGateway(userID, req)
@@ -151,9 +253,96 @@ func createUserGateway(logger log.Logger, gatewayRepo gatewayRepository) http.Ha
 	}
 }
 
+func updateUserGateway(logger log.Logger, gatewayRepo gatewayRepository, fileTransferRepo filetransfer.Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		req, err := readGatewayRequest(r)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		if req.Origin != "" {
+			if err := checkGatewayCutoffTime(fileTransferRepo, req.Origin); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+		}
+
+		id, userID := getGatewayID(r), moovhttp.GetUserID(r)
+		requestID := moovhttp.GetRequestID(r)
+
+		gateway, err := gatewayRepo.updateUserGateway(id, userID, req)
+		if err != nil {
+			logger.Log("gateways", fmt.Sprintf("problem updating gateway=%s: %v", id, err), "requestID", requestID, "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		if gateway == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(gateway)
+	}
+}
+
+func deleteUserGateway(logger log.Logger, gatewayRepo gatewayRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		id, userID := getGatewayID(r), moovhttp.GetUserID(r)
+		if err := gatewayRepo.deleteUserGateway(id, userID); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// getTransferGateway returns the Gateway a Transfer is created with. An empty id picks the user's default
+// Gateway, which is nil when they haven't created any Gateways.
+func getTransferGateway(gatewayRepo gatewayRepository, id GatewayID, userID string) (*Gateway, error) {
+	if id == "" {
+		return gatewayRepo.getDefaultGateway(userID)
+	}
+	gateway, err := gatewayRepo.getUserGateway(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if gateway == nil {
+		return nil, fmt.Errorf("gateway=%s not found", id)
+	}
+	return gateway, nil
+}
+
+// setGatewayFileHeader writes the Gateway's routing numbers and names into an ACH file header.
+func setGatewayFileHeader(header *ach.FileHeader, gateway *Gateway) {
+	header.ImmediateOrigin = gateway.Origin
+	header.ImmediateOriginName = gateway.OriginName
+	header.ImmediateDestination = gateway.Destination
+	header.ImmediateDestinationName = gateway.DestinationName
+}
+
 type gatewayRepository interface {
-	getUserGateway(userID string) (*Gateway, error)
+	getUserGateways(userID string) ([]*Gateway, error)
+	getUserGateway(id GatewayID, userID string) (*Gateway, error)
+	getDefaultGateway(userID string) (*Gateway, error)
+
 	createUserGateway(userID string, req gatewayRequest) (*Gateway, error)
+	updateUserGateway(id GatewayID, userID string, req gatewayRequest) (*Gateway, error)
+	deleteUserGateway(id GatewayID, userID string) error
 }
 
 func NewGatewayRepo(logger log.Logger, db *sql.DB) *SQLGatewayRepo {
@@ -169,67 +358,192 @@ func (r *SQLGatewayRepo) Close() error {
 	return r.db.Close()
 }
 
+const gatewayColumns = `gateway_id, coalesce(name, ''), origin, origin_name, destination, destination_name, coalesce(is_default, 0), created_at, last_updated_at`
+
+func scanGateway(row interface{ Scan(...interface{}) error }) (*Gateway, error) {
+	gateway := &Gateway{}
+	var (
+		created time.Time
+		updated *time.Time
+	)
+	err := row.Scan(&gateway.ID, &gateway.Name, &gateway.Origin, &gateway.OriginName, &gateway.Destination, &gateway.DestinationName, &gateway.Default, &created, &updated)
+	if err != nil {
+		return nil, err
+	}
+	gateway.Created = base.NewTime(created)
+	gateway.Updated = gateway.Created
+	if updated != nil {
+		gateway.Updated = base.NewTime(*updated)
+	}
+	return gateway, nil
+}
+
+func (r *SQLGatewayRepo) getUserGateways(userID string) ([]*Gateway, error) {
+	query := fmt.Sprintf(`select %s from gateways where user_id = ? and deleted_at is null order by created_at asc`, gatewayColumns)
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var gateways []*Gateway
+	for rows.Next() {
+		gateway, err := scanGateway(rows)
+		if err != nil {
+			return nil, fmt.Errorf("getUserGateways: scan: %v", err)
+		}
+		gateways = append(gateways, gateway)
+	}
+	return gateways, rows.Err()
+}
+
+func (r *SQLGatewayRepo) getGateway(query string, args ...interface{}) (*Gateway, error) {
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	gateway, err := scanGateway(stmt.QueryRow(args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // not found
+		}
+		return nil, err
+	}
+	return gateway, nil
+}
+
+func (r *SQLGatewayRepo) getUserGateway(id GatewayID, userID string) (*Gateway, error) {
+	query := fmt.Sprintf(`select %s from gateways where gateway_id = ? and user_id = ? and deleted_at is null limit 1`, gatewayColumns)
+	return r.getGateway(query, id, userID)
+}
+
+func (r *SQLGatewayRepo) getDefaultGateway(userID string) (*Gateway, error) {
+	query := fmt.Sprintf(`select %s from gateways where user_id = ? and is_default = 1 and deleted_at is null limit 1`, gatewayColumns)
+	return r.getGateway(query, userID)
+}
+
+// clearDefaultGateway unmarks the user's default Gateway so another can take its place.
+func clearDefaultGateway(tx *sql.Tx, userID string, now time.Time) error {
+	query := `update gateways set is_default = 0, last_updated_at = ? where user_id = ? and is_default = 1 and deleted_at is null`
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(now, userID)
+	return err
+}
+
+// createUserGateway saves a new Gateway. The user's first Gateway becomes their default.
 func (r *SQLGatewayRepo) createUserGateway(userID string, req gatewayRequest) (*Gateway, error) {
+	now := time.Now()
 	gateway := &Gateway{
+		ID:              GatewayID(base.ID()),
+		Name:            req.Name,
 		Origin:          req.Origin,
 		OriginName:      req.OriginName,
 		Destination:     req.Destination,
 		DestinationName: req.DestinationName,
-		Created:         base.NewTime(time.Now()),
+		Default:         req.Default,
+		Created:         base.NewTime(now),
+		Updated:         base.NewTime(now),
 	}
 	if err := gateway.validate(); err != nil {
 		return nil, err
 	}
 
+	existing, err := r.getDefaultGateway(userID)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		gateway.Default = true
+	}
+
 	tx, err := r.db.Begin()
 	if err != nil {
 		return nil, err
 	}
+	if gateway.Default {
+		if err := clearDefaultGateway(tx, userID, now); err != nil {
+			return nil, fmt.Errorf("createUserGateway: clear default: error=%v rollback=%v", err, tx.Rollback())
+		}
+	}
 
-	query := `select gateway_id from gateways where user_id = ? and deleted_at is null`
+	query := `insert into gateways (gateway_id, user_id, name, origin, origin_name, destination, destination_name, is_default, created_at, last_updated_at) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	stmt, err := tx.Prepare(query)
 	if err != nil {
+		return nil, fmt.Errorf("createUserGateway: prepare error=%v rollback=%v", err, tx.Rollback())
+	}
+	_, err = stmt.Exec(gateway.ID, userID, gateway.Name, gateway.Origin, gateway.OriginName, gateway.Destination, gateway.DestinationName, gateway.Default, now, now)
+	stmt.Close()
+	if err != nil {
+		return nil, fmt.Errorf("createUserGateway: exec error=%v rollback=%v", err, tx.Rollback())
+	}
+	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
-
-	row := stmt.QueryRow(userID)
+	return gateway, nil
+}
 
-	var gatewayID string
-	err = row.Scan(&gatewayID)
-	if err != nil && !strings.Contains(err.Error(), "no rows in result set") {
-		return nil, fmt.Errorf("createUserGateway: scan error=%v rollback=%v", err, tx.Rollback())
+// updateUserGateway overwrites each non-empty field of req onto the Gateway and returns the result,
+// which is nil if the Gateway wasn't found.
+func (r *SQLGatewayRepo) updateUserGateway(id GatewayID, userID string, req gatewayRequest) (*Gateway, error) {
+	gateway, err := r.getUserGateway(id, userID)
+	if err != nil || gateway == nil {
+		return nil, err
+	}
+	if req.Name != "" {
+		gateway.Name = req.Name
+	}
+	if req.Origin != "" {
+		gateway.Origin = req.Origin
+	}
+	if req.OriginName != "" {
+		gateway.OriginName = req.OriginName
 	}
-	if gatewayID == "" {
-		gatewayID = base.ID()
+	if req.Destination != "" {
+		gateway.Destination = req.Destination
 	}
-	gateway.ID = GatewayID(gatewayID)
+	if req.DestinationName != "" {
+		gateway.DestinationName = req.DestinationName
+	}
+	if err := gateway.validate(); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	gateway.Updated = base.NewTime(now)
 
-	// insert/update row
-	query = `insert into gateways (gateway_id, user_id, origin, origin_name, destination, destination_name, created_at) values (?, ?, ?, ?, ?, ?, ?)`
-	stmt, err = tx.Prepare(query)
+	tx, err := r.db.Begin()
 	if err != nil {
-		return nil, fmt.Errorf("createUserGateway: prepare error=%v rollback=%v", err, tx.Rollback())
+		return nil, err
+	}
+	if req.Default && !gateway.Default {
+		if err := clearDefaultGateway(tx, userID, now); err != nil {
+			return nil, fmt.Errorf("updateUserGateway: clear default: error=%v rollback=%v", err, tx.Rollback())
+		}
+		gateway.Default = true
 	}
 
-	_, err = stmt.Exec(gatewayID, userID, gateway.Origin, gateway.OriginName, gateway.Destination, gateway.DestinationName, gateway.Created.Time)
+	query := `update gateways set name = ?, origin = ?, origin_name = ?, destination = ?, destination_name = ?, is_default = ?, last_updated_at = ?
+where gateway_id = ? and user_id = ? and deleted_at is null`
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return nil, fmt.Errorf("updateUserGateway: prepare error=%v rollback=%v", err, tx.Rollback())
+	}
+	_, err = stmt.Exec(gateway.Name, gateway.Origin, gateway.OriginName, gateway.Destination, gateway.DestinationName, gateway.Default, now, id, userID)
 	stmt.Close()
 	if err != nil {
-		// We need to update the row as it already exists.
-		if database.UniqueViolation(err) {
-			query = `update gateways set origin = ?, origin_name = ?, destination = ?, destination_name = ? where gateway_id = ? and user_id = ?`
-			stmt, err = tx.Prepare(query)
-			if err != nil {
-				return nil, fmt.Errorf("createUserGateway: update: error=%v rollback=%v", err, tx.Rollback())
-			}
-			_, err = stmt.Exec(gateway.Origin, gateway.OriginName, gateway.Destination, gateway.DestinationName, gatewayID, userID)
-			stmt.Close()
-			if err != nil {
-				return nil, fmt.Errorf("createUserGateway: update exec: error=%v rollback=%v", err, tx.Rollback())
-			}
-		} else {
-			return nil, fmt.Errorf("createUserGateway: exec error=%v rollback=%v", err, tx.Rollback())
-		}
+		return nil, fmt.Errorf("updateUserGateway: exec error=%v rollback=%v", err, tx.Rollback())
 	}
 	if err := tx.Commit(); err != nil {
 		return nil, err
@@ -237,26 +551,42 @@ func (r *SQLGatewayRepo) createUserGateway(userID string, req gatewayRequest) (*
 	return gateway, nil
 }
 
-func (r *SQLGatewayRepo) getUserGateway(userID string) (*Gateway, error) {
-	query := `select gateway_id, origin, origin_name, destination, destination_name, created_at
-from gateways where user_id = ? and deleted_at is null limit 1`
-	stmt, err := r.db.Prepare(query)
-	if err != nil {
-		return nil, err
+// deleteUserGateway removes the Gateway. When it was the user's default their oldest remaining Gateway becomes the default.
+func (r *SQLGatewayRepo) deleteUserGateway(id GatewayID, userID string) error {
+	gateway, err := r.getUserGateway(id, userID)
+	if err != nil || gateway == nil {
+		return err
 	}
-	defer stmt.Close()
 
-	row := stmt.QueryRow(userID)
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
 
-	gateway := &Gateway{}
-	var created time.Time
-	err = row.Scan(&gateway.ID, &gateway.Origin, &gateway.OriginName, &gateway.Destination, &gateway.DestinationName, &created)
+	query := `update gateways set deleted_at = ?, is_default = 0 where gateway_id = ? and user_id = ? and deleted_at is null`
+	stmt, err := tx.Prepare(query)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("deleteUserGateway: prepare error=%v rollback=%v", err, tx.Rollback())
 	}
-	gateway.Created = base.NewTime(created)
-	if gateway.ID == "" {
-		return nil, nil // not found
+	_, err = stmt.Exec(now, id, userID)
+	stmt.Close()
+	if err != nil {
+		return fmt.Errorf("deleteUserGateway: exec error=%v rollback=%v", err, tx.Rollback())
 	}
-	return gateway, nil
+
+	if gateway.Default {
+		query = `select gateway_id from gateways where user_id = ? and deleted_at is null order by created_at asc limit 1`
+		var next string
+		if err := tx.QueryRow(query, userID).Scan(&next); err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("deleteUserGateway: next default: error=%v rollback=%v", err, tx.Rollback())
+		}
+		if next != "" {
+			query = `update gateways set is_default = 1, last_updated_at = ? where gateway_id = ? and user_id = ?`
+			if _, err := tx.Exec(query, now, next, userID); err != nil {
+				return fmt.Errorf("deleteUserGateway: set default: error=%v rollback=%v", err, tx.Rollback())
+			}
+		}
+	}
+	return tx.Commit()
 }
@@ -5,16 +5,82 @@
 package paygate
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/moov-io/ach"
 	"github.com/moov-io/base"
 	"github.com/moov-io/paygate/internal/database"
+	"github.com/moov-io/paygate/internal/filetransfer"
 
 	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
 )
 
+// cutoffTimesRepository is a filetransfer.Repository with cutoff times for the given routing numbers
+type cutoffTimesRepository struct {
+	filetransfer.Repository
+
+	routingNumbers []string
+}
+
+func (r *cutoffTimesRepository) GetCutoffTimes() ([]*filetransfer.CutoffTime, error) {
+	var cutoffTimes []*filetransfer.CutoffTime
+	for i := range r.routingNumbers {
+		cutoffTimes = append(cutoffTimes, &filetransfer.CutoffTime{RoutingNumber: r.routingNumbers[i], Cutoff: 1700, Loc: time.UTC})
+	}
+	return cutoffTimes, nil
+}
+
+type mockGatewayRepository struct {
+	gateways []*Gateway
+	err      error
+}
+
+func (r *mockGatewayRepository) getUserGateways(userID string) ([]*Gateway, error) {
+	return r.gateways, r.err
+}
+
+func (r *mockGatewayRepository) getUserGateway(id GatewayID, userID string) (*Gateway, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	for i := range r.gateways {
+		if r.gateways[i].ID == id {
+			return r.gateways[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *mockGatewayRepository) getDefaultGateway(userID string) (*Gateway, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	for i := range r.gateways {
+		if r.gateways[i].Default {
+			return r.gateways[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *mockGatewayRepository) createUserGateway(userID string, req gatewayRequest) (*Gateway, error) {
+	return nil, r.err
+}
+
+func (r *mockGatewayRepository) updateUserGateway(id GatewayID, userID string, req gatewayRequest) (*Gateway, error) {
+	return nil, r.err
+}
+
+func (r *mockGatewayRepository) deleteUserGateway(id GatewayID, userID string) error {
+	return r.err
+}
+
 func TestGateways__gatewayRequest(t *testing.T) {
 	req := gatewayRequest{}
 	if err := req.missingFields(); err == nil {
@@ -22,12 +88,52 @@ func TestGateways__gatewayRequest(t *testing.T) {
 	}
 }
 
+func TestGateways__getTransferGateway(t *testing.T) {
+	repo := &mockGatewayRepository{}
+	if gw, err := getTransferGateway(repo, "", "user"); gw != nil || err != nil {
+		t.Errorf("gateway=%v error=%v", gw, err)
+	}
+	if _, err := getTransferGateway(repo, "missing", "user"); err == nil {
+		t.Error("expected error")
+	}
+
+	repo.gateways = []*Gateway{{ID: "first", Default: true}, {ID: "second"}}
+	if gw, err := getTransferGateway(repo, "", "user"); err != nil || gw.ID != "first" {
+		t.Errorf("gateway=%v error=%v", gw, err)
+	}
+	if gw, err := getTransferGateway(repo, "second", "user"); err != nil || gw.ID != "second" {
+		t.Errorf("gateway=%v error=%v", gw, err)
+	}
+
+	repo.err = errors.New("bad error")
+	if _, err := getTransferGateway(repo, "", "user"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestGateways__setGatewayFileHeader(t *testing.T) {
+	header := ach.NewFileHeader()
+	setGatewayFileHeader(&header, &Gateway{
+		Origin:          "231380104",
+		OriginName:      "my bank",
+		Destination:     "031300012",
+		DestinationName: "my other bank",
+	})
+	if header.ImmediateOrigin != "231380104" || header.ImmediateOriginName != "my bank" {
+		t.Errorf("origin=%s name=%s", header.ImmediateOrigin, header.ImmediateOriginName)
+	}
+	if header.ImmediateDestination != "031300012" || header.ImmediateDestinationName != "my other bank" {
+		t.Errorf("destination=%s name=%s", header.ImmediateDestination, header.ImmediateDestinationName)
+	}
+}
+
 func TestGateways_getUserGateways(t *testing.T) {
 	t.Parallel()
 
 	check := func(t *testing.T, repo gatewayRepository) {
 		userId := base.ID()
 		req := gatewayRequest{
+			Name:            "primary",
 			Origin:          "231380104",
 			OriginName:      "my bank",
 			Destination:     "031300012",
@@ -37,24 +143,70 @@ func TestGateways_getUserGateways(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
+		if !gateway.Default {
+			t.Error("expected first Gateway to be the default")
+		}
+
+		router := mux.NewRouter()
+		AddGatewayRoutes(log.NewNopLogger(), router, repo, &cutoffTimesRepository{})
 
+		// GET /gateways keeps returning a single object
 		w := httptest.NewRecorder()
 		r := httptest.NewRequest("GET", "/gateways", nil)
 		r.Header.Set("x-user-id", userId)
+		router.ServeHTTP(w, r)
+		w.Flush()
+
+		var dflt Gateway
+		if err := json.Unmarshal(w.Body.Bytes(), &dflt); err != nil {
+			t.Error(err)
+		}
+		if w.Code != 200 || dflt.ID != gateway.ID || !dflt.Default {
+			t.Errorf("got %d: %#v", w.Code, dflt)
+		}
 
-		getUserGateway(log.NewNopLogger(), repo)(w, r)
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest("GET", "/v2/gateways", nil)
+		r.Header.Set("x-user-id", userId)
+		router.ServeHTTP(w, r)
 		w.Flush()
 
 		if w.Code != 200 {
 			t.Errorf("got %d", w.Code)
 		}
 
-		var gw *Gateway
+		var gateways []*Gateway
+		if err := json.Unmarshal(w.Body.Bytes(), &gateways); err != nil {
+			t.Error(err)
+		}
+		if len(gateways) != 1 || gateways[0].ID != gateway.ID || gateways[0].Name != "primary" {
+			t.Errorf("unexpected gateways: %#v", gateways)
+		}
+
+		// read a single Gateway
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest("GET", "/gateways/"+string(gateway.ID), nil)
+		r.Header.Set("x-user-id", userId)
+		router.ServeHTTP(w, r)
+		w.Flush()
+
+		var gw Gateway
 		if err := json.Unmarshal(w.Body.Bytes(), &gw); err != nil {
 			t.Error(err)
 		}
-		if gw.ID != gateway.ID {
-			t.Errorf("gw.ID=%v, gateway.ID=%v", gw.ID, gateway.ID)
+		if w.Code != 200 || gw.ID != gateway.ID {
+			t.Errorf("got %d: %#v", w.Code, gw)
+		}
+
+		// other users can't read it
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest("GET", "/gateways/"+string(gateway.ID), nil)
+		r.Header.Set("x-user-id", base.ID())
+		router.ServeHTTP(w, r)
+		w.Flush()
+
+		if w.Code != 404 {
+			t.Errorf("got %d", w.Code)
 		}
 	}
 
@@ -86,7 +238,7 @@ func TestGateways_update(t *testing.T) {
 		}
 
 		// read gateway
-		gw, err := repo.getUserGateway(userId)
+		gw, err := repo.getUserGateway(gateway.ID, userId)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -94,21 +246,146 @@ func TestGateways_update(t *testing.T) {
 			t.Errorf("gw.ID=%v gateway.ID=%v", gw.ID, gateway.ID)
 		}
 
-		// Update Origin
-		req.Origin = "031300012"
-		_, err = repo.createUserGateway(userId, req)
+		// Update Origin over HTTP
+		router := mux.NewRouter()
+		AddGatewayRoutes(log.NewNopLogger(), router, repo, &cutoffTimesRepository{routingNumbers: []string{"231380104", "031300012"}})
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("PATCH", "/gateways/"+string(gateway.ID), bytes.NewReader([]byte(`{"origin": "031300012", "name": "updated"}`)))
+		r.Header.Set("x-user-id", userId)
+		router.ServeHTTP(w, r)
+		w.Flush()
+
+		if w.Code != 200 {
+			t.Errorf("got %d: %s", w.Code, w.Body.String())
+		}
+		gw, err = repo.getUserGateway(gateway.ID, userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gw.Origin != "031300012" || gw.Name != "updated" || gw.OriginName != req.OriginName {
+			t.Errorf("unexpected gateway: %#v", gw)
+		}
+
+		// origins without a cutoff time are rejected, since their files would never be uploaded
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest("PATCH", "/gateways/"+string(gateway.ID), bytes.NewReader([]byte(`{"origin": "121042882"}`)))
+		r.Header.Set("x-user-id", userId)
+		router.ServeHTTP(w, r)
+		w.Flush()
+
+		if w.Code != 400 {
+			t.Errorf("got %d: %s", w.Code, w.Body.String())
+		}
+		w = httptest.NewRecorder()
+		body := `{"origin": "121042882", "originName": "my bank", "destination": "031300012", "destinationName": "my other bank"}`
+		r = httptest.NewRequest("POST", "/gateways", bytes.NewReader([]byte(body)))
+		r.Header.Set("x-user-id", userId)
+		router.ServeHTTP(w, r)
+		w.Flush()
+
+		if w.Code != 400 {
+			t.Errorf("got %d: %s", w.Code, w.Body.String())
+		}
+		if gw, err := repo.getUserGateway(gateway.ID, userId); err != nil || gw.Origin != "031300012" {
+			t.Errorf("gateway=%#v error=%v", gw, err)
+		}
+
+		// invalid routing number
+		if _, err := repo.updateUserGateway(gateway.ID, userId, gatewayRequest{Origin: "1"}); err == nil {
+			t.Error("expected error")
+		}
+
+		// missing Gateway
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest("PATCH", "/gateways/missing", bytes.NewReader([]byte(`{"name": "updated"}`)))
+		r.Header.Set("x-user-id", userId)
+		router.ServeHTTP(w, r)
+		w.Flush()
+
+		if w.Code != 404 {
+			t.Errorf("got %d", w.Code)
+		}
+	}
+
+	// SQLite tests
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &SQLGatewayRepo{sqliteDB.DB, log.NewNopLogger()})
+
+	// MySQL tests
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &SQLGatewayRepo{mysqlDB.DB, log.NewNopLogger()})
+}
+
+func TestGateways_default(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo gatewayRepository) {
+		userId := base.ID()
+		req := gatewayRequest{
+			Origin:          "231380104",
+			OriginName:      "my bank",
+			Destination:     "031300012",
+			DestinationName: "my other bank",
+		}
+		first, err := repo.createUserGateway(userId, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := repo.createUserGateway(userId, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if second.Default {
+			t.Error("expected first Gateway to stay the default")
+		}
+
+		// a new default replaces the old
+		req.Default = true
+		third, err := repo.createUserGateway(userId, req)
 		if err != nil {
 			t.Fatal(err)
 		}
-		gw, err = repo.getUserGateway(userId)
+		if gw, err := repo.getDefaultGateway(userId); err != nil || gw.ID != third.ID {
+			t.Fatalf("gateway=%v error=%v", gw, err)
+		}
+
+		// updates can change the default
+		if _, err := repo.updateUserGateway(second.ID, userId, gatewayRequest{Default: true}); err != nil {
+			t.Fatal(err)
+		}
+		if gw, err := repo.getDefaultGateway(userId); err != nil || gw.ID != second.ID {
+			t.Fatalf("gateway=%v error=%v", gw, err)
+		}
+
+		// deleting the default makes the oldest Gateway the default
+		if err := repo.deleteUserGateway(second.ID, userId); err != nil {
+			t.Fatal(err)
+		}
+		if gw, err := repo.getUserGateway(second.ID, userId); err != nil || gw != nil {
+			t.Errorf("gateway=%v error=%v", gw, err)
+		}
+		if gw, err := repo.getDefaultGateway(userId); err != nil || gw.ID != first.ID {
+			t.Fatalf("gateway=%v error=%v", gw, err)
+		}
+		gateways, err := repo.getUserGateways(userId)
 		if err != nil {
 			t.Fatal(err)
 		}
-		if gw.ID != gateway.ID {
-			t.Errorf("gw.ID=%v gateway.ID=%v", gw.ID, gateway.ID)
+		if len(gateways) != 2 || gateways[0].ID != first.ID || gateways[1].ID != third.ID {
+			t.Errorf("unexpected gateways: %#v", gateways)
+		}
+
+		// deleting every Gateway leaves no default
+		for i := range gateways {
+			if err := repo.deleteUserGateway(gateways[i].ID, userId); err != nil {
+				t.Fatal(err)
+			}
 		}
-		if gw.Origin != req.Origin {
-			t.Errorf("gw.Origin=%v expected %v", gw.Origin, req.Origin)
+		if gw, err := repo.getDefaultGateway(userId); err != nil || gw != nil {
+			t.Errorf("gateway=%v error=%v", gw, err)
 		}
 	}
 
@@ -76,6 +76,12 @@ func wrapResponseWriter(logger log.Logger, w http.ResponseWriter, r *http.Reques
 	return moovhttp.EnsureHeaders(logger, routeHistogram.With("route", route), inmemIdempotentRecorder, w, r)
 }
 
+// ensureHeaders is like wrapResponseWriter, but doesn't check X-Idempotency-Key for routes wrapped with idempotentHandler.
+func ensureHeaders(logger log.Logger, w http.ResponseWriter, r *http.Request) (http.ResponseWriter, error) {
+	route := fmt.Sprintf("%s-%s", strings.ToLower(r.Method), cleanMetricsPath(r.URL.Path))
+	return moovhttp.EnsureHeaders(logger, routeHistogram.With("route", route), nil, w, r)
+}
+
 var baseIdRegex = regexp.MustCompile(`([a-f0-9]{40})`)
 
 // cleanMetricsPath takes a URL path and formats it for Prometheus metrics
@@ -0,0 +1,301 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/moov-io/base"
+	moovhttp "github.com/moov-io/base/http"
+	"github.com/moov-io/base/idempotent"
+	"github.com/moov-io/paygate/internal/database"
+
+	"github.com/go-kit/kit/log"
+)
+
+var (
+	// idempotencyKeyTTL is how long the response to a request with an X-Idempotency-Key is replayed for retries.
+	idempotencyKeyTTL = func() time.Duration {
+		if dur, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_KEY_TTL")); err == nil && dur > 0 {
+			return dur
+		}
+		return 24 * time.Hour
+	}()
+
+	// idempotencyKeyLease is how long a key is held for a request which hasn't finished after its last heartbeat.
+	// Running requests heartbeat every third of the lease, so afterwards the request is assumed to have crashed
+	// and the key can be used again.
+	idempotencyKeyLease = func() time.Duration {
+		if dur, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_KEY_LEASE")); err == nil && dur > 0 {
+			return dur
+		}
+		return 2 * time.Minute
+	}()
+)
+
+// idempotentRequest is a request made with an X-Idempotency-Key. Status is zero while the first request
+// with the key is still running.
+type idempotentRequest struct {
+	Status int
+	Body   []byte
+
+	// requestHash is the hex encoded SHA256 of the request body which first used the key
+	requestHash string
+}
+
+type idempotencyContextKey struct{}
+
+// idempotentResponse is stored in each request's context to track if its response can be replayed.
+type idempotentResponse struct {
+	final bool
+}
+
+// finalIdempotentResponse marks the 4xx response written for r as final, so it's replayed for retries with the
+// same X-Idempotency-Key. moovhttp.Problem writes a 400 for database and network errors as well as invalid requests,
+// so only errors caused by the request itself should be marked.
+func finalIdempotentResponse(r *http.Request) {
+	if resp, ok := r.Context().Value(idempotencyContextKey{}).(*idempotentResponse); ok {
+		resp.final = true
+	}
+}
+
+// idempotentHandler wraps next so requests are only ran once for each user's X-Idempotency-Key. Retries within
+// IDEMPOTENCY_KEY_TTL are replayed the original response status and body, and a 409 is returned while the first
+// request is still running. Keys reused with a different request body are rejected with a 422.
+//
+// Each request holds its key with a random token and heartbeats while running, only requests which stop heartbeating
+// for IDEMPOTENCY_KEY_LEASE lose their key. Saving (or removing) the response requires the token so a request can't
+// overwrite the key of another.
+//
+// Only 2xx responses and 4xx responses marked with finalIdempotentResponse are saved, requests without a key or
+// which fail otherwise can be retried.
+//
+// next should call ensureHeaders rather than wrapResponseWriter as the key is recorded in repo.
+func idempotentHandler(logger log.Logger, repo idempotencyKeyRepository, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, key := moovhttp.GetUserID(r), idempotent.Header(r)
+		if repo == nil || userID == "" || key == "" {
+			next(w, r)
+			return
+		}
+
+		// Read the body so retries can be compared to the first request
+		bs, err := read(r.Body)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(bs))
+		sum := sha256.Sum256(bs)
+		requestHash := hex.EncodeToString(sum[:])
+
+		token := base.ID()
+		existing, err := repo.startIdempotentRequest(userID, key, token, requestHash, time.Now())
+		if err != nil {
+			logger.Log("idempotency", fmt.Sprintf("problem recording idempotency key: %v", err), "requestID", moovhttp.GetRequestID(r), "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		if existing != nil {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			if existing.requestHash != "" && existing.requestHash != requestHash {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("%s=%s was used with a different request body", idempotent.HeaderKey, key)})
+				return
+			}
+			if existing.Status == 0 {
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("a request with %s=%s is in progress", idempotent.HeaderKey, key)})
+				return
+			}
+			w.WriteHeader(existing.Status)
+			w.Write(existing.Body)
+			return
+		}
+
+		done := make(chan struct{})
+		go heartbeatIdempotentRequest(logger, repo, userID, key, token, done)
+
+		resp := &idempotentResponse{}
+		rec := &recordingResponseWriter{ResponseWriter: w}
+		next(rec, r.WithContext(context.WithValue(r.Context(), idempotencyContextKey{}, resp)))
+		close(done)
+
+		if (rec.status >= 200 && rec.status <= 299) || (rec.status >= 400 && rec.status <= 499 && resp.final) {
+			err = repo.completeIdempotentRequest(userID, key, token, &idempotentRequest{Status: rec.status, Body: rec.body.Bytes()})
+		} else {
+			err = repo.deleteIdempotentRequest(userID, key, token)
+		}
+		if err != nil {
+			logger.Log("idempotency", fmt.Sprintf("problem saving response for idempotency key: %v", err), "requestID", moovhttp.GetRequestID(r), "userID", userID)
+		}
+	}
+}
+
+// heartbeatIdempotentRequest keeps the key held by token from expiring until done is closed.
+func heartbeatIdempotentRequest(logger log.Logger, repo idempotencyKeyRepository, userID, key, token string, done chan struct{}) {
+	tick := time.NewTicker(idempotencyKeyLease / 3)
+	defer tick.Stop()
+
+	for {
+		select {
+		case now := <-tick.C:
+			if err := repo.heartbeatIdempotentRequest(userID, key, token, now); err != nil {
+				logger.Log("idempotency", fmt.Sprintf("problem extending idempotency key lease: %v", err), "userID", userID)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// recordingResponseWriter keeps a copy of the status and body written to an http.ResponseWriter.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+type idempotencyKeyRepository interface {
+	// startIdempotentRequest records a request with key for userID, held by token. If the key was used within
+	// IDEMPOTENCY_KEY_TTL the existing request is returned instead, and nil when the caller should run the request.
+	// Keys of unfinished requests which haven't heartbeat within IDEMPOTENCY_KEY_LEASE are taken over.
+	startIdempotentRequest(userID, key, token, requestHash string, now time.Time) (*idempotentRequest, error)
+
+	// heartbeatIdempotentRequest extends the lease of the unfinished request holding key with token.
+	heartbeatIdempotentRequest(userID, key, token string, now time.Time) error
+
+	// completeIdempotentRequest saves the response to replay for retries, if key is still held by token.
+	completeIdempotentRequest(userID, key, token string, req *idempotentRequest) error
+
+	// deleteIdempotentRequest forgets key so the request can be retried, if key is still held by token.
+	deleteIdempotentRequest(userID, key, token string) error
+}
+
+func NewIdempotencyKeyRepo(logger log.Logger, db *sql.DB) *SQLIdempotencyKeyRepo {
+	return &SQLIdempotencyKeyRepo{log: logger, db: db}
+}
+
+type SQLIdempotencyKeyRepo struct {
+	db  *sql.DB
+	log log.Logger
+}
+
+func (r *SQLIdempotencyKeyRepo) Close() error {
+	return r.db.Close()
+}
+
+func (r *SQLIdempotencyKeyRepo) startIdempotentRequest(userID, key, token, requestHash string, now time.Time) (*idempotentRequest, error) {
+	// Expired keys, and keys held by requests which stopped heartbeating (likely crashed), are removed so they can be used again
+	if _, err := r.db.Exec(`delete from idempotency_keys where created_at < ?;`, now.Add(-1*idempotencyKeyTTL)); err != nil {
+		return nil, err
+	}
+	query := `delete from idempotency_keys where user_id = ? and idempotency_key = ? and status_code = 0 and coalesce(heartbeat_at, created_at) < ?;`
+	if _, err := r.db.Exec(query, userID, key, now.Add(-1*idempotencyKeyLease)); err != nil {
+		return nil, err
+	}
+
+	query = `insert into idempotency_keys (user_id, idempotency_key, status_code, request_hash, token, heartbeat_at, created_at) values (?, ?, 0, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(userID, key, requestHash, token, now, now); err == nil {
+		return nil, nil
+	} else if !database.UniqueViolation(err) {
+		return nil, err
+	}
+
+	// Another request used this key first
+	query = `select status_code, body, request_hash from idempotency_keys where user_id = ? and idempotency_key = ? limit 1;`
+	var (
+		req       idempotentRequest
+		body, sum *string
+	)
+	if err := r.db.QueryRow(query, userID, key).Scan(&req.Status, &body, &sum); err != nil {
+		if err == sql.ErrNoRows {
+			// the other request failed and was deleted, so this is still a conflict to retry
+			return &idempotentRequest{}, nil
+		}
+		return nil, err
+	}
+	if body != nil {
+		req.Body = []byte(*body)
+	}
+	if sum != nil {
+		req.requestHash = *sum
+	}
+	return &req, nil
+}
+
+func (r *SQLIdempotencyKeyRepo) heartbeatIdempotentRequest(userID, key, token string, now time.Time) error {
+	query := `update idempotency_keys set heartbeat_at = ? where user_id = ? and idempotency_key = ? and token = ? and status_code = 0;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(now, userID, key, token)
+	return err
+}
+
+func (r *SQLIdempotencyKeyRepo) completeIdempotentRequest(userID, key, token string, req *idempotentRequest) error {
+	query := `update idempotency_keys set status_code = ?, body = ? where user_id = ? and idempotency_key = ? and token = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(req.Status, string(req.Body), userID, key, token)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("idempotency key %s is no longer held by this request", key)
+	}
+	return nil
+}
+
+func (r *SQLIdempotencyKeyRepo) deleteIdempotentRequest(userID, key, token string) error {
+	query := `delete from idempotency_keys where user_id = ? and idempotency_key = ? and token = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(userID, key, token)
+	return err
+}
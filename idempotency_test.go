@@ -0,0 +1,199 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/moov-io/base"
+	"github.com/moov-io/paygate/internal/database"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestIdempotency__handler(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	repo := NewIdempotencyKeyRepo(log.NewNopLogger(), db.DB)
+
+	var (
+		calls  int
+		status = http.StatusOK
+		final  bool
+	)
+	handler := idempotentHandler(log.NewNopLogger(), repo, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if final {
+			finalIdempotentResponse(r)
+		}
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"call":%d}`, calls)
+	})
+	body := `{"amount":"USD 1.00"}`
+	serve := func(userID, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/transfers", strings.NewReader(body))
+		req.Header.Set("x-user-id", userID)
+		if key != "" {
+			req.Header.Set("x-idempotency-key", key)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		w.Flush()
+		return w
+	}
+
+	userID, key := base.ID(), base.ID()
+	if w := serve(userID, key); w.Code != http.StatusOK || w.Body.String() != `{"call":1}` {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+
+	// retries are replayed
+	if w := serve(userID, key); w.Code != http.StatusOK || w.Body.String() != `{"call":1}` || calls != 1 {
+		t.Errorf("got %d: %s (calls=%d)", w.Code, w.Body.String(), calls)
+	}
+
+	// keys are scoped per user and requests without a key always run
+	if w := serve(base.ID(), key); w.Body.String() != `{"call":2}` {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve(userID, ""); w.Body.String() != `{"call":3}` {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+
+	// keys can't be reused with a different body
+	body = `{"amount":"USD 2.00"}`
+	if w := serve(userID, key); w.Code != http.StatusUnprocessableEntity || calls != 3 {
+		t.Errorf("got %d: %s (calls=%d)", w.Code, w.Body.String(), calls)
+	}
+
+	// final errors are replayed, but other 4xx and 5xx responses can be retried
+	key, status, final = base.ID(), http.StatusBadRequest, true
+	serve(userID, key)
+	if w := serve(userID, key); w.Code != http.StatusBadRequest || w.Body.String() != `{"call":4}` {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+	key, final = base.ID(), false
+	serve(userID, key)
+	if w := serve(userID, key); w.Code != http.StatusBadRequest || w.Body.String() != `{"call":6}` {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+	key, status = base.ID(), http.StatusInternalServerError
+	serve(userID, key)
+	if w := serve(userID, key); w.Code != http.StatusInternalServerError || w.Body.String() != `{"call":8}` {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestIdempotency__concurrent(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	repo := NewIdempotencyKeyRepo(log.NewNopLogger(), db.DB)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	handler := idempotentHandler(log.NewNopLogger(), repo, func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { close(started) })
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+
+	userID, key := base.ID(), base.ID()
+	serve := func() int {
+		req := httptest.NewRequest("POST", "/transfers", nil)
+		req.Header.Set("x-user-id", userID)
+		req.Header.Set("x-idempotency-key", key)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if code := serve(); code != http.StatusOK {
+			t.Errorf("got %d", code)
+		}
+	}()
+
+	<-started // the first request recorded its key and is running
+	if code := serve(); code != http.StatusConflict {
+		t.Errorf("got %d", code)
+	}
+	close(release)
+	wg.Wait()
+
+	if code := serve(); code != http.StatusOK {
+		t.Errorf("got %d", code)
+	}
+}
+
+func TestIdempotency__expired(t *testing.T) {
+	check := func(t *testing.T, repo *SQLIdempotencyKeyRepo) {
+		userID, key := base.ID(), base.ID()
+		created := time.Now().Add(-1 * idempotencyKeyTTL)
+
+		if existing, err := repo.startIdempotentRequest(userID, key, "first", "hash", created); existing != nil || err != nil {
+			t.Fatalf("existing=%#v error=%v", existing, err)
+		}
+		if err := repo.completeIdempotentRequest(userID, key, "first", &idempotentRequest{Status: http.StatusOK, Body: []byte("{}")}); err != nil {
+			t.Fatal(err)
+		}
+		existing, err := repo.startIdempotentRequest(userID, key, "second", "hash", created.Add(time.Minute))
+		if err != nil || existing == nil || existing.Status != http.StatusOK || string(existing.Body) != "{}" || existing.requestHash != "hash" {
+			t.Fatalf("existing=%#v error=%v", existing, err)
+		}
+
+		// the key can be used again once it expires
+		if existing, err := repo.startIdempotentRequest(userID, key, "third", "hash", time.Now().Add(time.Minute)); existing != nil || err != nil {
+			t.Errorf("existing=%#v error=%v", existing, err)
+		}
+
+		// requests which haven't finished keep the key while they heartbeat
+		now := time.Now().Add(time.Minute)
+		if err := repo.heartbeatIdempotentRequest(userID, key, "third", now.Add(idempotencyKeyLease)); err != nil {
+			t.Fatal(err)
+		}
+		existing, err = repo.startIdempotentRequest(userID, key, "fourth", "hash", now.Add(idempotencyKeyLease+time.Second))
+		if err != nil || existing == nil || existing.Status != 0 {
+			t.Fatalf("existing=%#v error=%v", existing, err)
+		}
+
+		// and lose it once they stop
+		if existing, err := repo.startIdempotentRequest(userID, key, "fourth", "hash", now.Add(2*idempotencyKeyLease+time.Second)); existing != nil || err != nil {
+			t.Errorf("existing=%#v error=%v", existing, err)
+		}
+
+		// the request which lost its key can't overwrite (or remove) the new request's key
+		if err := repo.completeIdempotentRequest(userID, key, "third", &idempotentRequest{Status: http.StatusOK, Body: []byte("{}")}); err == nil {
+			t.Error("expected error")
+		}
+		if err := repo.deleteIdempotentRequest(userID, key, "third"); err != nil {
+			t.Fatal(err)
+		}
+		existing, err = repo.startIdempotentRequest(userID, key, "fifth", "hash", now.Add(2*idempotencyKeyLease+time.Minute))
+		if err != nil || existing == nil || existing.Status != 0 {
+			t.Errorf("existing=%#v error=%v", existing, err)
+		}
+	}
+
+	// SQLite tests
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, NewIdempotencyKeyRepo(log.NewNopLogger(), sqliteDB.DB))
+
+	// MySQL tests
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, NewIdempotencyKeyRepo(log.NewNopLogger(), mysqlDB.DB))
+}
@@ -35,6 +35,21 @@ func execsql(name, raw string) *migrator.MigrationNoTx {
 	}
 }
 
+// execsqls is like execsql, but runs each statement in order.
+func execsqls(name string, raw ...string) *migrator.MigrationNoTx {
+	return &migrator.MigrationNoTx{
+		Name: name,
+		Func: func(db *sql.DB) error {
+			for i := range raw {
+				if _, err := db.Exec(raw[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
 // UniqueViolation returns true when the provided error matches a database error
 // for duplicate entries (violating a unique table constraint).
 func UniqueViolation(err error) bool {
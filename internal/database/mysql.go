@@ -97,6 +97,235 @@ var (
 			"unique_sftp_configs",
 			`create unique index sftp_configs_idx on sftp_configs(routing_number);`,
 		),
+		execsqls(
+			"unique_file_transfer_configs",
+			// Keep the most recently written config of any duplicated routing numbers, the table has no key
+			// so one is added while removing duplicates.
+			`alter table file_transfer_configs add column dedup_id int not null auto_increment primary key;`,
+			`delete c1 from file_transfer_configs c1 join file_transfer_configs c2 on c1.routing_number = c2.routing_number and c1.dedup_id < c2.dedup_id;`,
+			`alter table file_transfer_configs drop column dedup_id;`,
+			`create unique index file_transfer_configs_idx on file_transfer_configs(routing_number);`,
+		),
+		execsql(
+			"add_balance_entries_to_file_transfer_configs",
+			"alter table file_transfer_configs add column balance_entries boolean default false;",
+		),
+		execsql(
+			"add_filename_template_to_file_transfer_configs",
+			"alter table file_transfer_configs add column filename_template varchar(200) not null default '';",
+		),
+		execsql(
+			"add_immediate_origin_to_file_transfer_configs",
+			"alter table file_transfer_configs add column immediate_origin varchar(10) not null default '';",
+		),
+		execsql(
+			"add_immediate_origin_name_to_file_transfer_configs",
+			"alter table file_transfer_configs add column immediate_origin_name varchar(23) not null default '';",
+		),
+		execsql(
+			"add_immediate_destination_to_file_transfer_configs",
+			"alter table file_transfer_configs add column immediate_destination varchar(10) not null default '';",
+		),
+		execsql(
+			"add_immediate_destination_name_to_file_transfer_configs",
+			"alter table file_transfer_configs add column immediate_destination_name varchar(23) not null default '';",
+		),
+		execsql(
+			"add_reference_code_to_file_transfer_configs",
+			"alter table file_transfer_configs add column reference_code varchar(8) not null default '';",
+		),
+		execsql(
+			"add_file_id_modifier_to_file_transfer_configs",
+			"alter table file_transfer_configs add column file_id_modifier varchar(1) not null default '';",
+		),
+		execsql(
+			"create_file_approvals",
+			`create table if not exists file_approvals(approval_id varchar(40) primary key, filename varchar(100), filepath varchar(500), origin varchar(10), destination varchar(10), entry_count integer, total_debit integer, total_credit integer, status varchar(20), reason varchar(500), created_at datetime, last_updated_at datetime);`,
+		),
+		execsql(
+			"create_processed_files",
+			`create table if not exists processed_files(file_id varchar(40) primary key, routing_number varchar(10), kind varchar(10), filename varchar(100), hash varchar(64), filepath varchar(500), status varchar(20), created_at datetime, processed_at datetime);`,
+		),
+		execsql(
+			"unique_processed_files",
+			`create unique index processed_files_idx on processed_files(filename, hash);`,
+		),
+		execsql(
+			"create_micro_deposit_attempts",
+			`create table if not exists micro_deposit_attempts(depository_id varchar(40), user_id varchar(40), created_at datetime, deleted_at datetime);`,
+		),
+		execsql(
+			"add_encrypted_account_number_to_depositories",
+			"alter table depositories add column encrypted_account_number varchar(500) default '';",
+		),
+		execsql(
+			"add_account_number_hash_to_depositories",
+			"alter table depositories add column account_number_hash varchar(64) default '';",
+		),
+		execsql(
+			"add_encrypted_identification_to_originators",
+			"alter table originators add column encrypted_identification varchar(500) default '';",
+		),
+		execsql(
+			"create_authorizations",
+			`create table if not exists authorizations(authorization_id varchar(40) primary key, user_id varchar(40), receiver_id varchar(40), depository_id varchar(40), type varchar(10), authorized_at datetime, ip_address varchar(45), user_agent varchar(500), terms text, max_amount varchar(30), status varchar(10), revoked_at datetime, revocation_reason varchar(500), created_at datetime, last_updated_at datetime);`,
+		),
+		execsql(
+			"add_status_to_originators",
+			"alter table originators add column status varchar(20) default 'active';",
+		),
+		execsql(
+			"create_ofac_rescreens",
+			`create table if not exists ofac_rescreens(rescreen_id varchar(40) primary key, screened integer, matches integer, errors integer, started_at datetime, finished_at datetime);`,
+		),
+		execsql(
+			"create_ofac_rescreen_results",
+			`create table if not exists ofac_rescreen_results(rescreen_id varchar(40), kind varchar(20), object_id varchar(40), user_id varchar(40), name varchar(500), entity_id varchar(40), sdn_match double, previous_status varchar(20), failed_transfers text, error varchar(500));`,
+		),
+		execsql(
+			"create_ofac_hits",
+			`create table if not exists ofac_hits(hit_id varchar(40) primary key, user_id varchar(40), name varchar(500), entity_id varchar(40), sdn_name varchar(500), sdn_type varchar(40), sdn_match double, ofac_status varchar(20), request_id varchar(40), status varchar(20), notes varchar(2000), reviewed_by varchar(40), expires_at datetime, created_at datetime, last_updated_at datetime);`,
+		),
+		execsql(
+			"create_transfer_ofac_screenings",
+			`create table if not exists transfer_ofac_screenings(transfer_id varchar(40), party varchar(40), name varchar(500), entity_id varchar(40), sdn_match double, blocked boolean, created_at datetime);`,
+		),
+		execsql(
+			"create_webhooks",
+			`create table if not exists webhooks(webhook_id varchar(40) primary key, user_id varchar(40), url varchar(500), event_types varchar(500), encrypted_secret text, created_at datetime, last_updated_at datetime, deleted_at datetime);`,
+		),
+		execsql(
+			"create_webhook_deliveries",
+			`create table if not exists webhook_deliveries(delivery_id varchar(40) primary key, webhook_id varchar(40), user_id varchar(40), event_id varchar(40), event_type varchar(40), payload text, status varchar(20), attempts integer, next_attempt_at datetime, created_at datetime, last_updated_at datetime);`,
+		),
+		execsql(
+			"create_webhook_delivery_attempts",
+			`create table if not exists webhook_delivery_attempts(delivery_id varchar(40), attempt integer, status_code integer, error text, attempted_at datetime);`,
+		),
+		execsql(
+			"add_events_payload",
+			`alter table events add column payload text;`,
+		),
+		execsql(
+			"create_event_metadata",
+			`create table if not exists event_metadata(event_id varchar(40), user_id varchar(40), name varchar(40), value varchar(100));`,
+		),
+		execsql(
+			"add_gateways_name",
+			`alter table gateways add column name varchar(50) default '';`,
+		),
+		execsql(
+			"add_gateways_is_default",
+			`alter table gateways add column is_default boolean default false;`,
+		),
+		execsql(
+			"add_gateways_last_updated_at",
+			`alter table gateways add column last_updated_at datetime;`,
+		),
+		execsql(
+			"default_existing_gateways",
+			`update gateways set is_default = true where deleted_at is null;`,
+		),
+		execsql(
+			"add_transfers_gateway_id",
+			`alter table transfers add column gateway_id varchar(40) default '';`,
+		),
+		execsql(
+			"create_ledger_accounts",
+			`create table if not exists ledger_accounts(account_id varchar(40) primary key, user_id varchar(40), depository_id varchar(40), account_key varchar(64), routing_number varchar(10), account_number_masked varchar(50), type varchar(20), created_at datetime);`,
+		),
+		execsql(
+			"create_ledger_accounts_unique_idx",
+			`create unique index ledger_accounts_key_idx on ledger_accounts (user_id, account_key);`,
+		),
+		execsql(
+			"create_ledger_transactions",
+			`create table if not exists ledger_transactions(transaction_id varchar(40) primary key, user_id varchar(40), reversal_of varchar(40) default '', reversed_by varchar(40), created_at datetime);`,
+		),
+		execsql(
+			"create_ledger_transaction_lines",
+			`create table if not exists ledger_transaction_lines(transaction_id varchar(40), account_id varchar(40), purpose varchar(20), amount integer, created_at datetime);`,
+		),
+		execsql(
+			"create_file_uploads",
+			`create table if not exists file_uploads(filename varchar(100), uploaded_as varchar(100), origin varchar(10), destination varchar(10), uploaded_at datetime);`,
+		),
+		execsql(
+			"create_reconciliations",
+			`create table if not exists reconciliations(reconciliation_id varchar(40) primary key, date varchar(10), transfers integer, amount integer, discrepancies integer, errors integer, started_at datetime, finished_at datetime);`,
+		),
+		execsql(
+			"create_reconciliation_discrepancies",
+			`create table if not exists reconciliation_discrepancies(reconciliation_id varchar(40), kind varchar(30), transfer_id varchar(40), user_id varchar(40), status varchar(10), amount integer, transaction_id varchar(40), filename varchar(100), message varchar(500));`,
+		),
+		execsql(
+			"create_transfer_limits",
+			`create table if not exists transfer_limits(limit_id varchar(40) primary key, user_id varchar(40), originator_id varchar(40), sec_code varchar(3), direction varchar(10), max_amount integer, daily_amount integer, monthly_amount integer, daily_count integer, monthly_count integer, created_at datetime, last_updated_at datetime, deleted_at datetime);`,
+		),
+		execsql(
+			"create_transfer_limit_usage",
+			`create table if not exists transfer_limit_usage(limit_id varchar(40), user_id varchar(40), period varchar(10), amount integer, transfers integer);`,
+		),
+		execsql(
+			"create_transfer_limit_usage_unique_idx",
+			`create unique index transfer_limit_usage_idx on transfer_limit_usage (limit_id, user_id, period);`,
+		),
+		execsql(
+			"expand_transfers_status",
+			`alter table transfers modify status varchar(20);`,
+		),
+		execsql(
+			"add_transfers_reviewed_by",
+			`alter table transfers add column reviewed_by varchar(40);`,
+		),
+		execsql(
+			"add_transfers_reviewed_at",
+			`alter table transfers add column reviewed_at datetime;`,
+		),
+		execsql(
+			"create_transfer_approvers",
+			`create table if not exists transfer_approvers(user_id varchar(40), approver_id varchar(40), created_at datetime);`,
+		),
+		execsql(
+			"create_idempotency_keys",
+			`create table if not exists idempotency_keys(user_id varchar(40), idempotency_key varchar(50), status_code integer, body mediumtext, created_at datetime);`,
+		),
+		execsql(
+			"create_idempotency_keys_unique_idx",
+			`create unique index idempotency_keys_idx on idempotency_keys (user_id, idempotency_key);`,
+		),
+		execsql(
+			"create_idempotency_keys_created_at_idx",
+			`create index idempotency_keys_created_at_idx on idempotency_keys (created_at);`,
+		),
+		execsql(
+			"add_idempotency_keys_request_hash",
+			`alter table idempotency_keys add column request_hash varchar(64);`,
+		),
+		execsql(
+			"add_file_approvals_reviewed_by",
+			`alter table file_approvals add column reviewed_by varchar(40);`,
+		),
+		execsql(
+			"add_transfers_transaction_reversed_at",
+			`alter table transfers add column transaction_reversed_at datetime;`,
+		),
+		execsql(
+			"add_idempotency_keys_token",
+			`alter table idempotency_keys add column token varchar(40);`,
+		),
+		execsql(
+			"add_idempotency_keys_heartbeat_at",
+			`alter table idempotency_keys add column heartbeat_at datetime;`,
+		),
+		execsql(
+			"add_transfers_reversal_transaction_id",
+			`alter table transfers add column reversal_transaction_id varchar(40);`,
+		),
+		execsql(
+			"create_transfer_limit_reservations",
+			`create table if not exists transfer_limit_reservations(transfer_id varchar(40), limit_id varchar(40), user_id varchar(40), period varchar(10), amount integer);`,
+		),
 	)
 )
 
@@ -91,6 +91,228 @@ var (
 			"unique_sftp_configs",
 			`create unique index sftp_configs_idx on sftp_configs(routing_number);`,
 		),
+		execsqls(
+			"unique_file_transfer_configs",
+			// Keep the most recently written config of any duplicated routing numbers
+			`delete from file_transfer_configs where rowid not in (select max(rowid) from file_transfer_configs group by routing_number);`,
+			`create unique index file_transfer_configs_idx on file_transfer_configs(routing_number);`,
+		),
+		execsql(
+			"add_balance_entries_to_file_transfer_configs",
+			"alter table file_transfer_configs add column balance_entries integer default 0;",
+		),
+		execsql(
+			"add_filename_template_to_file_transfer_configs",
+			"alter table file_transfer_configs add column filename_template default '';",
+		),
+		execsql(
+			"add_immediate_origin_to_file_transfer_configs",
+			"alter table file_transfer_configs add column immediate_origin default '';",
+		),
+		execsql(
+			"add_immediate_origin_name_to_file_transfer_configs",
+			"alter table file_transfer_configs add column immediate_origin_name default '';",
+		),
+		execsql(
+			"add_immediate_destination_to_file_transfer_configs",
+			"alter table file_transfer_configs add column immediate_destination default '';",
+		),
+		execsql(
+			"add_immediate_destination_name_to_file_transfer_configs",
+			"alter table file_transfer_configs add column immediate_destination_name default '';",
+		),
+		execsql(
+			"add_reference_code_to_file_transfer_configs",
+			"alter table file_transfer_configs add column reference_code default '';",
+		),
+		execsql(
+			"add_file_id_modifier_to_file_transfer_configs",
+			"alter table file_transfer_configs add column file_id_modifier default '';",
+		),
+		execsql(
+			"create_file_approvals",
+			`create table if not exists file_approvals(approval_id primary key, filename, filepath, origin, destination, entry_count integer, total_debit integer, total_credit integer, status, reason, created_at datetime, last_updated_at datetime);`,
+		),
+		execsql(
+			"create_processed_files",
+			`create table if not exists processed_files(file_id primary key, routing_number, kind, filename, hash, filepath, status, created_at datetime, processed_at datetime);`,
+		),
+		execsql(
+			"unique_processed_files",
+			`create unique index processed_files_idx on processed_files(filename, hash);`,
+		),
+		execsql(
+			"create_micro_deposit_attempts",
+			`create table if not exists micro_deposit_attempts(depository_id, user_id, created_at datetime, deleted_at datetime);`,
+		),
+		execsql(
+			"add_encrypted_account_number_to_depositories",
+			"alter table depositories add column encrypted_account_number default '';",
+		),
+		execsql(
+			"add_account_number_hash_to_depositories",
+			"alter table depositories add column account_number_hash default '';",
+		),
+		execsql(
+			"add_encrypted_identification_to_originators",
+			"alter table originators add column encrypted_identification default '';",
+		),
+		execsql(
+			"create_authorizations",
+			`create table if not exists authorizations(authorization_id primary key, user_id, receiver_id, depository_id, type, authorized_at datetime, ip_address, user_agent, terms, max_amount, status, revoked_at datetime, revocation_reason, created_at datetime, last_updated_at datetime);`,
+		),
+		execsql(
+			"add_status_to_originators",
+			"alter table originators add column status default 'active';",
+		),
+		execsql(
+			"create_ofac_rescreens",
+			`create table if not exists ofac_rescreens(rescreen_id primary key, screened integer, matches integer, errors integer, started_at datetime, finished_at datetime);`,
+		),
+		execsql(
+			"create_ofac_rescreen_results",
+			`create table if not exists ofac_rescreen_results(rescreen_id, kind, object_id, user_id, name, entity_id, sdn_match, previous_status, failed_transfers, error);`,
+		),
+		execsql(
+			"create_ofac_hits",
+			`create table if not exists ofac_hits(hit_id primary key, user_id, name, entity_id, sdn_name, sdn_type, sdn_match, ofac_status, request_id, status, notes, reviewed_by, expires_at datetime, created_at datetime, last_updated_at datetime);`,
+		),
+		execsql(
+			"create_transfer_ofac_screenings",
+			`create table if not exists transfer_ofac_screenings(transfer_id, party, name, entity_id, sdn_match, blocked boolean, created_at datetime);`,
+		),
+		execsql(
+			"create_webhooks",
+			`create table if not exists webhooks(webhook_id primary key, user_id, url, event_types, encrypted_secret, created_at datetime, last_updated_at datetime, deleted_at datetime);`,
+		),
+		execsql(
+			"create_webhook_deliveries",
+			`create table if not exists webhook_deliveries(delivery_id primary key, webhook_id, user_id, event_id, event_type, payload, status, attempts integer, next_attempt_at datetime, created_at datetime, last_updated_at datetime);`,
+		),
+		execsql(
+			"create_webhook_delivery_attempts",
+			`create table if not exists webhook_delivery_attempts(delivery_id, attempt integer, status_code integer, error, attempted_at datetime);`,
+		),
+		execsql(
+			"add_events_payload",
+			`alter table events add column payload;`,
+		),
+		execsql(
+			"create_event_metadata",
+			`create table if not exists event_metadata(event_id, user_id, name, value);`,
+		),
+		execsql(
+			"add_gateways_name",
+			`alter table gateways add column name;`,
+		),
+		execsql(
+			"add_gateways_is_default",
+			`alter table gateways add column is_default boolean default 0;`,
+		),
+		execsql(
+			"add_gateways_last_updated_at",
+			`alter table gateways add column last_updated_at datetime;`,
+		),
+		execsql(
+			"default_existing_gateways",
+			`update gateways set is_default = 1 where deleted_at is null;`,
+		),
+		execsql(
+			"add_transfers_gateway_id",
+			`alter table transfers add column gateway_id;`,
+		),
+		execsql(
+			"create_ledger_accounts",
+			`create table if not exists ledger_accounts(account_id primary key, user_id, depository_id, account_key, routing_number, account_number_masked, type, created_at datetime);`,
+		),
+		execsql(
+			"create_ledger_accounts_unique_idx",
+			`create unique index ledger_accounts_key_idx on ledger_accounts (user_id, account_key);`,
+		),
+		execsql(
+			"create_ledger_transactions",
+			`create table if not exists ledger_transactions(transaction_id primary key, user_id, reversal_of default '', reversed_by, created_at datetime);`,
+		),
+		execsql(
+			"create_ledger_transaction_lines",
+			`create table if not exists ledger_transaction_lines(transaction_id, account_id, purpose, amount integer, created_at datetime);`,
+		),
+		execsql(
+			"create_file_uploads",
+			`create table if not exists file_uploads(filename, uploaded_as, origin, destination, uploaded_at datetime);`,
+		),
+		execsql(
+			"create_reconciliations",
+			`create table if not exists reconciliations(reconciliation_id primary key, date, transfers integer, amount integer, discrepancies integer, errors integer, started_at datetime, finished_at datetime);`,
+		),
+		execsql(
+			"create_reconciliation_discrepancies",
+			`create table if not exists reconciliation_discrepancies(reconciliation_id, kind, transfer_id, user_id, status, amount integer, transaction_id, filename, message);`,
+		),
+		execsql(
+			"create_transfer_limits",
+			`create table if not exists transfer_limits(limit_id primary key, user_id, originator_id, sec_code, direction, max_amount integer, daily_amount integer, monthly_amount integer, daily_count integer, monthly_count integer, created_at datetime, last_updated_at datetime, deleted_at datetime);`,
+		),
+		execsql(
+			"create_transfer_limit_usage",
+			`create table if not exists transfer_limit_usage(limit_id, user_id, period, amount integer, transfers integer);`,
+		),
+		execsql(
+			"create_transfer_limit_usage_unique_idx",
+			`create unique index transfer_limit_usage_idx on transfer_limit_usage (limit_id, user_id, period);`,
+		),
+		execsql(
+			"add_transfers_reviewed_by",
+			`alter table transfers add column reviewed_by;`,
+		),
+		execsql(
+			"add_transfers_reviewed_at",
+			`alter table transfers add column reviewed_at datetime;`,
+		),
+		execsql(
+			"create_transfer_approvers",
+			`create table if not exists transfer_approvers(user_id, approver_id, created_at datetime);`,
+		),
+		execsql(
+			"create_idempotency_keys",
+			`create table if not exists idempotency_keys(user_id, idempotency_key, status_code integer, body, created_at datetime);`,
+		),
+		execsql(
+			"create_idempotency_keys_unique_idx",
+			`create unique index idempotency_keys_idx on idempotency_keys (user_id, idempotency_key);`,
+		),
+		execsql(
+			"create_idempotency_keys_created_at_idx",
+			`create index idempotency_keys_created_at_idx on idempotency_keys (created_at);`,
+		),
+		execsql(
+			"add_idempotency_keys_request_hash",
+			`alter table idempotency_keys add column request_hash;`,
+		),
+		execsql(
+			"add_file_approvals_reviewed_by",
+			`alter table file_approvals add column reviewed_by;`,
+		),
+		execsql(
+			"add_transfers_transaction_reversed_at",
+			`alter table transfers add column transaction_reversed_at datetime;`,
+		),
+		execsql(
+			"add_idempotency_keys_token",
+			`alter table idempotency_keys add column token;`,
+		),
+		execsql(
+			"add_idempotency_keys_heartbeat_at",
+			`alter table idempotency_keys add column heartbeat_at datetime;`,
+		),
+		execsql(
+			"add_transfers_reversal_transaction_id",
+			`alter table transfers add column reversal_transaction_id varchar(40);`,
+		),
+		execsql(
+			"create_transfer_limit_reservations",
+			`create table if not exists transfer_limit_reservations(transfer_id, limit_id, user_id, period, amount integer);`,
+		),
 	)
 )
 
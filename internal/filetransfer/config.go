@@ -24,6 +24,8 @@ import (
 
 type Repository interface {
 	GetConfigs() ([]*Config, error)
+	upsertConfig(cfg *Config) error
+	deleteConfig(routingNumber string) error
 
 	GetCutoffTimes() ([]*CutoffTime, error)
 	upsertCutoffTime(routingNumber string, cutoff int, loc *time.Location) error
@@ -89,7 +91,9 @@ func (r *sqlRepository) GetCounts() (int, int, int) {
 }
 
 func (r *sqlRepository) GetConfigs() ([]*Config, error) {
-	query := `select routing_number, inbound_path, outbound_path, return_path from file_transfer_configs;`
+	query := `select routing_number, inbound_path, outbound_path, return_path, balance_entries, filename_template,
+immediate_origin, immediate_origin_name, immediate_destination, immediate_destination_name, reference_code, file_id_modifier
+from file_transfer_configs;`
 	stmt, err := r.db.Prepare(query)
 	if err != nil {
 		return nil, err
@@ -104,14 +108,33 @@ func (r *sqlRepository) GetConfigs() ([]*Config, error) {
 	defer rows.Close()
 	for rows.Next() {
 		var cfg Config
-		if err := rows.Scan(&cfg.RoutingNumber, &cfg.InboundPath, &cfg.OutboundPath, &cfg.ReturnPath); err != nil {
+		var balance *bool
+		hdr := &cfg.FileHeader
+		err := rows.Scan(&cfg.RoutingNumber, &cfg.InboundPath, &cfg.OutboundPath, &cfg.ReturnPath, &balance, &cfg.FilenameTemplate,
+			&hdr.ImmediateOrigin, &hdr.ImmediateOriginName, &hdr.ImmediateDestination, &hdr.ImmediateDestinationName, &hdr.ReferenceCode, &hdr.FileIDModifier)
+		if err != nil {
 			return nil, fmt.Errorf("GetConfigs: scan: %v", err)
 		}
+		cfg.BalanceEntries = balance != nil && *balance
 		configs = append(configs, &cfg)
 	}
 	return configs, rows.Err()
 }
 
+func (r *sqlRepository) upsertConfig(cfg *Config) error {
+	query := `replace into file_transfer_configs (routing_number, inbound_path, outbound_path, return_path, balance_entries, filename_template,
+immediate_origin, immediate_origin_name, immediate_destination, immediate_destination_name, reference_code, file_id_modifier)
+values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	hdr := cfg.FileHeader
+	return exec(r.db, query, cfg.RoutingNumber, cfg.InboundPath, cfg.OutboundPath, cfg.ReturnPath, cfg.BalanceEntries, cfg.FilenameTemplate,
+		hdr.ImmediateOrigin, hdr.ImmediateOriginName, hdr.ImmediateDestination, hdr.ImmediateDestinationName, hdr.ReferenceCode, hdr.FileIDModifier)
+}
+
+func (r *sqlRepository) deleteConfig(routingNumber string) error {
+	query := `delete from file_transfer_configs where routing_number = ?;`
+	return exec(r.db, query, routingNumber)
+}
+
 func (r *sqlRepository) GetCutoffTimes() ([]*CutoffTime, error) {
 	query := `select routing_number, cutoff, location from cutoff_times;`
 	stmt, err := r.db.Prepare(query)
@@ -336,6 +359,14 @@ func (r *localFileTransferRepository) GetConfigs() ([]*Config, error) {
 	return []*Config{cfg}, nil
 }
 
+func (r *localFileTransferRepository) upsertConfig(cfg *Config) error {
+	return nil
+}
+
+func (r *localFileTransferRepository) deleteConfig(routingNumber string) error {
+	return nil
+}
+
 func (r *localFileTransferRepository) GetCutoffTimes() ([]*CutoffTime, error) {
 	nyc, _ := time.LoadLocation("America/New_York")
 	return []*CutoffTime{
@@ -543,11 +574,54 @@ func manageFileTransferConfig(logger log.Logger, repo Repository) http.HandlerFu
 		}
 		switch r.Method {
 		case "PUT":
-			// TODO(adam): impl
-			logger.Log("file-transfer-configs", "", "requestID", moovhttp.GetRequestID(r))
+			type request struct {
+				InboundPath      string `json:"inboundPath"`
+				OutboundPath     string `json:"outboundPath"`
+				ReturnPath       string `json:"returnPath"`
+				BalanceEntries   bool   `json:"balanceEntries"`
+				FilenameTemplate string `json:"filenameTemplate,omitempty"`
+				FileHeader       struct {
+					ImmediateOrigin          string `json:"immediateOrigin,omitempty"`
+					ImmediateOriginName      string `json:"immediateOriginName,omitempty"`
+					ImmediateDestination     string `json:"immediateDestination,omitempty"`
+					ImmediateDestinationName string `json:"immediateDestinationName,omitempty"`
+					ReferenceCode            string `json:"referenceCode,omitempty"`
+					FileIDModifier           string `json:"fileIDModifier,omitempty"`
+				} `json:"fileHeader,omitempty"`
+			}
+			var req request
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if req.InboundPath == "" || req.OutboundPath == "" || req.ReturnPath == "" {
+				moovhttp.Problem(w, errors.New("missing inboundPath, outboundPath, or returnPath"))
+				return
+			}
+			cfg := &Config{
+				RoutingNumber:    routingNumber,
+				InboundPath:      req.InboundPath,
+				OutboundPath:     req.OutboundPath,
+				ReturnPath:       req.ReturnPath,
+				BalanceEntries:   req.BalanceEntries,
+				FilenameTemplate: req.FilenameTemplate,
+				FileHeader:       FileHeaderOverrides(req.FileHeader),
+			}
+			if err := cfg.validate(); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if err := repo.upsertConfig(cfg); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			logger.Log("file-transfer-configs", fmt.Sprintf("updating file-transfer config routingNumber=%s balanceEntries=%v", routingNumber, req.BalanceEntries), "requestID", moovhttp.GetRequestID(r))
 		case "DELETE":
-			// TODO(adam): impl
-			logger.Log("file-transfer-configs", "", "requestID", moovhttp.GetRequestID(r))
+			if err := repo.deleteConfig(routingNumber); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			logger.Log("file-transfer-configs", fmt.Sprintf("deleting file-transfer config routingNumber=%s", routingNumber), "requestID", moovhttp.GetRequestID(r))
 		default:
 			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb %s", r.Method))
 			return
@@ -218,6 +218,63 @@ func TestMySQLFileTransferRepository(t *testing.T) {
 	testdb.Close()
 }
 
+func TestConfigs__UpsertDeleteFileTransferConfig(t *testing.T) {
+	check := func(t *testing.T, repo *sqlRepository) {
+		writeFileTransferConfig(t, repo.db)
+
+		configs, err := repo.GetConfigs()
+		if err != nil || len(configs) != 1 {
+			t.Fatalf("got configs: %#v error=%v", configs, err)
+		}
+		if configs[0].BalanceEntries {
+			t.Errorf("unexpected BalanceEntries: %#v", configs[0])
+		}
+
+		// upsert (update or insert)
+		cfg := configs[0]
+		cfg.OutboundPath = "upload/"
+		cfg.BalanceEntries = true
+		cfg.FilenameTemplate = "{{ .RoutingNumber }}-{{ .N }}.txt"
+		cfg.FileHeader.ImmediateOriginName = "My Company"
+		cfg.FileHeader.FileIDModifier = "B"
+		if err := repo.upsertConfig(cfg); err != nil {
+			t.Fatal(err)
+		}
+		configs, err = repo.GetConfigs()
+		if err != nil || len(configs) != 1 {
+			t.Fatalf("got configs: %#v error=%v", configs, err)
+		}
+		if configs[0].OutboundPath != "upload/" || !configs[0].BalanceEntries {
+			t.Errorf("unexpected config: %#v", configs[0])
+		}
+		if configs[0].FilenameTemplate != cfg.FilenameTemplate {
+			t.Errorf("FilenameTemplate=%q", configs[0].FilenameTemplate)
+		}
+		if configs[0].FileHeader != cfg.FileHeader {
+			t.Errorf("FileHeader=%#v", configs[0].FileHeader)
+		}
+
+		// delete
+		if err := repo.deleteConfig(cfg.RoutingNumber); err != nil {
+			t.Fatal(err)
+		}
+		configs, err = repo.GetConfigs()
+		if err != nil || len(configs) != 0 {
+			t.Fatalf("got configs: %#v error=%v", configs, err)
+		}
+	}
+
+	// SQLite tests
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlRepository{sqliteDB.DB})
+
+	// MySQL tests
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlRepository{mysqlDB.DB})
+}
+
 func TestFileTransferConfigs__maskPassword(t *testing.T) {
 	if v := maskPassword(""); v != "**" {
 		t.Errorf("got %q", v)
@@ -337,6 +394,12 @@ func TestLocalFileTransferRepository(t *testing.T) {
 	if err := repo.deleteSFTPConfig(""); err != nil {
 		t.Error(err)
 	}
+	if err := repo.upsertConfig(&Config{}); err != nil {
+		t.Error(err)
+	}
+	if err := repo.deleteConfig(""); err != nil {
+		t.Error(err)
+	}
 }
 
 func writeSFTPConfig(t *testing.T, repo *testSQLRepository) {
@@ -6,10 +6,12 @@ package filetransfer
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/go-kit/kit/log"
 )
@@ -20,6 +22,61 @@ type Config struct {
 	InboundPath  string
 	OutboundPath string
 	ReturnPath   string
+
+	// BalanceEntries will add offset records (against our ODFI account) to each batch of
+	// merged files prior to upload. Some ODFIs require every uploaded file to be balanced.
+	BalanceEntries bool
+
+	// FilenameTemplate is a text/template for naming uploaded files. See DefaultFilenameTemplate
+	// for the default and FilenameData for the available values.
+	FilenameTemplate string
+
+	// FileHeader holds values which override the FileHeader of merged files prior to upload.
+	FileHeader FileHeaderOverrides
+}
+
+// FileHeaderOverrides are values written into the FileHeader of ACH files uploaded to an ODFI.
+// Empty values leave the FileHeader unchanged.
+type FileHeaderOverrides struct {
+	ImmediateOrigin          string
+	ImmediateOriginName      string
+	ImmediateDestination     string
+	ImmediateDestinationName string
+	ReferenceCode            string
+	FileIDModifier           string
+}
+
+func (o FileHeaderOverrides) validate() error {
+	if n := utf8.RuneCountInString(o.ImmediateOrigin); n > 10 {
+		return fmt.Errorf("invalid ImmediateOrigin %q", o.ImmediateOrigin)
+	}
+	if n := utf8.RuneCountInString(o.ImmediateDestination); n > 10 {
+		return fmt.Errorf("invalid ImmediateDestination %q", o.ImmediateDestination)
+	}
+	if utf8.RuneCountInString(o.ImmediateOriginName) > 23 || utf8.RuneCountInString(o.ImmediateDestinationName) > 23 {
+		return errors.New("ImmediateOriginName and ImmediateDestinationName can be at most 23 characters")
+	}
+	if utf8.RuneCountInString(o.ReferenceCode) > 8 {
+		return fmt.Errorf("invalid ReferenceCode %q", o.ReferenceCode)
+	}
+	if v := o.FileIDModifier; v != "" && (len(v) != 1 || !strings.Contains("ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789", v)) {
+		return fmt.Errorf("invalid FileIDModifier %q", v)
+	}
+	return nil
+}
+
+// Empty returns true if no FileHeader values are overridden.
+func (o FileHeaderOverrides) Empty() bool {
+	return o == FileHeaderOverrides{}
+}
+
+func (cfg *Config) validate() error {
+	if cfg.FilenameTemplate != "" {
+		if err := ValidateFilenameTemplate(cfg.FilenameTemplate); err != nil {
+			return err
+		}
+	}
+	return cfg.FileHeader.validate()
 }
 
 type File struct {
@@ -0,0 +1,82 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package filetransfer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultFilenameTemplate is the filename template used for ACH files when a Config has
+// no FilenameTemplate. Rendered it looks like: 20181222-301234567-1.ach
+//
+// yyyy = Year of file creation
+// MM = Month of file creation
+// dd = Day of file creation
+// RTN . . . = 9-digit Routing Transit Number of the bank (ODFI or RDFI) (example: 301234567)
+// N = file sequence of the day, i.e., 1, 2, 3, ..., 9, A, B, ...
+const DefaultFilenameTemplate = `{{ date "20060102" }}-{{ .RoutingNumber }}-{{ .N }}.ach`
+
+// FilenameData holds the values available to filename templates.
+type FilenameData struct {
+	// RoutingNumber is the ABA routing number the file is uploaded for
+	RoutingNumber string
+
+	// N is the file sequence of the day, i.e., 1, 2, 3, ..., 9, A, B, ...
+	N string
+}
+
+var filenameFunctions = template.FuncMap{
+	// date formats the current time with the Go time layout provided, i.e. {{ date "20060102" }}
+	"date": func(layout string) string {
+		return time.Now().Format(layout)
+	},
+}
+
+// Filename renders the FilenameTemplate of cfg (or DefaultFilenameTemplate if unset) with data.
+func (cfg *Config) Filename(data FilenameData) (string, error) {
+	if cfg == nil || cfg.FilenameTemplate == "" {
+		return RenderACHFilename(DefaultFilenameTemplate, data)
+	}
+	return RenderACHFilename(cfg.FilenameTemplate, data)
+}
+
+// RenderACHFilename executes the given text/template with data and returns the filename.
+func RenderACHFilename(raw string, data FilenameData) (string, error) {
+	t, err := parseFilenameTemplate(raw)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("filename template: %v", err)
+	}
+	filename := strings.TrimSpace(buf.String())
+	if filename == "" || strings.ContainsAny(filename, `/\`) {
+		return "", fmt.Errorf("filename template: invalid filename %q", filename)
+	}
+	return filename, nil
+}
+
+// ValidateFilenameTemplate returns an error if raw is not a usable filename template.
+func ValidateFilenameTemplate(raw string) error {
+	if raw == "" {
+		return errors.New("filename template: empty template")
+	}
+	_, err := RenderACHFilename(raw, FilenameData{RoutingNumber: "121042882", N: "1"})
+	return err
+}
+
+func parseFilenameTemplate(raw string) (*template.Template, error) {
+	t, err := template.New("filename").Funcs(filenameFunctions).Option("missingkey=error").Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("filename template: %v", err)
+	}
+	return t, nil
+}
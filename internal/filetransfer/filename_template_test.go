@@ -0,0 +1,99 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package filetransfer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFilenameTemplate__default(t *testing.T) {
+	filename, err := RenderACHFilename(DefaultFilenameTemplate, FilenameData{RoutingNumber: "987654320", N: "B"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := fmt.Sprintf("%s-987654320-B.ach", time.Now().Format("20060102")); filename != v {
+		t.Errorf("got %q", filename)
+	}
+
+	// nil and empty Configs use the default
+	var cfg *Config
+	if v, _ := cfg.Filename(FilenameData{RoutingNumber: "987654320", N: "B"}); v != filename {
+		t.Errorf("got %q", v)
+	}
+	cfg = &Config{}
+	if v, _ := cfg.Filename(FilenameData{RoutingNumber: "987654320", N: "B"}); v != filename {
+		t.Errorf("got %q", v)
+	}
+}
+
+func TestFilenameTemplate__custom(t *testing.T) {
+	cfg := &Config{FilenameTemplate: `PAYGATE.{{ .RoutingNumber }}.{{ date "060102" }}.{{ .N }}.txt`}
+	filename, err := cfg.Filename(FilenameData{RoutingNumber: "987654320", N: "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := fmt.Sprintf("PAYGATE.987654320.%s.3.txt", time.Now().Format("060102")); filename != v {
+		t.Errorf("got %q", filename)
+	}
+}
+
+func TestFilenameTemplate__validate(t *testing.T) {
+	if err := ValidateFilenameTemplate(DefaultFilenameTemplate); err != nil {
+		t.Error(err)
+	}
+	bad := []string{
+		"",
+		"{{ .Missing }}.ach",
+		"{{ date }",
+		"{{ .RoutingNumber }}/{{ .N }}.ach",
+		"   ",
+	}
+	for i := range bad {
+		if err := ValidateFilenameTemplate(bad[i]); err == nil {
+			t.Errorf("expected error for %q", bad[i])
+		}
+	}
+}
+
+func TestFileHeaderOverrides__validate(t *testing.T) {
+	o := FileHeaderOverrides{}
+	if !o.Empty() {
+		t.Error("expected empty overrides")
+	}
+	o = FileHeaderOverrides{
+		ImmediateOrigin:      "1234567890",
+		ImmediateOriginName:  "My Company",
+		ImmediateDestination: "987654320",
+		ReferenceCode:        "ref",
+		FileIDModifier:       "B",
+	}
+	if o.Empty() {
+		t.Error("expected overrides")
+	}
+	if err := o.validate(); err != nil {
+		t.Error(err)
+	}
+
+	bad := []FileHeaderOverrides{
+		{ImmediateOrigin: "12345678901"},
+		{ImmediateDestination: "12345678901"},
+		{ImmediateOriginName: "this name is far too long for a header"},
+		{ReferenceCode: "123456789"},
+		{FileIDModifier: "a"},
+		{FileIDModifier: "AB"},
+	}
+	for i := range bad {
+		if err := bad[i].validate(); err == nil {
+			t.Errorf("expected error: %#v", bad[i])
+		}
+	}
+
+	cfg := &Config{FilenameTemplate: "{{ .Other }}"}
+	if err := cfg.validate(); err == nil {
+		t.Error("expected error")
+	}
+}
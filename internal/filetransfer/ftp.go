@@ -56,10 +56,9 @@ func (cfg *FTPConfig) String() string {
 
 // FTPTransferAgent is an FTP implementation of a Agent
 type FTPTransferAgent struct {
-	conn *ftp.ServerConn
-
-	// TODO(adam): What sort of metrics should we collect? Just each operation into a histogram?
-	// If so we could wrap those in an Agent shim with Prometheus
+	conn     *ftp.ServerConn
+	dialOpts []ftp.DialOption
+	hostname string
 
 	cfg        *Config
 	ftpConfigs []*FTPConfig
@@ -95,17 +94,49 @@ func newFTPTransferAgent(logger log.Logger, cfg *Config, ftpConfigs []*FTPConfig
 	if tlsOpt != nil {
 		opts = append(opts, *tlsOpt)
 	}
+	agent.dialOpts = opts
 
 	// Make the first connection
-	conn, err := ftp.Dial(ftpConf.Hostname, opts...)
-	if err != nil {
+	if err := retry(logger, ftpConf.Hostname, "dial", func() error { return agent.dial(ftpConf) }); err != nil {
 		return nil, err
 	}
+	agent.hostname = ftpConf.Hostname
+	return agent, nil
+}
+
+// dial connects and logs into the FTP server, replacing (and closing) any previous connection.
+func (agent *FTPTransferAgent) dial(ftpConf *FTPConfig) error {
+	conn, err := ftp.Dial(ftpConf.Hostname, agent.dialOpts...)
+	if err != nil {
+		return err
+	}
 	if err := conn.Login(ftpConf.Username, ftpConf.Password); err != nil {
-		return nil, err
+		conn.Quit()
+		return err
+	}
+	if agent.conn != nil {
+		agent.conn.Quit()
 	}
 	agent.conn = conn
-	return agent, nil
+	return nil
+}
+
+// reconnect returns a func which dials the FTP server again and moves into dir (if set), for operations
+// retried after a connection error.
+func (agent *FTPTransferAgent) reconnect(dir string) func() error {
+	return func() error {
+		ftpConf := agent.findConfig()
+		if ftpConf == nil {
+			return fmt.Errorf("ftp: unable to find config for %s", agent.cfg.RoutingNumber)
+		}
+		if err := agent.dial(ftpConf); err != nil {
+			return err
+		}
+		if dir != "" {
+			return agent.conn.ChangeDir(dir)
+		}
+		return nil
+	}
 }
 
 func tlsDialOption(caFilePath string) (*ftp.DialOption, error) {
@@ -151,7 +182,13 @@ func (agent *FTPTransferAgent) Delete(path string) error {
 	if path == "" || strings.HasSuffix(path, "/") {
 		return fmt.Errorf("FTPTransferAgent: invalid path %v", path)
 	}
-	return agent.conn.Delete(path)
+
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+
+	return retryWithReconnect(agent.logger, agent.hostname, "delete", agent.reconnect(""), func() error {
+		return agent.conn.Delete(path)
+	})
 }
 
 // uploadFile saves the content of File at the given filename in the OutboundPath directory
@@ -183,9 +220,17 @@ func (agent *FTPTransferAgent) UploadFile(f File) error {
 		}
 	}(wd)
 
+	// Read the contents into memory so retries can re-send the entire file
+	bs, err := ioutil.ReadAll(f.Contents)
+	if err != nil {
+		return fmt.Errorf("ftp: problem reading %s: %v", f.Filename, err)
+	}
+
 	// Write file contents into path
 	// Take the base of f.Filename and our (out of band) OutboundPath to avoid accepting a write like '../../../../etc/passwd'.
-	return agent.conn.Stor(filepath.Base(f.Filename), f.Contents)
+	return retryWithReconnect(agent.logger, agent.hostname, "upload", agent.reconnect(agent.cfg.OutboundPath), func() error {
+		return agent.conn.Stor(filepath.Base(f.Filename), bytes.NewReader(bs))
+	})
 }
 
 func (agent *FTPTransferAgent) GetInboundFiles() ([]File, error) {
@@ -216,19 +261,30 @@ func (agent *FTPTransferAgent) readFiles(path string) ([]File, error) {
 	}
 
 	// Read files in current directory
-	items, err := agent.conn.NameList("")
+	var items []string
+	err = retryWithReconnect(agent.logger, agent.hostname, "list", agent.reconnect(path), func() error {
+		items, err = agent.conn.NameList("")
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	var files []File
 	for i := range items {
-		resp, err := agent.conn.Retr(items[i])
-		if err != nil {
-			return nil, fmt.Errorf("problem retrieving %s: %v", items[i], err)
-		}
-		r, err := agent.readResponse(resp)
+		var r io.ReadCloser
+		err := retryWithReconnect(agent.logger, agent.hostname, "read", agent.reconnect(path), func() error {
+			resp, err := agent.conn.Retr(items[i])
+			if err != nil {
+				return fmt.Errorf("problem retrieving %s: %v", items[i], err)
+			}
+			r, err = agent.readResponse(resp)
+			if err != nil {
+				return fmt.Errorf("problem reading %s: %v", items[i], err)
+			}
+			return nil
+		})
 		if err != nil {
-			return nil, fmt.Errorf("problem reading %s: %v", items[i], err)
+			return nil, err
 		}
 		files = append(files, File{
 			Filename: items[i],
@@ -0,0 +1,202 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package filetransfer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// retryMaxAttempts is how many times each FTP/SFTP operation (dial, list, read, upload, delete) is tried.
+	retryMaxAttempts = func() int {
+		if n, err := strconv.Atoi(os.Getenv("FILE_TRANSFER_RETRY_ATTEMPTS")); err == nil && n > 0 {
+			return n
+		}
+		return 3
+	}()
+
+	// retryInitialInterval is the wait after the first failed attempt, it doubles after each
+	// following failure (up to retryMaxInterval).
+	retryInitialInterval = func() time.Duration {
+		if dur, err := time.ParseDuration(os.Getenv("FILE_TRANSFER_RETRY_INTERVAL")); err == nil && dur > 0 {
+			return dur
+		}
+		return 500 * time.Millisecond
+	}()
+	retryMaxInterval = 30 * time.Second
+
+	// circuitBreakerFailures is how many consecutive failed operations against a host open its
+	// circuit breaker. While open every operation against the host fails immediately.
+	circuitBreakerFailures = func() int {
+		if n, err := strconv.Atoi(os.Getenv("FILE_TRANSFER_CIRCUIT_BREAKER_FAILURES")); err == nil && n > 0 {
+			return n
+		}
+		return 5
+	}()
+
+	// circuitBreakerCooldown is how long a circuit breaker stays open before letting an operation through.
+	circuitBreakerCooldown = func() time.Duration {
+		if dur, err := time.ParseDuration(os.Getenv("FILE_TRANSFER_CIRCUIT_BREAKER_COOLDOWN")); err == nil && dur > 0 {
+			return dur
+		}
+		return time.Minute
+	}()
+
+	operationAttempts = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Name: "file_transfer_operation_attempts",
+		Help: "Counter of FTP/SFTP operation attempts",
+	}, []string{"hostname", "operation"})
+
+	operationFailures = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Name: "file_transfer_operation_failures",
+		Help: "Counter of failed FTP/SFTP operation attempts",
+	}, []string{"hostname", "operation"})
+
+	// ErrCircuitOpen is returned for operations against a host whose circuit breaker is open.
+	ErrCircuitOpen = errors.New("circuit breaker open")
+)
+
+// circuitBreaker tracks consecutive failures against a host
+type circuitBreaker struct {
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+}
+
+// allow returns false while the breaker is open. After circuitBreakerCooldown one operation is let
+// through to check the host and the breaker re-opens if that operation fails.
+func (cb *circuitBreaker) allow(now time.Time) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.openedAt.IsZero() {
+		return true
+	}
+	if now.Sub(cb.openedAt) >= circuitBreakerCooldown {
+		cb.openedAt = now // only let one operation through per cooldown
+		return true
+	}
+	return false
+}
+
+func (cb *circuitBreaker) record(err error, now time.Time) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if err == nil {
+		cb.failures = 0
+		cb.openedAt = time.Time{}
+		return
+	}
+	cb.failures++
+	if cb.failures >= circuitBreakerFailures {
+		cb.openedAt = now
+	}
+}
+
+var (
+	circuitBreakersMu sync.Mutex
+	circuitBreakers   = make(map[string]*circuitBreaker)
+)
+
+func hostCircuitBreaker(hostname string) *circuitBreaker {
+	circuitBreakersMu.Lock()
+	defer circuitBreakersMu.Unlock()
+
+	cb, ok := circuitBreakers[hostname]
+	if !ok {
+		cb = &circuitBreaker{}
+		circuitBreakers[hostname] = cb
+	}
+	return cb
+}
+
+// retry calls fn until it succeeds or retryMaxAttempts is reached, waiting with exponential backoff
+// between attempts. Each host has a circuit breaker which fails operations immediately once the host
+// has failed too many times in a row.
+func retry(logger log.Logger, hostname, operation string, fn func() error) error {
+	cb := hostCircuitBreaker(hostname)
+	wait := retryInitialInterval
+
+	var err error
+	for attempt := 1; attempt <= retryMaxAttempts; attempt++ {
+		if !cb.allow(time.Now()) {
+			return fmt.Errorf("%s %s: %v", operation, hostname, ErrCircuitOpen)
+		}
+
+		operationAttempts.With("hostname", hostname, "operation", operation).Add(1)
+		err = fn()
+		cb.record(err, time.Now())
+		if err == nil {
+			return nil
+		}
+		operationFailures.With("hostname", hostname, "operation", operation).Add(1)
+
+		if attempt < retryMaxAttempts {
+			if logger != nil {
+				logger.Log("filetransfer", fmt.Sprintf("%s %s failed (attempt %d of %d), retrying in %v: %v", operation, hostname, attempt, retryMaxAttempts, wait, err))
+			}
+			time.Sleep(wait)
+			if wait *= 2; wait > retryMaxInterval {
+				wait = retryMaxInterval
+			}
+		}
+	}
+	return err
+}
+
+// retryWithReconnect is like retry, but when fn fails with a connection error reconnect is called before the
+// next attempt. Retrying on a broken connection would otherwise fail every attempt.
+func retryWithReconnect(logger log.Logger, hostname, operation string, reconnect func() error, fn func() error) error {
+	return retry(logger, hostname, operation, func() error {
+		err := fn()
+		if err != nil && isConnectionError(err) {
+			if logger != nil {
+				logger.Log("filetransfer", fmt.Sprintf("%s %s: reconnecting after connection error: %v", operation, hostname, err))
+			}
+			if rerr := reconnect(); rerr != nil {
+				return fmt.Errorf("%v (and reconnecting: %v)", err, rerr)
+			}
+		}
+		return err
+	})
+}
+
+// isConnectionError returns true if err means the connection to a FTP/SFTP server is broken. Our agents
+// wrap errors as strings, so their messages are also checked.
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	if e, ok := err.(*textproto.Error); ok {
+		return e.Code == 421 // FTP: service not available, closing control connection
+	}
+	msg := err.Error()
+	for _, s := range []string{"use of closed network connection", "connection reset", "broken pipe", "connection lost", "unexpected EOF", "i/o timeout", "421 "} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return strings.HasSuffix(msg, ": EOF")
+}
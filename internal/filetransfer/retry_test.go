@@ -0,0 +1,180 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package filetransfer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// setupRetryTest shortens retry intervals and returns a func to restore the original values
+func setupRetryTest() func() {
+	attempts, interval := retryMaxAttempts, retryInitialInterval
+	failures, cooldown := circuitBreakerFailures, circuitBreakerCooldown
+
+	retryMaxAttempts, retryInitialInterval = 3, time.Millisecond
+	circuitBreakerFailures, circuitBreakerCooldown = 5, time.Minute
+
+	return func() {
+		retryMaxAttempts, retryInitialInterval = attempts, interval
+		circuitBreakerFailures, circuitBreakerCooldown = failures, cooldown
+	}
+}
+
+func TestRetry(t *testing.T) {
+	defer setupRetryTest()()
+
+	calls := 0
+	err := retry(log.NewNopLogger(), t.Name(), "upload", func() error {
+		if calls++; calls < 3 {
+			return errors.New("bad error")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("got %d calls", calls)
+	}
+
+	// give up after retryMaxAttempts
+	calls = 0
+	err = retry(nil, t.Name(), "delete", func() error {
+		calls++
+		return errors.New("bad error")
+	})
+	if err == nil || err.Error() != "bad error" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("got %d calls", calls)
+	}
+}
+
+func TestRetry__circuitBreaker(t *testing.T) {
+	defer setupRetryTest()()
+
+	calls := 0
+	fail := func() error {
+		calls++
+		return errors.New("bad error")
+	}
+	retry(nil, t.Name(), "dial", fail) // 3 failures
+	retry(nil, t.Name(), "dial", fail) // 2 failures, then open
+
+	if calls != 5 {
+		t.Errorf("got %d calls", calls)
+	}
+	err := retry(nil, t.Name(), "dial", fail)
+	if err == nil || !strings.Contains(err.Error(), ErrCircuitOpen.Error()) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if calls != 5 {
+		t.Errorf("got %d calls", calls)
+	}
+
+	// other hosts aren't affected
+	if err := retry(nil, t.Name()+"-other", "dial", func() error { return nil }); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	defer setupRetryTest()()
+	circuitBreakerFailures = 2
+
+	now := time.Now()
+	cb := &circuitBreaker{}
+	if !cb.allow(now) {
+		t.Fatal("expected closed circuit breaker")
+	}
+	cb.record(errors.New("bad error"), now)
+	cb.record(errors.New("bad error"), now)
+	if cb.allow(now) {
+		t.Fatal("expected open circuit breaker")
+	}
+
+	// after the cooldown one operation is let through
+	later := now.Add(circuitBreakerCooldown)
+	if !cb.allow(later) {
+		t.Fatal("expected half-open circuit breaker")
+	}
+	if cb.allow(later) {
+		t.Fatal("expected only one operation let through")
+	}
+
+	// success closes the breaker
+	cb.record(nil, later)
+	if !cb.allow(later) {
+		t.Error("expected closed circuit breaker")
+	}
+}
+
+func TestRetryWithReconnect(t *testing.T) {
+	defer setupRetryTest()()
+
+	calls, reconnects := 0, 0
+	reconnect := func() error {
+		reconnects++
+		return nil
+	}
+	err := retryWithReconnect(log.NewNopLogger(), t.Name(), "upload", reconnect, func() error {
+		if calls++; calls == 1 {
+			return fmt.Errorf("sftp: problem creating file.ach: %v", io.EOF)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 || reconnects != 1 {
+		t.Errorf("calls=%d reconnects=%d", calls, reconnects)
+	}
+
+	// other errors are retried on the same connection
+	calls, reconnects = 0, 0
+	err = retryWithReconnect(nil, t.Name(), "delete", reconnect, func() error {
+		calls++
+		return errors.New("550 file not found")
+	})
+	if err == nil || calls != 3 || reconnects != 0 {
+		t.Errorf("calls=%d reconnects=%d error=%v", calls, reconnects, err)
+	}
+
+	// reconnect errors are returned
+	err = retryWithReconnect(nil, t.Name()+"-redial", "list", func() error { return errors.New("dial failed") }, func() error {
+		return errors.New("write tcp: broken pipe")
+	})
+	if err == nil || !strings.Contains(err.Error(), "dial failed") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	cases := map[error]bool{
+		nil:                                   false,
+		io.EOF:                                true,
+		&net.OpError{Op: "read", Err: io.EOF}: true,
+		&textproto.Error{Code: 421, Msg: "closing control connection"}: true,
+		&textproto.Error{Code: 550, Msg: "file not found"}:             false,
+		errors.New("sftp: open file.ach: connection lost"):             true,
+		errors.New("sftp: readdir /inbound: EOF"):                      true,
+		errors.New("sftp: open file.ach: file does not exist"):         false,
+	}
+	for err, expected := range cases {
+		if got := isConnectionError(err); got != expected {
+			t.Errorf("%v: got %v", err, got)
+		}
+	}
+}
@@ -78,12 +78,15 @@ func (cfg *SFTPConfig) String() string {
 }
 
 type SFTPTransferAgent struct {
-	conn   *ssh.Client
-	client *sftp.Client
+	conn     *ssh.Client
+	client   *sftp.Client
+	hostname string
 
 	cfg         *Config
 	sftpConfigs []*SFTPConfig
 
+	logger log.Logger
+
 	mu sync.Mutex // protects all read/write methods
 }
 
@@ -97,17 +100,25 @@ func (a *SFTPTransferAgent) findConfig() *SFTPConfig {
 }
 
 func newSFTPTransferAgent(logger log.Logger, cfg *Config, sftpConfigs []*SFTPConfig) (*SFTPTransferAgent, error) {
-	agent := &SFTPTransferAgent{cfg: cfg, sftpConfigs: sftpConfigs}
+	agent := &SFTPTransferAgent{cfg: cfg, sftpConfigs: sftpConfigs, logger: logger}
 	sftpConf := agent.findConfig()
 	if sftpConf == nil {
 		return nil, fmt.Errorf("sftp: unable to find config for %s", cfg.RoutingNumber)
 	}
+	agent.hostname = sftpConf.Hostname
+
+	if err := agent.connect(sftpConf); err != nil {
+		return nil, err
+	}
+	return agent, nil
+}
 
-	conn, stdin, stdout, err := sftpConnect(logger, sftpConf)
+// connect opens the SSH connection and SFTP client, replacing (and closing) any previous ones.
+func (agent *SFTPTransferAgent) connect(sftpConf *SFTPConfig) error {
+	conn, stdin, stdout, err := sftpConnect(agent.logger, sftpConf)
 	if err != nil {
-		return nil, fmt.Errorf("filetransfer: %v", err)
+		return fmt.Errorf("filetransfer: %v", err)
 	}
-	agent.conn = conn
 
 	// Setup our SFTP client
 	var opts = []sftp.ClientOption{
@@ -118,11 +129,26 @@ func newSFTPTransferAgent(logger log.Logger, cfg *Config, sftpConfigs []*SFTPCon
 	client, err := sftp.NewClientPipe(stdout, stdin, opts...)
 	if err != nil {
 		go conn.Close()
-		return nil, fmt.Errorf("filetransfer: sftp connect: %v", err)
+		return fmt.Errorf("filetransfer: sftp connect: %v", err)
 	}
-	agent.client = client
 
-	return agent, nil
+	if agent.client != nil {
+		agent.client.Close()
+	}
+	if agent.conn != nil {
+		go agent.conn.Close()
+	}
+	agent.conn, agent.client = conn, client
+	return nil
+}
+
+// reconnect opens a new connection for operations retried after a connection error.
+func (agent *SFTPTransferAgent) reconnect() error {
+	sftpConf := agent.findConfig()
+	if sftpConf == nil {
+		return fmt.Errorf("sftp: unable to find config for %s", agent.cfg.RoutingNumber)
+	}
+	return agent.connect(sftpConf)
 }
 
 var (
@@ -166,13 +192,11 @@ func sftpConnect(logger log.Logger, sftpConf *SFTPConfig) (*ssh.Client, io.Write
 
 	// Connect to the remote server
 	var client *ssh.Client
-	var err error
-	for i := 0; i < 3; i++ {
-		if client == nil {
-			client, err = ssh.Dial("tcp", sftpConf.Hostname, conf) // retry connection
-			time.Sleep(250 * time.Millisecond)
-		}
-	}
+	err := retry(logger, sftpConf.Hostname, "dial", func() error {
+		c, err := ssh.Dial("tcp", sftpConf.Hostname, conf)
+		client = c
+		return err
+	})
 	if client == nil && err != nil {
 		return nil, nil, nil, fmt.Errorf("sftpConnect: error with routingNumber=%s: %v", sftpConf.RoutingNumber, err)
 	}
@@ -261,16 +285,21 @@ func (agent *SFTPTransferAgent) ReturnPath() string {
 }
 
 func (agent *SFTPTransferAgent) Delete(path string) error {
-	info, err := agent.client.Stat(path)
-	if err != nil {
-		return fmt.Errorf("sftp: delete stat: %v", err)
-	}
-	if info != nil {
-		if err := agent.client.Remove(path); err != nil {
-			return fmt.Errorf("sftp: delete: %v", err)
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+
+	return retryWithReconnect(agent.logger, agent.hostname, "delete", agent.reconnect, func() error {
+		info, err := agent.client.Stat(path)
+		if err != nil {
+			return fmt.Errorf("sftp: delete stat: %v", err)
 		}
-	}
-	return nil // not found
+		if info != nil {
+			if err := agent.client.Remove(path); err != nil {
+				return fmt.Errorf("sftp: delete: %v", err)
+			}
+		}
+		return nil // not found
+	})
 }
 
 // uploadFile saves the content of File at the given filename in the OutboundPath directory
@@ -290,22 +319,31 @@ func (agent *SFTPTransferAgent) UploadFile(f File) error {
 		}
 	}
 
-	// Take the base of f.Filename and our (out of band) OutboundPath to avoid accepting a write like '../../../../etc/passwd'.
-	fd, err := agent.client.Create(filepath.Join(agent.cfg.OutboundPath, filepath.Base(f.Filename)))
+	// Read the contents into memory so retries can re-send the entire file
+	bs, err := ioutil.ReadAll(f.Contents)
 	if err != nil {
-		return fmt.Errorf("sftp: problem creating %s: %v", f.Filename, err)
-	}
-	n, err := io.Copy(fd, f.Contents)
-	if n == 0 || err != nil {
-		return fmt.Errorf("sftp: problem copying (n=%d) %s: %v", n, f.Filename, err)
-	}
-	if err := fd.Close(); err != nil {
-		return fmt.Errorf("sftp: problem closing %s: %v", f.Filename, err)
+		return fmt.Errorf("sftp: problem reading %s: %v", f.Filename, err)
 	}
-	if err := fd.Chmod(0600); err != nil {
-		return fmt.Errorf("sftp: problem chmod %s: %v", f.Filename, err)
-	}
-	return nil
+
+	return retryWithReconnect(agent.logger, agent.hostname, "upload", agent.reconnect, func() error {
+		// Take the base of f.Filename and our (out of band) OutboundPath to avoid accepting a write like '../../../../etc/passwd'.
+		fd, err := agent.client.Create(filepath.Join(agent.cfg.OutboundPath, filepath.Base(f.Filename)))
+		if err != nil {
+			return fmt.Errorf("sftp: problem creating %s: %v", f.Filename, err)
+		}
+		n, err := io.Copy(fd, bytes.NewReader(bs))
+		if n == 0 || err != nil {
+			fd.Close()
+			return fmt.Errorf("sftp: problem copying (n=%d) %s: %v", n, f.Filename, err)
+		}
+		if err := fd.Close(); err != nil {
+			return fmt.Errorf("sftp: problem closing %s: %v", f.Filename, err)
+		}
+		if err := fd.Chmod(0600); err != nil {
+			return fmt.Errorf("sftp: problem chmod %s: %v", f.Filename, err)
+		}
+		return nil
+	})
 }
 
 func (agent *SFTPTransferAgent) GetInboundFiles() ([]File, error) {
@@ -317,34 +355,46 @@ func (agent *SFTPTransferAgent) GetReturnFiles() ([]File, error) {
 }
 
 func (agent *SFTPTransferAgent) readFiles(dir string) ([]File, error) {
-	infos, err := agent.client.ReadDir(dir)
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+
+	var infos []os.FileInfo
+	err := retryWithReconnect(agent.logger, agent.hostname, "list", agent.reconnect, func() error {
+		var err error
+		infos, err = agent.client.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("sftp: readdir %s: %v", dir, err)
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("sftp: readdir %s: %v", dir, err)
+		return nil, err
 	}
 
 	var files []File
 	for i := range infos {
-		fd, err := agent.client.Open(filepath.Join(dir, infos[i].Name()))
-		if err != nil {
-			return nil, fmt.Errorf("sftp: open %s: %v", infos[i].Name(), err)
-		}
 		var buf bytes.Buffer
-		for i := 0; i < 3; i++ {
-			if i > 0 {
-				buf.Reset() // clean our buffer if we're retrying
+		err := retryWithReconnect(agent.logger, agent.hostname, "read", agent.reconnect, func() error {
+			buf.Reset() // clean our buffer if we're retrying
+
+			fd, err := agent.client.Open(filepath.Join(dir, infos[i].Name()))
+			if err != nil {
+				return fmt.Errorf("sftp: open %s: %v", infos[i].Name(), err)
 			}
+			defer fd.Close()
+
 			// Attempt to read fd. Partial reads have n > 0, but err != nil and need to be retried.
 			if n, err := io.Copy(&buf, fd); n == 0 || err != nil {
-				fd.Close()
 				if err != nil && !strings.Contains(err.Error(), sftp.InternalInconsistency.Error()) {
-					return nil, fmt.Errorf("sftp: read (n=%d) %s: %v", n, infos[i].Name(), err)
+					return fmt.Errorf("sftp: read (n=%d) %s: %v", n, infos[i].Name(), err)
 				}
-				return nil, fmt.Errorf("sftp: read (n=%d) on %s", n, infos[i].Name())
-			} else {
-				break // successful read
+				return fmt.Errorf("sftp: read (n=%d) on %s", n, infos[i].Name())
 			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
 		}
-		fd.Close()
 		files = append(files, File{
 			Filename: infos[i].Name(),
 			Contents: ioutil.NopCloser(&buf),
@@ -0,0 +1,222 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+// Package secrets implements envelope encryption of small values (account numbers, etc) stored in paygate's database.
+//
+// Each value is encrypted with a random data encryption key (DEK) using AES-GCM. The DEK is then encrypted (wrapped)
+// with a key encryption key (KEK) and stored alongside the value. Rotating the KEK only requires re-wrapping each DEK.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const version = "v1"
+
+// Keeper encrypts, decrypts and hashes values.
+type Keeper struct {
+	// primary is the ID of the KEK new values are wrapped with
+	primary string
+	keks    map[string][]byte
+
+	hashKey []byte
+}
+
+// NewKeeper returns a Keeper from a comma separated list of keyID:base64(key) KEKs and a base64 encoded hash key.
+// Every key must be 32 bytes (AES-256). The first KEK is used to encrypt new values, the others are only used
+// to decrypt values until they've been re-wrapped.
+//
+// The hash key is used for deterministic lookups of values and can't be rotated without re-hashing every value.
+func NewKeeper(keys string, hashKey string) (*Keeper, error) {
+	k := &Keeper{
+		keks: make(map[string][]byte),
+	}
+	for _, raw := range strings.Split(keys, ",") {
+		parts := strings.SplitN(strings.TrimSpace(raw), ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.New("secrets: keys must be formatted as id:base64(key)")
+		}
+		key, err := decodeKey(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("secrets: key %s: %v", parts[0], err)
+		}
+		if _, exists := k.keks[parts[0]]; exists {
+			return nil, fmt.Errorf("secrets: duplicate key %s", parts[0])
+		}
+		if k.primary == "" {
+			k.primary = parts[0]
+		}
+		k.keks[parts[0]] = key
+	}
+	key, err := decodeKey(hashKey)
+	if err != nil {
+		return nil, fmt.Errorf("secrets: hash key: %v", err)
+	}
+	k.hashKey = key
+	return k, nil
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("got %d byte key, expected 32 bytes", len(key))
+	}
+	return key, nil
+}
+
+// PrimaryKeyID returns the ID of the KEK new values are encrypted under.
+func (k *Keeper) PrimaryKeyID() string {
+	return k.primary
+}
+
+// Encrypt returns the envelope encrypted form of plaintext.
+//
+// The format is: v1:<kek id>:base64(wrapped dek):base64(ciphertext)
+func (k *Keeper) Encrypt(plaintext string) (string, error) {
+	dek := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dek, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := seal(k.keks[k.primary], dek)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{
+		version,
+		k.primary,
+		base64.StdEncoding.EncodeToString(wrapped),
+		base64.StdEncoding.EncodeToString(ciphertext),
+	}, ":"), nil
+}
+
+// Encrypted returns true if value looks like it was returned from Encrypt.
+func Encrypted(value string) bool {
+	return strings.HasPrefix(value, version+":")
+}
+
+// Decrypt returns the plaintext of a value from Encrypt.
+func (k *Keeper) Decrypt(value string) (string, error) {
+	env, err := k.parse(value)
+	if err != nil {
+		return "", err
+	}
+	dek, err := open(env.kek, env.wrapped)
+	if err != nil {
+		return "", fmt.Errorf("secrets: unwrapping key: %v", err)
+	}
+	plaintext, err := open(dek, env.ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("secrets: decrypting: %v", err)
+	}
+	return string(plaintext), nil
+}
+
+// Rewrap re-encrypts the DEK of value with the primary KEK. The returned bool is false if value was already
+// wrapped with the primary KEK (and is returned unchanged).
+func (k *Keeper) Rewrap(value string) (string, bool, error) {
+	env, err := k.parse(value)
+	if err != nil {
+		return "", false, err
+	}
+	if env.kekID == k.primary {
+		return value, false, nil
+	}
+	dek, err := open(env.kek, env.wrapped)
+	if err != nil {
+		return "", false, fmt.Errorf("secrets: unwrapping key: %v", err)
+	}
+	wrapped, err := seal(k.keks[k.primary], dek)
+	if err != nil {
+		return "", false, err
+	}
+	return strings.Join([]string{
+		version,
+		k.primary,
+		base64.StdEncoding.EncodeToString(wrapped),
+		base64.StdEncoding.EncodeToString(env.ciphertext),
+	}, ":"), true, nil
+}
+
+// Hash returns a deterministic keyed hash (HMAC-SHA256) of value for lookups.
+func (k *Keeper) Hash(value string) string {
+	mac := hmac.New(sha256.New, k.hashKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type envelope struct {
+	kekID      string
+	kek        []byte
+	wrapped    []byte
+	ciphertext []byte
+}
+
+func (k *Keeper) parse(value string) (*envelope, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 4 || parts[0] != version {
+		return nil, errors.New("secrets: invalid encrypted value")
+	}
+	kek, ok := k.keks[parts[1]]
+	if !ok {
+		return nil, fmt.Errorf("secrets: unknown key %s", parts[1])
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("secrets: invalid wrapped key: %v", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, fmt.Errorf("secrets: invalid ciphertext: %v", err)
+	}
+	return &envelope{kekID: parts[1], kek: kek, wrapped: wrapped, ciphertext: ciphertext}, nil
+}
+
+// seal encrypts plaintext with AES-GCM and prepends the random nonce
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
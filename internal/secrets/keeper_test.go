@@ -0,0 +1,117 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func TestKeeper(t *testing.T) {
+	k, err := NewKeeper("one:"+testKey(1), testKey(9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.PrimaryKeyID() != "one" {
+		t.Errorf("primary key: %s", k.PrimaryKeyID())
+	}
+
+	enc, err := k.Encrypt("123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(enc, "v1:one:") || strings.Contains(enc, "123456789") {
+		t.Errorf("unexpected encrypted value: %s", enc)
+	}
+	if !Encrypted(enc) || Encrypted("123456789") {
+		t.Error("unexpected Encrypted")
+	}
+	other, _ := k.Encrypt("123456789")
+	if enc == other {
+		t.Error("expected random encryption")
+	}
+
+	dec, err := k.Decrypt(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec != "123456789" {
+		t.Errorf("got %q", dec)
+	}
+
+	// hashes are deterministic
+	if k.Hash("123456789") != k.Hash("123456789") || k.Hash("123456789") == k.Hash("987654321") {
+		t.Error("unexpected hashes")
+	}
+}
+
+func TestKeeper__rotation(t *testing.T) {
+	old, err := NewKeeper("one:"+testKey(1), testKey(9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := old.Encrypt("123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// add a new primary key, the old key is kept for decrypting
+	k, err := NewKeeper("two:"+testKey(2)+", one:"+testKey(1), testKey(9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec, err := k.Decrypt(enc); dec != "123456789" || err != nil {
+		t.Fatalf("dec=%q error=%v", dec, err)
+	}
+
+	rewrapped, changed, err := k.Rewrap(enc)
+	if err != nil || !changed {
+		t.Fatalf("changed=%v error=%v", changed, err)
+	}
+	if !strings.HasPrefix(rewrapped, "v1:two:") {
+		t.Errorf("unexpected value: %s", rewrapped)
+	}
+	if _, changed, _ := k.Rewrap(rewrapped); changed {
+		t.Error("expected no change")
+	}
+
+	// drop the old key
+	k, _ = NewKeeper("two:"+testKey(2), testKey(9))
+	if dec, err := k.Decrypt(rewrapped); dec != "123456789" || err != nil {
+		t.Errorf("dec=%q error=%v", dec, err)
+	}
+	if _, err := k.Decrypt(enc); err == nil {
+		t.Error("expected error")
+	}
+	if k.Hash("123456789") != old.Hash("123456789") {
+		t.Error("hash changed")
+	}
+}
+
+func TestKeeper__errors(t *testing.T) {
+	keys := []string{"", "one", "one:foo", "one:" + base64.StdEncoding.EncodeToString([]byte("short")), "one:" + testKey(1) + ",one:" + testKey(2)}
+	for i := range keys {
+		if _, err := NewKeeper(keys[i], testKey(9)); err == nil {
+			t.Errorf("%q: expected error", keys[i])
+		}
+	}
+	if _, err := NewKeeper("one:"+testKey(1), ""); err == nil {
+		t.Error("expected error")
+	}
+
+	k, _ := NewKeeper("one:"+testKey(1), testKey(9))
+	values := []string{"", "123456789", "v1:other:AA==:AA==", "v1:one:AA==:AA==", "v1:one:!!:AA=="}
+	for i := range values {
+		if _, err := k.Decrypt(values[i]); err == nil {
+			t.Errorf("%q: expected error", values[i])
+		}
+	}
+}
@@ -28,6 +28,39 @@ import (
 	"github.com/go-kit/kit/log"
 )
 
+var (
+	// microDepositMaxAttempts is how many failed confirmations are allowed before a Depository is locked.
+	microDepositMaxAttempts = func() int {
+		if n, err := strconv.Atoi(os.Getenv("MICRO_DEPOSIT_MAX_ATTEMPTS")); err == nil && n > 0 {
+			return n
+		}
+		return 5
+	}()
+
+	// microDepositExpiration is how long micro-deposits can be confirmed for after being initiated.
+	microDepositExpiration = func() time.Duration {
+		if n, err := strconv.Atoi(os.Getenv("MICRO_DEPOSIT_EXPIRATION_DAYS")); err == nil && n > 0 {
+			return time.Duration(n) * 24 * time.Hour
+		}
+		return 30 * 24 * time.Hour
+	}()
+
+	errMicroDepositsExpired = errors.New("micro-deposits have expired and need to be reset")
+	errMicroDepositsLocked  = errors.New("too many failed attempts")
+
+	errDepositoryNotUnverified = errors.New("depository is not unverified")
+	errDepositoryLocked        = errors.New("depository is locked, its micro-deposits need to be reset before changing account details")
+)
+
+// microDepositGuessError is returned when guessed micro-deposit amounts don't match, these count as failed attempts.
+type microDepositGuessError struct {
+	msg string
+}
+
+func (e *microDepositGuessError) Error() string {
+	return e.msg
+}
+
 // ODFIAccount represents the depository account micro-deposts are debited from
 type ODFIAccount struct {
 	accountNumber string
@@ -249,8 +282,6 @@ func postMicroDepositTransactions(logger log.Logger, ODFIAccount *ODFIAccount, c
 func (r *DepositoryRouter) submitMicroDeposits(userID string, requestID string, amounts []Amount, sum int, dep *Depository) ([]microDeposit, error) {
 	odfiOriginator, odfiDepository := r.odfiAccount.metadata()
 
-	// TODO(adam): reject if user has been failed too much verifying this Depository -- w.WriteHeader(http.StatusConflict)
-
 	var microDeposits []microDeposit
 	for i := range amounts {
 		req := &transferRequest{
@@ -281,7 +312,7 @@ func (r *DepositoryRouter) submitMicroDeposits(userID string, requestID string,
 
 		// Build and submit the file (with micro-deposits) to moov's ACH service
 		idempotencyKey := base.ID()
-		file, err := constructACHFile(string(xfer.ID), idempotencyKey, userID, xfer, rec, dep, odfiOriginator, odfiDepository)
+		file, err := constructACHFile(string(xfer.ID), idempotencyKey, userID, xfer, rec, dep, odfiOriginator, odfiDepository, nil)
 		if err != nil {
 			err = fmt.Errorf("problem constructing ACH file for userID=%s: %v", userID, err)
 			r.logger.Log("microDeposits", err, "requestID", requestID, "userID", userID)
@@ -302,8 +333,20 @@ func (r *DepositoryRouter) submitMicroDeposits(userID string, requestID string,
 		}
 		r.logger.Log("microDeposits", fmt.Sprintf("created ACH file=%s depository=%s", xfer.ID, dep.ID), "requestID", requestID, "userID", userID)
 
-		// Store the Transfer creation as an event
-		if err := writeTransferEvent(userID, req, r.eventRepo); err != nil {
+		// Store the micro-deposit as an event
+		err = r.eventRepo.writeEvent(userID, &Event{
+			ID:      EventID(base.ID()),
+			Topic:   fmt.Sprintf("%s micro-deposit to depository %s", req.Type, dep.ID),
+			Message: req.Description,
+			Type:    MicroDepositEvent,
+			Payload: eventPayload(xfer),
+			Metadata: map[string]string{
+				eventDepositoryID: string(dep.ID),
+				eventTransferID:   string(xfer.ID),
+				eventFileID:       fileID,
+			},
+		})
+		if err != nil {
 			return nil, fmt.Errorf("userID=%s problem writing micro-deposit transfer event: %v", userID, err)
 		}
 
@@ -354,9 +397,17 @@ type confirmDepositoryRequest struct {
 	Amounts []string `json:"amounts"`
 }
 
+type confirmDepositoryResponse struct {
+	Error             string `json:"error,omitempty"`
+	RemainingAttempts int    `json:"remainingAttempts"`
+}
+
 // confirmMicroDeposits checks our database for a depository's micro deposits (used to validate the user owns the Depository)
 // and if successful changes the Depository status to DepositoryVerified.
 //
+// Each guess is recorded and after microDepositMaxAttempts failures the Depository is locked. Locked Depositories
+// (and expired micro-deposits, which return 410 Gone) need to be reset through the admin endpoint.
+//
 // TODO(adam): Should we allow a Depository to be confirmed before the micro-deposit ACH file is
 // upload? Technically there's really no way for an end-user to see them before posting, however
 // out demo and tests can lookup in Accounts right away and quickly verify the Depository.
@@ -383,6 +434,12 @@ func (r *DepositoryRouter) confirmMicroDeposits() http.HandlerFunc {
 			moovhttp.Problem(w, err)
 			return
 		}
+		if dep.Status == DepositoryLocked {
+			// 409 - Too many attempts
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(confirmDepositoryResponse{Error: errMicroDepositsLocked.Error()})
+			return
+		}
 		if dep.Status != DepositoryUnverified {
 			err = fmt.Errorf("depository %s in bogus status %s", dep.ID, dep.Status)
 			r.logger.Log("confirmMicroDeposits", err, "userID", userID)
@@ -390,8 +447,6 @@ func (r *DepositoryRouter) confirmMicroDeposits() http.HandlerFunc {
 			return
 		}
 
-		// TODO(adam): if we've failed too many times return '409 - Too many attempts'
-
 		// Read amounts from request JSON
 		var req confirmDepositoryRequest
 		rr := io.LimitReader(httpReq.Body, maxReadBytes)
@@ -415,26 +470,64 @@ func (r *DepositoryRouter) confirmMicroDeposits() http.HandlerFunc {
 			moovhttp.Problem(w, errors.New("invalid amounts, found none"))
 			return
 		}
-		if err := r.depositoryRepo.confirmMicroDeposits(id, userID, amounts); err != nil {
-			r.logger.Log("confirmMicroDeposits", fmt.Sprintf("problem confirming micro-deposits: %v", err), "userID", userID)
-			moovhttp.Problem(w, err)
-			return
-		}
 
-		// Update Depository status
-		if err := markDepositoryVerified(r.depositoryRepo, id, userID); err != nil {
-			r.logger.Log("confirmMicroDeposits", fmt.Sprintf("problem marking depository as Verified: %v", err), "userID", userID)
+		// The attempt is recorded and the Depository marked Verified (or Locked) along with checking amounts
+		failures, err := r.depositoryRepo.confirmMicroDeposits(id, userID, amounts)
+		if err != nil {
+			r.logger.Log("confirmMicroDeposits", fmt.Sprintf("problem confirming micro-deposits: %v", err), "userID", userID)
+			r.failedMicroDepositAttempt(w, dep, userID, failures, err)
 			return
 		}
 
 		// 200 - Micro deposits verified
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("{}"))
+		json.NewEncoder(w).Encode(confirmDepositoryResponse{RemainingAttempts: remainingMicroDepositAttempts(failures)})
 	}
 }
 
+// failedMicroDepositAttempt writes the response for a confirmation which didn't verify the Depository.
+func (r *DepositoryRouter) failedMicroDepositAttempt(w http.ResponseWriter, dep *Depository, userID string, failures int, err error) {
+	switch err {
+	case errMicroDepositsLocked, errDepositoryNotUnverified:
+		// 409 - Too many attempts, or another request changed the Depository's status
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(confirmDepositoryResponse{Error: err.Error()})
+		return
+	case errMicroDepositsExpired:
+		// 410 - Micro-deposits need to be reset
+		w.WriteHeader(http.StatusGone)
+		json.NewEncoder(w).Encode(confirmDepositoryResponse{Error: err.Error(), RemainingAttempts: remainingMicroDepositAttempts(failures)})
+		return
+	}
+	if _, ok := err.(*microDepositGuessError); !ok {
+		moovhttp.Problem(w, err)
+		return
+	}
+
+	remaining := remainingMicroDepositAttempts(failures)
+	if remaining > 0 {
+		// 400 - Invalid Amounts
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(confirmDepositoryResponse{Error: err.Error(), RemainingAttempts: remaining})
+		return
+	}
+
+	r.logger.Log("confirmMicroDeposits", fmt.Sprintf("locked depository=%s after %d failed attempts", dep.ID, failures), "userID", userID)
+	// 409 - Too many attempts
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(confirmDepositoryResponse{Error: errMicroDepositsLocked.Error()})
+}
+
+func remainingMicroDepositAttempts(failures int) int {
+	if n := microDepositMaxAttempts - failures; n > 0 {
+		return n
+	}
+	return 0
+}
+
 func AddMicroDepositAdminRoutes(logger log.Logger, svc *admin.Server, depRepo DepositoryRepository) {
 	svc.AddHandler("/depositories/{depositoryId}/micro-deposits", getMicroDeposits(logger, depRepo))
+	svc.AddHandler("/depositories/{depositoryId}/micro-deposits/reset", resetMicroDeposits(logger, depRepo))
 }
 
 // getMicroDeposits is an http.HandlerFunc for paygate's admin server to return micro-deposits for a given Depository
@@ -472,10 +565,42 @@ func getMicroDeposits(logger log.Logger, depositoryRepo DepositoryRepository) ht
 	}
 }
 
+// resetMicroDeposits is an http.HandlerFunc for paygate's admin server to remove a Depository's micro-deposits and failed
+// attempts. Locked Depositories are unlocked so a fresh round of micro-deposits can be initiated.
+func resetMicroDeposits(logger log.Logger, depositoryRepo DepositoryRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w = wrap(logger, w, r)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		if r.Method != "POST" {
+			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb: %s", r.Method))
+			return
+		}
+
+		id, requestID := getDepositoryID(r), moovhttp.GetRequestID(r)
+		if id == "" {
+			// 404 - A depository with the specified ID was not found.
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "depository not found"}`))
+			return
+		}
+
+		if err := depositoryRepo.resetMicroDeposits(id); err != nil {
+			logger.Log("microDeposits", fmt.Sprintf("admin: problem resetting micro-deposits for depository=%s: %v", id, err), "requestID", requestID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		logger.Log("microDeposits", fmt.Sprintf("admin: reset micro-deposits for depository=%s", id), "requestID", requestID)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("{}"))
+	}
+}
+
 // getMicroDeposits will retrieve the micro deposits for a given depository. This endpoint is designed for paygate's admin endpoints.
 // If an amount does not parse it will be discardded silently.
 func (r *SQLDepositoryRepo) getMicroDeposits(id DepositoryID) ([]microDeposit, error) {
-	query := `select amount, file_id from micro_deposits where depository_id = ?`
+	query := `select amount, file_id from micro_deposits where depository_id = ? and deleted_at is null`
 	stmt, err := r.db.Prepare(query)
 	if err != nil {
 		return nil, err
@@ -563,18 +688,113 @@ func (r *SQLDepositoryRepo) initiateMicroDeposits(id DepositoryID, userID string
 
 // confirmMicroDeposits will compare the provided guessAmounts against what's been persisted for a user. If the amounts do not match
 // or there are a mismatched amount the call will return a non-nil error.
-func (r *SQLDepositoryRepo) confirmMicroDeposits(id DepositoryID, userID string, guessAmounts []Amount) error {
-	microDeposits, err := r.getMicroDepositsForUser(id, userID)
+//
+// Everything happens in one transaction: the Depository's status is locked with a conditional update, the attempt is recorded
+// before amounts are compared and the Depository is marked Verified (or Locked once it's out of attempts). The returned count
+// is of failed attempts, including this one when the amounts don't match.
+func (r *SQLDepositoryRepo) confirmMicroDeposits(id DepositoryID, userID string, guessAmounts []Amount) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	failures, err := confirmMicroDepositsTx(tx, id, userID, guessAmounts, time.Now())
+	if _, ok := err.(*microDepositGuessError); ok || err == nil || err == errMicroDepositsLocked {
+		// failed attempts (and locking the Depository) are kept
+		if commitErr := tx.Commit(); commitErr != nil {
+			return failures, commitErr
+		}
+		return failures, err
+	}
+	if rollbackErr := tx.Rollback(); rollbackErr != nil {
+		r.logger.Log("confirmMicroDeposits", fmt.Sprintf("problem rolling back depository=%s: %v", id, rollbackErr), "userID", userID)
+	}
+	return failures, err
+}
+
+func confirmMicroDepositsTx(tx *sql.Tx, id DepositoryID, userID string, guessAmounts []Amount, now time.Time) (int, error) {
+	// Touch the Depository first so concurrent confirmations wait on its row, then read the status we hold
+	query := `update depositories set last_updated_at = ? where depository_id = ? and user_id = ? and status = ? and deleted_at is null`
+	if _, err := tx.Exec(query, now, id, userID, DepositoryUnverified); err != nil {
+		return 0, fmt.Errorf("unable to confirm micro deposits, got error=%v", err)
+	}
+	var status DepositoryStatus
+	query = `select status from depositories where depository_id = ? and user_id = ? and deleted_at is null limit 1`
+	if err := tx.QueryRow(query, id, userID).Scan(&status); err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("unable to confirm micro deposits, got error=%v", err)
+	}
+	if status == DepositoryLocked {
+		return microDepositMaxAttempts, errMicroDepositsLocked
+	}
+	if status != DepositoryUnverified {
+		return 0, errDepositoryNotUnverified
+	}
+
+	var failures int
+	query = `select count(*) from micro_deposit_attempts where depository_id = ? and user_id = ? and deleted_at is null`
+	if err := tx.QueryRow(query, id, userID).Scan(&failures); err != nil {
+		return 0, fmt.Errorf("unable to confirm micro deposits, got error=%v", err)
+	}
+	if failures >= microDepositMaxAttempts {
+		if err := lockDepositoryTx(tx, id, now); err != nil {
+			return failures, err
+		}
+		return failures, errMicroDepositsLocked
+	}
+
+	query = `select amount, file_id from micro_deposits where user_id = ? and depository_id = ? and deleted_at is null`
+	rows, err := tx.Query(query, userID, id)
 	if err != nil {
-		return fmt.Errorf("unable to confirm micro deposits, got error=%v", err)
+		return failures, fmt.Errorf("unable to confirm micro deposits, got error=%v", err)
+	}
+	microDeposits, err := accumulateMicroDeposits(rows)
+	rows.Close()
+	if err != nil {
+		return failures, fmt.Errorf("unable to confirm micro deposits, got error=%v", err)
 	}
 	if len(microDeposits) == 0 {
-		return errors.New("unable to confirm micro deposits, got 0 micro deposits")
+		return failures, errors.New("unable to confirm micro deposits, got 0 micro deposits")
+	}
+
+	var expired int
+	query = `select count(*) from micro_deposits where user_id = ? and depository_id = ? and created_at < ? and deleted_at is null`
+	if err := tx.QueryRow(query, userID, id, now.Add(-1*microDepositExpiration)).Scan(&expired); err != nil {
+		return failures, fmt.Errorf("unable to confirm micro deposits, got error=%v", err)
+	}
+	if expired > 0 {
+		return failures, errMicroDepositsExpired
 	}
 
+	// Record the attempt before checking amounts
+	query = `insert into micro_deposit_attempts (depository_id, user_id, created_at) values (?, ?, ?)`
+	if _, err := tx.Exec(query, id, userID, now); err != nil {
+		return failures, fmt.Errorf("unable to record micro deposit attempt, got error=%v", err)
+	}
+	failures++
+
+	if err := compareMicroDeposits(microDeposits, guessAmounts); err != nil {
+		if failures >= microDepositMaxAttempts {
+			if err := lockDepositoryTx(tx, id, now); err != nil {
+				return failures, err
+			}
+		}
+		return failures, err
+	}
+
+	failures-- // this attempt matched
+
+	query = `update depositories set status = ?, last_updated_at = ? where depository_id = ? and user_id = ? and status = ? and deleted_at is null`
+	if _, err := tx.Exec(query, DepositoryVerified, now, id, userID, DepositoryUnverified); err != nil {
+		return failures, fmt.Errorf("unable to mark depository as verified, got error=%v", err)
+	}
+	return failures, nil
+}
+
+// compareMicroDeposits returns a microDepositGuessError unless guessAmounts match every micro-deposit.
+func compareMicroDeposits(microDeposits []microDeposit, guessAmounts []Amount) error {
 	// Check amounts, all must match
 	if len(guessAmounts) != len(microDeposits) || len(guessAmounts) == 0 {
-		return fmt.Errorf("incorrect amount of guesses, got %d", len(guessAmounts)) // don't share len(microDeposits), that's an info leak
+		// don't share len(microDeposits), that's an info leak
+		return &microDepositGuessError{fmt.Sprintf("incorrect amount of guesses, got %d", len(guessAmounts))}
 	}
 
 	found := 0
@@ -588,12 +808,97 @@ func (r *SQLDepositoryRepo) confirmMicroDeposits(id DepositoryID, userID string,
 	}
 
 	if found != len(microDeposits) {
-		return errors.New("incorrect micro deposit guesses")
+		return &microDepositGuessError{"incorrect micro deposit guesses"}
 	}
 
 	return nil
 }
 
+func lockDepositoryTx(tx *sql.Tx, id DepositoryID, now time.Time) error {
+	query := `update depositories set status = ?, last_updated_at = ? where depository_id = ? and status = ? and deleted_at is null`
+	if _, err := tx.Exec(query, DepositoryLocked, now, id, DepositoryUnverified); err != nil {
+		return fmt.Errorf("unable to lock depository, got error=%v", err)
+	}
+	return nil
+}
+
+// getMicroDepositAttempts returns the count of confirmation attempts since micro-deposits were last reset.
+func (r *SQLDepositoryRepo) getMicroDepositAttempts(id DepositoryID, userID string) (int, error) {
+	query := `select count(*) from micro_deposit_attempts where depository_id = ? and user_id = ? and deleted_at is null`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var n int
+	if err := stmt.QueryRow(id, userID).Scan(&n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (r *SQLDepositoryRepo) resetMicroDeposits(id DepositoryID) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	queries := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`update micro_deposits set deleted_at = ? where depository_id = ? and deleted_at is null`, []interface{}{now, id}},
+		{`update micro_deposit_attempts set deleted_at = ? where depository_id = ? and deleted_at is null`, []interface{}{now, id}},
+		{`update depositories set status = ?, last_updated_at = ? where depository_id = ? and status = ? and deleted_at is null`, []interface{}{DepositoryUnverified, now, id, DepositoryLocked}},
+	}
+	for i := range queries {
+		stmt, err := tx.Prepare(queries[i].query)
+		if err != nil {
+			return fmt.Errorf("resetMicroDeposits: prepare error=%v rollback=%v", err, tx.Rollback())
+		}
+		_, err = stmt.Exec(queries[i].args...)
+		stmt.Close()
+		if err != nil {
+			return fmt.Errorf("resetMicroDeposits: exec error=%v rollback=%v", err, tx.Rollback())
+		}
+	}
+	return tx.Commit()
+}
+
+// getMergedMicroDeposits returns the micro-deposits which were merged into filename and haven't been removed.
+func (r *SQLDepositoryRepo) getMergedMicroDeposits(filename string) ([]uploadableMicroDeposit, error) {
+	query := `select depository_id, user_id, amount, file_id, created_at from micro_deposits where merged_filename = ? and deleted_at is null order by created_at asc`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var microDeposits []uploadableMicroDeposit
+	for rows.Next() {
+		var m uploadableMicroDeposit
+		var value string
+		if err := rows.Scan(&m.depositoryID, &m.userID, &value, &m.fileID, &m.createdAt); err != nil {
+			return nil, fmt.Errorf("getMergedMicroDeposits: scan: %v", err)
+		}
+		var amt Amount
+		if err := amt.FromString(value); err != nil {
+			return nil, fmt.Errorf("getMergedMicroDeposits: amount: %v", err)
+		}
+		m.amount = &amt
+		microDeposits = append(microDeposits, m)
+	}
+	return microDeposits, rows.Err()
+}
+
 // getMicroDepositCursor returns a microDepositCursor for iterating through micro-deposits in ascending order (by CreatedAt)
 // beginning at the start of the current day.
 func (r *SQLDepositoryRepo) getMicroDepositCursor(batchSize int) *microDepositCursor {
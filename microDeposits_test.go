@@ -291,15 +291,22 @@ func TestMicroDeposits__confirmMicroDeposits(t *testing.T) {
 				depositoryID := DepositoryID(base.ID())
 				userID := base.ID()
 
+				dep := &Depository{ID: depositoryID, RoutingNumber: "121042882", AccountNumber: "151", Status: DepositoryUnverified}
+				if err := db.upsertUserDepository(userID, dep); err != nil {
+					t.Fatal(err)
+				}
 				if err := db.initiateMicroDeposits(depositoryID, userID, tc.state.microDeposits); err != nil {
 					t.Fatal(err)
 				}
 
-				err := db.confirmMicroDeposits(depositoryID, userID, tc.state.guesses)
+				_, err := db.confirmMicroDeposits(depositoryID, userID, tc.state.guesses)
 				if tc.expectedErrMessage == "" {
 					if err != nil {
 						t.Errorf("nil was the expected result, got '%s' instead", err)
 					}
+					if dep, _ := db.getUserDepository(depositoryID, userID); dep == nil || dep.Status != DepositoryVerified {
+						t.Errorf("expected a verified depository: %#v", dep)
+					}
 				} else {
 					if err == nil {
 						t.Error("expected an error message, got nil instead")
@@ -355,7 +362,7 @@ func TestMicroDeposits__initiateError(t *testing.T) {
 		depositoryRepo: depRepo,
 	}
 	r := mux.NewRouter()
-	router.RegisterRoutes(r, true) // disable Accounts service calls
+	router.RegisterRoutes(r)
 
 	w := httptest.NewRecorder()
 	req := httptest.NewRequest("POST", fmt.Sprintf("/depositories/%s/micro-deposits", id), nil)
@@ -376,7 +383,7 @@ func TestMicroDeposits__confirmError(t *testing.T) {
 		depositoryRepo: depRepo,
 	}
 	r := mux.NewRouter()
-	router.RegisterRoutes(r, true) // disable Accounts service calls
+	router.RegisterRoutes(r)
 
 	var buf bytes.Buffer
 	err := json.NewEncoder(&buf).Encode(confirmDepositoryRequest{
@@ -397,6 +404,195 @@ func TestMicroDeposits__confirmError(t *testing.T) {
 	}
 }
 
+func TestMicroDeposits__confirmAttempts(t *testing.T) {
+	id, userID := DepositoryID(base.ID()), base.ID()
+	depRepo := &mockDepositoryRepository{
+		depositories: []*Depository{{ID: id, Status: DepositoryUnverified}},
+		confirmErr:   &microDepositGuessError{"incorrect micro deposit guesses"},
+		attempts:     microDepositMaxAttempts - 2,
+	}
+	router := &DepositoryRouter{
+		logger:         log.NewNopLogger(),
+		depositoryRepo: depRepo,
+	}
+	r := mux.NewRouter()
+	router.RegisterRoutes(r)
+
+	confirm := func() (int, confirmDepositoryResponse) {
+		var buf bytes.Buffer
+		json.NewEncoder(&buf).Encode(confirmDepositoryRequest{Amounts: []string{"USD 0.11", "USD 0.12"}})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", fmt.Sprintf("/depositories/%s/micro-deposits/confirm", id), &buf)
+		req.Header.Set("x-user-id", userID)
+		r.ServeHTTP(w, req)
+		w.Flush()
+
+		var resp confirmDepositoryResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, resp
+	}
+
+	code, resp := confirm()
+	if code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status %d", code)
+	}
+	if resp.RemainingAttempts != 1 || resp.Error != "incorrect micro deposit guesses" {
+		t.Errorf("unexpected response: %#v", resp)
+	}
+
+	// out of attempts, so the Depository is locked
+	code, resp = confirm()
+	if code != http.StatusConflict {
+		t.Errorf("bogus HTTP status %d", code)
+	}
+	if resp.RemainingAttempts != 0 || depRepo.status != DepositoryLocked {
+		t.Errorf("status=%s response=%#v", depRepo.status, resp)
+	}
+
+	// locked Depositories can't be confirmed
+	depRepo.depositories[0].Status = DepositoryLocked
+	depRepo.confirmErr = nil
+	if code, _ := confirm(); code != http.StatusConflict {
+		t.Errorf("bogus HTTP status %d", code)
+	}
+
+	// other errors aren't counted as attempts, expired micro-deposits need a reset
+	depRepo.depositories[0].Status = DepositoryUnverified
+	depRepo.confirmErr, depRepo.attempts = errMicroDepositsExpired, 0
+	if code, resp := confirm(); code != http.StatusGone || resp.Error != errMicroDepositsExpired.Error() {
+		t.Errorf("bogus HTTP status %d: %#v", code, resp)
+	}
+	if depRepo.attempts != 0 {
+		t.Errorf("got %d attempts", depRepo.attempts)
+	}
+}
+
+func TestMicroDeposits__attemptsAndReset(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *SQLDepositoryRepo) {
+		id, userID := DepositoryID(base.ID()), base.ID()
+		dep := &Depository{
+			ID:            id,
+			BankName:      "bank name",
+			Holder:        "holder",
+			HolderType:    Individual,
+			Type:          Checking,
+			RoutingNumber: "121042882",
+			AccountNumber: "151",
+			Status:        DepositoryUnverified,
+			Created:       base.NewTime(time.Now()),
+		}
+		if err := repo.upsertUserDepository(userID, dep); err != nil {
+			t.Fatal(err)
+		}
+		amt, _ := NewAmount("USD", "0.11")
+		if err := repo.initiateMicroDeposits(id, userID, []microDeposit{{amount: *amt, fileID: base.ID()}}); err != nil {
+			t.Fatal(err)
+		}
+
+		wrong, _ := NewAmount("USD", "0.12")
+		for i := 1; i <= microDepositMaxAttempts; i++ {
+			n, err := repo.confirmMicroDeposits(id, userID, []Amount{*wrong})
+			if _, ok := err.(*microDepositGuessError); n != i || !ok {
+				t.Fatalf("n=%d error=%v", n, err)
+			}
+		}
+		if n, err := repo.getMicroDepositAttempts(id, base.ID()); n != 0 || err != nil {
+			t.Errorf("other user: n=%d error=%v", n, err)
+		}
+
+		// out of attempts, so even correct amounts are rejected
+		if dep, err := repo.getUserDepository(id, userID); err != nil || dep.Status != DepositoryLocked {
+			t.Errorf("depository=%#v error=%v", dep, err)
+		}
+		if n, err := repo.confirmMicroDeposits(id, userID, []Amount{*amt}); n != microDepositMaxAttempts || err != errMicroDepositsLocked {
+			t.Errorf("n=%d error=%v", n, err)
+		}
+		if n, err := repo.getMicroDepositAttempts(id, userID); n != microDepositMaxAttempts || err != nil {
+			t.Errorf("n=%d error=%v", n, err)
+		}
+
+		if err := repo.resetMicroDeposits(id); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := repo.getMicroDepositAttempts(id, userID); n != 0 || err != nil {
+			t.Errorf("n=%d error=%v", n, err)
+		}
+		if mds, err := repo.getMicroDepositsForUser(id, userID); len(mds) != 0 || err != nil {
+			t.Errorf("micro-deposits=%#v error=%v", mds, err)
+		}
+		dep, err := repo.getUserDepository(id, userID)
+		if err != nil || dep.Status != DepositoryUnverified {
+			t.Errorf("depository=%#v error=%v", dep, err)
+		}
+
+		// a fresh round can be initiated
+		if err := repo.initiateMicroDeposits(id, userID, []microDeposit{{amount: *amt, fileID: base.ID()}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// SQLite tests
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &SQLDepositoryRepo{sqliteDB.DB, log.NewNopLogger()})
+
+	// MySQL tests
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &SQLDepositoryRepo{mysqlDB.DB, log.NewNopLogger()})
+}
+
+func TestMicroDeposits__expired(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	repo := &SQLDepositoryRepo{db.DB, log.NewNopLogger()}
+
+	id, userID := DepositoryID(base.ID()), base.ID()
+	if err := repo.upsertUserDepository(userID, &Depository{ID: id, Status: DepositoryUnverified}); err != nil {
+		t.Fatal(err)
+	}
+	query := `insert into micro_deposits (depository_id, user_id, amount, file_id, created_at) values (?, ?, ?, ?, ?)`
+	if _, err := db.DB.Exec(query, id, userID, "USD 0.11", base.ID(), time.Now().Add(-1*microDepositExpiration-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	amt, _ := NewAmount("USD", "0.11")
+	if _, err := repo.confirmMicroDeposits(id, userID, []Amount{*amt}); err != errMicroDepositsExpired {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestMicroDeposits__AdminReset(t *testing.T) {
+	depRepo := &mockDepositoryRepository{attempts: 5, status: DepositoryLocked}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/depositories/{depositoryId}/micro-deposits/reset", resetMicroDeposits(log.NewNopLogger(), depRepo))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/depositories/foo/micro-deposits/reset", nil))
+	w.Flush()
+	if w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status %d: %s", w.Code, w.Body.String())
+	}
+	if depRepo.attempts != 0 || depRepo.status != DepositoryUnverified {
+		t.Errorf("attempts=%d status=%s", depRepo.attempts, depRepo.status)
+	}
+
+	// error
+	depRepo.err = errors.New("bad error")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/depositories/foo/micro-deposits/reset", nil))
+	w.Flush()
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status %d: %s", w.Code, w.Body.String())
+	}
+}
+
 func TestMicroDeposits__routes(t *testing.T) {
 	t.Parallel()
 
@@ -404,7 +600,7 @@ func TestMicroDeposits__routes(t *testing.T) {
 		id, userID := DepositoryID(base.ID()), base.ID()
 
 		depRepo := &SQLDepositoryRepo{db, log.NewNopLogger()}
-		eventRepo := &SQLEventRepo{db, log.NewNopLogger()}
+		eventRepo := &SQLEventRepo{db, log.NewNopLogger(), nil}
 
 		// Write depository
 		dep := &Depository{
@@ -450,7 +646,7 @@ func TestMicroDeposits__routes(t *testing.T) {
 			eventRepo:      eventRepo,
 		}
 		r := mux.NewRouter()
-		router.RegisterRoutes(r, false)
+		router.RegisterRoutes(r)
 
 		// Set ACH_ENDPOINT to override the achclient.New call
 		os.Setenv("ACH_ENDPOINT", server.URL)
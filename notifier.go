@@ -0,0 +1,84 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Notifier sends messages to people outside of paygate, i.e. Receivers verifying their email address.
+type Notifier interface {
+	// Send delivers a message to the given email address.
+	Send(to string, subject string, body string) error
+}
+
+// NewNotifier returns an SMTP Notifier when SMTP_ADDRESS is set, otherwise messages are only logged.
+func NewNotifier(logger log.Logger) (Notifier, error) {
+	addr := os.Getenv("SMTP_ADDRESS")
+	if addr == "" {
+		logger.Log("notifier", "SMTP_ADDRESS not set, notifications will only be logged")
+		return &logNotifier{logger: logger}, nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP_ADDRESS %q: %v", addr, err)
+	}
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		return nil, errors.New("SMTP_FROM is required with SMTP_ADDRESS")
+	}
+	n := &smtpNotifier{
+		addr: addr,
+		from: from,
+	}
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		n.auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	logger.Log("notifier", fmt.Sprintf("sending notifications through SMTP server %s", addr))
+	return n, nil
+}
+
+// logNotifier is a Notifier for development and testing which writes messages to the log instead of delivering them.
+type logNotifier struct {
+	logger log.Logger
+}
+
+func (n *logNotifier) Send(to string, subject string, body string) error {
+	n.logger.Log("notifier", fmt.Sprintf("to=%s subject=%q body=%q", to, subject, body))
+	return nil
+}
+
+type smtpNotifier struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func (n *smtpNotifier) Send(to string, subject string, body string) error {
+	return smtp.SendMail(n.addr, n.auth, n.from, []string{to}, formatEmail(n.from, to, subject, body, time.Now()))
+}
+
+// formatEmail returns an RFC 5322 plain text message.
+func formatEmail(from, to, subject, body string, date time.Time) []byte {
+	clean := strings.NewReplacer("\r", "", "\n", "") // prevent header injection
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", clean.Replace(from))
+	fmt.Fprintf(&buf, "To: %s\r\n", clean.Replace(to))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", clean.Replace(subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	buf.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	return buf.Bytes()
+}
@@ -0,0 +1,76 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// testNotifier records the last message sent
+type testNotifier struct {
+	to, subject, body string
+	err               error
+}
+
+func (n *testNotifier) Send(to string, subject string, body string) error {
+	n.to, n.subject, n.body = to, subject, body
+	return n.err
+}
+
+func TestNotifier__new(t *testing.T) {
+	logger := log.NewNopLogger()
+
+	n, err := NewNotifier(logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := n.(*logNotifier); !ok {
+		t.Errorf("unexpected notifier: %T", n)
+	}
+	if err := n.Send("john@example.com", "subject", "body"); err != nil {
+		t.Error(err)
+	}
+
+	os.Setenv("SMTP_ADDRESS", "localhost:25")
+	defer os.Unsetenv("SMTP_ADDRESS")
+	if _, err := NewNotifier(logger); err == nil {
+		t.Error("expected error without SMTP_FROM")
+	}
+
+	os.Setenv("SMTP_FROM", "paygate@example.com")
+	defer os.Unsetenv("SMTP_FROM")
+	n, err = NewNotifier(logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sn, ok := n.(*smtpNotifier); !ok || sn.addr != "localhost:25" || sn.auth != nil {
+		t.Errorf("unexpected notifier: %#v", n)
+	}
+
+	os.Setenv("SMTP_ADDRESS", "localhost")
+	if _, err := NewNotifier(logger); err == nil {
+		t.Error("expected error without port")
+	}
+}
+
+func TestNotifier__formatEmail(t *testing.T) {
+	date := time.Date(2019, time.October, 1, 12, 0, 0, 0, time.UTC)
+	msg := string(formatEmail("paygate@example.com", "john@example.com\r\nBcc: jane@example.com", "Verify", "line one\nline two", date))
+
+	if !strings.Contains(msg, "To: john@example.comBcc: jane@example.com\r\n") {
+		t.Errorf("header wasn't cleaned: %s", msg)
+	}
+	if !strings.Contains(msg, "Date: Tue, 01 Oct 2019 12:00:00 +0000\r\n") {
+		t.Errorf("unexpected message: %s", msg)
+	}
+	if !strings.HasSuffix(msg, "\r\n\r\nline one\r\nline two") {
+		t.Errorf("unexpected message: %s", msg)
+	}
+}
@@ -46,6 +46,9 @@ type OFACClient interface {
 	GetCustomer(ctx context.Context, id string) (*ofac.OfacCustomer, error)
 
 	Search(ctx context.Context, name string, requestID string) (*ofac.Sdn, error)
+
+	// SearchAddress returns the top OFAC match for a physical address, or nil if nothing was found
+	SearchAddress(ctx context.Context, address, city, state, zip, country string, requestID string) (*ofac.Address, error)
 }
 
 type moovOFACClient struct {
@@ -132,6 +135,29 @@ func (c *moovOFACClient) Search(ctx context.Context, name string, requestID stri
 	return nil, nil // no OFAC results found, so cust not blocked
 }
 
+func (c *moovOFACClient) SearchAddress(ctx context.Context, address, city, state, zip, country string, requestID string) (*ofac.Address, error) {
+	search, resp, err := c.underlying.OFACApi.Search(ctx, &ofac.SearchOpts{
+		Address:    optional.NewString(address),
+		City:       optional.NewString(city),
+		State:      optional.NewString(state),
+		Zip:        optional.NewString(zip),
+		Country:    optional.NewString(country),
+		Limit:      optional.NewInt32(1),
+		XRequestID: optional.NewString(requestID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ofac.SearchAddress: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("ofac.SearchAddress: address=%q (status code: %d): %v", address, resp.StatusCode, err)
+	}
+	if len(search.Addresses) > 0 {
+		return &search.Addresses[0], nil
+	}
+	return nil, nil // no OFAC results found
+}
+
 // NewOFACClient returns an OFACClient instance and will default to using the OFAC address in
 // moov's standard Kubernetes setup.
 //
@@ -157,20 +183,31 @@ func NewOFACClient(logger log.Logger, endpoint string, httpClient *http.Client)
 	}
 }
 
-// rejectViaOFACMatch shares logic for handling the response from searchOFAC
-func rejectViaOFACMatch(logger log.Logger, api OFACClient, name string, userId string, requestID string) error {
+// rejectViaOFACMatch shares logic for handling the response from searchOFAC. Blocked matches are recorded
+// for review in reviewRepo (if non-nil) and matches a compliance officer has cleared aren't blocked.
+func rejectViaOFACMatch(logger log.Logger, api OFACClient, reviewRepo ofacReviewRepository, name string, userId string, requestID string) error {
 	sdn, status, err := searchOFAC(api, name, requestID)
 	if err != nil {
 		if sdn == nil {
 			return fmt.Errorf("ofac: blocking %q due to OFAC error: %v", name, err)
 		}
-		return fmt.Errorf("ofac: blocking SDN=%s due to OFAC error: %v", sdn.EntityID, err)
-	}
-	if strings.EqualFold(status, "unsafe") {
-		return fmt.Errorf("ofac: blocking due to OFAC status=%s SDN=%#v", status, sdn)
+		err = fmt.Errorf("ofac: blocking SDN=%s due to OFAC error: %v", sdn.EntityID, err)
+		if strings.EqualFold(status, "unsafe") {
+			_, hit, reviewErr := reviewOFACMatch(reviewRepo, sdn, status, name, userId, requestID)
+			return withOFACHit(logger, err, hit, reviewErr, userId, requestID)
+		}
+		return err
 	}
 	if sdn != nil && sdn.Match > OFACMatchThreshold {
-		return fmt.Errorf("ofac: blocking due to OFAC match=%.2f EntityID=%s", sdn.Match, sdn.EntityID)
+		cleared, hit, reviewErr := reviewOFACMatch(reviewRepo, sdn, status, name, userId, requestID)
+		if cleared {
+			if logger != nil {
+				logger.Log("customers", fmt.Sprintf("ofac: %s matched SDN %s (%.2f) but was cleared", name, sdn.EntityID, sdn.Match), "userId", userId, "requestID", requestID)
+			}
+			return nil
+		}
+		err := fmt.Errorf("ofac: blocking due to OFAC match=%.2f EntityID=%s", sdn.Match, sdn.EntityID)
+		return withOFACHit(logger, err, hit, reviewErr, userId, requestID)
 	}
 
 	if logger != nil {
@@ -183,6 +220,18 @@ func rejectViaOFACMatch(logger log.Logger, api OFACClient, name string, userId s
 	return nil
 }
 
+// withOFACHit adds the review queue's OFACHit ID to err. The original error is always returned, even if the hit
+// couldn't be recorded.
+func withOFACHit(logger log.Logger, err error, hit *OFACHit, reviewErr error, userId string, requestID string) error {
+	if reviewErr != nil && logger != nil {
+		logger.Log("customers", fmt.Sprintf("ofac: %v", reviewErr), "userId", userId, "requestID", requestID)
+	}
+	if hit == nil {
+		return err
+	}
+	return fmt.Errorf("%v (review hit=%s)", err, hit.ID)
+}
+
 // searchOFAC will attempt a search for the SDN metadata in OFAC and return a result. Any results are
 // returned with their match percent and callers MUST verify to reject or block from making transactions.
 //
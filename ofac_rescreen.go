@@ -0,0 +1,558 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moov-io/base"
+	"github.com/moov-io/base/admin"
+	moovhttp "github.com/moov-io/base/http"
+	ofac "github.com/moov-io/ofac/client"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// ofacRescreenInterval is how often existing Receivers, Originators and Depository holders are screened again.
+	// OFAC_RESCREEN_INTERVAL=off disables rescreening.
+	ofacRescreenInterval = func() time.Duration {
+		v := os.Getenv("OFAC_RESCREEN_INTERVAL")
+		if strings.EqualFold(v, "off") {
+			return 0
+		}
+		if dur, err := time.ParseDuration(v); err == nil && dur > 0 {
+			return dur
+		}
+		return 24 * time.Hour
+	}()
+
+	ofacRescreenMatches = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Name: "ofac_rescreen_matches",
+		Help: "Counter of existing Receivers, Originators and Depositories suspended after matching OFAC",
+	}, []string{"kind"})
+)
+
+// OFACRescreenKind is the type of object screened.
+type OFACRescreenKind string
+
+const (
+	OFACRescreenReceiver   OFACRescreenKind = "receiver"
+	OFACRescreenOriginator OFACRescreenKind = "originator"
+	OFACRescreenDepository OFACRescreenKind = "depository"
+)
+
+// eventKey is the Event metadata key which references this kind of object (e.g. "receiverId").
+func (k OFACRescreenKind) eventKey() string {
+	return string(k) + "Id"
+}
+
+// OFACRescreen is one run of screening every active Receiver, Originator and Depository holder.
+type OFACRescreen struct {
+	ID       string    `json:"id"`
+	Started  base.Time `json:"started"`
+	Finished base.Time `json:"finished"`
+
+	// Screened is how many names were searched
+	Screened int `json:"screened"`
+	// Matches is how many objects were over OFACMatchThreshold (or marked unsafe) and suspended
+	Matches int `json:"matches"`
+	// Errors is how many names couldn't be screened, or matches which couldn't be suspended
+	Errors int `json:"errors"`
+
+	// Results contains each match and error, names which didn't match aren't included
+	Results []*OFACRescreenResult `json:"results"`
+}
+
+// OFACRescreenResult is a name which matched OFAC, or failed to be screened, during an OFACRescreen.
+type OFACRescreenResult struct {
+	Kind     OFACRescreenKind `json:"kind"`
+	ObjectID string           `json:"objectId"`
+	UserID   string           `json:"userId"`
+	Name     string           `json:"name"`
+
+	EntityID string  `json:"entityId,omitempty"`
+	Match    float32 `json:"match,omitempty"`
+
+	// PreviousStatus is the object's status before it was suspended
+	PreviousStatus string `json:"previousStatus,omitempty"`
+
+	// FailedTransfers are Pending Transfers which were failed because of the match
+	FailedTransfers []TransferID `json:"failedTransfers,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// ofacRescreenTarget is an existing object to screen.
+type ofacRescreenTarget struct {
+	kind   OFACRescreenKind
+	id     string
+	userID string
+	name   string
+	status string
+}
+
+// OFACRescreener periodically screens existing Receivers, Originators and Depository holders against OFAC. The SDN list
+// changes daily, so objects which passed when created can match later. Matches are suspended (Depositories are rejected),
+// their pending Transfers are failed and events are written for the user. Matches are also recorded for review
+// and names a compliance officer has cleared aren't suspended.
+type OFACRescreener struct {
+	logger     log.Logger
+	ofacClient OFACClient
+
+	repo           ofacRescreenRepository
+	reviewRepo     ofacReviewRepository
+	receiverRepo   receiverRepository
+	depositoryRepo DepositoryRepository
+	originatorRepo originatorRepository
+	transferRepo   transferRepository
+	eventRepo      EventRepository
+
+	// accountsClient reverses the postings of Transfers failed by a match
+	accountsClient AccountsClient
+
+	mu sync.Mutex // only one rescreen runs at a time
+}
+
+func NewOFACRescreener(
+	logger log.Logger,
+	ofacClient OFACClient,
+	repo ofacRescreenRepository,
+	reviewRepo ofacReviewRepository,
+	receiverRepo receiverRepository,
+	depositoryRepo DepositoryRepository,
+	originatorRepo originatorRepository,
+	transferRepo transferRepository,
+	eventRepo EventRepository,
+	accountsClient AccountsClient,
+) *OFACRescreener {
+	return &OFACRescreener{
+		logger:         logger,
+		ofacClient:     ofacClient,
+		repo:           repo,
+		reviewRepo:     reviewRepo,
+		receiverRepo:   receiverRepo,
+		depositoryRepo: depositoryRepo,
+		originatorRepo: originatorRepo,
+		transferRepo:   transferRepo,
+		eventRepo:      eventRepo,
+		accountsClient: accountsClient,
+	}
+}
+
+// Start rescreens every OFAC_RESCREEN_INTERVAL until ctx is finished.
+func (s *OFACRescreener) Start(ctx context.Context) {
+	if ofacRescreenInterval == 0 {
+		s.logger.Log("ofac-rescreen", "disabling OFAC rescreening via config (OFAC_RESCREEN_INTERVAL)")
+		return
+	}
+	s.logger.Log("ofac-rescreen", fmt.Sprintf("rescreening against OFAC every %v", ofacRescreenInterval))
+
+	tick := time.NewTicker(ofacRescreenInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			if _, err := s.rescreen(); err != nil {
+				s.logger.Log("ofac-rescreen", fmt.Sprintf("ERROR: rescreening: %v", err))
+			}
+
+		case <-ctx.Done():
+			s.logger.Log("ofac-rescreen", "Shutting down due to context.Done()")
+			return
+		}
+	}
+}
+
+// rescreen searches OFAC for every active object and suspends any which match.
+func (s *OFACRescreener) rescreen() (*OFACRescreen, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run := &OFACRescreen{
+		ID:      base.ID(),
+		Started: base.NewTime(time.Now()),
+	}
+	targets, err := s.repo.getRescreenTargets()
+	if err != nil {
+		return nil, fmt.Errorf("problem reading objects to rescreen: %v", err)
+	}
+	for i := range targets {
+		target := targets[i]
+		run.Screened++
+
+		result := &OFACRescreenResult{
+			Kind:     target.kind,
+			ObjectID: target.id,
+			UserID:   target.userID,
+			Name:     target.name,
+		}
+		sdn, blocked, err := screenOFAC(s.ofacClient, s.reviewRepo, target.name, target.userID, run.ID)
+		if sdn != nil {
+			result.EntityID, result.Match = sdn.EntityID, sdn.Match
+		}
+		if err != nil {
+			run.Errors++
+			result.Error = err.Error()
+			run.Results = append(run.Results, result)
+			continue
+		}
+		if !blocked {
+			continue
+		}
+
+		run.Matches++
+		ofacRescreenMatches.With("kind", string(target.kind)).Add(1)
+		if err := s.suspend(target, result); err != nil {
+			run.Errors++
+			result.Error = err.Error()
+		}
+		run.Results = append(run.Results, result)
+	}
+	run.Finished = base.NewTime(time.Now())
+
+	s.logger.Log("ofac-rescreen", fmt.Sprintf("rescreen=%s screened=%d matches=%d errors=%d", run.ID, run.Screened, run.Matches, run.Errors))
+	return run, s.repo.saveRescreen(run)
+}
+
+// suspend blocks an object which matched OFAC from being used and fails its pending Transfers.
+func (s *OFACRescreener) suspend(target ofacRescreenTarget, result *OFACRescreenResult) error {
+	result.PreviousStatus = target.status
+	reason := fmt.Sprintf("OFAC rescreen matched SDN=%s (match=%.2f)", result.EntityID, result.Match)
+
+	var err error
+	switch target.kind {
+	case OFACRescreenReceiver:
+		var receiver *Receiver
+		receiver, err = s.receiverRepo.getUserReceiver(ReceiverID(target.id), target.userID)
+		if err != nil || receiver == nil {
+			return fmt.Errorf("receiver not found: %v", err)
+		}
+		if err := updateReceiverStatus(s.receiverRepo, s.eventRepo, receiver, target.userID, ReceiverSuspended, reason); err != nil {
+			return err
+		}
+		result.FailedTransfers, err = s.transferRepo.failPendingReceiverTransfers(receiver.ID, target.userID)
+
+	case OFACRescreenOriginator:
+		id := OriginatorID(target.id)
+		if err := s.originatorRepo.updateOriginatorStatus(id, target.userID, OriginatorSuspended); err != nil {
+			return err
+		}
+		if err := s.writeEvent(target, OriginatorEvent, string(OriginatorSuspended), reason); err != nil {
+			return err
+		}
+		result.FailedTransfers, err = s.transferRepo.failPendingOriginatorTransfers(id, target.userID)
+
+	case OFACRescreenDepository:
+		id := DepositoryID(target.id)
+		if err := s.depositoryRepo.updateDepositoryStatus(id, DepositoryRejected); err != nil {
+			return err
+		}
+		if err := s.writeEvent(target, DepositoryEvent, string(DepositoryRejected), reason); err != nil {
+			return err
+		}
+		result.FailedTransfers, err = s.transferRepo.failPendingDepositoryTransfers(id, target.userID)
+	}
+	if err != nil {
+		return fmt.Errorf("problem failing pending transfers: %v", err)
+	}
+	var reverseErr error
+	for i := range result.FailedTransfers {
+		// Release the funds held by the failed Transfer's posting
+		xfer, err := s.transferRepo.getTransfer(result.FailedTransfers[i])
+		if err == nil {
+			err = reverseTransferTransaction(s.accountsClient, s.transferRepo, "", xfer)
+		}
+		if err != nil {
+			reverseErr = fmt.Errorf("problem reversing transfer=%s: %v", result.FailedTransfers[i], err)
+			s.logger.Log("ofac-rescreen", reverseErr.Error(), "userID", target.userID)
+		}
+
+		err = s.eventRepo.writeEvent(target.userID, &Event{
+			ID:      EventID(base.ID()),
+			Topic:   fmt.Sprintf("transfer %s failed", result.FailedTransfers[i]),
+			Message: fmt.Sprintf("%s %s was suspended: %s", target.kind, target.id, reason),
+			Type:    TransferEvent,
+			Metadata: map[string]string{
+				eventTransferID:        string(result.FailedTransfers[i]),
+				target.kind.eventKey(): target.id,
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	s.logger.Log("ofac-rescreen", fmt.Sprintf("suspended %s=%s: %s failed transfers: %v", target.kind, target.id, reason, result.FailedTransfers), "userID", target.userID)
+	return reverseErr
+}
+
+func (s *OFACRescreener) writeEvent(target ofacRescreenTarget, eventType EventType, status string, reason string) error {
+	return s.eventRepo.writeEvent(target.userID, &Event{
+		ID:      EventID(base.ID()),
+		Topic:   fmt.Sprintf("%s %s %s", target.kind, target.id, status),
+		Message: fmt.Sprintf("status: %s -> %s reason: %s", target.status, status, reason),
+		Type:    eventType,
+		Metadata: map[string]string{
+			target.kind.eventKey(): target.id,
+		},
+	})
+}
+
+// screenOFAC searches OFAC for name and returns true if it should be blocked, either from an "unsafe" status
+// or a match over OFACMatchThreshold which hasn't been cleared. Blocked matches are recorded for review in reviewRepo.
+// Unlike rejectViaOFACMatch an error is only returned when OFAC or the review queue can't be read.
+func screenOFAC(api OFACClient, reviewRepo ofacReviewRepository, name string, userID string, requestID string) (*ofac.Sdn, bool, error) {
+	sdn, status, err := searchOFAC(api, name, requestID)
+	if err != nil && !strings.EqualFold(status, "unsafe") {
+		return sdn, false, err
+	}
+	if !strings.EqualFold(status, "unsafe") && (sdn == nil || sdn.Match <= OFACMatchThreshold) {
+		return sdn, false, nil
+	}
+	cleared, _, err := reviewOFACMatch(reviewRepo, sdn, status, name, userID, requestID)
+	if err != nil {
+		return sdn, false, err
+	}
+	return sdn, !cleared, nil
+}
+
+func AddOFACRescreenRoutes(logger log.Logger, svc *admin.Server, rescreener *OFACRescreener) {
+	svc.AddHandler("/ofac/rescreens", ofacRescreens(logger, rescreener))
+}
+
+// ofacRescreens is an http.HandlerFunc for paygate's admin server. GET lists recent rescreens with their results
+// (newest first, ?limit=N) and POST starts a rescreen, returning its results.
+func ofacRescreens(logger log.Logger, rescreener *OFACRescreener) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w = wrap(logger, w, r)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		requestID := moovhttp.GetRequestID(r)
+		switch r.Method {
+		case "GET":
+			limit := 10
+			if v := r.URL.Query().Get("limit"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n <= 0 {
+					moovhttp.Problem(w, fmt.Errorf("invalid limit: %q", v))
+					return
+				}
+				limit = n
+			}
+			runs, err := rescreener.repo.getRescreens(limit)
+			if err != nil {
+				logger.Log("ofac-rescreen", fmt.Sprintf("admin: problem reading rescreens: %v", err), "requestID", requestID)
+				moovhttp.Problem(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(runs)
+
+		case "POST":
+			run, err := rescreener.rescreen()
+			if err != nil {
+				logger.Log("ofac-rescreen", fmt.Sprintf("admin: problem rescreening: %v", err), "requestID", requestID)
+				moovhttp.Problem(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(run)
+
+		default:
+			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb: %s", r.Method))
+		}
+	}
+}
+
+type ofacRescreenRepository interface {
+	// getRescreenTargets returns every Receiver, Originator and Depository which hasn't been deleted, suspended or rejected.
+	getRescreenTargets() ([]ofacRescreenTarget, error)
+
+	saveRescreen(run *OFACRescreen) error
+	getRescreens(limit int) ([]*OFACRescreen, error)
+}
+
+func NewOFACRescreenRepo(logger log.Logger, db *sql.DB) *SQLOFACRescreenRepo {
+	return &SQLOFACRescreenRepo{log: logger, db: db}
+}
+
+type SQLOFACRescreenRepo struct {
+	db  *sql.DB
+	log log.Logger
+}
+
+func (r *SQLOFACRescreenRepo) Close() error {
+	return r.db.Close()
+}
+
+func (r *SQLOFACRescreenRepo) getRescreenTargets() ([]ofacRescreenTarget, error) {
+	queries := []struct {
+		kind  OFACRescreenKind
+		query string
+		args  []interface{}
+	}{
+		{
+			kind:  OFACRescreenReceiver,
+			query: `select receiver_id, user_id, metadata, status from receivers where deleted_at is null and status in (?, ?)`,
+			args:  []interface{}{ReceiverUnverified, ReceiverVerified},
+		},
+		{
+			kind:  OFACRescreenOriginator,
+			query: `select originator_id, user_id, metadata, coalesce(status, ?) from originators where deleted_at is null and (status is null or status <> ?)`,
+			args:  []interface{}{OriginatorActive, OriginatorSuspended},
+		},
+		{
+			kind:  OFACRescreenDepository,
+			query: `select depository_id, user_id, holder, status from depositories where deleted_at is null and status <> ?`,
+			args:  []interface{}{DepositoryRejected},
+		},
+	}
+	var targets []ofacRescreenTarget
+	for i := range queries {
+		stmt, err := r.db.Prepare(queries[i].query)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := stmt.Query(queries[i].args...)
+		if err != nil {
+			stmt.Close()
+			return nil, err
+		}
+		for rows.Next() {
+			target := ofacRescreenTarget{kind: queries[i].kind}
+			var name *string
+			if err := rows.Scan(&target.id, &target.userID, &name, &target.status); err != nil {
+				rows.Close()
+				stmt.Close()
+				return nil, err
+			}
+			if name != nil && strings.TrimSpace(*name) != "" {
+				target.name = *name
+				targets = append(targets, target)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		stmt.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return targets, nil
+}
+
+func (r *SQLOFACRescreenRepo) saveRescreen(run *OFACRescreen) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	query := `insert into ofac_rescreens (rescreen_id, screened, matches, errors, started_at, finished_at) values (?, ?, ?, ?, ?, ?)`
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("saveRescreen: prepare error=%v rollback=%v", err, tx.Rollback())
+	}
+	_, err = stmt.Exec(run.ID, run.Screened, run.Matches, run.Errors, run.Started.Time, run.Finished.Time)
+	stmt.Close()
+	if err != nil {
+		return fmt.Errorf("saveRescreen: exec error=%v rollback=%v", err, tx.Rollback())
+	}
+
+	query = `insert into ofac_rescreen_results (rescreen_id, kind, object_id, user_id, name, entity_id, sdn_match, previous_status, failed_transfers, error) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	stmt, err = tx.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("saveRescreen: prepare error=%v rollback=%v", err, tx.Rollback())
+	}
+	defer stmt.Close()
+	for _, res := range run.Results {
+		var failed []string
+		for i := range res.FailedTransfers {
+			failed = append(failed, string(res.FailedTransfers[i]))
+		}
+		_, err := stmt.Exec(run.ID, res.Kind, res.ObjectID, res.UserID, res.Name, res.EntityID, res.Match, res.PreviousStatus, strings.Join(failed, ","), res.Error)
+		if err != nil {
+			return fmt.Errorf("saveRescreen: %s=%s error=%v rollback=%v", res.Kind, res.ObjectID, err, tx.Rollback())
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *SQLOFACRescreenRepo) getRescreens(limit int) ([]*OFACRescreen, error) {
+	query := `select rescreen_id, screened, matches, errors, started_at, finished_at from ofac_rescreens order by started_at desc limit ?`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []*OFACRescreen
+	for rows.Next() {
+		run := &OFACRescreen{}
+		var started, finished time.Time
+		if err := rows.Scan(&run.ID, &run.Screened, &run.Matches, &run.Errors, &started, &finished); err != nil {
+			return nil, err
+		}
+		run.Started, run.Finished = base.NewTime(started), base.NewTime(finished)
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range runs {
+		if runs[i].Results, err = r.getRescreenResults(runs[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return runs, nil
+}
+
+func (r *SQLOFACRescreenRepo) getRescreenResults(id string) ([]*OFACRescreenResult, error) {
+	query := `select kind, object_id, user_id, name, entity_id, sdn_match, previous_status, failed_transfers, error from ofac_rescreen_results where rescreen_id = ?`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*OFACRescreenResult
+	for rows.Next() {
+		res := &OFACRescreenResult{}
+		var failed string
+		if err := rows.Scan(&res.Kind, &res.ObjectID, &res.UserID, &res.Name, &res.EntityID, &res.Match, &res.PreviousStatus, &failed, &res.Error); err != nil {
+			return nil, err
+		}
+		if failed != "" {
+			for _, id := range strings.Split(failed, ",") {
+				res.FailedTransfers = append(res.FailedTransfers, TransferID(id))
+			}
+		}
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
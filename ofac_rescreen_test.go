@@ -0,0 +1,246 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/moov-io/base"
+	ofac "github.com/moov-io/ofac/client"
+	"github.com/moov-io/paygate/internal/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// namedOFACClient only returns SDNs for the names it has
+type namedOFACClient struct {
+	testOFACClient
+
+	sdns map[string]*ofac.Sdn
+}
+
+func (c *namedOFACClient) Search(_ context.Context, name string, _ string) (*ofac.Sdn, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.sdns[name], nil
+}
+
+func TestOFAC__screenOFAC(t *testing.T) {
+	client := &testOFACClient{
+		sdn:      &ofac.Sdn{EntityID: "123", SdnType: "individual", Match: 0.5},
+		customer: &ofac.OfacCustomer{},
+	}
+	if sdn, blocked, err := screenOFAC(client, nil, "john doe", "", ""); err != nil || blocked || sdn == nil {
+		t.Errorf("sdn=%v blocked=%v error=%v", sdn, blocked, err)
+	}
+	client.sdn.Match = 0.99
+	if _, blocked, err := screenOFAC(client, nil, "john doe", "", ""); err != nil || !blocked {
+		t.Errorf("blocked=%v error=%v", blocked, err)
+	}
+
+	// unsafe SDNs are blocked regardless of their match
+	client.sdn.Match = 0.1
+	client.customer.Status.Status = "unsafe"
+	if _, blocked, err := screenOFAC(client, nil, "john doe", "", ""); err != nil || !blocked {
+		t.Errorf("blocked=%v error=%v", blocked, err)
+	}
+
+	client.err = errors.New("bad error")
+	if _, blocked, err := screenOFAC(client, nil, "john doe", "", ""); err == nil || blocked {
+		t.Errorf("blocked=%v error=%v", blocked, err)
+	}
+}
+
+func TestOFACRescreener(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	logger := log.NewNopLogger()
+	receiverRepo, depRepo := NewReceiverRepo(logger, db.DB), NewDepositoryRepo(logger, db.DB)
+	origRepo, transferRepo := NewOriginatorRepo(logger, db.DB), NewTransferRepo(logger, db.DB)
+	eventRepo, repo := NewEventRepo(logger, db.DB, nil), NewOFACRescreenRepo(logger, db.DB)
+
+	userID := base.ID()
+	now := base.NewTime(time.Now())
+	dep := &Depository{
+		ID:            DepositoryID(base.ID()),
+		BankName:      "bank",
+		Holder:        "Jane Doe",
+		HolderType:    Individual,
+		Type:          Checking,
+		RoutingNumber: "121042882",
+		AccountNumber: "123456",
+		Status:        DepositoryVerified,
+		Created:       now,
+		Updated:       now,
+	}
+	if err := depRepo.upsertUserDepository(userID, dep); err != nil {
+		t.Fatal(err)
+	}
+	receiver := &Receiver{
+		ID:                ReceiverID(base.ID()),
+		Email:             "john@example.com",
+		DefaultDepository: dep.ID,
+		Status:            ReceiverVerified,
+		Metadata:          "John Smith",
+		Created:           now,
+		Updated:           now,
+	}
+	if err := receiverRepo.upsertUserReceiver(userID, receiver); err != nil {
+		t.Fatal(err)
+	}
+	orig, err := origRepo.createUserOriginator(userID, originatorRequest{DefaultDepository: dep.ID, Identification: "123456789", Metadata: "Acme Corp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	amt, _ := NewAmount("USD", "12.34")
+	xfers, err := transferRepo.createUserTransfers(userID, []*transferRequest{{
+		Type:                   PushTransfer,
+		Amount:                 *amt,
+		Originator:             orig.ID,
+		OriginatorDepository:   DepositoryID(base.ID()),
+		Receiver:               receiver.ID,
+		ReceiverDepository:     DepositoryID(base.ID()),
+		Description:            "payroll",
+		StandardEntryClassCode: "PPD",
+		transactionID:          "transaction",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ofacClient := &namedOFACClient{
+		testOFACClient: testOFACClient{customer: &ofac.OfacCustomer{}, company: &ofac.OfacCompany{}},
+		sdns: map[string]*ofac.Sdn{
+			"Jane Doe":   {EntityID: "1", SdnType: "individual", Match: 0.4}, // under OFACMatchThreshold
+			"John Smith": {EntityID: "2", SdnType: "individual", Match: 0.98},
+		},
+	}
+	accountsClient := &testAccountsClient{}
+	rescreener := NewOFACRescreener(logger, ofacClient, repo, nil, receiverRepo, depRepo, origRepo, transferRepo, eventRepo, accountsClient)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/ofac/rescreens", ofacRescreens(logger, rescreener))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/ofac/rescreens", nil))
+	w.Flush()
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var run OFACRescreen
+	if err := json.NewDecoder(w.Body).Decode(&run); err != nil {
+		t.Fatal(err)
+	}
+	if run.Screened != 3 || run.Matches != 1 || run.Errors != 0 || len(run.Results) != 1 {
+		t.Fatalf("unexpected rescreen: %#v", run)
+	}
+	result := run.Results[0]
+	if result.Kind != OFACRescreenReceiver || result.ObjectID != string(receiver.ID) || result.EntityID != "2" || result.PreviousStatus != "verified" {
+		t.Errorf("unexpected result: %#v", result)
+	}
+	if len(result.FailedTransfers) != 1 || result.FailedTransfers[0] != xfers[0].ID {
+		t.Errorf("unexpected failed transfers: %v", result.FailedTransfers)
+	}
+
+	// the Receiver is suspended and its Transfer failed
+	if r, _ := receiverRepo.getUserReceiver(receiver.ID, userID); r.Status != ReceiverSuspended {
+		t.Errorf("unexpected receiver status: %s", r.Status)
+	}
+	if xfer, _ := transferRepo.getUserTransfer(xfers[0].ID, userID); xfer.Status != TransferFailed {
+		t.Errorf("unexpected transfer status: %s", xfer.Status)
+	}
+	// its posting was reversed once
+	if len(accountsClient.reversedTransactions) != 1 || accountsClient.reversedTransactions[0] != "transaction" {
+		t.Errorf("reversed transactions: %v", accountsClient.reversedTransactions)
+	}
+	if claimed, err := transferRepo.claimTransactionReversal(xfers[0].ID); claimed || err != nil {
+		t.Errorf("reversal wasn't recorded: claimed=%v error=%v", claimed, err)
+	}
+	events, err := eventRepo.getUserEvents(userID)
+	if err != nil || len(events) != 2 {
+		t.Errorf("events=%#v error=%v", events, err)
+	}
+
+	// the Originator now matches, suspended objects aren't screened again
+	ofacClient.sdns["Acme Corp"] = &ofac.Sdn{EntityID: "3", SdnType: "entity", Match: 0.95}
+	next, err := rescreener.rescreen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Screened != 2 || next.Matches != 1 || next.Results[0].Kind != OFACRescreenOriginator {
+		t.Errorf("unexpected rescreen: %#v", next)
+	}
+	if o, _ := origRepo.getUserOriginator(orig.ID, userID); o.Status != OriginatorSuspended {
+		t.Errorf("unexpected originator status: %s", o.Status)
+	}
+
+	// OFAC errors are recorded, but nothing is suspended
+	ofacClient.err = errors.New("bad error")
+	if run, err := rescreener.rescreen(); err != nil || run.Errors != 1 || run.Matches != 0 {
+		t.Errorf("rescreen=%#v error=%v", run, err)
+	}
+	if d, _ := depRepo.getUserDepository(dep.ID, userID); d.Status != DepositoryVerified {
+		t.Errorf("unexpected depository status: %s", d.Status)
+	}
+
+	// report
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/ofac/rescreens?limit=2", nil))
+	w.Flush()
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var runs []*OFACRescreen
+	if err := json.NewDecoder(w.Body).Decode(&runs); err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[1].ID != next.ID || len(runs[1].Results) != 1 || runs[1].Results[0].EntityID != "3" {
+		t.Errorf("unexpected rescreens: %#v", runs)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/ofac/rescreens?limit=-1", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
+
+func TestOFACRescreener__originatorsBlockTransfers(t *testing.T) {
+	origRepo := &mockOriginatorRepository{
+		originators: []*Originator{{ID: "originator", DefaultDepository: "originator", Identification: "123", Status: OriginatorSuspended}},
+	}
+	receiverRepo := &mockReceiverRepository{
+		receivers: []*Receiver{{ID: "receiver", Email: "john@example.com", DefaultDepository: "receiver", Status: ReceiverVerified}},
+	}
+	depRepo := &mockDepositoryRepository{
+		depositories: []*Depository{{
+			ID:            "receiver",
+			BankName:      "bank",
+			Holder:        "john",
+			HolderType:    Individual,
+			Type:          Checking,
+			RoutingNumber: "121042882",
+			AccountNumber: "123",
+			Status:        DepositoryVerified,
+		}},
+	}
+	req := &transferRequest{Receiver: "receiver", ReceiverDepository: "receiver", Originator: "originator", OriginatorDepository: "receiver"}
+	if _, _, _, _, err := getTransferObjects(req, base.ID(), depRepo, receiverRepo, origRepo); err == nil {
+		t.Error("expected error")
+	}
+	origRepo.originators[0].Status = OriginatorActive
+	if _, _, _, _, err := getTransferObjects(req, base.ID(), depRepo, receiverRepo, origRepo); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,454 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/moov-io/base"
+	"github.com/moov-io/base/admin"
+	moovhttp "github.com/moov-io/base/http"
+	ofac "github.com/moov-io/ofac/client"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+var (
+	// ofacClearanceExpiry is how long a cleared name and SDN pair is remembered for by default.
+	ofacClearanceExpiry = func() time.Duration {
+		v := os.Getenv("OFAC_CLEARANCE_EXPIRY")
+		if v == "" {
+			return 90 * 24 * time.Hour
+		}
+		dur, err := time.ParseDuration(v)
+		if err != nil || dur <= 0 {
+			panic(fmt.Sprintf("invalid OFAC_CLEARANCE_EXPIRY=%q: %v", v, err))
+		}
+		return dur
+	}()
+)
+
+type OFACHitID string
+
+type OFACHitStatus string
+
+const (
+	OFACHitPending   OFACHitStatus = "pending"
+	OFACHitCleared   OFACHitStatus = "cleared"
+	OFACHitConfirmed OFACHitStatus = "confirmed"
+)
+
+func (s OFACHitStatus) validate() error {
+	switch s {
+	case OFACHitPending, OFACHitCleared, OFACHitConfirmed:
+		return nil
+	default:
+		return fmt.Errorf("OFACHitStatus(%s) is invalid", s)
+	}
+}
+
+// OFACHit is a name which was blocked by an OFAC match and is queued for a compliance officer to review.
+// Clearing a hit lets the same name and SDN pass OFAC checks until the clearance expires. Confirming a hit
+// keeps it blocked.
+type OFACHit struct {
+	ID     OFACHitID `json:"id"`
+	UserID string    `json:"userId"`
+
+	// Name is what was searched in OFAC (Receiver or Originator metadata, Depository holder)
+	Name string `json:"name"`
+
+	EntityID string  `json:"entityId"`
+	SDNName  string  `json:"sdnName,omitempty"`
+	SDNType  string  `json:"sdnType,omitempty"`
+	Match    float32 `json:"match"`
+
+	// OFACStatus is the customer or company status from OFAC. Hits marked "unsafe" in OFAC can't be cleared.
+	OFACStatus string `json:"ofacStatus,omitempty"`
+
+	RequestID string `json:"requestId,omitempty"`
+
+	Status     OFACHitStatus `json:"status"`
+	Notes      string        `json:"notes,omitempty"`
+	ReviewedBy string        `json:"reviewedBy,omitempty"`
+
+	// Expires is when a cleared hit stops being remembered
+	Expires *base.Time `json:"expires,omitempty"`
+
+	Created base.Time `json:"created"`
+	Updated base.Time `json:"updated"`
+}
+
+func (hit *OFACHit) unsafe() bool {
+	return strings.EqualFold(hit.OFACStatus, "unsafe")
+}
+
+// reviewOFACMatch returns true if name and sdn have been cleared by a compliance officer, otherwise the
+// match is recorded in the review queue and its OFACHit returned. "unsafe" SDNs are never cleared.
+//
+// A nil repo disables the review queue.
+func reviewOFACMatch(repo ofacReviewRepository, sdn *ofac.Sdn, status string, name string, userID string, requestID string) (bool, *OFACHit, error) {
+	if repo == nil || sdn == nil {
+		return false, nil, nil
+	}
+	hit := &OFACHit{
+		ID:         OFACHitID(base.ID()),
+		UserID:     userID,
+		Name:       name,
+		EntityID:   sdn.EntityID,
+		SDNName:    sdn.SdnName,
+		SDNType:    sdn.SdnType,
+		Match:      sdn.Match,
+		OFACStatus: status,
+		RequestID:  requestID,
+		Status:     OFACHitPending,
+		Created:    base.NewTime(time.Now()),
+	}
+	hit.Updated = hit.Created
+	if !hit.unsafe() {
+		cleared, err := repo.isOFACCleared(name, sdn.EntityID)
+		if err != nil {
+			return false, nil, fmt.Errorf("problem reading OFAC clearance: %v", err)
+		}
+		if cleared {
+			return true, nil, nil
+		}
+	}
+	hit, err := repo.recordOFACHit(hit)
+	if err != nil {
+		return false, nil, fmt.Errorf("problem recording OFAC hit: %v", err)
+	}
+	return false, hit, nil
+}
+
+type ofacReviewRepository interface {
+	getOFACHits(status OFACHitStatus) ([]*OFACHit, error)
+	getOFACHit(id OFACHitID) (*OFACHit, error)
+
+	// recordOFACHit saves hit as pending, unless the same name and SDN are already pending review
+	// in which case the existing OFACHit is returned.
+	recordOFACHit(hit *OFACHit) (*OFACHit, error)
+
+	// reviewOFACHit changes the status of an OFACHit, but only if it's currently in the 'from' status.
+	reviewOFACHit(id OFACHitID, from, to OFACHitStatus, notes string, reviewedBy string, expires *time.Time) error
+
+	// isOFACCleared returns true if name and entityID have a cleared OFACHit which hasn't expired.
+	isOFACCleared(name string, entityID string) (bool, error)
+}
+
+func NewOFACReviewRepo(logger log.Logger, db *sql.DB) *SQLOFACReviewRepo {
+	return &SQLOFACReviewRepo{log: logger, db: db}
+}
+
+type SQLOFACReviewRepo struct {
+	db  *sql.DB
+	log log.Logger
+}
+
+func (r *SQLOFACReviewRepo) Close() error {
+	return r.db.Close()
+}
+
+func (r *SQLOFACReviewRepo) getOFACHits(status OFACHitStatus) ([]*OFACHit, error) {
+	query := `select hit_id from ofac_hits where status = ? order by created_at asc;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []OFACHitID
+	for rows.Next() {
+		var id OFACHitID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("getOFACHits: scan: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var hits []*OFACHit
+	for i := range ids {
+		hit, err := r.getOFACHit(ids[i])
+		if err == nil && hit != nil {
+			hits = append(hits, hit)
+		}
+	}
+	return hits, nil
+}
+
+func (r *SQLOFACReviewRepo) getOFACHit(id OFACHitID) (*OFACHit, error) {
+	query := `select hit_id, user_id, name, entity_id, coalesce(sdn_name, ''), coalesce(sdn_type, ''), sdn_match, coalesce(ofac_status, ''), coalesce(request_id, ''),
+status, coalesce(notes, ''), coalesce(reviewed_by, ''), expires_at, created_at, last_updated_at from ofac_hits where hit_id = ? limit 1;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var (
+		hit              OFACHit
+		expires          *time.Time
+		created, updated time.Time
+	)
+	err = stmt.QueryRow(id).Scan(&hit.ID, &hit.UserID, &hit.Name, &hit.EntityID, &hit.SDNName, &hit.SDNType, &hit.Match, &hit.OFACStatus, &hit.RequestID,
+		&hit.Status, &hit.Notes, &hit.ReviewedBy, &expires, &created, &updated)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if expires != nil {
+		t := base.NewTime(*expires)
+		hit.Expires = &t
+	}
+	hit.Created, hit.Updated = base.NewTime(created), base.NewTime(updated)
+	return &hit, nil
+}
+
+func (r *SQLOFACReviewRepo) recordOFACHit(hit *OFACHit) (*OFACHit, error) {
+	query := `select hit_id from ofac_hits where lower(trim(name)) = ? and entity_id = ? and status = ? limit 1;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var id OFACHitID
+	err = stmt.QueryRow(normalizeOFACName(hit.Name), hit.EntityID, OFACHitPending).Scan(&id)
+	if err == nil {
+		return r.getOFACHit(id)
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	query = `insert into ofac_hits (hit_id, user_id, name, entity_id, sdn_name, sdn_type, sdn_match, ofac_status, request_id, status, created_at, last_updated_at)
+values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	stmt, err = r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(hit.ID, hit.UserID, hit.Name, hit.EntityID, hit.SDNName, hit.SDNType, hit.Match, hit.OFACStatus, hit.RequestID, hit.Status, hit.Created.Time, hit.Updated.Time)
+	if err != nil {
+		return nil, err
+	}
+	return hit, nil
+}
+
+func (r *SQLOFACReviewRepo) reviewOFACHit(id OFACHitID, from, to OFACHitStatus, notes string, reviewedBy string, expires *time.Time) error {
+	query := `update ofac_hits set status = ?, notes = ?, reviewed_by = ?, expires_at = ?, last_updated_at = ? where hit_id = ? and status = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(to, notes, reviewedBy, expires, time.Now(), id, from)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("OFAC hit %s is not %s", id, from)
+	}
+	return nil
+}
+
+func (r *SQLOFACReviewRepo) isOFACCleared(name string, entityID string) (bool, error) {
+	query := `select count(*) from ofac_hits where lower(trim(name)) = ? and entity_id = ? and status = ? and expires_at > ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	var n int
+	if err := stmt.QueryRow(normalizeOFACName(name), entityID, OFACHitCleared, time.Now()).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// normalizeOFACName lowercases and trims name so clearances aren't sensitive to case or padding.
+func normalizeOFACName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// AddOFACReviewRoutes registers the admin HTTP routes for compliance officers to review OFAC hits.
+func AddOFACReviewRoutes(logger log.Logger, svc *admin.Server, reviewRepo ofacReviewRepository) {
+	svc.AddHandler("/ofac/hits", getOFACHits(logger, reviewRepo))
+	svc.AddHandler("/ofac/hits/{hitId}", getOFACHit(logger, reviewRepo))
+	svc.AddHandler("/ofac/hits/{hitId}/clear", clearOFACHit(logger, reviewRepo))
+	svc.AddHandler("/ofac/hits/{hitId}/confirm", confirmOFACHit(logger, reviewRepo))
+}
+
+func getOFACHitID(r *http.Request) OFACHitID {
+	return OFACHitID(mux.Vars(r)["hitId"])
+}
+
+func getOFACHits(logger log.Logger, reviewRepo ofacReviewRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w = wrap(logger, w, r)
+
+		if r.Method != "GET" {
+			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb: %s", r.Method))
+			return
+		}
+
+		status := OFACHitPending
+		if v := r.URL.Query().Get("status"); v != "" {
+			status = OFACHitStatus(strings.ToLower(v))
+			if err := status.validate(); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+		}
+		hits, err := reviewRepo.getOFACHits(status)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(hits)
+	}
+}
+
+func getOFACHit(logger log.Logger, reviewRepo ofacReviewRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w = wrap(logger, w, r)
+
+		if r.Method != "GET" {
+			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb: %s", r.Method))
+			return
+		}
+
+		hit, err := reviewRepo.getOFACHit(getOFACHitID(r))
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if hit == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(hit)
+	}
+}
+
+type ofacReviewRequest struct {
+	Notes string `json:"notes"`
+
+	// Expires optionally overrides OFAC_CLEARANCE_EXPIRY when clearing a hit
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// readOFACReview reads the request body and the OFACHit it's reviewing, writing a response on any error.
+func readOFACReview(w http.ResponseWriter, r *http.Request, reviewRepo ofacReviewRepository) (*OFACHit, *ofacReviewRequest) {
+	var req ofacReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		moovhttp.Problem(w, err)
+		return nil, nil
+	}
+	if strings.TrimSpace(req.Notes) == "" {
+		moovhttp.Problem(w, errors.New("missing notes"))
+		return nil, nil
+	}
+	hit, err := reviewRepo.getOFACHit(getOFACHitID(r))
+	if err != nil {
+		moovhttp.Problem(w, err)
+		return nil, nil
+	}
+	if hit == nil {
+		http.NotFound(w, r)
+		return nil, nil
+	}
+	return hit, &req
+}
+
+func clearOFACHit(logger log.Logger, reviewRepo ofacReviewRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w = wrap(logger, w, r)
+
+		if r.Method != "POST" {
+			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb: %s", r.Method))
+			return
+		}
+
+		hit, req := readOFACReview(w, r, reviewRepo)
+		if hit == nil {
+			return
+		}
+		if hit.unsafe() {
+			moovhttp.Problem(w, fmt.Errorf("OFAC hit %s is marked unsafe in OFAC and can't be cleared", hit.ID))
+			return
+		}
+		expires := time.Now().Add(ofacClearanceExpiry)
+		if req.Expires != nil {
+			if !req.Expires.After(time.Now()) {
+				moovhttp.Problem(w, errors.New("expires must be in the future"))
+				return
+			}
+			expires = *req.Expires
+		}
+		if err := reviewRepo.reviewOFACHit(hit.ID, OFACHitPending, OFACHitCleared, req.Notes, moovhttp.GetUserID(r), &expires); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		logger.Log("ofac-reviews", fmt.Sprintf("cleared %q against SDN=%s until %v", hit.Name, hit.EntityID, expires.Format(time.RFC3339)), "requestID", moovhttp.GetRequestID(r))
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// confirmOFACHit keeps a hit blocked. Cleared hits can be confirmed to revoke their clearance.
+func confirmOFACHit(logger log.Logger, reviewRepo ofacReviewRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w = wrap(logger, w, r)
+
+		if r.Method != "POST" {
+			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb: %s", r.Method))
+			return
+		}
+
+		hit, req := readOFACReview(w, r, reviewRepo)
+		if hit == nil {
+			return
+		}
+		if hit.Status == OFACHitConfirmed {
+			moovhttp.Problem(w, fmt.Errorf("OFAC hit %s is already confirmed", hit.ID))
+			return
+		}
+		if err := reviewRepo.reviewOFACHit(hit.ID, hit.Status, OFACHitConfirmed, req.Notes, moovhttp.GetUserID(r), nil); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		logger.Log("ofac-reviews", fmt.Sprintf("confirmed %q against SDN=%s", hit.Name, hit.EntityID), "requestID", moovhttp.GetRequestID(r))
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moov-io/base"
+	ofac "github.com/moov-io/ofac/client"
+	"github.com/moov-io/paygate/internal/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestOFACReviews__repository(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *SQLOFACReviewRepo) {
+		sdn := &ofac.Sdn{EntityID: "123", SdnName: "JOHN SMITH", SdnType: "individual", Match: 0.95}
+
+		cleared, hit, err := reviewOFACMatch(repo, sdn, "", "John Smith", "user", "request")
+		if err != nil || cleared || hit == nil {
+			t.Fatalf("cleared=%v hit=%#v error=%v", cleared, hit, err)
+		}
+
+		// the same name and SDN aren't queued twice
+		_, other, err := reviewOFACMatch(repo, sdn, "", " john smith", "other", "request2")
+		if err != nil || other == nil || other.ID != hit.ID {
+			t.Fatalf("hit=%#v error=%v", other, err)
+		}
+		hits, err := repo.getOFACHits(OFACHitPending)
+		if err != nil || len(hits) != 1 {
+			t.Fatalf("hits=%#v error=%v", hits, err)
+		}
+		if h := hits[0]; h.Name != "John Smith" || h.SDNName != "JOHN SMITH" || h.Match < 0.94 || h.UserID != "user" || h.RequestID != "request" {
+			t.Errorf("unexpected hit: %#v", h)
+		}
+
+		// can't confirm a hit which isn't cleared
+		if err := repo.reviewOFACHit(hit.ID, OFACHitCleared, OFACHitConfirmed, "notes", "", nil); err == nil {
+			t.Error("expected error")
+		}
+
+		// expired clearances aren't honored
+		expires := time.Now().Add(-1 * time.Minute)
+		if err := repo.reviewOFACHit(hit.ID, OFACHitPending, OFACHitCleared, "common name", "officer", &expires); err != nil {
+			t.Fatal(err)
+		}
+		if cleared, err := repo.isOFACCleared("JOHN SMITH", "123"); err != nil || cleared {
+			t.Errorf("cleared=%v error=%v", cleared, err)
+		}
+
+		_, next, err := reviewOFACMatch(repo, sdn, "", "John Smith", "user", "request3")
+		if err != nil || next == nil || next.ID == hit.ID {
+			t.Fatalf("hit=%#v error=%v", next, err)
+		}
+		expires = time.Now().Add(time.Hour)
+		if err := repo.reviewOFACHit(next.ID, OFACHitPending, OFACHitCleared, "common name", "officer", &expires); err != nil {
+			t.Fatal(err)
+		}
+		found, err := repo.getOFACHit(next.ID)
+		if err != nil || found == nil {
+			t.Fatalf("hit=%#v error=%v", found, err)
+		}
+		if found.Status != OFACHitCleared || found.Notes != "common name" || found.ReviewedBy != "officer" || found.Expires == nil {
+			t.Errorf("unexpected hit: %#v", found)
+		}
+		if cleared, _, err := reviewOFACMatch(repo, sdn, "", "john smith ", "user", ""); err != nil || !cleared {
+			t.Errorf("cleared=%v error=%v", cleared, err)
+		}
+
+		// unsafe SDNs are always queued, even if cleared
+		if cleared, hit, err := reviewOFACMatch(repo, sdn, "unsafe", "John Smith", "user", ""); err != nil || cleared || hit == nil {
+			t.Errorf("cleared=%v hit=%#v error=%v", cleared, hit, err)
+		}
+
+		// other SDNs aren't cleared
+		if cleared, err := repo.isOFACCleared("John Smith", "456"); err != nil || cleared {
+			t.Errorf("cleared=%v error=%v", cleared, err)
+		}
+		if found, err := repo.getOFACHit(OFACHitID(base.ID())); err != nil || found != nil {
+			t.Errorf("hit=%#v error=%v", found, err)
+		}
+	}
+
+	// SQLite tests
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, NewOFACReviewRepo(log.NewNopLogger(), sqliteDB.DB))
+
+	// MySQL tests
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, NewOFACReviewRepo(log.NewNopLogger(), mysqlDB.DB))
+}
+
+func TestOFACReviews__rejectViaOFACMatch(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	logger := log.NewNopLogger()
+	repo := NewOFACReviewRepo(logger, db.DB)
+
+	client := &testOFACClient{
+		sdn:      &ofac.Sdn{EntityID: "123", SdnType: "individual", Match: 0.99},
+		customer: &ofac.OfacCustomer{},
+	}
+	err := rejectViaOFACMatch(logger, client, repo, "John Smith", "userId", "")
+	if err == nil || !strings.Contains(err.Error(), "review hit=") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hits, _ := repo.getOFACHits(OFACHitPending)
+	if len(hits) != 1 {
+		t.Fatalf("unexpected hits: %#v", hits)
+	}
+	expires := time.Now().Add(time.Hour)
+	if err := repo.reviewOFACHit(hits[0].ID, OFACHitPending, OFACHitCleared, "different person", "", &expires); err != nil {
+		t.Fatal(err)
+	}
+	if err := rejectViaOFACMatch(logger, client, repo, "John Smith", "userId", ""); err != nil {
+		t.Errorf("expected cleared: %v", err)
+	}
+	if _, blocked, err := screenOFAC(client, repo, "John Smith", "userId", ""); err != nil || blocked {
+		t.Errorf("blocked=%v error=%v", blocked, err)
+	}
+
+	// unsafe SDNs are still blocked
+	client.customer.Status.Status = "unsafe"
+	if err := rejectViaOFACMatch(logger, client, repo, "John Smith", "userId", ""); err == nil || !strings.Contains(err.Error(), "marked unsafe") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, blocked, err := screenOFAC(client, repo, "John Smith", "userId", ""); err != nil || !blocked {
+		t.Errorf("blocked=%v error=%v", blocked, err)
+	}
+}
+
+func TestOFACReviews__HTTP(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	logger := log.NewNopLogger()
+	repo := NewOFACReviewRepo(logger, db.DB)
+
+	_, hit, err := reviewOFACMatch(repo, &ofac.Sdn{EntityID: "123", Match: 0.95}, "", "John Smith", "user", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, unsafe, err := reviewOFACMatch(repo, &ofac.Sdn{EntityID: "456", Match: 0.5}, "unsafe", "Jane Doe", "user", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/ofac/hits", getOFACHits(logger, repo))
+	router.HandleFunc("/ofac/hits/{hitId}", getOFACHit(logger, repo))
+	router.HandleFunc("/ofac/hits/{hitId}/clear", clearOFACHit(logger, repo))
+	router.HandleFunc("/ofac/hits/{hitId}/confirm", confirmOFACHit(logger, repo))
+
+	// list
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/ofac/hits", nil))
+	w.Flush()
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var hits []*OFACHit
+	if err := json.NewDecoder(w.Body).Decode(&hits); err != nil || len(hits) != 2 {
+		t.Fatalf("hits=%#v error=%v", hits, err)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/ofac/hits?status=other", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+
+	// notes are required
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/ofac/hits/"+string(hit.ID)+"/clear", strings.NewReader(`{}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+
+	// expires must be in the future
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/ofac/hits/"+string(hit.ID)+"/clear", strings.NewReader(`{"notes": "common name", "expires": "2019-01-01T00:00:00Z"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+
+	// clear
+	req := httptest.NewRequest("POST", "/ofac/hits/"+string(hit.ID)+"/clear", strings.NewReader(`{"notes": "common name"}`))
+	req.Header.Set("x-user-id", "officer")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/ofac/hits/"+string(hit.ID), nil))
+	w.Flush()
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var found OFACHit
+	if err := json.NewDecoder(w.Body).Decode(&found); err != nil {
+		t.Fatal(err)
+	}
+	if found.Status != OFACHitCleared || found.ReviewedBy != "officer" || found.Expires == nil {
+		t.Errorf("unexpected hit: %#v", found)
+	}
+	if diff := time.Until(found.Expires.Time) - ofacClearanceExpiry; diff > time.Minute || diff < -time.Minute {
+		t.Errorf("unexpected expiry: %v", found.Expires)
+	}
+
+	// can't clear twice, but a clearance can be revoked
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/ofac/hits/"+string(hit.ID)+"/clear", strings.NewReader(`{"notes": "again"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/ofac/hits/"+string(hit.ID)+"/confirm", strings.NewReader(`{"notes": "same DOB"}`)))
+	if w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if cleared, err := repo.isOFACCleared("John Smith", "123"); err != nil || cleared {
+		t.Errorf("cleared=%v error=%v", cleared, err)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/ofac/hits/"+string(hit.ID)+"/confirm", strings.NewReader(`{"notes": "same DOB"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+
+	// unsafe hits can't be cleared
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/ofac/hits/"+string(unsafe.ID)+"/clear", strings.NewReader(`{"notes": "common name"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/ofac/hits/foo", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
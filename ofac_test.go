@@ -24,6 +24,7 @@ type testOFACClient struct {
 	company  *ofac.OfacCompany
 	customer *ofac.OfacCustomer
 	sdn      *ofac.Sdn
+	address  *ofac.Address
 
 	// error to be returned instead of field from above
 	err error
@@ -54,6 +55,13 @@ func (c *testOFACClient) Search(_ context.Context, name string, _ string) (*ofac
 	return c.sdn, nil
 }
 
+func (c *testOFACClient) SearchAddress(_ context.Context, address, city, state, zip, country string, _ string) (*ofac.Address, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.address, nil
+}
+
 func TestOFAC__matchThreshold(t *testing.T) {
 	cases := []struct {
 		in string
@@ -213,7 +221,7 @@ func TestOFAC__rejectViaOFACMatch(t *testing.T) {
 		err: errors.New("searchOFAC error"),
 	}
 
-	if err := rejectViaOFACMatch(logger, client, "name", "userId", ""); err == nil {
+	if err := rejectViaOFACMatch(logger, client, nil, "name", "userId", ""); err == nil {
 		t.Error("expected error")
 	} else {
 		if !strings.Contains(err.Error(), `ofac: blocking "name" due to OFAC error`) {
@@ -232,7 +240,7 @@ func TestOFAC__rejectViaOFACMatch(t *testing.T) {
 			},
 		},
 	}
-	if err := rejectViaOFACMatch(logger, client, "name", "userId", ""); err == nil {
+	if err := rejectViaOFACMatch(logger, client, nil, "name", "userId", ""); err == nil {
 		t.Error("expected error")
 	} else {
 		if !strings.Contains(err.Error(), "marked unsafe") {
@@ -248,7 +256,7 @@ func TestOFAC__rejectViaOFACMatch(t *testing.T) {
 		},
 		customer: &ofac.OfacCustomer{}, // non-nil to avoid panic
 	}
-	if err := rejectViaOFACMatch(logger, client, "name", "userId", ""); err == nil {
+	if err := rejectViaOFACMatch(logger, client, nil, "name", "userId", ""); err == nil {
 		t.Error("expected error")
 	} else {
 		if !strings.Contains(err.Error(), "ofac: blocking due to OFAC match=0.99") {
@@ -258,7 +266,7 @@ func TestOFAC__rejectViaOFACMatch(t *testing.T) {
 
 	// no results, happy path
 	client = &testOFACClient{}
-	if err := rejectViaOFACMatch(logger, client, "jane doe", "userId", ""); err != nil {
+	if err := rejectViaOFACMatch(logger, client, nil, "jane doe", "userId", ""); err != nil {
 		t.Fatalf("expected no error, but got %v", err)
 	}
 }
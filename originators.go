@@ -34,12 +34,16 @@ type Originator struct {
 	DefaultDepository DepositoryID `json:"defaultDepository"`
 
 	// Identification is a number by which the receiver is known to the originator
-	// This should be the 9 digit FEIN number for a company or Social Security Number for an Individual
+	// This should be the 9 digit FEIN number for a company or Social Security Number for an Individual.
+	// All but the last four digits are masked when encoded as JSON.
 	Identification string `json:"identification"`
 
 	// Metadata provides additional data to be used for display and search only
 	Metadata string `json:"metadata"`
 
+	// Status defines the current state of the Originator. Suspended Originators can't create Transfers.
+	Status OriginatorStatus `json:"status"`
+
 	// Created a timestamp representing the initial creation date of the object in ISO 8601
 	Created base.Time `json:"created"`
 
@@ -47,6 +51,21 @@ type Originator struct {
 	Updated base.Time `json:"updated"`
 }
 
+type OriginatorStatus string
+
+const (
+	OriginatorActive    OriginatorStatus = "active"
+	OriginatorSuspended OriginatorStatus = "suspended"
+)
+
+// MarshalJSON masks all but the last four digits of the Identification.
+func (o Originator) MarshalJSON() ([]byte, error) {
+	type originator Originator
+	orig := originator(o)
+	orig.Identification = maskAccountNumber(o.Identification)
+	return json.Marshal(orig)
+}
+
 func (o *Originator) missingFields() error {
 	if o.DefaultDepository == "" {
 		return errors.New("missing Originator.DefaultDepository")
@@ -91,9 +110,9 @@ func (r originatorRequest) missingFields() error {
 	return nil
 }
 
-func AddOriginatorRoutes(logger log.Logger, r *mux.Router, accountsCallsDisabled bool, accountsClient AccountsClient, ofacClient OFACClient, depositoryRepo DepositoryRepository, originatorRepo originatorRepository) {
+func AddOriginatorRoutes(logger log.Logger, r *mux.Router, accountsClient AccountsClient, ofacClient OFACClient, ofacReviewRepo ofacReviewRepository, depositoryRepo DepositoryRepository, originatorRepo originatorRepository) {
 	r.Methods("GET").Path("/originators").HandlerFunc(getUserOriginators(logger, originatorRepo))
-	r.Methods("POST").Path("/originators").HandlerFunc(createUserOriginator(logger, accountsCallsDisabled, accountsClient, ofacClient, originatorRepo, depositoryRepo))
+	r.Methods("POST").Path("/originators").HandlerFunc(createUserOriginator(logger, accountsClient, ofacClient, ofacReviewRepo, originatorRepo, depositoryRepo))
 
 	r.Methods("GET").Path("/originators/{originatorId}").HandlerFunc(getUserOriginator(logger, originatorRepo))
 	r.Methods("DELETE").Path("/originators/{originatorId}").HandlerFunc(deleteUserOriginator(logger, originatorRepo))
@@ -135,7 +154,7 @@ func readOriginatorRequest(r *http.Request) (originatorRequest, error) {
 	return req, nil
 }
 
-func createUserOriginator(logger log.Logger, accountsCallsDisabled bool, accountsClient AccountsClient, ofacClient OFACClient, originatorRepo originatorRepository, depositoryRepo DepositoryRepository) http.HandlerFunc {
+func createUserOriginator(logger log.Logger, accountsClient AccountsClient, ofacClient OFACClient, ofacReviewRepo ofacReviewRepository, originatorRepo originatorRepository, depositoryRepo DepositoryRepository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w, err := wrapResponseWriter(logger, w, r)
 		if err != nil {
@@ -159,7 +178,7 @@ func createUserOriginator(logger log.Logger, accountsCallsDisabled bool, account
 		}
 
 		// Verify account exists in Accounts for receiver (userID)
-		if !accountsCallsDisabled {
+		if accountsClient != nil {
 			account, err := accountsClient.SearchAccounts(requestID, userID, dep)
 			if err != nil || account == nil {
 				logger.Log("originators", fmt.Sprintf("problem finding account depository=%s: %v", dep.ID, err), "requestID", requestID, "userID", userID)
@@ -169,7 +188,7 @@ func createUserOriginator(logger log.Logger, accountsCallsDisabled bool, account
 		}
 
 		// Check OFAC for customer/company data
-		if err := rejectViaOFACMatch(logger, ofacClient, req.Metadata, userID, requestID); err != nil {
+		if err := rejectViaOFACMatch(logger, ofacClient, ofacReviewRepo, req.Metadata, userID, requestID); err != nil {
 			logger.Log("originators", fmt.Sprintf("error checking OFAC for '%s': %v", req.Metadata, err), "requestID", requestID, "userID", userID)
 			moovhttp.Problem(w, err)
 			return
@@ -244,6 +263,8 @@ type originatorRepository interface {
 
 	createUserOriginator(userID string, req originatorRequest) (*Originator, error)
 	deleteUserOriginator(id OriginatorID, userID string) error
+
+	updateOriginatorStatus(id OriginatorID, userID string, status OriginatorStatus) error
 }
 
 func NewOriginatorRepo(logger log.Logger, db *sql.DB) *SQLOriginatorRepo {
@@ -293,7 +314,7 @@ func (r *SQLOriginatorRepo) getUserOriginators(userID string) ([]*Originator, er
 }
 
 func (r *SQLOriginatorRepo) getUserOriginator(id OriginatorID, userID string) (*Originator, error) {
-	query := `select originator_id, default_depository, identification, metadata, created_at, last_updated_at
+	query := `select originator_id, default_depository, identification, encrypted_identification, metadata, status, created_at, last_updated_at
 from originators
 where originator_id = ? and user_id = ? and deleted_at is null
 limit 1`
@@ -307,13 +328,21 @@ limit 1`
 
 	orig := &Originator{}
 	var (
-		created time.Time
-		updated time.Time
+		plaintext string
+		encrypted *string
+		created   time.Time
+		updated   time.Time
 	)
-	err = row.Scan(&orig.ID, &orig.DefaultDepository, &orig.Identification, &orig.Metadata, &created, &updated)
+	err = row.Scan(&orig.ID, &orig.DefaultDepository, &plaintext, &encrypted, &orig.Metadata, &orig.Status, &created, &updated)
 	if err != nil {
 		return nil, err
 	}
+	if encrypted == nil {
+		encrypted = new(string)
+	}
+	if orig.Identification, err = decryptAccountNumber(*encrypted, plaintext); err != nil {
+		return nil, fmt.Errorf("originator=%s: %v", orig.ID, err)
+	}
 	orig.Created = base.NewTime(created)
 	orig.Updated = base.NewTime(updated)
 	if orig.ID == "" {
@@ -329,6 +358,7 @@ func (r *SQLOriginatorRepo) createUserOriginator(userID string, req originatorRe
 		DefaultDepository: req.DefaultDepository,
 		Identification:    req.Identification,
 		Metadata:          req.Metadata,
+		Status:            OriginatorActive,
 		Created:           base.NewTime(now),
 		Updated:           base.NewTime(now),
 	}
@@ -336,14 +366,20 @@ func (r *SQLOriginatorRepo) createUserOriginator(userID string, req originatorRe
 		return nil, err
 	}
 
-	query := `insert into originators (originator_id, user_id, default_depository, identification, metadata, created_at, last_updated_at) values (?, ?, ?, ?, ?, ?, ?)`
+	// Identification is only stored encrypted, the plaintext column is left empty
+	encrypted, err := accountNumberKeeper.Encrypt(orig.Identification)
+	if err != nil {
+		return nil, fmt.Errorf("problem encrypting originator identification: %v", err)
+	}
+
+	query := `insert into originators (originator_id, user_id, default_depository, identification, encrypted_identification, metadata, status, created_at, last_updated_at) values (?, ?, ?, '', ?, ?, ?, ?, ?)`
 	stmt, err := r.db.Prepare(query)
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(orig.ID, userID, orig.DefaultDepository, orig.Identification, orig.Metadata, now, now)
+	_, err = stmt.Exec(orig.ID, userID, orig.DefaultDepository, encrypted, orig.Metadata, orig.Status, now, now)
 	if err != nil {
 		return nil, err
 	}
@@ -361,3 +397,78 @@ func (r *SQLOriginatorRepo) deleteUserOriginator(id OriginatorID, userID string)
 	_, err = stmt.Exec(time.Now(), id, userID)
 	return err
 }
+
+func (r *SQLOriginatorRepo) updateOriginatorStatus(id OriginatorID, userID string, status OriginatorStatus) error {
+	query := `update originators set status = ?, last_updated_at = ? where originator_id = ? and user_id = ? and deleted_at is null`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(status, time.Now(), id, userID)
+	return err
+}
+
+// rotateEncryptionKeys re-wraps every Identification with the primary key-encryption key and encrypts values
+// stored before encryption was added. Deleted Originators are included so older keys can be removed.
+func (r *SQLOriginatorRepo) rotateEncryptionKeys() (int, error) {
+	query := `select originator_id, identification, encrypted_identification from originators`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	updates := make(map[string]string) // originator_id -> encrypted_identification
+	for rows.Next() {
+		var (
+			id, plaintext string
+			encrypted     *string
+		)
+		if err := rows.Scan(&id, &plaintext, &encrypted); err != nil {
+			return 0, err
+		}
+		if encrypted == nil || *encrypted == "" {
+			if plaintext == "" {
+				continue
+			}
+			if updates[id], err = accountNumberKeeper.Encrypt(plaintext); err != nil {
+				return 0, fmt.Errorf("originator=%s: %v", id, err)
+			}
+		} else {
+			rewrapped, changed, err := accountNumberKeeper.Rewrap(*encrypted)
+			if err != nil {
+				return 0, fmt.Errorf("originator=%s: %v", id, err)
+			}
+			if changed {
+				updates[id] = rewrapped
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	update, err := r.db.Prepare(`update originators set identification = '', encrypted_identification = ? where originator_id = ?`)
+	if err != nil {
+		return 0, err
+	}
+	defer update.Close()
+
+	rotated := 0
+	for id, encrypted := range updates {
+		if _, err := update.Exec(encrypted, id); err != nil {
+			return rotated, fmt.Errorf("problem rotating originator=%s: %v", id, err)
+		}
+		rotated++
+	}
+	return rotated, nil
+}
@@ -57,6 +57,16 @@ func (r *mockOriginatorRepository) deleteUserOriginator(id OriginatorID, userID
 	return r.err
 }
 
+func (r *mockOriginatorRepository) updateOriginatorStatus(id OriginatorID, userID string, status OriginatorStatus) error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.originators) > 0 {
+		r.originators[0].Status = status
+	}
+	return nil
+}
+
 func TestOriginators__read(t *testing.T) {
 	var buf bytes.Buffer
 	err := json.NewEncoder(&buf).Encode(originatorRequest{
@@ -183,7 +193,7 @@ func TestOriginators_OFACMatch(t *testing.T) {
 		},
 	}
 	ofacClient := &testOFACClient{}
-	createUserOriginator(logger, false, accountsClient, ofacClient, origRepo, depRepo)(w, req)
+	createUserOriginator(logger, accountsClient, ofacClient, nil, origRepo, depRepo)(w, req)
 	w.Flush()
 
 	if w.Code != http.StatusOK {
@@ -196,7 +206,7 @@ func TestOriginators_OFACMatch(t *testing.T) {
 		err: errors.New("blocking"),
 	}
 	req.Body = ioutil.NopCloser(strings.NewReader(rawBody))
-	createUserOriginator(logger, false, accountsClient, ofacClient, origRepo, depRepo)(w, req)
+	createUserOriginator(logger, accountsClient, ofacClient, nil, origRepo, depRepo)(w, req)
 	w.Flush()
 
 	if w.Code != http.StatusBadRequest {
@@ -223,7 +233,7 @@ func TestOriginators_HTTPGet(t *testing.T) {
 	}
 
 	router := mux.NewRouter()
-	AddOriginatorRoutes(log.NewNopLogger(), router, true, nil, nil, nil, repo)
+	AddOriginatorRoutes(log.NewNopLogger(), router, nil, nil, nil, nil, repo)
 
 	req := httptest.NewRequest("GET", fmt.Sprintf("/originators/%s", orig.ID), nil)
 	req.Header.Set("x-user-id", userID)
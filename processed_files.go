@@ -0,0 +1,452 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/moov-io/base"
+	"github.com/moov-io/base/admin"
+	moovhttp "github.com/moov-io/base/http"
+	"github.com/moov-io/paygate/internal/filetransfer"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+type ProcessedFileID string
+
+// ProcessedFileKind is the remote directory a file was downloaded from.
+type ProcessedFileKind string
+
+const (
+	InboundFileKind ProcessedFileKind = "inbound"
+	ReturnFileKind  ProcessedFileKind = "return"
+)
+
+type ProcessedFileStatus string
+
+const (
+	// FileDownloaded is a file which has been downloaded and stored, but not (successfully) processed yet.
+	FileDownloaded ProcessedFileStatus = "downloaded"
+
+	// FileProcessed is a file whose processing has completed. It's safe to delete from the remote server.
+	FileProcessed ProcessedFileStatus = "processed"
+)
+
+func (s ProcessedFileStatus) validate() error {
+	switch s {
+	case FileDownloaded, FileProcessed:
+		return nil
+	default:
+		return fmt.Errorf("ProcessedFileStatus(%s) is invalid", s)
+	}
+}
+
+// ProcessedFile is an inbound or return file downloaded from an ODFI. Files are keyed by their filename and
+// content hash so a file is only processed once, even if deleting it from the remote server fails.
+type ProcessedFile struct {
+	ID            ProcessedFileID     `json:"id"`
+	RoutingNumber string              `json:"routingNumber"`
+	Kind          ProcessedFileKind   `json:"kind"`
+	Filename      string              `json:"filename"`
+	Hash          string              `json:"hash"`
+	Status        ProcessedFileStatus `json:"status"`
+	Created       base.Time           `json:"created"`
+	Processed     *base.Time          `json:"processed,omitempty"`
+
+	// filepath is the location of our stored copy of the file
+	filepath string
+}
+
+// processDownloadedFiles registers and processes each file saveRemoteFiles wrote into dir. The remote paths of
+// files which have been processed (now or on a previous run) are returned so they can be deleted.
+func (c *fileTransferController) processDownloadedFiles(dir string, fileTransferConf *filetransfer.Config, depRepo DepositoryRepository, transferRepo transferRepository) []string {
+	var processed []string
+
+	kinds := []struct {
+		kind ProcessedFileKind
+		path string
+	}{
+		{kind: InboundFileKind, path: fileTransferConf.InboundPath},
+		{kind: ReturnFileKind, path: fileTransferConf.ReturnPath},
+	}
+	for _, k := range kinds {
+		infos, err := ioutil.ReadDir(filepath.Join(dir, k.path))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				c.logger.Log("processDownloadedFiles", fmt.Sprintf("problem reading %s files in %s", k.kind, dir), "error", err)
+			}
+			continue
+		}
+		for i := range infos {
+			if infos[i].IsDir() {
+				continue
+			}
+			file, err := c.registerDownloadedFile(fileTransferConf.RoutingNumber, k.kind, filepath.Join(dir, k.path, infos[i].Name()))
+			if err != nil {
+				c.logger.Log("processDownloadedFiles", fmt.Sprintf("problem registering %s file %s", k.kind, infos[i].Name()), "error", err)
+				continue
+			}
+			if file.Status == FileProcessed {
+				c.logger.Log("processDownloadedFiles", fmt.Sprintf("skipping already processed %s file %s (%s)", k.kind, file.Filename, file.ID))
+			} else {
+				if err := c.processFile(file, depRepo, transferRepo); err != nil {
+					c.logger.Log("processDownloadedFiles", fmt.Sprintf("problem processing %s file %s (%s)", k.kind, file.Filename, file.ID), "error", err)
+					continue
+				}
+			}
+			processed = append(processed, filepath.Join(k.path, infos[i].Name()))
+		}
+	}
+	return processed
+}
+
+// registerDownloadedFile returns the ProcessedFile for the file at path. New files are copied into our storage
+// directory (so they can be processed again later) and recorded as downloaded.
+func (c *fileTransferController) registerDownloadedFile(routingNumber string, kind ProcessedFileKind, path string) (*ProcessedFile, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(bs)
+	file := &ProcessedFile{
+		ID:            ProcessedFileID(base.ID()),
+		RoutingNumber: routingNumber,
+		Kind:          kind,
+		Filename:      filepath.Base(path),
+		Hash:          hex.EncodeToString(sum[:]),
+		Status:        FileDownloaded,
+		Created:       base.NewTime(time.Now()),
+		filepath:      path,
+	}
+	if c.processedFileRepo == nil {
+		return file, nil
+	}
+
+	existing, err := c.processedFileRepo.lookupProcessedFile(file.Filename, file.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return existing, nil
+	}
+
+	dir := filepath.Join(c.rootDir, "processed", string(kind))
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	file.filepath = filepath.Join(dir, fmt.Sprintf("%s-%s", file.ID, file.Filename))
+	if err := ioutil.WriteFile(file.filepath, bs, 0644); err != nil {
+		return nil, err
+	}
+	if err := c.processedFileRepo.createProcessedFile(file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// processFile reads and processes a file based on its kind and then marks it as processed.
+func (c *fileTransferController) processFile(file *ProcessedFile, depRepo DepositoryRepository, transferRepo transferRepository) error {
+	var err error
+	switch file.Kind {
+	case InboundFileKind:
+		err = c.processInboundFile(file.filepath)
+	case ReturnFileKind:
+		err = c.processReturnFile(file.filepath, depRepo, transferRepo)
+	default:
+		err = fmt.Errorf("unknown file kind %q", file.Kind)
+	}
+	if err != nil {
+		return err
+	}
+	if c.processedFileRepo == nil {
+		return nil
+	}
+	if err := c.processedFileRepo.markFileProcessed(file.ID); err != nil {
+		return fmt.Errorf("problem marking %s processed: %v", file.ID, err)
+	}
+	file.Status = FileProcessed
+	return nil
+}
+
+// deleteProcessedFiles removes processed files from the remote server.
+func (c *fileTransferController) deleteProcessedFiles(fileTransferConf *filetransfer.Config, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	agent, err := filetransfer.New(c.logger, c.findTransferType(fileTransferConf.RoutingNumber), fileTransferConf, c.repo)
+	if err != nil {
+		return fmt.Errorf("deleteProcessedFiles: problem with %s file transfer agent init: %v", fileTransferConf.RoutingNumber, err)
+	}
+	defer agent.Close()
+
+	return c.deleteRemoteFiles(agent, paths)
+}
+
+func (c *fileTransferController) deleteRemoteFiles(agent filetransfer.Agent, paths []string) error {
+	var problems []string
+	for i := range paths {
+		if err := agent.Delete(paths[i]); err != nil {
+			problems = append(problems, fmt.Sprintf("%T: Delete filename=%s error=%v", agent, paths[i], err))
+		} else {
+			c.logger.Log("deleteRemoteFiles", fmt.Sprintf("%T: deleted processed file %s", agent, paths[i]))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New("  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+type ProcessedFileRepository interface {
+	// getProcessedFiles returns the most recent files, optionally filtered by status (an empty status returns all files).
+	getProcessedFiles(status ProcessedFileStatus) ([]*ProcessedFile, error)
+	getProcessedFile(id ProcessedFileID) (*ProcessedFile, error)
+
+	// lookupProcessedFile returns the file matching filename and hash, or nil if it hasn't been seen before.
+	lookupProcessedFile(filename, hash string) (*ProcessedFile, error)
+
+	createProcessedFile(file *ProcessedFile) error
+	markFileProcessed(id ProcessedFileID) error
+}
+
+func NewProcessedFileRepo(logger log.Logger, db *sql.DB) *SQLProcessedFileRepo {
+	return &SQLProcessedFileRepo{log: logger, db: db}
+}
+
+type SQLProcessedFileRepo struct {
+	db  *sql.DB
+	log log.Logger
+}
+
+func (r *SQLProcessedFileRepo) Close() error {
+	return r.db.Close()
+}
+
+func (r *SQLProcessedFileRepo) getProcessedFiles(status ProcessedFileStatus) ([]*ProcessedFile, error) {
+	query, args := `select file_id from processed_files order by created_at desc limit 100;`, []interface{}{}
+	if status != "" {
+		query, args = `select file_id from processed_files where status = ? order by created_at desc limit 100;`, []interface{}{status}
+	}
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []ProcessedFileID
+	for rows.Next() {
+		var id ProcessedFileID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("getProcessedFiles: scan: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var files []*ProcessedFile
+	for i := range ids {
+		file, err := r.getProcessedFile(ids[i])
+		if err == nil && file != nil {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+func (r *SQLProcessedFileRepo) getProcessedFile(id ProcessedFileID) (*ProcessedFile, error) {
+	query := `select file_id, routing_number, kind, filename, hash, filepath, status, created_at, processed_at from processed_files where file_id = ? limit 1;`
+	return r.scanProcessedFile(query, id)
+}
+
+func (r *SQLProcessedFileRepo) lookupProcessedFile(filename, hash string) (*ProcessedFile, error) {
+	query := `select file_id, routing_number, kind, filename, hash, filepath, status, created_at, processed_at from processed_files where filename = ? and hash = ? limit 1;`
+	return r.scanProcessedFile(query, filename, hash)
+}
+
+func (r *SQLProcessedFileRepo) scanProcessedFile(query string, args ...interface{}) (*ProcessedFile, error) {
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var (
+		file      ProcessedFile
+		created   time.Time
+		processed *time.Time
+	)
+	err = stmt.QueryRow(args...).Scan(&file.ID, &file.RoutingNumber, &file.Kind, &file.Filename, &file.Hash, &file.filepath, &file.Status, &created, &processed)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	file.Created = base.NewTime(created)
+	if processed != nil {
+		t := base.NewTime(*processed)
+		file.Processed = &t
+	}
+	return &file, nil
+}
+
+func (r *SQLProcessedFileRepo) createProcessedFile(file *ProcessedFile) error {
+	query := `insert into processed_files (file_id, routing_number, kind, filename, hash, filepath, status, created_at) values (?, ?, ?, ?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(file.ID, file.RoutingNumber, file.Kind, file.Filename, file.Hash, file.filepath, file.Status, file.Created.Time)
+	return err
+}
+
+func (r *SQLProcessedFileRepo) markFileProcessed(id ProcessedFileID) error {
+	query := `update processed_files set status = ?, processed_at = ? where file_id = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(FileProcessed, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("processed file %s not found", id)
+	}
+	return nil
+}
+
+// AddProcessedFileRoutes registers the admin HTTP routes for viewing downloaded inbound and return files
+// and re-running the processing of a stored file.
+func AddProcessedFileRoutes(logger log.Logger, svc *admin.Server, controller *fileTransferController, depRepo DepositoryRepository, transferRepo transferRepository) {
+	svc.AddHandler("/files/processed", getProcessedFiles(logger, controller.processedFileRepo))
+	svc.AddHandler("/files/processed/{fileId}", getProcessedFile(logger, controller.processedFileRepo))
+	svc.AddHandler("/files/processed/{fileId}/reprocess", reprocessFile(logger, controller, depRepo, transferRepo))
+}
+
+func getProcessedFileID(r *http.Request) ProcessedFileID {
+	return ProcessedFileID(mux.Vars(r)["fileId"])
+}
+
+func getProcessedFiles(logger log.Logger, repo ProcessedFileRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w = wrap(logger, w, r)
+
+		if r.Method != "GET" {
+			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb: %s", r.Method))
+			return
+		}
+
+		var status ProcessedFileStatus
+		if v := r.URL.Query().Get("status"); v != "" {
+			status = ProcessedFileStatus(strings.ToLower(v))
+			if err := status.validate(); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+		}
+		files, err := repo.getProcessedFiles(status)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(files)
+	}
+}
+
+func getProcessedFile(logger log.Logger, repo ProcessedFileRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w = wrap(logger, w, r)
+
+		if r.Method != "GET" {
+			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb: %s", r.Method))
+			return
+		}
+
+		file, err := repo.getProcessedFile(getProcessedFileID(r))
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if file == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(file)
+	}
+}
+
+func reprocessFile(logger log.Logger, controller *fileTransferController, depRepo DepositoryRepository, transferRepo transferRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w = wrap(logger, w, r)
+
+		if r.Method != "POST" {
+			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb: %s", r.Method))
+			return
+		}
+		if controller.processedFileRepo == nil {
+			moovhttp.Problem(w, errors.New("processed file registry is not configured"))
+			return
+		}
+
+		file, err := controller.processedFileRepo.getProcessedFile(getProcessedFileID(r))
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if file == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		// Returns are matched against processed transfers only, so transfers which were already
+		// reclaimed by an earlier run aren't reversed again.
+		logger.Log("files", fmt.Sprintf("reprocessing %s file %s (%s)", file.Kind, file.Filename, file.ID), "requestID", moovhttp.GetRequestID(r))
+		if err := controller.processFile(file, depRepo, transferRepo); err != nil {
+			moovhttp.Problem(w, fmt.Errorf("problem reprocessing %s: %v", file.ID, err))
+			return
+		}
+		file, err = controller.processedFileRepo.getProcessedFile(file.ID)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(file)
+	}
+}
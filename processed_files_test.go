@@ -0,0 +1,254 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/moov-io/base"
+	"github.com/moov-io/paygate/internal/database"
+	"github.com/moov-io/paygate/internal/filetransfer"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestProcessedFiles__repository(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *SQLProcessedFileRepo) {
+		file := &ProcessedFile{
+			ID:            ProcessedFileID(base.ID()),
+			RoutingNumber: "076401251",
+			Kind:          ReturnFileKind,
+			Filename:      "return-WEB.ach",
+			Hash:          "abc123",
+			Status:        FileDownloaded,
+			Created:       base.NewTime(time.Now()),
+			filepath:      filepath.Join("storage", "processed", "return", "return-WEB.ach"),
+		}
+		if err := repo.createProcessedFile(file); err != nil {
+			t.Fatal(err)
+		}
+		// the same filename and hash can't be registered twice
+		dup := *file
+		dup.ID = ProcessedFileID(base.ID())
+		if err := repo.createProcessedFile(&dup); err == nil {
+			t.Error("expected error")
+		}
+
+		found, err := repo.lookupProcessedFile("return-WEB.ach", "abc123")
+		if err != nil || found == nil {
+			t.Fatalf("file=%#v error=%v", found, err)
+		}
+		if found.ID != file.ID || found.filepath != file.filepath || found.Status != FileDownloaded || found.Processed != nil {
+			t.Errorf("unexpected file: %#v", found)
+		}
+		if found, err := repo.lookupProcessedFile("return-WEB.ach", "other"); err != nil || found != nil {
+			t.Errorf("file=%#v error=%v", found, err)
+		}
+
+		if err := repo.markFileProcessed(file.ID); err != nil {
+			t.Fatal(err)
+		}
+		found, err = repo.getProcessedFile(file.ID)
+		if err != nil || found == nil {
+			t.Fatalf("file=%#v error=%v", found, err)
+		}
+		if found.Status != FileProcessed || found.Processed == nil {
+			t.Errorf("unexpected file: %#v", found)
+		}
+		if err := repo.markFileProcessed(ProcessedFileID(base.ID())); err == nil {
+			t.Error("expected error")
+		}
+
+		if files, err := repo.getProcessedFiles(""); err != nil || len(files) != 1 {
+			t.Errorf("files=%#v error=%v", files, err)
+		}
+		if files, err := repo.getProcessedFiles(FileDownloaded); err != nil || len(files) != 0 {
+			t.Errorf("files=%#v error=%v", files, err)
+		}
+	}
+
+	// SQLite tests
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, NewProcessedFileRepo(log.NewNopLogger(), sqliteDB.DB))
+
+	// MySQL tests
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, NewProcessedFileRepo(log.NewNopLogger(), mysqlDB.DB))
+}
+
+// setupDownloadedFiles writes an inbound and return file into dir as saveRemoteFiles would
+func setupDownloadedFiles(t *testing.T, dir string) {
+	t.Helper()
+
+	for _, path := range []string{"inbound/ppd-debit.ach", "return/return-WEB.ach"} {
+		bs, err := ioutil.ReadFile(filepath.Join("testdata", filepath.Base(path)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0777)
+		if err := ioutil.WriteFile(filepath.Join(dir, path), bs, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestProcessedFiles__processDownloadedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "processDownloadedFiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	repo := NewProcessedFileRepo(log.NewNopLogger(), db.DB)
+	controller := &fileTransferController{
+		rootDir:           dir,
+		processedFileRepo: repo,
+		logger:            log.NewNopLogger(),
+	}
+	cfg := &filetransfer.Config{RoutingNumber: "076401251", InboundPath: "inbound/", ReturnPath: "return/"}
+
+	downloaded := filepath.Join(dir, "downloaded")
+	setupDownloadedFiles(t, downloaded)
+	ioutil.WriteFile(filepath.Join(downloaded, "return", "invalid.ach"), []byte("invalid"), 0644)
+
+	depRepo, transferRepo := &mockDepositoryRepository{}, &mockTransferRepository{}
+	processed := controller.processDownloadedFiles(downloaded, cfg, depRepo, transferRepo)
+	if len(processed) != 2 {
+		t.Fatalf("processed=%v", processed)
+	}
+	if processed[0] != filepath.Join("inbound", "ppd-debit.ach") || processed[1] != filepath.Join("return", "return-WEB.ach") {
+		t.Errorf("processed=%v", processed)
+	}
+
+	// the invalid file is stored, but not processed
+	files, err := repo.getProcessedFiles(FileDownloaded)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("files=%#v error=%v", files, err)
+	}
+	if files[0].Filename != "invalid.ach" {
+		t.Errorf("unexpected file: %#v", files[0])
+	}
+	if _, err := os.Stat(files[0].filepath); err != nil {
+		t.Errorf("stored file: %v", err)
+	}
+
+	// download the same files again (i.e. the remote delete failed), they're skipped
+	processed = controller.processDownloadedFiles(downloaded, cfg, depRepo, transferRepo)
+	if len(processed) != 2 {
+		t.Fatalf("processed=%v", processed)
+	}
+	files, err = repo.getProcessedFiles("")
+	if err != nil || len(files) != 3 {
+		t.Fatalf("files=%#v error=%v", files, err)
+	}
+
+	// delete the processed files from the remote server
+	agent := &mockFileTransferAgent{}
+	if err := controller.deleteRemoteFiles(agent, processed); err != nil {
+		t.Fatal(err)
+	}
+	if agent.deletedFile != filepath.Join("return", "return-WEB.ach") {
+		t.Errorf("deleted file was %s", agent.deletedFile)
+	}
+}
+
+func TestProcessedFiles__HTTP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "processedFiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	logger := log.NewNopLogger()
+	controller := &fileTransferController{
+		rootDir:           dir,
+		processedFileRepo: NewProcessedFileRepo(logger, db.DB),
+		logger:            logger,
+	}
+	downloaded := filepath.Join(dir, "downloaded")
+	setupDownloadedFiles(t, downloaded)
+
+	depRepo := &mockDepositoryRepository{}
+	transferRepo := &mockTransferRepository{err: errors.New("bad error")}
+	cfg := &filetransfer.Config{RoutingNumber: "076401251", InboundPath: "inbound/", ReturnPath: "return/"}
+	if processed := controller.processDownloadedFiles(downloaded, cfg, depRepo, transferRepo); len(processed) != 2 {
+		t.Fatalf("processed=%v", processed)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/files/processed", getProcessedFiles(logger, controller.processedFileRepo))
+	router.HandleFunc("/files/processed/{fileId}", getProcessedFile(logger, controller.processedFileRepo))
+	router.HandleFunc("/files/processed/{fileId}/reprocess", reprocessFile(logger, controller, depRepo, transferRepo))
+
+	// list
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/files/processed?status=processed", nil))
+	w.Flush()
+	if w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var files []*ProcessedFile
+	if err := json.NewDecoder(w.Body).Decode(&files); err != nil || len(files) != 2 {
+		t.Fatalf("files=%#v error=%v", files, err)
+	}
+
+	// invalid status
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/files/processed?status=other", nil))
+	w.Flush()
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+
+	// get
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/files/processed/"+string(files[0].ID), nil))
+	w.Flush()
+	if w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var file ProcessedFile
+	if err := json.NewDecoder(w.Body).Decode(&file); err != nil || file.ID != files[0].ID {
+		t.Errorf("file=%#v error=%v", file, err)
+	}
+
+	// reprocess
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/files/processed/"+string(files[0].ID)+"/reprocess", nil))
+	w.Flush()
+	if w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if err := json.NewDecoder(w.Body).Decode(&file); err != nil || file.Status != FileProcessed {
+		t.Errorf("file=%#v error=%v", file, err)
+	}
+
+	// not found
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/files/processed/foo/reprocess", nil))
+	w.Flush()
+	if w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+}
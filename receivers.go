@@ -126,9 +126,9 @@ func (r receiverRequest) missingFields() error {
 	return nil
 }
 
-func AddReceiverRoutes(logger log.Logger, r *mux.Router, ofacClient OFACClient, receiverRepo receiverRepository, depositoryRepo DepositoryRepository) {
+func AddReceiverRoutes(logger log.Logger, r *mux.Router, ofacClient OFACClient, ofacReviewRepo ofacReviewRepository, receiverRepo receiverRepository, depositoryRepo DepositoryRepository) {
 	r.Methods("GET").Path("/receivers").HandlerFunc(getUserReceivers(logger, receiverRepo))
-	r.Methods("POST").Path("/receivers").HandlerFunc(createUserReceiver(logger, ofacClient, receiverRepo, depositoryRepo))
+	r.Methods("POST").Path("/receivers").HandlerFunc(createUserReceiver(logger, ofacClient, ofacReviewRepo, receiverRepo, depositoryRepo))
 
 	r.Methods("GET").Path("/receivers/{receiverId}").HandlerFunc(getUserReceiver(logger, receiverRepo))
 	r.Methods("PATCH").Path("/receivers/{receiverId}").HandlerFunc(updateUserReceiver(logger, receiverRepo))
@@ -180,7 +180,7 @@ func parseAndValidateEmail(raw string) (string, error) {
 	return addr.Address, nil
 }
 
-func createUserReceiver(logger log.Logger, ofacClient OFACClient, receiverRepo receiverRepository, depositoryRepo DepositoryRepository) http.HandlerFunc {
+func createUserReceiver(logger log.Logger, ofacClient OFACClient, ofacReviewRepo ofacReviewRepository, receiverRepo receiverRepository, depositoryRepo DepositoryRepository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w, err := wrapResponseWriter(logger, w, r)
 		if err != nil {
@@ -225,7 +225,7 @@ func createUserReceiver(logger log.Logger, ofacClient OFACClient, receiverRepo r
 		}
 
 		// Check OFAC for receiver/company data
-		if err := rejectViaOFACMatch(logger, ofacClient, receiver.Metadata, userID, requestID); err != nil {
+		if err := rejectViaOFACMatch(logger, ofacClient, ofacReviewRepo, receiver.Metadata, userID, requestID); err != nil {
 			logger.Log("receivers", fmt.Errorf("error with OFAC call: %v", err), "requestID", requestID, "userID", userID)
 			moovhttp.Problem(w, err)
 			return
@@ -365,7 +365,7 @@ func TestReceivers_OFACMatch(t *testing.T) {
 
 		// happy path, no OFAC match
 		client := &testOFACClient{}
-		createUserReceiver(log.NewNopLogger(), client, receiverRepo, depRepo)(w, req)
+		createUserReceiver(log.NewNopLogger(), client, nil, receiverRepo, depRepo)(w, req)
 		w.Flush()
 
 		if w.Code != http.StatusOK {
@@ -378,7 +378,7 @@ func TestReceivers_OFACMatch(t *testing.T) {
 			err: errors.New("blocking"),
 		}
 		req.Body = ioutil.NopCloser(strings.NewReader(rawBody))
-		createUserReceiver(log.NewNopLogger(), client, receiverRepo, depRepo)(w, req)
+		createUserReceiver(log.NewNopLogger(), client, nil, receiverRepo, depRepo)(w, req)
 		w.Flush()
 
 		if w.Code != http.StatusBadRequest {
@@ -443,7 +443,7 @@ func TestReceivers__HTTPGet(t *testing.T) {
 	}
 
 	router := mux.NewRouter()
-	AddReceiverRoutes(log.NewNopLogger(), router, nil, repo, nil)
+	AddReceiverRoutes(log.NewNopLogger(), router, nil, nil, repo, nil)
 
 	req := httptest.NewRequest("GET", fmt.Sprintf("/receivers/%s", rec.ID), nil)
 	req.Header.Set("x-user-id", userID)
@@ -0,0 +1,285 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/moov-io/base"
+	"github.com/moov-io/base/admin"
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+var (
+	// receiverVerificationTTL is how long a Receiver has to confirm their email address.
+	receiverVerificationTTL = func() time.Duration {
+		if v := os.Getenv("RECEIVER_VERIFICATION_TOKEN_TTL"); v != "" {
+			if dur, err := time.ParseDuration(v); err == nil && dur > 0 {
+				return dur
+			}
+		}
+		return 72 * time.Hour
+	}()
+
+	// receiverVerificationKey signs verification tokens. Until SetupReceiverVerificationSecret is called a random
+	// key is used, so tokens are only valid on the paygate instance which issued them until it restarts.
+	receiverVerificationKey = func() []byte {
+		key := make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			panic(fmt.Sprintf("receiver verification key: %v", err))
+		}
+		return key
+	}()
+
+	errReceiverVerificationExpired = errors.New("verification token has expired")
+	errReceiverVerificationInvalid = errors.New("invalid verification token")
+)
+
+// SetupReceiverVerificationSecret sets the secret used to sign Receiver verification tokens. It needs to be the same
+// on every paygate instance, without one a random per-process key is kept and a warning is logged.
+func SetupReceiverVerificationSecret(logger log.Logger, secret string) {
+	if secret == "" {
+		logger.Log("receivers", "WARNING: using a random receiver verification key, tokens are invalid on other instances and after a restart. Set RECEIVER_VERIFICATION_SECRET")
+		return
+	}
+	receiverVerificationKey = []byte(secret)
+}
+
+// receiverVerificationToken returns a token which proves the holder received a message sent to the Receiver's email address.
+// Tokens are formatted as "expires.signature" where signature is an HMAC of the Receiver, user, email and expiration.
+func receiverVerificationToken(receiver *Receiver, userID string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, receiverVerificationKey)
+	mac.Write([]byte(strings.Join([]string{string(receiver.ID), userID, receiver.Email, exp}, "|")))
+	return exp + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func checkReceiverVerificationToken(receiver *Receiver, userID string, token string, now time.Time) error {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return errReceiverVerificationInvalid
+	}
+	exp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return errReceiverVerificationInvalid
+	}
+	expected := receiverVerificationToken(receiver, userID, time.Unix(exp, 0))
+	if !hmac.Equal([]byte(expected), []byte(token)) {
+		return errReceiverVerificationInvalid
+	}
+	if now.After(time.Unix(exp, 0)) {
+		return errReceiverVerificationExpired
+	}
+	return nil
+}
+
+func AddReceiverVerificationRoutes(logger log.Logger, r *mux.Router, notifier Notifier, receiverRepo receiverRepository, eventRepo EventRepository) {
+	r.Methods("POST").Path("/receivers/{receiverId}/verify").HandlerFunc(verifyReceiver(logger, notifier, receiverRepo))
+	r.Methods("POST").Path("/receivers/{receiverId}/confirm").HandlerFunc(confirmReceiver(logger, receiverRepo, eventRepo))
+}
+
+type verifyReceiverResponse struct {
+	Expires time.Time `json:"expires"`
+}
+
+// verifyReceiver sends a signed, expiring token to the Receiver's email address which is confirmed with confirmReceiver.
+func verifyReceiver(logger log.Logger, notifier Notifier, receiverRepo receiverRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		id, userID := getReceiverID(r), moovhttp.GetUserID(r)
+		requestID := moovhttp.GetRequestID(r)
+
+		receiver, err := receiverRepo.getUserReceiver(id, userID)
+		if err != nil {
+			logger.Log("receivers", fmt.Sprintf("problem getting receiver=%s: %v", id, err), "requestID", requestID, "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		if receiver == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if receiver.Status != ReceiverUnverified {
+			moovhttp.Problem(w, fmt.Errorf("receiver %s in bogus status %s", receiver.ID, receiver.Status))
+			return
+		}
+
+		expires := time.Now().Add(receiverVerificationTTL)
+		token := receiverVerificationToken(receiver, userID, expires)
+		body := fmt.Sprintf("Please confirm your email address with the following verification code.\n\n%s\n\nThis code expires at %s.\n", token, expires.Format(time.RFC1123))
+		if err := notifier.Send(receiver.Email, "Verify your email address", body); err != nil {
+			logger.Log("receivers", fmt.Sprintf("problem sending verification for receiver=%s: %v", receiver.ID, err), "requestID", requestID, "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		logger.Log("receivers", fmt.Sprintf("sent verification for receiver=%s", receiver.ID), "requestID", requestID, "userID", userID)
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(verifyReceiverResponse{Expires: expires})
+	}
+}
+
+type confirmReceiverRequest struct {
+	Token string `json:"token"`
+}
+
+// confirmReceiver checks a token from verifyReceiver and moves the Receiver to ReceiverVerified.
+func confirmReceiver(logger log.Logger, receiverRepo receiverRepository, eventRepo EventRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		var req confirmReceiverRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxReadBytes)).Decode(&req); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if req.Token == "" {
+			moovhttp.Problem(w, errors.New("missing token"))
+			return
+		}
+
+		id, userID := getReceiverID(r), moovhttp.GetUserID(r)
+		requestID := moovhttp.GetRequestID(r)
+
+		receiver, err := receiverRepo.getUserReceiver(id, userID)
+		if err != nil {
+			logger.Log("receivers", fmt.Sprintf("problem getting receiver=%s: %v", id, err), "requestID", requestID, "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		if receiver == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if receiver.Status != ReceiverUnverified {
+			moovhttp.Problem(w, fmt.Errorf("receiver %s in bogus status %s", receiver.ID, receiver.Status))
+			return
+		}
+		if err := checkReceiverVerificationToken(receiver, userID, req.Token, time.Now()); err != nil {
+			logger.Log("receivers", fmt.Sprintf("receiver=%s: %v", receiver.ID, err), "requestID", requestID, "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		if err := updateReceiverStatus(receiverRepo, eventRepo, receiver, userID, ReceiverVerified, "email address confirmed"); err != nil {
+			logger.Log("receivers", fmt.Sprintf("problem verifying receiver=%s: %v", receiver.ID, err), "requestID", requestID, "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		logger.Log("receivers", fmt.Sprintf("verified receiver=%s", receiver.ID), "requestID", requestID, "userID", userID)
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(receiver)
+	}
+}
+
+// updateReceiverStatus saves the Receiver's new status and records the change, with its reason, as an event.
+func updateReceiverStatus(receiverRepo receiverRepository, eventRepo EventRepository, receiver *Receiver, userID string, status ReceiverStatus, reason string) error {
+	previous := receiver.Status
+	receiver.Status = status
+	receiver.Updated = base.NewTime(time.Now())
+	if err := receiverRepo.upsertUserReceiver(userID, receiver); err != nil {
+		return err
+	}
+	return eventRepo.writeEvent(userID, &Event{
+		ID:      EventID(base.ID()),
+		Topic:   fmt.Sprintf("receiver %s %s", receiver.ID, status),
+		Message: fmt.Sprintf("status: %s -> %s reason: %s", previous, status, reason),
+		Type:    ReceiverEvent,
+		Payload: eventPayload(receiver),
+		Metadata: map[string]string{
+			eventReceiverID: string(receiver.ID),
+		},
+	})
+}
+
+func AddReceiverAdminRoutes(logger log.Logger, svc *admin.Server, receiverRepo receiverRepository, eventRepo EventRepository) {
+	svc.AddHandler("/receivers/{receiverId}/suspend", changeReceiverStatus(logger, receiverRepo, eventRepo, ReceiverSuspended))
+	svc.AddHandler("/receivers/{receiverId}/deactivate", changeReceiverStatus(logger, receiverRepo, eventRepo, ReceiverDeactivated))
+}
+
+type changeReceiverStatusRequest struct {
+	Reason string `json:"reason"`
+}
+
+// changeReceiverStatus is an http.HandlerFunc for paygate's admin server to suspend or deactivate a Receiver.
+// Deactivated Receivers can't be changed.
+func changeReceiverStatus(logger log.Logger, receiverRepo receiverRepository, eventRepo EventRepository, status ReceiverStatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w = wrap(logger, w, r)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		if r.Method != "POST" {
+			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb: %s", r.Method))
+			return
+		}
+
+		var req changeReceiverStatusRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxReadBytes)).Decode(&req); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if req.Reason == "" {
+			moovhttp.Problem(w, errors.New("missing reason"))
+			return
+		}
+
+		id, userID := getReceiverID(r), moovhttp.GetUserID(r)
+		requestID := moovhttp.GetRequestID(r)
+		if userID == "" {
+			moovhttp.Problem(w, errors.New("missing x-user-id header"))
+			return
+		}
+
+		receiver, err := receiverRepo.getUserReceiver(id, userID)
+		if err != nil {
+			logger.Log("receivers", fmt.Sprintf("admin: problem getting receiver=%s: %v", id, err), "requestID", requestID, "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		if receiver == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if receiver.Status == status || receiver.Status == ReceiverDeactivated {
+			moovhttp.Problem(w, fmt.Errorf("receiver %s in bogus status %s", receiver.ID, receiver.Status))
+			return
+		}
+
+		if err := updateReceiverStatus(receiverRepo, eventRepo, receiver, userID, status, req.Reason); err != nil {
+			logger.Log("receivers", fmt.Sprintf("admin: problem updating receiver=%s: %v", receiver.ID, err), "requestID", requestID, "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		logger.Log("receivers", fmt.Sprintf("admin: receiver=%s is %s: %s", receiver.ID, status, req.Reason), "requestID", requestID, "userID", userID)
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(receiver)
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moov-io/base"
+	"github.com/moov-io/paygate/internal/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestReceivers__verificationToken(t *testing.T) {
+	receiver := &Receiver{ID: ReceiverID(base.ID()), Email: "john@example.com"}
+	userID, now := base.ID(), time.Now()
+
+	token := receiverVerificationToken(receiver, userID, now.Add(time.Hour))
+	if err := checkReceiverVerificationToken(receiver, userID, token, now); err != nil {
+		t.Error(err)
+	}
+	if err := checkReceiverVerificationToken(receiver, userID, token, now.Add(2*time.Hour)); err != errReceiverVerificationExpired {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := checkReceiverVerificationToken(receiver, base.ID(), token, now); err != errReceiverVerificationInvalid {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// tokens can't be modified
+	parts := strings.Split(token, ".")
+	forged := fmt.Sprintf("%d.%s", now.Add(24*time.Hour).Unix(), parts[1])
+	for _, token := range []string{"", "foo", "123.abc", forged} {
+		if err := checkReceiverVerificationToken(receiver, userID, token, now); err != errReceiverVerificationInvalid {
+			t.Errorf("%q: unexpected error: %v", token, err)
+		}
+	}
+
+	// changing the email invalidates tokens
+	receiver.Email = "jane@example.com"
+	if err := checkReceiverVerificationToken(receiver, userID, token, now); err != errReceiverVerificationInvalid {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestReceivers__SetupReceiverVerificationSecret(t *testing.T) {
+	previous := receiverVerificationKey
+	defer func() { receiverVerificationKey = previous }()
+
+	receiver := &Receiver{ID: ReceiverID(base.ID()), Email: "john@example.com"}
+	userID, expires := base.ID(), time.Now().Add(time.Hour)
+
+	// the random key is kept without a secret
+	SetupReceiverVerificationSecret(log.NewNopLogger(), "")
+	token := receiverVerificationToken(receiver, userID, expires)
+	if err := checkReceiverVerificationToken(receiver, userID, token, time.Now()); err != nil {
+		t.Error(err)
+	}
+
+	// tokens signed with the random key aren't valid once the secret is set
+	SetupReceiverVerificationSecret(log.NewNopLogger(), "secret")
+	if err := checkReceiverVerificationToken(receiver, userID, token, time.Now()); err != errReceiverVerificationInvalid {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if string(receiverVerificationKey) != "secret" {
+		t.Errorf("unexpected key: %q", receiverVerificationKey)
+	}
+	token = receiverVerificationToken(receiver, userID, expires)
+	if err := checkReceiverVerificationToken(receiver, userID, token, time.Now()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReceivers__verifyAndConfirm(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	userID := base.ID()
+	receiver := &Receiver{
+		ID:                ReceiverID(base.ID()),
+		Email:             "john@example.com",
+		DefaultDepository: DepositoryID(base.ID()),
+		Status:            ReceiverUnverified,
+	}
+	repo := &mockReceiverRepository{receivers: []*Receiver{receiver}}
+	eventRepo := &SQLEventRepo{db.DB, log.NewNopLogger(), nil}
+	notifier := &testNotifier{}
+
+	router := mux.NewRouter()
+	AddReceiverVerificationRoutes(log.NewNopLogger(), router, notifier, repo, eventRepo)
+
+	call := func(path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/receivers/%s/%s", receiver.ID, path), strings.NewReader(body))
+		req.Header.Set("x-user-id", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	if w := call("verify", ""); w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if notifier.to != "john@example.com" || notifier.subject == "" {
+		t.Errorf("unexpected notification: %#v", notifier)
+	}
+	token := receiverVerificationToken(receiver, userID, time.Now().Add(receiverVerificationTTL))
+	exp := strings.Split(token, ".")[0]
+	if !strings.Contains(notifier.body, exp+".") {
+		t.Skipf("token expiration crossed a second boundary: %s", notifier.body)
+	}
+	lines := strings.Split(notifier.body, "\n")
+	token = lines[2]
+
+	// invalid token
+	if w := call("confirm", `{"token": "123.abc"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if w := call("confirm", `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if receiver.Status != ReceiverUnverified {
+		t.Errorf("unexpected status: %s", receiver.Status)
+	}
+
+	w := call("confirm", fmt.Sprintf(`{"token": %q}`, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if receiver.Status != ReceiverVerified || !strings.Contains(w.Body.String(), `"status":"verified"`) {
+		t.Errorf("status=%s body=%s", receiver.Status, w.Body.String())
+	}
+	events, err := eventRepo.getUserEvents(userID)
+	if err != nil || len(events) != 1 || events[0].Type != ReceiverEvent {
+		t.Errorf("events=%#v error=%v", events, err)
+	}
+
+	// verified receivers can't be verified again
+	if w := call("verify", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if w := call("confirm", fmt.Sprintf(`{"token": %q}`, token)); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+
+	// not found
+	repo.receivers = nil
+	if w := call("verify", ""); w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestReceivers__adminChangeStatus(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	userID := base.ID()
+	receiver := &Receiver{
+		ID:                ReceiverID(base.ID()),
+		Email:             "john@example.com",
+		DefaultDepository: DepositoryID(base.ID()),
+		Status:            ReceiverVerified,
+	}
+	repo := &mockReceiverRepository{receivers: []*Receiver{receiver}}
+	eventRepo := &SQLEventRepo{db.DB, log.NewNopLogger(), nil}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/receivers/{receiverId}/suspend", changeReceiverStatus(log.NewNopLogger(), repo, eventRepo, ReceiverSuspended))
+	router.HandleFunc("/receivers/{receiverId}/deactivate", changeReceiverStatus(log.NewNopLogger(), repo, eventRepo, ReceiverDeactivated))
+
+	call := func(path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/receivers/%s/%s", receiver.ID, path), strings.NewReader(body))
+		req.Header.Set("x-user-id", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	// missing reason
+	if w := call("suspend", `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := call("suspend", `{"reason": "fraud investigation"}`); w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if receiver.Status != ReceiverSuspended {
+		t.Errorf("unexpected status: %s", receiver.Status)
+	}
+	events, err := eventRepo.getUserEvents(userID)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("events=%#v error=%v", events, err)
+	}
+	if !strings.Contains(events[0].Message, "verified -> suspended reason: fraud investigation") {
+		t.Errorf("unexpected event: %#v", events[0])
+	}
+
+	// already suspended
+	if w := call("suspend", `{"reason": "fraud investigation"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := call("deactivate", `{"reason": "account closed"}`); w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if receiver.Status != ReceiverDeactivated {
+		t.Errorf("unexpected status: %s", receiver.Status)
+	}
+
+	// deactivated receivers can't be changed
+	if w := call("suspend", `{"reason": "other"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+
+	// wrong HTTP verb
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/receivers/%s/suspend", receiver.ID), nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,694 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/base"
+	"github.com/moov-io/base/admin"
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// reconciliationInterval is how often the previous business day is reconciled.
+	// RECONCILIATION_INTERVAL=off disables reconciliation.
+	reconciliationInterval = func() time.Duration {
+		v := os.Getenv("RECONCILIATION_INTERVAL")
+		if strings.EqualFold(v, "off") {
+			return 0
+		}
+		if dur, err := time.ParseDuration(v); err == nil && dur > 0 {
+			return dur
+		}
+		return 24 * time.Hour
+	}()
+
+	reconciliationDiscrepancies = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Name: "reconciliation_discrepancies",
+		Help: "Counter of Transfers which didn't reconcile against Accounts or uploaded files",
+	}, []string{"kind"})
+
+	// reconciliationTransactionLimit is how many transactions are first read from an account when looking for a Transfer's
+	// posting. More are read until they go back past the reconciled day.
+	reconciliationTransactionLimit = 1000
+)
+
+const reconciliationDateFormat = "2006-01-02"
+
+// ReconciliationKind is the type of discrepancy found for a Transfer.
+type ReconciliationKind string
+
+const (
+	// ReconciliationMissingPosting is a Transfer without a transaction in Accounts
+	ReconciliationMissingPosting ReconciliationKind = "missing_posting"
+	// ReconciliationAmountMismatch is a Transfer whose transaction lines don't match its amount
+	ReconciliationAmountMismatch ReconciliationKind = "amount_mismatch"
+	// ReconciliationMissingFromFile is a Transfer which wasn't merged into a file, or whose file wasn't uploaded
+	ReconciliationMissingFromFile ReconciliationKind = "missing_from_file"
+	// ReconciliationMissingReversal is a reclaimed Transfer whose transaction wasn't reversed
+	ReconciliationMissingReversal ReconciliationKind = "missing_reversal"
+	// ReconciliationDoubleReversal is a reclaimed Transfer whose transaction was reversed more than once
+	ReconciliationDoubleReversal ReconciliationKind = "double_reversal"
+	// ReconciliationUnexpectedReversal is a processed (not returned) Transfer whose transaction was reversed
+	ReconciliationUnexpectedReversal ReconciliationKind = "unexpected_reversal"
+	// ReconciliationError is a Transfer which couldn't be checked
+	ReconciliationError ReconciliationKind = "error"
+)
+
+// Reconciliation is one run comparing a business day's processed and reclaimed Transfers against their
+// transactions in Accounts and the ACH files which were uploaded.
+type Reconciliation struct {
+	ID string `json:"id"`
+	// Date is the business day (YYYY-MM-DD) whose Transfers were checked
+	Date     string    `json:"date"`
+	Started  base.Time `json:"started"`
+	Finished base.Time `json:"finished"`
+
+	// Transfers is how many Transfers were checked and Amount is their sum
+	Transfers int    `json:"transfers"`
+	Amount    Amount `json:"amount"`
+
+	// Discrepancies is how many problems were found, a Transfer can have several
+	Discrepancies int `json:"discrepancies"`
+	// Errors is how many Transfers couldn't be checked
+	Errors int `json:"errors"`
+
+	// Totals is the count and sum of Transfer amounts for each kind of discrepancy
+	Totals map[ReconciliationKind]*ReconciliationTotal `json:"totals"`
+
+	Results []*ReconciliationDiscrepancy `json:"results"`
+}
+
+type ReconciliationTotal struct {
+	Count  int    `json:"count"`
+	Amount Amount `json:"amount"`
+}
+
+// ReconciliationDiscrepancy is a Transfer which didn't reconcile.
+type ReconciliationDiscrepancy struct {
+	Kind       ReconciliationKind `json:"kind"`
+	TransferID TransferID         `json:"transferId"`
+	UserID     string             `json:"userId"`
+	Status     TransferStatus     `json:"status"`
+	Amount     Amount             `json:"amount"`
+
+	TransactionID string `json:"transactionId,omitempty"`
+	Filename      string `json:"filename,omitempty"`
+
+	Message string `json:"message"`
+}
+
+// addDiscrepancy records a problem with xfer on the run.
+func (run *Reconciliation) addDiscrepancy(kind ReconciliationKind, xfer *reconciliationTransfer, msg string) {
+	if kind == ReconciliationError {
+		run.Errors++
+	} else {
+		run.Discrepancies++
+	}
+	reconciliationDiscrepancies.With("kind", string(kind)).Add(1)
+	run.Results = append(run.Results, &ReconciliationDiscrepancy{
+		Kind:          kind,
+		TransferID:    xfer.id,
+		UserID:        xfer.userID,
+		Status:        xfer.status,
+		Amount:        xfer.amount,
+		TransactionID: xfer.transactionID,
+		Filename:      xfer.mergedFilename,
+		Message:       msg,
+	})
+}
+
+// summarize computes Totals from the run's Results.
+func (run *Reconciliation) summarize() {
+	sums := make(map[ReconciliationKind]int)
+	run.Totals = make(map[ReconciliationKind]*ReconciliationTotal)
+	for _, res := range run.Results {
+		if run.Totals[res.Kind] == nil {
+			run.Totals[res.Kind] = &ReconciliationTotal{}
+		}
+		run.Totals[res.Kind].Count++
+		sums[res.Kind] += res.Amount.Int()
+	}
+	for kind, sum := range sums {
+		run.Totals[kind].Amount = amountFromCents(sum)
+	}
+}
+
+// reconciliationTransfer is a Transfer and the references reconciliation checks.
+type reconciliationTransfer struct {
+	id                   TransferID
+	userID               string
+	amount               Amount
+	status               TransferStatus
+	originatorDepository DepositoryID
+	transactionID        string
+	mergedFilename       string
+
+	// reversalTransactionID is the transaction which reversed the Transfer's posting, if paygate reversed it
+	reversalTransactionID string
+
+	// posting is the Transfer's transaction found in Accounts
+	posting  *accounts.Transaction
+	postings []accounts.Transaction
+}
+
+// Reconciler checks each business day that processed Transfers were posted to Accounts (with their amount),
+// were merged into a file which was uploaded and that reclaimed Transfers were reversed exactly once.
+type Reconciler struct {
+	logger         log.Logger
+	accountsClient AccountsClient
+
+	repo           reconciliationRepository
+	uploadRepo     fileUploadRepository
+	depositoryRepo DepositoryRepository
+
+	mu sync.Mutex // only one reconciliation runs at a time
+}
+
+func NewReconciler(
+	logger log.Logger,
+	accountsClient AccountsClient,
+	repo reconciliationRepository,
+	uploadRepo fileUploadRepository,
+	depositoryRepo DepositoryRepository,
+) *Reconciler {
+	return &Reconciler{
+		logger:         logger,
+		accountsClient: accountsClient,
+		repo:           repo,
+		uploadRepo:     uploadRepo,
+		depositoryRepo: depositoryRepo,
+	}
+}
+
+// Start reconciles the previous business day every RECONCILIATION_INTERVAL until ctx is finished.
+func (r *Reconciler) Start(ctx context.Context) {
+	if reconciliationInterval == 0 {
+		r.logger.Log("reconciliation", "disabling reconciliation via config (RECONCILIATION_INTERVAL)")
+		return
+	}
+	r.logger.Log("reconciliation", fmt.Sprintf("reconciling Transfers every %v", reconciliationInterval))
+
+	tick := time.NewTicker(reconciliationInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			if _, err := r.reconcile(previousBankingDay(time.Now())); err != nil {
+				r.logger.Log("reconciliation", fmt.Sprintf("ERROR: reconciling: %v", err))
+			}
+
+		case <-ctx.Done():
+			r.logger.Log("reconciliation", "Shutting down due to context.Done()")
+			return
+		}
+	}
+}
+
+// previousBankingDay returns the latest banking day before now.
+func previousBankingDay(now time.Time) time.Time {
+	day := base.NewTime(now)
+	for {
+		day.Time = day.Time.AddDate(0, 0, -1)
+		if day.IsBankingDay() {
+			return day.Time
+		}
+	}
+}
+
+// reconcile checks every processed and reclaimed Transfer created on day.
+func (r *Reconciler) reconcile(day time.Time) (*Reconciliation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	run := &Reconciliation{
+		ID:      base.ID(),
+		Date:    start.Format(reconciliationDateFormat),
+		Started: base.NewTime(time.Now()),
+	}
+	xfers, err := r.repo.getReconciliationTransfers(start, start.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, fmt.Errorf("problem reading transfers to reconcile: %v", err)
+	}
+
+	sum := 0
+	transactions := make(map[string][]accounts.Transaction) // account ID to its transactions
+	for i := range xfers {
+		run.Transfers++
+		sum += xfers[i].amount.Int()
+
+		r.checkFile(run, xfers[i])
+		if r.accountsClient != nil {
+			r.checkPosting(run, xfers[i], start, transactions)
+		}
+	}
+	if r.accountsClient != nil {
+		var ids []string
+		for i := range transactions {
+			for j := range transactions[i] {
+				ids = append(ids, transactions[i][j].ID)
+			}
+		}
+		transferTransactions, err := r.repo.getTransferTransactions(ids)
+		if err != nil {
+			return nil, fmt.Errorf("problem reading transfer transactions: %v", err)
+		}
+		checkReversals(run, xfers, transferTransactions)
+	}
+	run.Amount = amountFromCents(sum)
+	run.summarize()
+	run.Finished = base.NewTime(time.Now())
+
+	r.logger.Log("reconciliation", fmt.Sprintf("reconciliation=%s date=%s transfers=%d discrepancies=%d errors=%d", run.ID, run.Date, run.Transfers, run.Discrepancies, run.Errors))
+	return run, r.repo.saveReconciliation(run)
+}
+
+// checkFile verifies xfer was merged into a file which was uploaded.
+func (r *Reconciler) checkFile(run *Reconciliation, xfer *reconciliationTransfer) {
+	if xfer.mergedFilename == "" {
+		run.addDiscrepancy(ReconciliationMissingFromFile, xfer, "transfer was not merged into a file")
+		return
+	}
+	upload, err := r.uploadRepo.getFileUpload(xfer.mergedFilename)
+	if err != nil {
+		run.addDiscrepancy(ReconciliationError, xfer, fmt.Sprintf("problem reading upload of %s: %v", xfer.mergedFilename, err))
+		return
+	}
+	if upload == nil {
+		run.addDiscrepancy(ReconciliationMissingFromFile, xfer, fmt.Sprintf("file %s was not uploaded", xfer.mergedFilename))
+	}
+}
+
+// checkPosting finds xfer's transaction in Accounts and verifies each line is for the Transfer's amount.
+// Transactions read from Accounts (back to since) are kept in cache so each account is only read once per run.
+func (r *Reconciler) checkPosting(run *Reconciliation, xfer *reconciliationTransfer, since time.Time, cache map[string][]accounts.Transaction) {
+	if xfer.transactionID == "" {
+		run.addDiscrepancy(ReconciliationMissingPosting, xfer, "transfer has no transaction")
+		return
+	}
+	dep, err := r.depositoryRepo.getUserDepository(xfer.originatorDepository, xfer.userID)
+	if err != nil || dep == nil {
+		run.addDiscrepancy(ReconciliationError, xfer, fmt.Sprintf("problem reading originator depository=%s: %v", xfer.originatorDepository, err))
+		return
+	}
+	account, err := r.accountsClient.SearchAccounts(run.ID, xfer.userID, dep)
+	if err != nil || account == nil {
+		run.addDiscrepancy(ReconciliationError, xfer, fmt.Sprintf("problem reading account for depository=%s: %v", dep.ID, err))
+		return
+	}
+	postings, ok := cache[account.ID]
+	if !ok {
+		postings, err = r.readAccountTransactions(run.ID, xfer.userID, account.ID, since)
+		if err != nil {
+			run.addDiscrepancy(ReconciliationError, xfer, fmt.Sprintf("problem reading transactions for account=%s: %v", account.ID, err))
+			return
+		}
+		cache[account.ID] = postings
+	}
+	xfer.postings = postings
+	for i := range xfer.postings {
+		if xfer.postings[i].ID == xfer.transactionID {
+			xfer.posting = &xfer.postings[i]
+			break
+		}
+	}
+	if xfer.posting == nil {
+		run.addDiscrepancy(ReconciliationMissingPosting, xfer, fmt.Sprintf("transaction not found on account=%s", account.ID))
+		return
+	}
+	for _, line := range xfer.posting.Lines {
+		if int(line.Amount) != xfer.amount.Int() {
+			run.addDiscrepancy(ReconciliationAmountMismatch, xfer, fmt.Sprintf("account=%s %s line is %d cents, transfer is %d cents", line.AccountID, line.Purpose, int(line.Amount), xfer.amount.Int()))
+			return
+		}
+	}
+}
+
+// readAccountTransactions returns the transactions posted against accountID since (and any before). Accounts only
+// returns the most recent transactions, so the limit is doubled until the oldest is from before since.
+func (r *Reconciler) readAccountTransactions(requestID, userID, accountID string, since time.Time) ([]accounts.Transaction, error) {
+	for limit := reconciliationTransactionLimit; ; limit *= 2 {
+		transactions, err := r.accountsClient.GetAccountTransactions(requestID, userID, accountID, limit)
+		if err != nil || len(transactions) < limit {
+			return transactions, err
+		}
+		for i := range transactions {
+			if transactions[i].Timestamp.Before(since) {
+				return transactions, nil
+			}
+		}
+	}
+}
+
+// checkReversals matches reversals (transactions which undo a posting) to the Transfers they reverse.
+// Reclaimed Transfers should have exactly one reversal and processed Transfers none.
+//
+// Reclaimed Transfers are matched to the reversal saved when paygate reversed their transaction. Any other
+// transaction which undoes a Transfer's posting is an extra reversal, unless it's the posting (or saved reversal)
+// of a Transfer from any day, which transferTransactions holds.
+func checkReversals(run *Reconciliation, xfers []*reconciliationTransfer, transferTransactions map[string]bool) {
+	claimed := make(map[string]bool)
+	for i := range xfers {
+		if xfers[i].posting == nil || xfers[i].status != TransferReclaimed {
+			continue
+		}
+		var reversal *accounts.Transaction
+		for j := range xfers[i].postings {
+			if xfers[i].reversalTransactionID != "" && xfers[i].postings[j].ID == xfers[i].reversalTransactionID {
+				reversal = &xfers[i].postings[j]
+				break
+			}
+		}
+		if reversal == nil || !isReversal(xfers[i].posting, reversal) {
+			run.addDiscrepancy(ReconciliationMissingReversal, xfers[i], "returned transfer's transaction was not reversed")
+			continue
+		}
+		claimed[reversal.ID] = true
+	}
+
+	claim := func(xfer *reconciliationTransfer) *accounts.Transaction {
+		for i := range xfer.postings {
+			candidate := &xfer.postings[i]
+			if claimed[candidate.ID] || transferTransactions[candidate.ID] {
+				continue
+			}
+			if isReversal(xfer.posting, candidate) {
+				claimed[candidate.ID] = true
+				return candidate
+			}
+		}
+		return nil
+	}
+	for i := range xfers {
+		if xfers[i].posting == nil {
+			continue
+		}
+		for extra := claim(xfers[i]); extra != nil; extra = claim(xfers[i]) {
+			if xfers[i].status == TransferReclaimed {
+				run.addDiscrepancy(ReconciliationDoubleReversal, xfers[i], fmt.Sprintf("transaction reversed again by transaction=%s", extra.ID))
+			} else {
+				run.addDiscrepancy(ReconciliationUnexpectedReversal, xfers[i], fmt.Sprintf("transaction reversed by transaction=%s without a return", extra.ID))
+			}
+		}
+	}
+}
+
+// isReversal returns true if candidate was posted after posting and has the same lines with their purposes swapped.
+func isReversal(posting *accounts.Transaction, candidate *accounts.Transaction) bool {
+	if posting.ID == candidate.ID || candidate.Timestamp.Before(posting.Timestamp) || len(posting.Lines) != len(candidate.Lines) {
+		return false
+	}
+	used := make([]bool, len(candidate.Lines))
+	for _, line := range posting.Lines {
+		found := false
+		for j, other := range candidate.Lines {
+			if used[j] || other.AccountID != line.AccountID || other.Amount != line.Amount || other.Purpose == line.Purpose {
+				continue
+			}
+			used[j], found = true, true
+			break
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func AddReconciliationRoutes(logger log.Logger, svc *admin.Server, reconciler *Reconciler) {
+	svc.AddHandler("/reconciliations", reconciliations(logger, reconciler))
+}
+
+// reconciliations is an http.HandlerFunc for paygate's admin server. GET lists recent reconciliations with their
+// discrepancies (newest first, ?limit=N and ?date=YYYY-MM-DD) and POST reconciles a business day (?date=YYYY-MM-DD,
+// defaulting to the previous business day), returning the report.
+func reconciliations(logger log.Logger, reconciler *Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w = wrap(logger, w, r)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		requestID := moovhttp.GetRequestID(r)
+		date := r.URL.Query().Get("date")
+		if date != "" {
+			if _, err := time.Parse(reconciliationDateFormat, date); err != nil {
+				moovhttp.Problem(w, fmt.Errorf("invalid date: %q", date))
+				return
+			}
+		}
+		switch r.Method {
+		case "GET":
+			limit := 10
+			if v := r.URL.Query().Get("limit"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n <= 0 {
+					moovhttp.Problem(w, fmt.Errorf("invalid limit: %q", v))
+					return
+				}
+				limit = n
+			}
+			runs, err := reconciler.repo.getReconciliations(date, limit)
+			if err != nil {
+				logger.Log("reconciliation", fmt.Sprintf("admin: problem reading reconciliations: %v", err), "requestID", requestID)
+				moovhttp.Problem(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(runs)
+
+		case "POST":
+			day := previousBankingDay(time.Now())
+			if date != "" {
+				day, _ = time.Parse(reconciliationDateFormat, date)
+			}
+			run, err := reconciler.reconcile(day)
+			if err != nil {
+				logger.Log("reconciliation", fmt.Sprintf("admin: problem reconciling: %v", err), "requestID", requestID)
+				moovhttp.Problem(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(run)
+
+		default:
+			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb: %s", r.Method))
+		}
+	}
+}
+
+type reconciliationRepository interface {
+	// getReconciliationTransfers returns processed and reclaimed Transfers created between start (inclusive) and end.
+	getReconciliationTransfers(start, end time.Time) ([]*reconciliationTransfer, error)
+
+	// getTransferTransactions returns which of transactionIDs are the posting or saved reversal of any Transfer.
+	getTransferTransactions(transactionIDs []string) (map[string]bool, error)
+
+	saveReconciliation(run *Reconciliation) error
+
+	// getReconciliations returns the most recent runs, optionally only those for date (YYYY-MM-DD).
+	getReconciliations(date string, limit int) ([]*Reconciliation, error)
+}
+
+func NewReconciliationRepo(logger log.Logger, db *sql.DB) *SQLReconciliationRepo {
+	return &SQLReconciliationRepo{log: logger, db: db}
+}
+
+type SQLReconciliationRepo struct {
+	db  *sql.DB
+	log log.Logger
+}
+
+func (r *SQLReconciliationRepo) Close() error {
+	return r.db.Close()
+}
+
+func (r *SQLReconciliationRepo) getReconciliationTransfers(start, end time.Time) ([]*reconciliationTransfer, error) {
+	query := `select transfer_id, user_id, amount, status, originator_depository, coalesce(transaction_id, ''), coalesce(merged_filename, ''), coalesce(reversal_transaction_id, '') from transfers
+where created_at >= ? and created_at < ? and status in (?, ?) and deleted_at is null order by created_at asc`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(start, end, TransferProcessed, TransferReclaimed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var xfers []*reconciliationTransfer
+	for rows.Next() {
+		xfer := &reconciliationTransfer{}
+		var amt string
+		if err := rows.Scan(&xfer.id, &xfer.userID, &amt, &xfer.status, &xfer.originatorDepository, &xfer.transactionID, &xfer.mergedFilename, &xfer.reversalTransactionID); err != nil {
+			return nil, err
+		}
+		if err := xfer.amount.FromString(amt); err != nil {
+			return nil, fmt.Errorf("transfer=%s: %v", xfer.id, err)
+		}
+		xfers = append(xfers, xfer)
+	}
+	return xfers, rows.Err()
+}
+
+func (r *SQLReconciliationRepo) getTransferTransactions(transactionIDs []string) (map[string]bool, error) {
+	out := make(map[string]bool)
+	for len(transactionIDs) > 0 {
+		// query in batches to stay under the database's limit of parameters
+		batch := transactionIDs
+		if len(batch) > 250 {
+			batch = batch[:250]
+		}
+		transactionIDs = transactionIDs[len(batch):]
+
+		params := "?" + strings.Repeat(", ?", len(batch)-1)
+		query := fmt.Sprintf(`select coalesce(transaction_id, ''), coalesce(reversal_transaction_id, '') from transfers
+where transaction_id in (%s) or reversal_transaction_id in (%s)`, params, params)
+		args := make([]interface{}, 0, 2*len(batch))
+		for i := range batch {
+			args = append(args, batch[i])
+		}
+		args = append(args, args...)
+
+		rows, err := r.db.Query(query, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var posting, reversal string
+			if err := rows.Scan(&posting, &reversal); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			out[posting], out[reversal] = true, true
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	delete(out, "")
+	return out, nil
+}
+
+func (r *SQLReconciliationRepo) saveReconciliation(run *Reconciliation) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	query := `insert into reconciliations (reconciliation_id, date, transfers, amount, discrepancies, errors, started_at, finished_at) values (?, ?, ?, ?, ?, ?, ?, ?)`
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("saveReconciliation: prepare error=%v rollback=%v", err, tx.Rollback())
+	}
+	_, err = stmt.Exec(run.ID, run.Date, run.Transfers, run.Amount.Int(), run.Discrepancies, run.Errors, run.Started.Time, run.Finished.Time)
+	stmt.Close()
+	if err != nil {
+		return fmt.Errorf("saveReconciliation: exec error=%v rollback=%v", err, tx.Rollback())
+	}
+
+	query = `insert into reconciliation_discrepancies (reconciliation_id, kind, transfer_id, user_id, status, amount, transaction_id, filename, message) values (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	stmt, err = tx.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("saveReconciliation: prepare error=%v rollback=%v", err, tx.Rollback())
+	}
+	defer stmt.Close()
+	for _, res := range run.Results {
+		_, err := stmt.Exec(run.ID, res.Kind, res.TransferID, res.UserID, res.Status, res.Amount.Int(), res.TransactionID, res.Filename, res.Message)
+		if err != nil {
+			return fmt.Errorf("saveReconciliation: transfer=%s error=%v rollback=%v", res.TransferID, err, tx.Rollback())
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *SQLReconciliationRepo) getReconciliations(date string, limit int) ([]*Reconciliation, error) {
+	query, args := `select reconciliation_id, date, transfers, amount, discrepancies, errors, started_at, finished_at from reconciliations order by started_at desc limit ?`, []interface{}{limit}
+	if date != "" {
+		query, args = `select reconciliation_id, date, transfers, amount, discrepancies, errors, started_at, finished_at from reconciliations where date = ? order by started_at desc limit ?`, []interface{}{date, limit}
+	}
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []*Reconciliation
+	for rows.Next() {
+		run := &Reconciliation{}
+		var (
+			amt               int
+			started, finished time.Time
+		)
+		if err := rows.Scan(&run.ID, &run.Date, &run.Transfers, &amt, &run.Discrepancies, &run.Errors, &started, &finished); err != nil {
+			return nil, err
+		}
+		run.Amount = amountFromCents(amt)
+		run.Started, run.Finished = base.NewTime(started), base.NewTime(finished)
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range runs {
+		if runs[i].Results, err = r.getDiscrepancies(runs[i].ID); err != nil {
+			return nil, err
+		}
+		runs[i].summarize()
+	}
+	return runs, nil
+}
+
+func (r *SQLReconciliationRepo) getDiscrepancies(id string) ([]*ReconciliationDiscrepancy, error) {
+	query := `select kind, transfer_id, user_id, status, amount, transaction_id, filename, message from reconciliation_discrepancies where reconciliation_id = ?`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*ReconciliationDiscrepancy
+	for rows.Next() {
+		res := &ReconciliationDiscrepancy{}
+		var amt int
+		if err := rows.Scan(&res.Kind, &res.TransferID, &res.UserID, &res.Status, &amt, &res.TransactionID, &res.Filename, &res.Message); err != nil {
+			return nil, err
+		}
+		res.Amount = amountFromCents(amt)
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
@@ -0,0 +1,249 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/base"
+	"github.com/moov-io/paygate/internal/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestReconciliation__previousBankingDay(t *testing.T) {
+	monday := time.Date(2019, time.November, 18, 10, 0, 0, 0, time.UTC)
+	if day := previousBankingDay(monday); day.Weekday() != time.Friday || day.Day() != 15 {
+		t.Errorf("unexpected day: %v", day)
+	}
+	// Thanksgiving is skipped
+	friday := time.Date(2019, time.November, 29, 10, 0, 0, 0, time.UTC)
+	if day := previousBankingDay(friday); day.Day() != 27 {
+		t.Errorf("unexpected day: %v", day)
+	}
+}
+
+func TestReconciliation__checkReversals(t *testing.T) {
+	now := time.Now()
+	posting := accounts.Transaction{ID: "posted", Timestamp: now, Lines: []accounts.TransactionLine{
+		{AccountID: "a", Purpose: ledgerDebit, Amount: 100},
+		{AccountID: "b", Purpose: ledgerCredit, Amount: 100},
+	}}
+	reversal := func(id string) accounts.Transaction {
+		return accounts.Transaction{ID: id, Timestamp: now.Add(time.Minute), Lines: []accounts.TransactionLine{
+			{AccountID: "b", Purpose: ledgerDebit, Amount: 100},
+			{AccountID: "a", Purpose: ledgerCredit, Amount: 100},
+		}}
+	}
+	first, second := reversal("first"), reversal("second")
+	if !isReversal(&posting, &first) {
+		t.Error("expected reversal")
+	}
+	if isReversal(&posting, &posting) {
+		t.Error("a transaction doesn't reverse itself")
+	}
+	earlier := reversal("earlier")
+	earlier.Timestamp = now.Add(-1 * time.Minute)
+	if isReversal(&posting, &earlier) {
+		t.Error("reversals come after the posting")
+	}
+
+	amt, _ := NewAmount("USD", "1.00")
+	xfer := func(status TransferStatus, reversalID string, postings ...accounts.Transaction) *reconciliationTransfer {
+		return &reconciliationTransfer{
+			id:                    TransferID(base.ID()),
+			amount:                *amt,
+			status:                status,
+			transactionID:         posting.ID,
+			reversalTransactionID: reversalID,
+			posting:               &posting,
+			postings:              postings,
+		}
+	}
+	transferTransactions := map[string]bool{posting.ID: true, first.ID: true}
+
+	run := &Reconciliation{}
+	checkReversals(run, []*reconciliationTransfer{xfer(TransferReclaimed, first.ID, posting, first)}, transferTransactions)
+	if run.Discrepancies != 0 {
+		t.Errorf("unexpected results: %#v", run.Results)
+	}
+
+	run = &Reconciliation{}
+	checkReversals(run, []*reconciliationTransfer{xfer(TransferReclaimed, "", posting)}, transferTransactions)
+	if run.Discrepancies != 1 || run.Results[0].Kind != ReconciliationMissingReversal {
+		t.Errorf("unexpected results: %#v", run.Results)
+	}
+
+	// the saved reversal wasn't found in Accounts, so another transaction doesn't count
+	run = &Reconciliation{}
+	checkReversals(run, []*reconciliationTransfer{xfer(TransferReclaimed, "missing", posting, second)}, transferTransactions)
+	if run.Discrepancies != 2 || run.Results[0].Kind != ReconciliationMissingReversal || run.Results[1].Kind != ReconciliationDoubleReversal {
+		t.Errorf("unexpected results: %#v", run.Results)
+	}
+
+	run = &Reconciliation{}
+	checkReversals(run, []*reconciliationTransfer{xfer(TransferReclaimed, first.ID, posting, first, second)}, transferTransactions)
+	if run.Discrepancies != 1 || run.Results[0].Kind != ReconciliationDoubleReversal {
+		t.Errorf("unexpected results: %#v", run.Results)
+	}
+
+	run = &Reconciliation{}
+	checkReversals(run, []*reconciliationTransfer{xfer(TransferProcessed, "", posting, second)}, transferTransactions)
+	if run.Discrepancies != 1 || run.Results[0].Kind != ReconciliationUnexpectedReversal {
+		t.Errorf("unexpected results: %#v", run.Results)
+	}
+
+	// postings and reversals of other Transfers (from any day) aren't reversals of this Transfer
+	run = &Reconciliation{}
+	checkReversals(run, []*reconciliationTransfer{xfer(TransferProcessed, "", posting, first)}, transferTransactions)
+	if run.Discrepancies != 0 {
+		t.Errorf("unexpected results: %#v", run.Results)
+	}
+	run = &Reconciliation{}
+	checkReversals(run, []*reconciliationTransfer{xfer(TransferProcessed, "", posting, first), xfer(TransferReclaimed, first.ID, posting, first)}, transferTransactions)
+	if run.Discrepancies != 0 {
+		t.Errorf("unexpected results: %#v", run.Results)
+	}
+}
+
+func TestReconciler(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	logger := log.NewNopLogger()
+	ledger := NewLedger(logger, db.DB)
+	transferRepo := NewTransferRepo(logger, db.DB)
+	uploadRepo := NewFileUploadRepo(logger, db.DB)
+
+	userID := base.ID()
+	origDep := &Depository{ID: DepositoryID(base.ID()), RoutingNumber: "121042882", AccountNumber: "151", Type: Checking}
+	recDep := &Depository{ID: DepositoryID(base.ID()), RoutingNumber: "231380104", AccountNumber: "251", Type: Checking}
+	origAcct, _ := ledger.SearchAccounts("", userID, origDep)
+	recAcct, _ := ledger.SearchAccounts("", userID, recDep)
+
+	post := func(cents int) string {
+		t.Helper()
+		amt, _ := NewAmountFromInt("USD", cents)
+		tx, err := ledger.PostTransaction("", userID, createTransactionLines(origAcct, recAcct, *amt, PushTransfer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx.ID
+	}
+	create := func(cents int, transactionID string, filename string, status TransferStatus) TransferID {
+		t.Helper()
+		amt, _ := NewAmountFromInt("USD", cents)
+		xfers, err := transferRepo.createUserTransfers(userID, []*transferRequest{{
+			Type:                   PushTransfer,
+			Amount:                 *amt,
+			Originator:             OriginatorID(base.ID()),
+			OriginatorDepository:   origDep.ID,
+			Receiver:               ReceiverID(base.ID()),
+			ReceiverDepository:     recDep.ID,
+			Description:            "payroll",
+			StandardEntryClassCode: "PPD",
+			transactionID:          transactionID,
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := xfers[0].ID
+		if filename != "" {
+			if err := transferRepo.markTransferAsMerged(id, filename, "121042880000001"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := transferRepo.updateTransferStatus(id, status); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	if err := uploadRepo.recordFileUpload(&fileUpload{Filename: "uploaded.ach", Uploaded: base.NewTime(time.Now())}); err != nil {
+		t.Fatal(err)
+	}
+
+	create(1000, post(1000), "uploaded.ach", TransferProcessed)              // reconciles
+	create(1000, "", "uploaded.ach", TransferProcessed)                      // missing posting
+	mismatched := create(1000, post(500), "uploaded.ach", TransferProcessed) // amount mismatch
+	unsent := create(1000, post(1000), "missing.ach", TransferProcessed)     // missing from file
+	returned := post(700)
+	reversal, err := ledger.ReverseTransaction("", userID, returned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reclaimed := create(700, returned, "uploaded.ach", TransferReclaimed) // reversed once
+	if err := transferRepo.setReversalTransactionID(reclaimed, reversal.ID); err != nil {
+		t.Fatal(err)
+	}
+	unreversed := create(800, post(800), "uploaded.ach", TransferReclaimed) // missing reversal
+	create(900, post(900), "uploaded.ach", TransferPending)                 // not checked
+
+	// transactions are read until they go back past the reconciled day
+	limit := reconciliationTransactionLimit
+	reconciliationTransactionLimit = 2
+	defer func() { reconciliationTransactionLimit = limit }()
+
+	reconciler := NewReconciler(logger, ledger, NewReconciliationRepo(logger, db.DB), uploadRepo, &mockDepositoryRepository{depositories: []*Depository{origDep}})
+
+	router := mux.NewRouter()
+	router.HandleFunc("/reconciliations", reconciliations(logger, reconciler))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/reconciliations?date="+time.Now().UTC().Format(reconciliationDateFormat), nil))
+	w.Flush()
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var run Reconciliation
+	if err := json.NewDecoder(w.Body).Decode(&run); err != nil {
+		t.Fatal(err)
+	}
+	if run.Transfers != 6 || run.Amount.Int() != 5500 || run.Discrepancies != 4 || run.Errors != 0 {
+		t.Errorf("unexpected reconciliation: %#v", run)
+	}
+	kinds := make(map[ReconciliationKind]TransferID)
+	for _, res := range run.Results {
+		kinds[res.Kind] = res.TransferID
+	}
+	if kinds[ReconciliationAmountMismatch] != mismatched || kinds[ReconciliationMissingFromFile] != unsent || kinds[ReconciliationMissingReversal] != unreversed {
+		t.Errorf("unexpected results: %#v", kinds)
+	}
+	if total := run.Totals[ReconciliationMissingPosting]; total == nil || total.Count != 1 || total.Amount.Int() != 1000 {
+		t.Errorf("unexpected total: %#v", total)
+	}
+
+	// read the report
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/reconciliations?date="+run.Date, nil))
+	w.Flush()
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var runs []*Reconciliation
+	if err := json.NewDecoder(w.Body).Decode(&runs); err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].ID != run.ID || len(runs[0].Results) != 4 || runs[0].Totals[ReconciliationAmountMismatch].Count != 1 {
+		t.Errorf("unexpected reconciliations: %#v", runs)
+	}
+
+	// other days have nothing to reconcile
+	next, err := reconciler.reconcile(time.Now().AddDate(0, 0, 1))
+	if err != nil || next.Transfers != 0 || next.Discrepancies != 0 {
+		t.Errorf("reconciliation=%#v error=%v", next, err)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/reconciliations?date=yesterday", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
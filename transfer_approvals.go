@@ -0,0 +1,316 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/moov-io/base"
+	"github.com/moov-io/base/admin"
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/prometheus"
+	"github.com/gorilla/mux"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// transferApprovalThreshold is the amount at or above which a Transfer needs to be approved by another user
+	// before it's merged into an ACH file. A nil value disables transfer approvals.
+	transferApprovalThreshold = func() *Amount {
+		v := os.Getenv("TRANSFER_APPROVAL_THRESHOLD")
+		if v == "" {
+			return nil
+		}
+		var amt Amount
+		if err := amt.FromString(v); err != nil {
+			panic(fmt.Sprintf("invalid TRANSFER_APPROVAL_THRESHOLD=%q: %v", v, err))
+		}
+		return &amt
+	}()
+
+	// transferApprovalExpiry is how long a Transfer can await approval before it's canceled.
+	transferApprovalExpiry = func() time.Duration {
+		if dur, err := time.ParseDuration(os.Getenv("TRANSFER_APPROVAL_EXPIRY")); err == nil && dur > 0 {
+			return dur
+		}
+		return 72 * time.Hour
+	}()
+
+	transferApprovalsExpired = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Name: "transfer_approvals_expired",
+		Help: "Counter of Transfers canceled after awaiting approval for longer than TRANSFER_APPROVAL_EXPIRY",
+	}, nil)
+)
+
+// requiresTransferApproval returns true if amount is at or over transferApprovalThreshold.
+func requiresTransferApproval(amount Amount) bool {
+	if transferApprovalThreshold == nil {
+		return false
+	}
+	return amount.Int() >= transferApprovalThreshold.Int()
+}
+
+// TransferApprover is a user who can approve or reject another user's Transfers which are awaiting approval.
+type TransferApprover struct {
+	// UserID is the user whose Transfers can be reviewed
+	UserID string `json:"userId"`
+	// ApproverID is the user reviewing Transfers, they can never review their own Transfers
+	ApproverID string    `json:"approverId"`
+	Created    base.Time `json:"created"`
+}
+
+// TransferApprovalExpirer periodically cancels Transfers which have been awaiting approval for longer than
+// TRANSFER_APPROVAL_EXPIRY. Their Accounts transactions are reversed and an event is written for the user.
+type TransferApprovalExpirer struct {
+	logger         log.Logger
+	accountsClient AccountsClient
+	transferRepo   transferRepository
+	eventRepo      EventRepository
+
+	interval time.Duration
+}
+
+func NewTransferApprovalExpirer(logger log.Logger, accountsClient AccountsClient, transferRepo transferRepository, eventRepo EventRepository) *TransferApprovalExpirer {
+	return &TransferApprovalExpirer{
+		logger:         logger,
+		accountsClient: accountsClient,
+		transferRepo:   transferRepo,
+		eventRepo:      eventRepo,
+		interval:       time.Hour,
+	}
+}
+
+// Start cancels expired Transfers every hour until ctx is finished.
+func (e *TransferApprovalExpirer) Start(ctx context.Context) {
+	if transferApprovalThreshold == nil {
+		return
+	}
+	e.logger.Log("transfer-approvals", fmt.Sprintf("transfers at or over %s need approval within %v", transferApprovalThreshold.String(), transferApprovalExpiry))
+
+	tick := time.NewTicker(e.interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			if _, err := e.cancelExpired(time.Now()); err != nil {
+				e.logger.Log("transfer-approvals", fmt.Sprintf("ERROR: canceling expired transfers: %v", err))
+			}
+
+		case <-ctx.Done():
+			e.logger.Log("transfer-approvals", "Shutting down due to context.Done()")
+			return
+		}
+	}
+}
+
+// cancelExpired cancels Transfers which have been awaiting approval since before now minus TRANSFER_APPROVAL_EXPIRY.
+func (e *TransferApprovalExpirer) cancelExpired(now time.Time) ([]*Transfer, error) {
+	transfers, err := e.transferRepo.cancelExpiredTransfers(now.Add(-1 * transferApprovalExpiry))
+	for i := range transfers {
+		xfer := transfers[i]
+		transferApprovalsExpired.Add(1)
+
+		if err := reverseTransferTransaction(e.accountsClient, e.transferRepo, "", xfer); err != nil {
+			e.logger.Log("transfer-approvals", fmt.Sprintf("problem reversing transaction=%s of expired transfer=%s: %v", xfer.transactionID, xfer.ID, err), "userID", xfer.userID)
+		}
+		err := e.eventRepo.writeEvent(xfer.userID, &Event{
+			ID:      EventID(base.ID()),
+			Topic:   fmt.Sprintf("transfer %s canceled", xfer.ID),
+			Message: fmt.Sprintf("transfer wasn't approved within %v", transferApprovalExpiry),
+			Type:    TransferEvent,
+			Metadata: map[string]string{
+				eventTransferID: string(xfer.ID),
+			},
+		})
+		if err != nil {
+			e.logger.Log("transfer-approvals", fmt.Sprintf("error writing transfer=%s event: %v", xfer.ID, err), "userID", xfer.userID)
+		}
+	}
+	if len(transfers) > 0 {
+		e.logger.Log("transfer-approvals", fmt.Sprintf("canceled %d transfers awaiting approval", len(transfers)))
+	}
+	return transfers, err
+}
+
+// AddTransferApproverRoutes registers the admin HTTP routes for managing who can approve a user's Transfers.
+func AddTransferApproverRoutes(logger log.Logger, svc *admin.Server, repo transferApproverRepository) {
+	svc.AddHandler("/transfer-approvers", transferApprovers(logger, repo))
+	svc.AddHandler("/transfer-approvers/{approverId}", deleteTransferApprover(logger, repo))
+}
+
+// transferApprovers is an http.HandlerFunc for paygate's admin server. GET lists the approvers of the X-User-Id
+// user's Transfers and POST adds one.
+func transferApprovers(logger log.Logger, repo transferApproverRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w = wrap(logger, w, r)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		userID := moovhttp.GetUserID(r)
+		if userID == "" {
+			moovhttp.Problem(w, errors.New("missing X-User-Id"))
+			return
+		}
+
+		switch r.Method {
+		case "GET":
+			approvers, err := repo.getTransferApprovers(userID)
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(approvers)
+
+		case "POST":
+			var approver TransferApprover
+			if err := json.NewDecoder(r.Body).Decode(&approver); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if approver.ApproverID == "" || approver.ApproverID == userID {
+				moovhttp.Problem(w, errors.New("approverId must be a different user"))
+				return
+			}
+			approver.UserID, approver.Created = userID, base.NewTime(time.Now())
+			if err := repo.addTransferApprover(&approver); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			logger.Log("transfer-approvals", fmt.Sprintf("added approver=%s", approver.ApproverID), "requestID", moovhttp.GetRequestID(r), "userID", userID)
+
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(approver)
+
+		default:
+			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb: %s", r.Method))
+		}
+	}
+}
+
+func deleteTransferApprover(logger log.Logger, repo transferApproverRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w = wrap(logger, w, r)
+
+		if r.Method != "DELETE" {
+			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb: %s", r.Method))
+			return
+		}
+
+		userID, approverID := moovhttp.GetUserID(r), mux.Vars(r)["approverId"]
+		if err := repo.removeTransferApprover(userID, approverID); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		logger.Log("transfer-approvals", fmt.Sprintf("removed approver=%s", approverID), "requestID", moovhttp.GetRequestID(r), "userID", userID)
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+type transferApproverRepository interface {
+	getTransferApprovers(userID string) ([]*TransferApprover, error)
+
+	// isTransferApprover returns true if approverID can review userID's Transfers.
+	isTransferApprover(userID, approverID string) (bool, error)
+
+	// addTransferApprover saves approver, adding an existing approver again does nothing.
+	addTransferApprover(approver *TransferApprover) error
+	removeTransferApprover(userID, approverID string) error
+}
+
+func NewTransferApproverRepo(logger log.Logger, db *sql.DB) *SQLTransferApproverRepo {
+	return &SQLTransferApproverRepo{log: logger, db: db}
+}
+
+type SQLTransferApproverRepo struct {
+	db  *sql.DB
+	log log.Logger
+}
+
+func (r *SQLTransferApproverRepo) Close() error {
+	return r.db.Close()
+}
+
+func (r *SQLTransferApproverRepo) getTransferApprovers(userID string) ([]*TransferApprover, error) {
+	query := `select user_id, approver_id, created_at from transfer_approvers where user_id = ? order by created_at asc;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var approvers []*TransferApprover
+	for rows.Next() {
+		var (
+			approver TransferApprover
+			created  time.Time
+		)
+		if err := rows.Scan(&approver.UserID, &approver.ApproverID, &created); err != nil {
+			return nil, fmt.Errorf("getTransferApprovers: scan: %v", err)
+		}
+		approver.Created = base.NewTime(created)
+		approvers = append(approvers, &approver)
+	}
+	return approvers, rows.Err()
+}
+
+func (r *SQLTransferApproverRepo) isTransferApprover(userID, approverID string) (bool, error) {
+	query := `select count(*) from transfer_approvers where user_id = ? and approver_id = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	var n int
+	if err := stmt.QueryRow(userID, approverID).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (r *SQLTransferApproverRepo) addTransferApprover(approver *TransferApprover) error {
+	if exists, err := r.isTransferApprover(approver.UserID, approver.ApproverID); err != nil || exists {
+		return err
+	}
+
+	query := `insert into transfer_approvers (user_id, approver_id, created_at) values (?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(approver.UserID, approver.ApproverID, approver.Created.Time)
+	return err
+}
+
+func (r *SQLTransferApproverRepo) removeTransferApprover(userID, approverID string) error {
+	query := `delete from transfer_approvers where user_id = ? and approver_id = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(userID, approverID)
+	return err
+}
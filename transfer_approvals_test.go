@@ -0,0 +1,292 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moov-io/base"
+	"github.com/moov-io/paygate/internal/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+type mockTransferApproverRepository struct {
+	approvers []*TransferApprover
+	err       error
+}
+
+func (r *mockTransferApproverRepository) getTransferApprovers(userID string) ([]*TransferApprover, error) {
+	return r.approvers, r.err
+}
+
+func (r *mockTransferApproverRepository) isTransferApprover(userID, approverID string) (bool, error) {
+	for i := range r.approvers {
+		if r.approvers[i].UserID == userID && r.approvers[i].ApproverID == approverID {
+			return true, r.err
+		}
+	}
+	return false, r.err
+}
+
+func (r *mockTransferApproverRepository) addTransferApprover(approver *TransferApprover) error {
+	r.approvers = append(r.approvers, approver)
+	return r.err
+}
+
+func (r *mockTransferApproverRepository) removeTransferApprover(userID, approverID string) error {
+	return r.err
+}
+
+// setTransferApprovalThreshold overrides TRANSFER_APPROVAL_THRESHOLD, the returned func restores it.
+func setTransferApprovalThreshold(t *testing.T, v string) func() {
+	t.Helper()
+
+	previous := transferApprovalThreshold
+	amt, err := NewAmount("USD", v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transferApprovalThreshold = amt
+	return func() {
+		transferApprovalThreshold = previous
+	}
+}
+
+func TestTransferApprovals__requiresTransferApproval(t *testing.T) {
+	amt, _ := NewAmount("USD", "100.00")
+	if requiresTransferApproval(*amt) {
+		t.Error("approvals are disabled by default")
+	}
+
+	defer setTransferApprovalThreshold(t, "100.00")()
+
+	if !requiresTransferApproval(*amt) {
+		t.Error("expected approval at the threshold")
+	}
+	amt, _ = NewAmount("USD", "99.99")
+	if requiresTransferApproval(*amt) {
+		t.Error("expected no approval under the threshold")
+	}
+}
+
+func TestTransferApprovals__review(t *testing.T) {
+	defer setTransferApprovalThreshold(t, "1000.00")()
+
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	logger := log.NewNopLogger()
+	ledger := NewLedger(logger, db.DB)
+	transferRepo := NewTransferRepo(logger, db.DB)
+	approverRepo := NewTransferApproverRepo(logger, db.DB)
+	eventRepo := NewEventRepo(logger, db.DB, nil)
+
+	creator, approver := base.ID(), base.ID()
+	origDep := &Depository{ID: DepositoryID(base.ID()), RoutingNumber: "121042882", AccountNumber: "151", Type: Checking}
+	recDep := &Depository{ID: DepositoryID(base.ID()), RoutingNumber: "231380104", AccountNumber: "251", Type: Checking}
+	origAcct, _ := ledger.SearchAccounts("", creator, origDep)
+	recAcct, _ := ledger.SearchAccounts("", creator, recDep)
+
+	create := func(amount string) *Transfer {
+		t.Helper()
+		amt, _ := NewAmount("USD", amount)
+		tx, err := ledger.PostTransaction("", creator, createTransactionLines(origAcct, recAcct, *amt, PushTransfer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		xfers, err := transferRepo.createUserTransfers(creator, []*transferRequest{{
+			Type:                   PushTransfer,
+			Amount:                 *amt,
+			Originator:             OriginatorID(base.ID()),
+			OriginatorDepository:   origDep.ID,
+			Receiver:               ReceiverID(base.ID()),
+			ReceiverDepository:     recDep.ID,
+			Description:            "treasury",
+			StandardEntryClassCode: "CCD",
+			transactionID:          tx.ID,
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return xfers[0]
+	}
+
+	large := create("5000.00")
+	if large.Status != TransferAwaitingApproval {
+		t.Fatalf("unexpected status: %s", large.Status)
+	}
+
+	// the cursor only reads Pending transfers, and moves past the transfer awaiting approval
+	cur := transferRepo.getTransferCursor(10, &mockDepositoryRepository{depositories: []*Depository{origDep}})
+	small := create("10.00")
+	if xfers, err := cur.Next(); err != nil || len(xfers) != 1 || xfers[0].ID != small.ID {
+		t.Fatalf("transfers=%#v error=%v", xfers, err)
+	}
+
+	router := createTestTransferRouter(nil, eventRepo, nil, nil, transferRepo)
+	defer router.close()
+	router.approverRepo = approverRepo
+	router.TransferRouter.accountsClient = ledger
+
+	handler := mux.NewRouter()
+	router.RegisterRoutes(handler)
+	review := func(userID string, xfer *Transfer, action string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/transfers/"+string(xfer.ID)+"/"+action, strings.NewReader(body))
+		req.Header.Set("x-user-id", userID)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	if w := review(creator, large, "approve", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("creator can't approve: %d", w.Code)
+	}
+	if w := review(approver, large, "approve", ""); w.Code != http.StatusNotFound {
+		t.Errorf("approver isn't authorized yet: %d", w.Code)
+	}
+	if err := approverRepo.addTransferApprover(&TransferApprover{UserID: creator, ApproverID: approver, Created: base.NewTime(time.Now())}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := review(approver, large, "approve", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var approved Transfer
+	if err := json.NewDecoder(w.Body).Decode(&approved); err != nil {
+		t.Fatal(err)
+	}
+	if approved.Status != TransferPending || approved.ReviewedBy != approver || approved.Reviewed == nil {
+		t.Errorf("unexpected transfer: %#v", approved)
+	}
+	if w := review(approver, large, "approve", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("transfer was already approved: %d", w.Code)
+	}
+
+	// approved transfers are read by the cursor even though they were created earlier
+	if xfers, err := cur.Next(); err != nil || len(xfers) != 1 || xfers[0].ID != large.ID {
+		t.Fatalf("transfers=%#v error=%v", xfers, err)
+	}
+
+	// reject another transfer, its transaction is reversed
+	rejected := create("2500.00")
+	if w := review(approver, rejected, "reject", `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("missing reason: %d", w.Code)
+	}
+	if w := review(approver, rejected, "reject", `{"reason": "unexpected vendor"}`); w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	xfer, err := transferRepo.getTransfer(rejected.ID)
+	if err != nil || xfer.Status != TransferCanceled || xfer.ReviewedBy != approver {
+		t.Fatalf("transfer=%#v error=%v", xfer, err)
+	}
+	transactions, err := ledger.GetAccountTransactions("", creator, origAcct.ID, 100)
+	if err != nil || len(transactions) != 4 { // three postings and one reversal
+		t.Errorf("transactions=%d error=%v", len(transactions), err)
+	}
+	events, err := eventRepo.getUserTransferEvents(creator, rejected.ID)
+	if err != nil || len(events) != 1 || !strings.Contains(events[0].Message, "unexpected vendor") {
+		t.Errorf("events=%#v error=%v", events, err)
+	}
+}
+
+func TestTransferApprovals__expire(t *testing.T) {
+	defer setTransferApprovalThreshold(t, "1000.00")()
+
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	logger := log.NewNopLogger()
+	transferRepo := NewTransferRepo(logger, db.DB)
+	eventRepo := NewEventRepo(logger, db.DB, nil)
+
+	userID := base.ID()
+	amt, _ := NewAmount("USD", "1000.00")
+	xfers, err := transferRepo.createUserTransfers(userID, []*transferRequest{{
+		Type:                   PullTransfer,
+		Amount:                 *amt,
+		Originator:             OriginatorID(base.ID()),
+		OriginatorDepository:   DepositoryID(base.ID()),
+		Receiver:               ReceiverID(base.ID()),
+		ReceiverDepository:     DepositoryID(base.ID()),
+		Description:            "treasury",
+		StandardEntryClassCode: "CCD",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expirer := NewTransferApprovalExpirer(logger, &testAccountsClient{}, transferRepo, eventRepo)
+	if canceled, err := expirer.cancelExpired(time.Now()); err != nil || len(canceled) != 0 {
+		t.Fatalf("canceled=%#v error=%v", canceled, err)
+	}
+	canceled, err := expirer.cancelExpired(time.Now().Add(transferApprovalExpiry + time.Minute))
+	if err != nil || len(canceled) != 1 || canceled[0].ID != xfers[0].ID {
+		t.Fatalf("canceled=%#v error=%v", canceled, err)
+	}
+
+	xfer, err := transferRepo.getUserTransfer(xfers[0].ID, userID)
+	if err != nil || xfer.Status != TransferCanceled {
+		t.Errorf("transfer=%#v error=%v", xfer, err)
+	}
+	events, err := eventRepo.getUserTransferEvents(userID, xfer.ID)
+	if err != nil || len(events) != 1 {
+		t.Errorf("events=%#v error=%v", events, err)
+	}
+}
+
+func TestTransferApprovals__approverRoutes(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	repo := NewTransferApproverRepo(log.NewNopLogger(), db.DB)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/transfer-approvers", transferApprovers(log.NewNopLogger(), repo))
+	router.HandleFunc("/transfer-approvers/{approverId}", deleteTransferApprover(log.NewNopLogger(), repo))
+
+	serve := func(method, path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		req.Header.Set("x-user-id", "treasury")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	if w := serve("POST", "/transfer-approvers", `{"approverId": "treasury"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("users can't approve their own transfers: %d", w.Code)
+	}
+	for i := 0; i < 2; i++ {
+		if w := serve("POST", "/transfer-approvers", `{"approverId": "controller"}`); w.Code != http.StatusOK {
+			t.Fatalf("got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	w := serve("GET", "/transfer-approvers", "")
+	var approvers []*TransferApprover
+	if err := json.NewDecoder(w.Body).Decode(&approvers); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(approvers) != 1 || approvers[0].UserID != "treasury" || approvers[0].ApproverID != "controller" {
+		t.Errorf("got %d: %#v", w.Code, approvers)
+	}
+
+	if w := serve("DELETE", "/transfer-approvers/controller", ""); w.Code != http.StatusOK {
+		t.Errorf("got %d", w.Code)
+	}
+	if ok, err := repo.isTransferApprover("treasury", "controller"); ok || err != nil {
+		t.Errorf("approver=%v error=%v", ok, err)
+	}
+}
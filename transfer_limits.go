@@ -0,0 +1,564 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/moov-io/base"
+	"github.com/moov-io/base/admin"
+	moovhttp "github.com/moov-io/base/http"
+	"github.com/moov-io/paygate/internal/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+type TransferLimitID string
+
+// TransferLimit caps the amount and number of Transfers a user can create. A limit applies to every Transfer
+// matching its UserID, Originator, StandardEntryClassCode and Direction, where empty fields match everything.
+// For example a limit with only StandardEntryClassCode set caps every user's WEB transfers.
+//
+// Daily and monthly totals are counted separately for each user from when Transfers are created (in UTC),
+// deleting a Transfer doesn't free up its usage. Zero values are not enforced.
+type TransferLimit struct {
+	ID TransferLimitID `json:"id"`
+
+	// UserID, Originator, StandardEntryClassCode and Direction pick which Transfers the limit applies to
+	UserID                 string                 `json:"userId,omitempty"`
+	Originator             OriginatorID           `json:"originator,omitempty"`
+	StandardEntryClassCode string                 `json:"standardEntryClassCode,omitempty"`
+	Direction              TransferLimitDirection `json:"direction,omitempty"`
+
+	// MaxAmount is the largest single Transfer allowed
+	MaxAmount *Amount `json:"maxAmount,omitempty"`
+
+	DailyAmount   *Amount `json:"dailyAmount,omitempty"`
+	MonthlyAmount *Amount `json:"monthlyAmount,omitempty"`
+	DailyCount    int     `json:"dailyCount,omitempty"`
+	MonthlyCount  int     `json:"monthlyCount,omitempty"`
+
+	Created base.Time `json:"created"`
+	Updated base.Time `json:"updated"`
+}
+
+// TransferLimitDirection restricts a TransferLimit to debits (pull transfers) or credits (push transfers).
+type TransferLimitDirection string
+
+const (
+	TransferLimitDebit  TransferLimitDirection = "debit"
+	TransferLimitCredit TransferLimitDirection = "credit"
+)
+
+func (d TransferLimitDirection) validate() error {
+	switch d {
+	case "", TransferLimitDebit, TransferLimitCredit:
+		return nil
+	default:
+		return fmt.Errorf("TransferLimitDirection(%s) is invalid", d)
+	}
+}
+
+// transferDirection returns the TransferLimitDirection of a Transfer's type.
+func transferDirection(t TransferType) TransferLimitDirection {
+	if t == PullTransfer {
+		return TransferLimitDebit
+	}
+	return TransferLimitCredit
+}
+
+func (l *TransferLimit) validate() error {
+	if l == nil {
+		return errors.New("nil TransferLimit")
+	}
+	if err := l.Direction.validate(); err != nil {
+		return err
+	}
+	amounts := map[string]*Amount{"maxAmount": l.MaxAmount, "dailyAmount": l.DailyAmount, "monthlyAmount": l.MonthlyAmount}
+	for name, amt := range amounts {
+		if amt == nil {
+			continue
+		}
+		if err := amt.Validate(); err != nil {
+			return fmt.Errorf("TransferLimit.%s: %v", name, err)
+		}
+	}
+	if l.DailyCount < 0 || l.MonthlyCount < 0 {
+		return errors.New("TransferLimit counts can't be negative")
+	}
+	if l.MaxAmount.Int() == 0 && l.DailyAmount.Int() == 0 && l.MonthlyAmount.Int() == 0 && l.DailyCount == 0 && l.MonthlyCount == 0 {
+		return errors.New("TransferLimit has no amounts or counts")
+	}
+	return nil
+}
+
+// applies returns true if the limit covers a Transfer request from userID.
+func (l *TransferLimit) applies(userID string, req *transferRequest) bool {
+	if l.UserID != "" && l.UserID != userID {
+		return false
+	}
+	if l.Originator != "" && l.Originator != req.Originator {
+		return false
+	}
+	if l.StandardEntryClassCode != "" && !strings.EqualFold(l.StandardEntryClassCode, req.StandardEntryClassCode) {
+		return false
+	}
+	return l.Direction == "" || l.Direction == transferDirection(req.Type)
+}
+
+// describe names one of the limit's caps, i.e. "daily WEB debit amount for originator=..."
+func (l *TransferLimit) describe(period, measure string) string {
+	parts := []string{period}
+	if l.StandardEntryClassCode != "" {
+		parts = append(parts, strings.ToUpper(l.StandardEntryClassCode))
+	}
+	if l.Direction != "" {
+		parts = append(parts, string(l.Direction))
+	}
+	parts = append(parts, measure)
+	if l.Originator != "" {
+		parts = append(parts, fmt.Sprintf("for originator=%s", l.Originator))
+	}
+	return strings.Join(parts, " ")
+}
+
+// TransferLimitError is returned when a Transfer would go over a TransferLimit.
+type TransferLimitError struct {
+	Limit TransferLimitID
+
+	// Name describes which of the limit's caps was hit, i.e. "daily debit amount"
+	Name string
+
+	// Max is the cap and Remaining is how much of it can still be used
+	Max       string
+	Remaining string
+}
+
+func (e *TransferLimitError) Error() string {
+	return fmt.Sprintf("transfer is over the %s limit of %s (limit=%s), %s remaining", e.Name, e.Max, e.Limit, e.Remaining)
+}
+
+func formatLimitCount(n int) string {
+	if n < 0 {
+		n = 0
+	}
+	if n == 1 {
+		return "1 transfer"
+	}
+	return fmt.Sprintf("%d transfers", n)
+}
+
+// reserveTransferLimits adds each request to the usage of every TransferLimit which applies to it, returning a
+// *TransferLimitError if any limit would be exceeded. It's called in the transaction which creates the Transfers
+// so concurrent requests can't both use the remaining headroom, and rolling back releases the reservations.
+//
+// When transferIDs are given (one for each request) the reservations are recorded so releaseTransferLimitUsage
+// can give them back.
+func reserveTransferLimits(tx *sql.Tx, userID string, requests []*transferRequest, transferIDs []TransferID, now time.Time) error {
+	query := fmt.Sprintf(`select %s from transfer_limits where (user_id = ? or user_id = '') and deleted_at is null`, transferLimitColumns)
+	rows, err := tx.Query(query, userID)
+	if err != nil {
+		return err
+	}
+	var limits []*TransferLimit
+	for rows.Next() {
+		limit, err := scanTransferLimit(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		limits = append(limits, limit)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	now = now.UTC()
+	for i := range requests {
+		amount := requests[i].Amount.Int()
+		for _, limit := range limits {
+			if !limit.applies(userID, requests[i]) {
+				continue
+			}
+			if max := limit.MaxAmount.Int(); max > 0 && amount > max {
+				return &TransferLimitError{
+					Limit:     limit.ID,
+					Name:      limit.describe("single transfer", "amount"),
+					Max:       limit.MaxAmount.String(),
+					Remaining: limit.MaxAmount.String(),
+				}
+			}
+			periods := []struct {
+				name, key           string
+				maxAmount, maxCount int
+			}{
+				{"daily", now.Format("2006-01-02"), limit.DailyAmount.Int(), limit.DailyCount},
+				{"monthly", now.Format("2006-01"), limit.MonthlyAmount.Int(), limit.MonthlyCount},
+			}
+			for _, p := range periods {
+				if p.maxAmount == 0 && p.maxCount == 0 {
+					continue
+				}
+				ok, err := reserveTransferLimitUsage(tx, limit.ID, userID, p.key, amount, p.maxAmount, p.maxCount)
+				if err != nil {
+					return fmt.Errorf("problem reserving limit=%s: %v", limit.ID, err)
+				}
+				if ok {
+					if len(transferIDs) > i {
+						if err := recordTransferLimitReservation(tx, transferIDs[i], limit.ID, userID, p.key, amount); err != nil {
+							return fmt.Errorf("problem recording limit=%s reservation: %v", limit.ID, err)
+						}
+					}
+					continue
+				}
+				usedAmount, usedCount, err := getTransferLimitUsage(tx, limit.ID, userID, p.key)
+				if err != nil {
+					return fmt.Errorf("problem reading limit=%s usage: %v", limit.ID, err)
+				}
+				if p.maxAmount > 0 && usedAmount+amount > p.maxAmount {
+					max, remaining := amountFromCents(p.maxAmount), amountFromCents(p.maxAmount-usedAmount)
+					return &TransferLimitError{
+						Limit:     limit.ID,
+						Name:      limit.describe(p.name, "amount"),
+						Max:       max.String(),
+						Remaining: remaining.String(),
+					}
+				}
+				return &TransferLimitError{
+					Limit:     limit.ID,
+					Name:      limit.describe(p.name, "count"),
+					Max:       formatLimitCount(p.maxCount),
+					Remaining: formatLimitCount(p.maxCount - usedCount),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// reserveTransferLimitUsage adds amount (and one transfer) to a limit's usage for period if it stays within
+// maxAmount and maxCount (zero values aren't checked). False is returned when the limit would be exceeded.
+//
+// The conditional update makes concurrent reservations wait on each other. The first reservation of a period
+// inserts its usage row and, when another request inserted it first, the update is tried again.
+func reserveTransferLimitUsage(tx *sql.Tx, limitID TransferLimitID, userID string, period string, amount, maxAmount, maxCount int) (bool, error) {
+	update := `update transfer_limit_usage set amount = amount + ?, transfers = transfers + 1
+where limit_id = ? and user_id = ? and period = ? and (? = 0 or amount + ? <= ?) and (? = 0 or transfers + 1 <= ?)`
+	for attempt := 0; attempt < 2; attempt++ {
+		res, err := tx.Exec(update, amount, limitID, userID, period, maxAmount, amount, maxAmount, maxCount, maxCount)
+		if err != nil {
+			return false, err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			return true, nil
+		}
+		if maxAmount > 0 && amount > maxAmount {
+			return false, nil
+		}
+		query := `insert into transfer_limit_usage (limit_id, user_id, period, amount, transfers) values (?, ?, ?, ?, 1)`
+		if _, err := tx.Exec(query, limitID, userID, period, amount); err == nil {
+			return true, nil
+		} else if !database.UniqueViolation(err) {
+			return false, err
+		}
+		// the usage row exists, so either the limit was reached or it was just inserted by another request
+	}
+	return false, nil
+}
+
+func recordTransferLimitReservation(tx *sql.Tx, id TransferID, limitID TransferLimitID, userID string, period string, amount int) error {
+	query := `insert into transfer_limit_reservations (transfer_id, limit_id, user_id, period, amount) values (?, ?, ?, ?, ?)`
+	_, err := tx.Exec(query, id, limitID, userID, period, amount)
+	return err
+}
+
+// releaseTransferLimitUsage gives back the usage reserved for a Transfer which won't be sent (i.e. it was canceled,
+// rejected, expired or failed) so it no longer counts against its TransferLimits. Each reservation is only released once.
+func releaseTransferLimitUsage(tx *sql.Tx, id TransferID) error {
+	rows, err := tx.Query(`select limit_id, user_id, period, amount from transfer_limit_reservations where transfer_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	type reservation struct {
+		limitID        TransferLimitID
+		userID, period string
+		amount         int
+	}
+	var reservations []reservation
+	for rows.Next() {
+		var res reservation
+		if err := rows.Scan(&res.limitID, &res.userID, &res.period, &res.amount); err != nil {
+			rows.Close()
+			return err
+		}
+		reservations = append(reservations, res)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, res := range reservations {
+		// deleting the reservation first means a concurrent release can't give it back again
+		query := `delete from transfer_limit_reservations where transfer_id = ? and limit_id = ? and period = ?`
+		result, err := tx.Exec(query, id, res.limitID, res.period)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			continue
+		}
+		query = `update transfer_limit_usage set amount = amount - ?, transfers = transfers - 1 where limit_id = ? and user_id = ? and period = ?`
+		if _, err := tx.Exec(query, res.amount, res.limitID, res.userID, res.period); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getTransferLimitUsage(tx *sql.Tx, limitID TransferLimitID, userID string, period string) (int, int, error) {
+	query := `select amount, transfers from transfer_limit_usage where limit_id = ? and user_id = ? and period = ?`
+	var amount, count int
+	if err := tx.QueryRow(query, limitID, userID, period).Scan(&amount, &count); err != nil && err != sql.ErrNoRows {
+		return 0, 0, err
+	}
+	return amount, count, nil
+}
+
+// AddTransferLimitRoutes registers the admin HTTP routes for managing TransferLimits.
+func AddTransferLimitRoutes(logger log.Logger, svc *admin.Server, repo transferLimitRepository) {
+	svc.AddHandler("/transfer-limits", transferLimits(logger, repo))
+	svc.AddHandler("/transfer-limits/{limitId}", transferLimit(logger, repo))
+}
+
+func getTransferLimitID(r *http.Request) TransferLimitID {
+	return TransferLimitID(mux.Vars(r)["limitId"])
+}
+
+func readTransferLimit(r *http.Request) (*TransferLimit, error) {
+	var limit TransferLimit
+	if err := json.NewDecoder(r.Body).Decode(&limit); err != nil {
+		return nil, err
+	}
+	limit.StandardEntryClassCode = strings.ToUpper(limit.StandardEntryClassCode)
+	if err := limit.validate(); err != nil {
+		return nil, err
+	}
+	return &limit, nil
+}
+
+// transferLimits is an http.HandlerFunc for paygate's admin server. GET lists TransferLimits (?userId=... only returns
+// limits which apply to that user) and POST creates a TransferLimit.
+func transferLimits(logger log.Logger, repo transferLimitRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w = wrap(logger, w, r)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		switch r.Method {
+		case "GET":
+			limits, err := repo.getTransferLimits(r.URL.Query().Get("userId"))
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(limits)
+
+		case "POST":
+			limit, err := readTransferLimit(r)
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			limit.ID = TransferLimitID(base.ID())
+			if err := repo.createTransferLimit(limit); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			logger.Log("transfer-limits", fmt.Sprintf("created limit=%s", limit.ID), "requestID", moovhttp.GetRequestID(r))
+
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(limit)
+
+		default:
+			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb: %s", r.Method))
+		}
+	}
+}
+
+// transferLimit is an http.HandlerFunc for paygate's admin server to read (GET), replace (PUT) or delete (DELETE)
+// a TransferLimit.
+func transferLimit(logger log.Logger, repo transferLimitRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w = wrap(logger, w, r)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		id, requestID := getTransferLimitID(r), moovhttp.GetRequestID(r)
+		existing, err := repo.getTransferLimit(id)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if existing == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch r.Method {
+		case "GET":
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(existing)
+
+		case "PUT":
+			limit, err := readTransferLimit(r)
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			limit.ID, limit.Created = existing.ID, existing.Created
+			if err := repo.updateTransferLimit(limit); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			logger.Log("transfer-limits", fmt.Sprintf("updated limit=%s", id), "requestID", requestID)
+
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(limit)
+
+		case "DELETE":
+			if err := repo.deleteTransferLimit(id); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			logger.Log("transfer-limits", fmt.Sprintf("deleted limit=%s", id), "requestID", requestID)
+
+			w.WriteHeader(http.StatusOK)
+
+		default:
+			moovhttp.Problem(w, fmt.Errorf("unsupported HTTP verb: %s", r.Method))
+		}
+	}
+}
+
+type transferLimitRepository interface {
+	// getTransferLimits returns every TransferLimit, or only those which can apply to userID when it's non-empty.
+	getTransferLimits(userID string) ([]*TransferLimit, error)
+	getTransferLimit(id TransferLimitID) (*TransferLimit, error)
+
+	// createTransferLimit and updateTransferLimit set the limit's Created and Updated times.
+	createTransferLimit(limit *TransferLimit) error
+	updateTransferLimit(limit *TransferLimit) error
+	deleteTransferLimit(id TransferLimitID) error
+}
+
+func NewTransferLimitRepo(logger log.Logger, db *sql.DB) *SQLTransferLimitRepo {
+	return &SQLTransferLimitRepo{log: logger, db: db}
+}
+
+type SQLTransferLimitRepo struct {
+	db  *sql.DB
+	log log.Logger
+}
+
+func (r *SQLTransferLimitRepo) Close() error {
+	return r.db.Close()
+}
+
+// transferLimitColumns are read by scanTransferLimit. Amounts are stored in cents where zero isn't enforced.
+const transferLimitColumns = `limit_id, user_id, originator_id, sec_code, direction, max_amount, daily_amount, monthly_amount, daily_count, monthly_count, created_at, last_updated_at`
+
+func scanTransferLimit(rows *sql.Rows) (*TransferLimit, error) {
+	var (
+		limit               TransferLimit
+		max, daily, monthly int
+		created, updated    time.Time
+	)
+	err := rows.Scan(&limit.ID, &limit.UserID, &limit.Originator, &limit.StandardEntryClassCode, &limit.Direction, &max, &daily, &monthly, &limit.DailyCount, &limit.MonthlyCount, &created, &updated)
+	if err != nil {
+		return nil, err
+	}
+	limit.MaxAmount, limit.DailyAmount, limit.MonthlyAmount = limitAmount(max), limitAmount(daily), limitAmount(monthly)
+	limit.Created, limit.Updated = base.NewTime(created), base.NewTime(updated)
+	return &limit, nil
+}
+
+// limitAmount returns nil for zero cents, which isn't enforced.
+func limitAmount(cents int) *Amount {
+	if cents <= 0 {
+		return nil
+	}
+	amt := amountFromCents(cents)
+	return &amt
+}
+
+func (r *SQLTransferLimitRepo) queryTransferLimits(query string, args ...interface{}) ([]*TransferLimit, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var limits []*TransferLimit
+	for rows.Next() {
+		limit, err := scanTransferLimit(rows)
+		if err != nil {
+			return nil, err
+		}
+		limits = append(limits, limit)
+	}
+	return limits, rows.Err()
+}
+
+func (r *SQLTransferLimitRepo) getTransferLimits(userID string) ([]*TransferLimit, error) {
+	if userID != "" {
+		query := fmt.Sprintf(`select %s from transfer_limits where (user_id = ? or user_id = '') and deleted_at is null order by created_at asc`, transferLimitColumns)
+		return r.queryTransferLimits(query, userID)
+	}
+	query := fmt.Sprintf(`select %s from transfer_limits where deleted_at is null order by created_at asc`, transferLimitColumns)
+	return r.queryTransferLimits(query)
+}
+
+func (r *SQLTransferLimitRepo) getTransferLimit(id TransferLimitID) (*TransferLimit, error) {
+	query := fmt.Sprintf(`select %s from transfer_limits where limit_id = ? and deleted_at is null limit 1`, transferLimitColumns)
+	limits, err := r.queryTransferLimits(query, id)
+	if err != nil || len(limits) == 0 {
+		return nil, err
+	}
+	return limits[0], nil
+}
+
+func (r *SQLTransferLimitRepo) createTransferLimit(limit *TransferLimit) error {
+	limit.Created = base.NewTime(time.Now())
+	limit.Updated = limit.Created
+
+	query := `insert into transfer_limits (limit_id, user_id, originator_id, sec_code, direction, max_amount, daily_amount, monthly_amount, daily_count, monthly_count, created_at, last_updated_at) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := r.db.Exec(query, limit.ID, limit.UserID, limit.Originator, limit.StandardEntryClassCode, limit.Direction, limit.MaxAmount.Int(), limit.DailyAmount.Int(), limit.MonthlyAmount.Int(), limit.DailyCount, limit.MonthlyCount, limit.Created.Time, limit.Updated.Time)
+	return err
+}
+
+func (r *SQLTransferLimitRepo) updateTransferLimit(limit *TransferLimit) error {
+	limit.Updated = base.NewTime(time.Now())
+
+	query := `update transfer_limits set user_id = ?, originator_id = ?, sec_code = ?, direction = ?, max_amount = ?, daily_amount = ?, monthly_amount = ?, daily_count = ?, monthly_count = ?, last_updated_at = ?
+where limit_id = ? and deleted_at is null`
+	_, err := r.db.Exec(query, limit.UserID, limit.Originator, limit.StandardEntryClassCode, limit.Direction, limit.MaxAmount.Int(), limit.DailyAmount.Int(), limit.MonthlyAmount.Int(), limit.DailyCount, limit.MonthlyCount, limit.Updated.Time, limit.ID)
+	return err
+}
+
+func (r *SQLTransferLimitRepo) deleteTransferLimit(id TransferLimitID) error {
+	query := `update transfer_limits set deleted_at = ? where limit_id = ? and deleted_at is null`
+	_, err := r.db.Exec(query, time.Now(), id)
+	return err
+}
@@ -0,0 +1,225 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/moov-io/base"
+	"github.com/moov-io/paygate/internal/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestTransferLimit__validate(t *testing.T) {
+	limit := &TransferLimit{}
+	if err := limit.validate(); err == nil {
+		t.Error("expected error")
+	}
+	limit.DailyCount = 5
+	if err := limit.validate(); err != nil {
+		t.Error(err)
+	}
+	limit.Direction = "sideways"
+	if err := limit.validate(); err == nil {
+		t.Error("expected error")
+	}
+	limit.Direction, limit.MonthlyCount = TransferLimitDebit, -1
+	if err := limit.validate(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestTransferLimit__applies(t *testing.T) {
+	req := &transferRequest{Type: PullTransfer, Originator: "orig", StandardEntryClassCode: "WEB"}
+
+	limit := &TransferLimit{}
+	if !limit.applies("user", req) {
+		t.Error("empty limit applies to everything")
+	}
+	limit = &TransferLimit{UserID: "user", Originator: "orig", StandardEntryClassCode: "web", Direction: TransferLimitDebit}
+	if !limit.applies("user", req) {
+		t.Error("expected limit to apply")
+	}
+	if limit.applies("other", req) {
+		t.Error("limit is for another user")
+	}
+	req.Type = PushTransfer
+	if limit.applies("user", req) {
+		t.Error("limit is only for debits")
+	}
+	if v := limit.describe("daily", "amount"); v != "daily WEB debit amount for originator=orig" {
+		t.Errorf("got %q", v)
+	}
+}
+
+func TestTransferLimits__createUserTransfers(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, limitRepo *SQLTransferLimitRepo, transferRepo *SQLTransferRepo) {
+		userID := base.ID()
+		amount := func(v string) *Amount {
+			amt, _ := NewAmount("USD", v)
+			return amt
+		}
+		request := func(xferType TransferType, sec string, amt string) *transferRequest {
+			return &transferRequest{
+				Type:                   xferType,
+				Amount:                 *amount(amt),
+				Originator:             OriginatorID(base.ID()),
+				OriginatorDepository:   DepositoryID(base.ID()),
+				Receiver:               ReceiverID(base.ID()),
+				ReceiverDepository:     DepositoryID(base.ID()),
+				Description:            "test",
+				StandardEntryClassCode: sec,
+			}
+		}
+		limits := []*TransferLimit{
+			{ID: TransferLimitID(base.ID()), UserID: userID, Direction: TransferLimitCredit, MaxAmount: amount("75.00"), DailyAmount: amount("100.00")},
+			{ID: TransferLimitID(base.ID()), UserID: userID, StandardEntryClassCode: "WEB", DailyCount: 1},
+		}
+		for i := range limits {
+			if err := limitRepo.createTransferLimit(limits[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if _, err := transferRepo.createUserTransfers(userID, []*transferRequest{request(PushTransfer, "PPD", "60.00")}); err != nil {
+			t.Fatal(err)
+		}
+
+		// over the daily credit amount
+		_, err := transferRepo.createUserTransfers(userID, []*transferRequest{request(PushTransfer, "PPD", "50.00")})
+		if e, ok := err.(*TransferLimitError); !ok || e.Limit != limits[0].ID || e.Remaining != "USD 40.00" || e.Max != "USD 100.00" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := transferRepo.checkTransferLimits(userID, []*transferRequest{request(PushTransfer, "PPD", "50.00")}); err == nil {
+			t.Error("expected error")
+		}
+
+		// over the single transfer amount
+		_, err = transferRepo.createUserTransfers(userID, []*transferRequest{request(PushTransfer, "PPD", "80.00")})
+		if e, ok := err.(*TransferLimitError); !ok || e.Name != "single transfer credit amount" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// debits aren't limited, but WEB transfers are counted
+		web := request(PullTransfer, "WEB", "500.00")
+		if _, err := transferRepo.createUserTransfers(userID, []*transferRequest{web}); err != nil {
+			t.Fatal(err)
+		}
+		_, err = transferRepo.createUserTransfers(userID, []*transferRequest{request(PullTransfer, "WEB", "1.00")})
+		if e, ok := err.(*TransferLimitError); !ok || e.Limit != limits[1].ID || e.Remaining != "0 transfers" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// a rejected batch doesn't write (or reserve) anything
+		batch := []*transferRequest{request(PushTransfer, "PPD", "40.00"), request(PushTransfer, "PPD", "1.00")}
+		if _, err := transferRepo.createUserTransfers(userID, batch); err == nil {
+			t.Fatal("expected error")
+		}
+		xfers, err := transferRepo.getUserTransfers(userID)
+		if err != nil || len(xfers) != 2 {
+			t.Fatalf("transfers=%d error=%v", len(xfers), err)
+		}
+		if _, err := transferRepo.createUserTransfers(userID, batch[:1]); err != nil {
+			t.Fatal(err)
+		}
+
+		// deleted limits aren't enforced
+		if err := limitRepo.deleteTransferLimit(limits[0].ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := transferRepo.createUserTransfers(userID, []*transferRequest{request(PushTransfer, "PPD", "500.00")}); err != nil {
+			t.Fatal(err)
+		}
+
+		// failed Transfers give back their usage
+		if _, err := transferRepo.failPendingOriginatorTransfers(web.Originator, userID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := transferRepo.createUserTransfers(userID, []*transferRequest{request(PullTransfer, "WEB", "1.00")}); err != nil {
+			t.Fatal(err)
+		}
+		_, err = transferRepo.createUserTransfers(userID, []*transferRequest{request(PullTransfer, "WEB", "1.00")})
+		if e, ok := err.(*TransferLimitError); !ok || e.Limit != limits[1].ID {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// other users aren't limited
+		if _, err := transferRepo.createUserTransfers(base.ID(), []*transferRequest{request(PullTransfer, "WEB", "1.00")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// SQLite tests
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, NewTransferLimitRepo(log.NewNopLogger(), sqliteDB.DB), NewTransferRepo(log.NewNopLogger(), sqliteDB.DB))
+
+	// MySQL tests
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, NewTransferLimitRepo(log.NewNopLogger(), mysqlDB.DB), NewTransferRepo(log.NewNopLogger(), mysqlDB.DB))
+}
+
+func TestTransferLimits__adminRoutes(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	repo := NewTransferLimitRepo(log.NewNopLogger(), db.DB)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/transfer-limits", transferLimits(log.NewNopLogger(), repo))
+	router.HandleFunc("/transfer-limits/{limitId}", transferLimit(log.NewNopLogger(), repo))
+
+	serve := func(method, path string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader([]byte(body))))
+		w.Flush()
+		return w
+	}
+
+	w := serve("POST", "/transfer-limits", `{"userId": "user", "standardEntryClassCode": "web", "dailyAmount": "USD 100.00"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	var limit TransferLimit
+	if err := json.NewDecoder(w.Body).Decode(&limit); err != nil {
+		t.Fatal(err)
+	}
+	if limit.ID == "" || limit.StandardEntryClassCode != "WEB" || limit.DailyAmount.Int() != 10000 {
+		t.Errorf("unexpected limit: %#v", limit)
+	}
+
+	if w := serve("POST", "/transfer-limits", `{"userId": "user"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("got %d", w.Code)
+	}
+
+	w = serve("PUT", "/transfer-limits/"+string(limit.ID), `{"userId": "user", "dailyCount": 3}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	w = serve("GET", "/transfer-limits?userId=user", "")
+	var limits []*TransferLimit
+	if err := json.NewDecoder(w.Body).Decode(&limits); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(limits) != 1 || limits[0].DailyCount != 3 || limits[0].DailyAmount != nil {
+		t.Errorf("got %d: %#v", w.Code, limits)
+	}
+
+	if w := serve("DELETE", "/transfer-limits/"+string(limit.ID), ""); w.Code != http.StatusOK {
+		t.Errorf("got %d", w.Code)
+	}
+	if w := serve("GET", "/transfer-limits/"+string(limit.ID), ""); w.Code != http.StatusNotFound {
+		t.Errorf("got %d", w.Code)
+	}
+}
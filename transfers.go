@@ -20,6 +20,7 @@ import (
 	"github.com/moov-io/base"
 	moovhttp "github.com/moov-io/base/http"
 	"github.com/moov-io/base/idempotent"
+	"github.com/moov-io/paygate/internal/filetransfer"
 	"github.com/moov-io/paygate/pkg/achclient"
 
 	"github.com/go-kit/kit/log"
@@ -70,9 +71,19 @@ type Transfer struct {
 	// SameDay indicates that the transfer should be processed the same day if possible.
 	SameDay bool `json:"sameDay"`
 
+	// Gateway is the Gateway whose routing numbers and names are written in the ACH file header.
+	// It's empty when the Transfer was created before the user had any Gateways.
+	Gateway GatewayID `json:"gateway,omitempty"`
+
 	// Created a timestamp representing the initial creation date of the object in ISO 8601
 	Created base.Time `json:"created"`
 
+	// ReviewedBy is the user who approved or rejected a Transfer which was awaiting approval
+	ReviewedBy string `json:"reviewedBy,omitempty"`
+
+	// Reviewed is when the Transfer was approved or rejected
+	Reviewed *base.Time `json:"reviewed,omitempty"`
+
 	// CCDDetail is an optional struct which enables sending CCD ACH transfers.
 	CCDDetail *CCDDetail `json:"CCDDetail,omitempty"`
 
@@ -85,6 +96,9 @@ type Transfer struct {
 	// WEBDetail is an optional struct which enables sending WEB ACH transfers.
 	WEBDetail *WEBDetail `json:"WEBDetail,omitempty"`
 
+	// OFACScreenings are the OFAC results for each party of an IAT transfer
+	OFACScreenings []*OFACScreening `json:"OFACScreenings,omitempty"`
+
 	// Hidden fields (populated in lookupTransferFromReturn)
 	transactionID string
 	userID        string
@@ -116,6 +130,7 @@ type transferRequest struct {
 	Description            string       `json:"description,omitempty"`
 	StandardEntryClassCode string       `json:"standardEntryClassCode"`
 	SameDay                bool         `json:"sameDay,omitempty"`
+	Gateway                GatewayID    `json:"gateway,omitempty"`
 
 	CCDDetail *CCDDetail `json:"CCDDetail,omitempty"`
 	IATDetail *IATDetail `json:"IATDetail,omitempty"`
@@ -123,8 +138,10 @@ type transferRequest struct {
 	WEBDetail *WEBDetail `json:"WEBDetail,omitempty"`
 
 	// Internal fields for auditing and tracing
-	fileID        string
-	transactionID string
+	transferID     TransferID
+	fileID         string
+	transactionID  string
+	ofacScreenings []*OFACScreening
 }
 
 func (r transferRequest) missingFields() error {
@@ -161,6 +178,7 @@ func (r transferRequest) asTransfer(id string) *Transfer {
 		StandardEntryClassCode: r.StandardEntryClassCode,
 		Status:                 TransferPending,
 		SameDay:                r.SameDay,
+		Gateway:                r.Gateway,
 		Created:                base.Now(),
 	}
 	// Copy along the YYYDetail sub-object for specific SEC codes
@@ -214,6 +232,10 @@ const (
 	TransferPending   TransferStatus = "pending"
 	TransferProcessed TransferStatus = "processed"
 	TransferReclaimed TransferStatus = "reclaimed"
+
+	// TransferAwaitingApproval is the status of Transfers at or over TRANSFER_APPROVAL_THRESHOLD. They become
+	// Pending once approved by another user, or Canceled when rejected or not approved in time.
+	TransferAwaitingApproval TransferStatus = "awaiting_approval"
 )
 
 func (ts TransferStatus) Equal(other TransferStatus) bool {
@@ -222,7 +244,7 @@ func (ts TransferStatus) Equal(other TransferStatus) bool {
 
 func (ts TransferStatus) validate() error {
 	switch ts {
-	case TransferCanceled, TransferFailed, TransferPending, TransferProcessed, TransferReclaimed:
+	case TransferCanceled, TransferFailed, TransferPending, TransferProcessed, TransferReclaimed, TransferAwaitingApproval:
 		return nil
 	default:
 		return fmt.Errorf("TransferStatus(%s) is invalid", ts)
@@ -249,11 +271,18 @@ type TransferRouter struct {
 	receiverRepository receiverRepository
 	origRepo           originatorRepository
 	transferRepo       transferRepository
+	authorizationRepo  authorizationRepository
+	gatewayRepo        gatewayRepository
+	approverRepo       transferApproverRepository
+	idempotencyRepo    idempotencyKeyRepository
+	fileTransferRepo   filetransfer.Repository
+
+	ofacClient     OFACClient
+	ofacReviewRepo ofacReviewRepository
 
 	achClientFactory func(userID string) *achclient.ACH
 
-	accountsClient        AccountsClient
-	accountsCallsDisabled bool
+	accountsClient AccountsClient
 }
 
 func NewTransferRouter(
@@ -263,20 +292,32 @@ func NewTransferRouter(
 	receiverRepo receiverRepository,
 	originatorsRepo originatorRepository,
 	transferRepo transferRepository,
+	authorizationRepo authorizationRepository,
+	gatewayRepo gatewayRepository,
+	approverRepo transferApproverRepository,
+	idempotencyRepo idempotencyKeyRepository,
+	fileTransferRepo filetransfer.Repository,
+	ofacClient OFACClient,
+	ofacReviewRepo ofacReviewRepository,
 	achClientFactory func(userID string) *achclient.ACH,
 	accountsClient AccountsClient,
-	accountsCallsDisabled bool,
 ) *TransferRouter {
 	return &TransferRouter{
-		logger:                logger,
-		depRepo:               depositoryRepo,
-		eventRepo:             eventRepo,
-		receiverRepository:    receiverRepo,
-		origRepo:              originatorsRepo,
-		transferRepo:          transferRepo,
-		achClientFactory:      achClientFactory,
-		accountsClient:        accountsClient,
-		accountsCallsDisabled: accountsCallsDisabled,
+		logger:             logger,
+		depRepo:            depositoryRepo,
+		eventRepo:          eventRepo,
+		receiverRepository: receiverRepo,
+		origRepo:           originatorsRepo,
+		transferRepo:       transferRepo,
+		authorizationRepo:  authorizationRepo,
+		gatewayRepo:        gatewayRepo,
+		approverRepo:       approverRepo,
+		idempotencyRepo:    idempotencyRepo,
+		fileTransferRepo:   fileTransferRepo,
+		ofacClient:         ofacClient,
+		ofacReviewRepo:     ofacReviewRepo,
+		achClientFactory:   achClientFactory,
+		accountsClient:     accountsClient,
 	}
 }
 
@@ -286,10 +327,13 @@ func (c *TransferRouter) RegisterRoutes(router *mux.Router) {
 
 	router.Methods("POST").Path("/transfers").HandlerFunc(c.createUserTransfers())
 	router.Methods("POST").Path("/transfers/batch").HandlerFunc(c.createUserTransfers())
+	router.Methods("POST").Path("/transfers/preview").HandlerFunc(c.previewUserTransfers())
 
 	router.Methods("DELETE").Path("/transfers/{transferId}").HandlerFunc(c.deleteUserTransfer())
 
 	router.Methods("GET").Path("/transfers/{transferId}/events").HandlerFunc(c.getUserTransferEvents())
+	router.Methods("POST").Path("/transfers/{transferId}/approve").HandlerFunc(c.reviewTransfer(TransferPending))
+	router.Methods("POST").Path("/transfers/{transferId}/reject").HandlerFunc(c.reviewTransfer(TransferCanceled))
 	router.Methods("POST").Path("/transfers/{transferId}/failed").HandlerFunc(c.validateUserTransfer())
 	router.Methods("POST").Path("/transfers/{transferId}/files").HandlerFunc(c.getUserTransferFiles())
 }
@@ -374,8 +418,8 @@ func readTransferRequests(r *http.Request) ([]*transferRequest, error) {
 }
 
 func (c *TransferRouter) createUserTransfers() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		w, err := wrapResponseWriter(c.logger, w, r)
+	return idempotentHandler(c.logger, c.idempotencyRepo, func(w http.ResponseWriter, r *http.Request) {
+		w, err := ensureHeaders(c.logger, w, r)
 		if err != nil {
 			return
 		}
@@ -384,6 +428,7 @@ func (c *TransferRouter) createUserTransfers() http.HandlerFunc {
 		requests, err := readTransferRequests(r)
 		if err != nil {
 			fmt.Printf("A: %v\n", err)
+			finalIdempotentResponse(r)
 			moovhttp.Problem(w, err)
 			return
 		}
@@ -397,12 +442,21 @@ func (c *TransferRouter) createUserTransfers() http.HandlerFunc {
 			idempotencyKey = base.ID()
 		}
 
+		// Reject transfers over a TransferLimit before anything is posted to Accounts
+		if err := c.transferRepo.checkTransferLimits(userID, requests); err != nil {
+			c.logger.Log("transfers", fmt.Sprintf("rejecting transfers: %v", err), "requestID", requestID, "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+
 		for i := range requests {
 			id, req := base.ID(), requests[i]
 			if err := req.missingFields(); err != nil {
+				finalIdempotentResponse(r)
 				moovhttp.Problem(w, err)
 				return
 			}
+			req.transferID = TransferID(id)
 
 			// Grab and validate objects required for this transfer.
 			receiver, receiverDep, orig, origDep, err := getTransferObjects(req, userID, c.depRepo, c.receiverRepository, c.origRepo)
@@ -415,9 +469,42 @@ func (c *TransferRouter) createUserTransfers() http.HandlerFunc {
 				return
 			}
 
+			// The Gateway's routing numbers and names go in the ACH file header
+			gateway, err := getTransferGateway(c.gatewayRepo, req.Gateway, userID)
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if gateway != nil {
+				req.Gateway = gateway.ID
+			}
+
+			// Consumer debits need proof the Receiver authorized them
+			if err := checkDebitAuthorization(c.authorizationRepo, userID, req); err != nil {
+				c.logger.Log("transfers", fmt.Sprintf("rejecting transfer: %v", err), "requestID", requestID, "userID", userID)
+				moovhttp.Problem(w, err)
+				return
+			}
+
+			// Every party of an IAT transfer is screened against OFAC
+			if req.StandardEntryClassCode == "IAT" {
+				screenings, err := screenIATParties(c.ofacClient, c.ofacReviewRepo, req.IATDetail, userID, requestID)
+				if err != nil {
+					c.logger.Log("transfers", fmt.Sprintf("rejecting IAT transfer: %v", err), "requestID", requestID, "userID", userID)
+					if e, ok := err.(*IATScreeningError); ok {
+						w.WriteHeader(http.StatusBadRequest)
+						json.NewEncoder(w).Encode(iatScreeningResponse{Error: e.Error(), Matches: e.Matches})
+						return
+					}
+					moovhttp.Problem(w, err)
+					return
+				}
+				req.ofacScreenings = screenings
+			}
+
 			// Post the Transfer's transaction against the Accounts
 			var transactionID string
-			if !c.accountsCallsDisabled {
+			if c.accountsClient != nil {
 				tx, err := c.postAccountTransaction(userID, origDep, receiverDep, req.Amount, req.Type, requestID)
 				if err != nil {
 					c.logger.Log("transfers", err.Error())
@@ -429,7 +516,7 @@ func (c *TransferRouter) createUserTransfers() http.HandlerFunc {
 
 			// Save Transfer object
 			transfer := req.asTransfer(id)
-			file, err := constructACHFile(id, idempotencyKey, userID, transfer, receiver, receiverDep, orig, origDep)
+			file, err := constructACHFile(id, idempotencyKey, userID, transfer, receiver, receiverDep, orig, origDep, gateway)
 			if err != nil {
 				moovhttp.Problem(w, err)
 				return
@@ -463,12 +550,29 @@ func (c *TransferRouter) createUserTransfers() http.HandlerFunc {
 		transfers, err := c.transferRepo.createUserTransfers(userID, requests)
 		if err != nil {
 			c.logger.Log("transfers", fmt.Sprintf("error creating transfers: %v", err), "requestID", requestID, "userID", userID)
+			c.reverseTransactions(userID, requestID, requests)
 			moovhttp.Problem(w, err)
 			return
 		}
 
 		writeResponse(c.logger, w, len(requests), transfers)
 		c.logger.Log("transfers", fmt.Sprintf("Created transfers for user_id=%s request=%s", userID, requestID))
+	})
+}
+
+// reverseTransactions undoes the Accounts transactions posted for requests which weren't saved, i.e. when a
+// concurrent request used up a TransferLimit.
+func (c *TransferRouter) reverseTransactions(userID string, requestID string, requests []*transferRequest) {
+	if c.accountsClient == nil {
+		return
+	}
+	for i := range requests {
+		if requests[i].transactionID == "" {
+			continue
+		}
+		if _, err := c.accountsClient.ReverseTransaction(requestID, userID, requests[i].transactionID); err != nil {
+			c.logger.Log("transfers", fmt.Sprintf("problem reversing transaction=%s: %v", requests[i].transactionID, err), "requestID", requestID, "userID", userID)
+		}
 	}
 }
 
@@ -524,7 +628,7 @@ func (c *TransferRouter) deleteUserTransfer() http.HandlerFunc {
 			moovhttp.Problem(w, err)
 			return
 		}
-		if transfer.Status != TransferPending {
+		if transfer.Status != TransferPending && transfer.Status != TransferAwaitingApproval {
 			moovhttp.Problem(w, fmt.Errorf("a %s transfer can't be deleted", transfer.Status))
 			return
 		}
@@ -551,6 +655,91 @@ func (c *TransferRouter) deleteUserTransfer() http.HandlerFunc {
 	}
 }
 
+// reviewTransfer returns an http.HandlerFunc which approves (status is Pending) or rejects (status is Canceled) a
+// Transfer awaiting approval. The reviewer must be one of the creator's TransferApprovers and can't be the creator.
+func (c *TransferRouter) reviewTransfer(status TransferStatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(c.logger, w, r)
+		if err != nil {
+			return
+		}
+
+		var req struct {
+			Reason string `json:"reason"`
+		}
+		if status == TransferCanceled {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if req.Reason == "" {
+				moovhttp.Problem(w, errors.New("missing reason"))
+				return
+			}
+		}
+
+		id, reviewerID := getTransferID(r), moovhttp.GetUserID(r)
+		requestID := moovhttp.GetRequestID(r)
+		transfer, err := c.transferRepo.getTransfer(id)
+		if err != nil {
+			c.logger.Log("transfers", fmt.Sprintf("error reading transfer=%s for review: %v", id, err), "requestID", requestID, "userID", reviewerID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		if transfer == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if transfer.userID == reviewerID {
+			moovhttp.Problem(w, errors.New("a transfer must be reviewed by a different user than its creator"))
+			return
+		}
+		if ok, err := c.approverRepo.isTransferApprover(transfer.userID, reviewerID); err != nil || !ok {
+			if err != nil {
+				moovhttp.Problem(w, err)
+			} else {
+				http.NotFound(w, r) // the Transfer belongs to a user the reviewer can't approve for
+			}
+			return
+		}
+		if err := c.transferRepo.reviewTransfer(id, status, reviewerID); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		event := &Event{
+			ID:      EventID(base.ID()),
+			Topic:   fmt.Sprintf("transfer %s approved", id),
+			Message: fmt.Sprintf("approved by %s", reviewerID),
+			Type:    TransferEvent,
+			Metadata: map[string]string{
+				eventTransferID: string(id),
+			},
+		}
+		if status == TransferCanceled {
+			event.Topic = fmt.Sprintf("transfer %s rejected", id)
+			event.Message = fmt.Sprintf("rejected by %s: %s", reviewerID, req.Reason)
+
+			if err := reverseTransferTransaction(c.accountsClient, c.transferRepo, requestID, transfer); err != nil {
+				c.logger.Log("transfers", fmt.Sprintf("problem reversing transaction=%s of rejected transfer=%s: %v", transfer.transactionID, id, err), "requestID", requestID, "userID", transfer.userID)
+			}
+		}
+		if err := c.eventRepo.writeEvent(transfer.userID, event); err != nil {
+			c.logger.Log("transfers", fmt.Sprintf("error writing transfer=%s review event: %v", id, err), "requestID", requestID, "userID", transfer.userID)
+		}
+		c.logger.Log("transfers", fmt.Sprintf("transfer=%s is %s after review", id, status), "requestID", requestID, "userID", reviewerID)
+
+		transfer, err = c.transferRepo.getUserTransfer(id, transfer.userID)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(transfer)
+	}
+}
+
 // POST /transfers/{id}/failed
 // 200 - no errors
 // 400 - errors, check json
@@ -651,6 +840,18 @@ type transferRepository interface {
 	getUserTransfer(id TransferID, userID string) (*Transfer, error)
 	updateTransferStatus(id TransferID, status TransferStatus) error
 
+	// getTransfer returns the Transfer (with its userID and transactionID) regardless of the user who created it,
+	// or nil if it doesn't exist.
+	getTransfer(id TransferID) (*Transfer, error)
+
+	// reviewTransfer moves a Transfer awaiting approval to status (Pending when approved, Canceled when rejected)
+	// and records who reviewed it.
+	reviewTransfer(id TransferID, status TransferStatus, reviewedBy string) error
+
+	// cancelExpiredTransfers cancels Transfers created before the given time which are still awaiting approval.
+	// The canceled Transfers are returned with their userID and transactionID.
+	cancelExpiredTransfers(createdBefore time.Time) ([]*Transfer, error)
+
 	getFileIDForTransfer(id TransferID, userID string) (string, error)
 
 	lookupTransferFromReturn(sec string, amount *Amount, traceNumber string, effectiveEntryDate time.Time) (*Transfer, error)
@@ -664,8 +865,61 @@ type transferRepository interface {
 	getTransferCursor(batchSize int, depRepo DepositoryRepository) *transferCursor
 	markTransferAsMerged(id TransferID, filename string, traceNumber string) error
 
+	// getMergedTransfers returns the Transfers (with their userID and transactionID) merged into filename with any
+	// of the given trace numbers.
+	getMergedTransfers(filename string, traceNumbers []string) ([]*Transfer, error)
+
+	// checkTransferLimits returns a *TransferLimitError if requests would go over a TransferLimit,
+	// createUserTransfers enforces the limits atomically.
+	checkTransferLimits(userID string, requests []*transferRequest) error
 	createUserTransfers(userID string, requests []*transferRequest) ([]*Transfer, error)
 	deleteUserTransfer(id TransferID, userID string) error
+
+	// failPendingDepositoryTransfers marks Pending Transfers which haven't been merged and reference the Depository
+	// as Failed. The IDs of failed Transfers are returned.
+	failPendingDepositoryTransfers(id DepositoryID, userID string) ([]TransferID, error)
+
+	// failPendingReceiverTransfers and failPendingOriginatorTransfers are like failPendingDepositoryTransfers
+	// for Transfers to a Receiver or from an Originator.
+	failPendingReceiverTransfers(id ReceiverID, userID string) ([]TransferID, error)
+	failPendingOriginatorTransfers(id OriginatorID, userID string) ([]TransferID, error)
+
+	// claimTransactionReversal records that the Transfer's Accounts transaction is being reversed. It returns false
+	// if the reversal was already recorded. releaseTransactionReversal removes the record when reversing fails.
+	claimTransactionReversal(id TransferID) (bool, error)
+	releaseTransactionReversal(id TransferID) error
+
+	// setReversalTransactionID saves the ID of the Accounts transaction which reversed the Transfer's, so
+	// reconciliation can match the reversal to the Transfer.
+	setReversalTransactionID(id TransferID, transactionID string) error
+}
+
+// reverseTransferTransaction reverses the Accounts transaction posted when a Transfer was created. The reversal is
+// recorded before calling Accounts so retrying whatever failed the Transfer doesn't reverse it twice.
+func reverseTransferTransaction(accountsClient AccountsClient, transferRepo transferRepository, requestID string, xfer *Transfer) error {
+	if accountsClient == nil || xfer == nil || xfer.transactionID == "" {
+		return nil
+	}
+	claimed, err := transferRepo.claimTransactionReversal(xfer.ID)
+	if err != nil {
+		return fmt.Errorf("problem recording reversal of transfer=%s: %v", xfer.ID, err)
+	}
+	if !claimed {
+		return nil // already reversed
+	}
+	reversal, err := accountsClient.ReverseTransaction(requestID, xfer.userID, xfer.transactionID)
+	if err != nil {
+		if releaseErr := transferRepo.releaseTransactionReversal(xfer.ID); releaseErr != nil {
+			return fmt.Errorf("transfer=%s: %v (and releasing reversal: %v)", xfer.ID, err, releaseErr)
+		}
+		return fmt.Errorf("transfer=%s: %v", xfer.ID, err)
+	}
+	if reversal != nil {
+		if err := transferRepo.setReversalTransactionID(xfer.ID, reversal.ID); err != nil {
+			return fmt.Errorf("problem saving reversal transaction=%s of transfer=%s: %v", reversal.ID, xfer.ID, err)
+		}
+	}
+	return nil
 }
 
 func NewTransferRepo(logger log.Logger, db *sql.DB) *SQLTransferRepo {
@@ -715,7 +969,7 @@ func (r *SQLTransferRepo) getUserTransfers(userID string) ([]*Transfer, error) {
 }
 
 func (r *SQLTransferRepo) getUserTransfer(id TransferID, userID string) (*Transfer, error) {
-	query := `select transfer_id, type, amount, originator_id, originator_depository, receiver, receiver_depository, description, standard_entry_class_code, status, same_day, created_at
+	query := `select transfer_id, type, amount, originator_id, originator_depository, receiver, receiver_depository, description, standard_entry_class_code, status, same_day, coalesce(gateway_id, ''), created_at, coalesce(reviewed_by, ''), reviewed_at
 from transfers
 where transfer_id = ? and user_id = ? and deleted_at is null
 limit 1`
@@ -729,14 +983,19 @@ limit 1`
 
 	transfer := &Transfer{}
 	var (
-		amt     string
-		created time.Time
+		amt      string
+		created  time.Time
+		reviewed *time.Time
 	)
-	err = row.Scan(&transfer.ID, &transfer.Type, &amt, &transfer.Originator, &transfer.OriginatorDepository, &transfer.Receiver, &transfer.ReceiverDepository, &transfer.Description, &transfer.StandardEntryClassCode, &transfer.Status, &transfer.SameDay, &created)
+	err = row.Scan(&transfer.ID, &transfer.Type, &amt, &transfer.Originator, &transfer.OriginatorDepository, &transfer.Receiver, &transfer.ReceiverDepository, &transfer.Description, &transfer.StandardEntryClassCode, &transfer.Status, &transfer.SameDay, &transfer.Gateway, &created, &transfer.ReviewedBy, &reviewed)
 	if err != nil {
 		return nil, err
 	}
 	transfer.Created = base.NewTime(created)
+	if reviewed != nil {
+		t := base.NewTime(*reviewed)
+		transfer.Reviewed = &t
+	}
 	// parse Amount struct
 	if err := transfer.Amount.FromString(amt); err != nil {
 		return nil, err
@@ -744,9 +1003,59 @@ limit 1`
 	if transfer.ID == "" {
 		return nil, nil // not found
 	}
+	if transfer.StandardEntryClassCode == ach.IAT {
+		if transfer.OFACScreenings, err = r.getOFACScreenings(transfer.ID); err != nil {
+			return nil, err
+		}
+	}
 	return transfer, nil
 }
 
+func (r *SQLTransferRepo) getOFACScreenings(id TransferID) ([]*OFACScreening, error) {
+	query := `select party, name, entity_id, sdn_match, blocked from transfer_ofac_screenings where transfer_id = ? order by created_at asc;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var screenings []*OFACScreening
+	for rows.Next() {
+		var screening OFACScreening
+		if err := rows.Scan(&screening.Party, &screening.Name, &screening.EntityID, &screening.Match, &screening.Blocked); err != nil {
+			return nil, fmt.Errorf("getOFACScreenings: scan: %v", err)
+		}
+		screenings = append(screenings, &screening)
+	}
+	return screenings, rows.Err()
+}
+
+func saveOFACScreenings(tx *sql.Tx, id TransferID, screenings []*OFACScreening, created time.Time) error {
+	if len(screenings) == 0 {
+		return nil
+	}
+	query := `insert into transfer_ofac_screenings (transfer_id, party, name, entity_id, sdn_match, blocked, created_at) values (?, ?, ?, ?, ?, ?, ?);`
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i := range screenings {
+		s := screenings[i]
+		if _, err := stmt.Exec(id, s.Party, s.Name, s.EntityID, s.Match, s.Blocked, created); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (r *SQLTransferRepo) updateTransferStatus(id TransferID, status TransferStatus) error {
 	query := `update transfers set status = ? where transfer_id = ? and deleted_at is null`
 	stmt, err := r.db.Prepare(query)
@@ -759,6 +1068,215 @@ func (r *SQLTransferRepo) updateTransferStatus(id TransferID, status TransferSta
 	return err
 }
 
+func (r *SQLTransferRepo) getTransfer(id TransferID) (*Transfer, error) {
+	query := `select user_id, coalesce(transaction_id, '') from transfers where transfer_id = ? and deleted_at is null limit 1`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var userID, transactionID string
+	if err := stmt.QueryRow(id).Scan(&userID, &transactionID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	transfer, err := r.getUserTransfer(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	transfer.userID, transfer.transactionID = userID, transactionID
+	return transfer, nil
+}
+
+func (r *SQLTransferRepo) reviewTransfer(id TransferID, status TransferStatus, reviewedBy string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	query := `update transfers set status = ?, reviewed_by = ?, reviewed_at = ? where transfer_id = ? and status = ? and deleted_at is null`
+	res, err := tx.Exec(query, status, reviewedBy, time.Now(), id, TransferAwaitingApproval)
+	if err != nil {
+		return fmt.Errorf("reviewTransfer: error=%v rollback=%v", err, tx.Rollback())
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		tx.Rollback()
+		return fmt.Errorf("transfer %s is not %s", id, TransferAwaitingApproval)
+	}
+	// rejected Transfers no longer count against TransferLimits
+	if status == TransferCanceled {
+		if err := releaseTransferLimitUsage(tx, id); err != nil {
+			return fmt.Errorf("reviewTransfer: release error=%v rollback=%v", err, tx.Rollback())
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *SQLTransferRepo) cancelExpiredTransfers(createdBefore time.Time) ([]*Transfer, error) {
+	query := `select transfer_id, user_id, coalesce(transaction_id, '') from transfers where status = ? and created_at < ? and deleted_at is null`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(TransferAwaitingApproval, createdBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var expired []*Transfer
+	for rows.Next() {
+		var xfer Transfer
+		if err := rows.Scan(&xfer.ID, &xfer.userID, &xfer.transactionID); err != nil {
+			return nil, fmt.Errorf("cancelExpiredTransfers: scan: %v", err)
+		}
+		expired = append(expired, &xfer)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var canceled []*Transfer
+	for i := range expired {
+		ok, err := r.cancelExpiredTransfer(expired[i].ID)
+		if err != nil {
+			return canceled, fmt.Errorf("cancelExpiredTransfers: transfer=%s: %v", expired[i].ID, err)
+		}
+		if ok { // skip Transfers reviewed since they were read
+			expired[i].Status = TransferCanceled
+			canceled = append(canceled, expired[i])
+		}
+	}
+	return canceled, nil
+}
+
+// cancelExpiredTransfer cancels a Transfer which is still awaiting approval and releases its TransferLimit usage.
+func (r *SQLTransferRepo) cancelExpiredTransfer(id TransferID) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	query := `update transfers set status = ? where transfer_id = ? and status = ? and deleted_at is null`
+	res, err := tx.Exec(query, TransferCanceled, id, TransferAwaitingApproval)
+	if err != nil {
+		return false, fmt.Errorf("error=%v rollback=%v", err, tx.Rollback())
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, tx.Rollback()
+	}
+	if err := releaseTransferLimitUsage(tx, id); err != nil {
+		return false, fmt.Errorf("release error=%v rollback=%v", err, tx.Rollback())
+	}
+	return true, tx.Commit()
+}
+
+func (r *SQLTransferRepo) failPendingDepositoryTransfers(id DepositoryID, userID string) ([]TransferID, error) {
+	return r.failPendingTransfers(userID, "(originator_depository = ? or receiver_depository = ?)", id, id)
+}
+
+func (r *SQLTransferRepo) failPendingReceiverTransfers(id ReceiverID, userID string) ([]TransferID, error) {
+	return r.failPendingTransfers(userID, "receiver = ?", id)
+}
+
+func (r *SQLTransferRepo) failPendingOriginatorTransfers(id OriginatorID, userID string) ([]TransferID, error) {
+	return r.failPendingTransfers(userID, "originator_id = ?", id)
+}
+
+// failPendingTransfers marks the user's Pending (or awaiting approval), unmerged Transfers matching condition as Failed.
+func (r *SQLTransferRepo) failPendingTransfers(userID string, condition string, args ...interface{}) ([]TransferID, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `select transfer_id from transfers
+where user_id = ? and ` + condition + ` and status in (?, ?) and merged_filename is null and deleted_at is null`
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return nil, fmt.Errorf("failPendingTransfers: prepare error=%v rollback=%v", err, tx.Rollback())
+	}
+	args = append(append([]interface{}{userID}, args...), TransferPending, TransferAwaitingApproval)
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		stmt.Close()
+		return nil, fmt.Errorf("failPendingTransfers: query error=%v rollback=%v", err, tx.Rollback())
+	}
+	var transferIDs []TransferID
+	for rows.Next() {
+		var transferID string
+		if err := rows.Scan(&transferID); err != nil {
+			rows.Close()
+			stmt.Close()
+			return nil, fmt.Errorf("failPendingTransfers: scan error=%v rollback=%v", err, tx.Rollback())
+		}
+		transferIDs = append(transferIDs, TransferID(transferID))
+	}
+	rows.Close()
+	stmt.Close()
+
+	query = `update transfers set status = ? where transfer_id = ? and status in (?, ?) and merged_filename is null and deleted_at is null`
+	stmt, err = tx.Prepare(query)
+	if err != nil {
+		return nil, fmt.Errorf("failPendingTransfers: prepare error=%v rollback=%v", err, tx.Rollback())
+	}
+	defer stmt.Close()
+	for i := range transferIDs {
+		if _, err := stmt.Exec(TransferFailed, transferIDs[i], TransferPending, TransferAwaitingApproval); err != nil {
+			return nil, fmt.Errorf("failPendingTransfers: transfer=%s error=%v rollback=%v", transferIDs[i], err, tx.Rollback())
+		}
+		// failed Transfers no longer count against TransferLimits
+		if err := releaseTransferLimitUsage(tx, transferIDs[i]); err != nil {
+			return nil, fmt.Errorf("failPendingTransfers: transfer=%s release error=%v rollback=%v", transferIDs[i], err, tx.Rollback())
+		}
+	}
+	return transferIDs, tx.Commit()
+}
+
+func (r *SQLTransferRepo) claimTransactionReversal(id TransferID) (bool, error) {
+	query := `update transfers set transaction_reversed_at = ? where transfer_id = ? and transaction_reversed_at is null and deleted_at is null`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(time.Now(), id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+func (r *SQLTransferRepo) releaseTransactionReversal(id TransferID) error {
+	query := `update transfers set transaction_reversed_at = null where transfer_id = ? and deleted_at is null`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(id)
+	return err
+}
+
+func (r *SQLTransferRepo) setReversalTransactionID(id TransferID, transactionID string) error {
+	query := `update transfers set reversal_transaction_id = ? where transfer_id = ? and deleted_at is null`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(transactionID, id)
+	return err
+}
+
 func (r *SQLTransferRepo) getFileIDForTransfer(id TransferID, userID string) (string, error) {
 	query := `select file_id from transfers where transfer_id = ? and user_id = ? and deleted_at is null limit 1;`
 	stmt, err := r.db.Prepare(query)
@@ -811,20 +1329,56 @@ func (r *SQLTransferRepo) setReturnCode(id TransferID, returnCode string) error
 	return err
 }
 
+// checkTransferLimits returns a *TransferLimitError if creating requests would go over a TransferLimit. Nothing is
+// reserved, so createUserTransfers can still be rejected by a concurrent request.
+func (r *SQLTransferRepo) checkTransferLimits(userID string, requests []*transferRequest) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	err = reserveTransferLimits(tx, userID, requests, nil, time.Now())
+	tx.Rollback()
+	return err
+}
+
+// createUserTransfers writes Transfers for each request after reserving them against every TransferLimit
+// which applies. A *TransferLimitError is returned if any limit would be exceeded and nothing is written.
 func (r *SQLTransferRepo) createUserTransfers(userID string, requests []*transferRequest) ([]*Transfer, error) {
-	query := `insert into transfers (transfer_id, user_id, type, amount, originator_id, originator_depository, receiver, receiver_depository, description, standard_entry_class_code, status, same_day, file_id, transaction_id, created_at) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-	stmt, err := r.db.Prepare(query)
+	now := time.Now()
+
+	// Transfers need their IDs before reserving limits so the reservations can be released
+	transferIDs := make([]TransferID, len(requests))
+	for i := range requests {
+		transferIDs[i] = requests[i].transferID
+		if transferIDs[i] == "" {
+			transferIDs[i] = TransferID(base.ID())
+		}
+	}
+
+	tx, err := r.db.Begin()
 	if err != nil {
 		return nil, err
 	}
+	if err := reserveTransferLimits(tx, userID, requests, transferIDs, now); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	query := `insert into transfers (transfer_id, user_id, type, amount, originator_id, originator_depository, receiver, receiver_depository, description, standard_entry_class_code, status, same_day, file_id, transaction_id, gateway_id, created_at) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return nil, fmt.Errorf("createUserTransfers: prepare error=%v rollback=%v", err, tx.Rollback())
+	}
 	defer stmt.Close()
 
 	var transfers []*Transfer
 
-	now := time.Now()
-	var status TransferStatus = TransferPending
 	for i := range requests {
-		req, transferId := requests[i], base.ID()
+		req, transferId := requests[i], string(transferIDs[i])
+		status := TransferPending
+		if requiresTransferApproval(req.Amount) {
+			status = TransferAwaitingApproval
+		}
 		xfer := &Transfer{
 			ID:                     TransferID(transferId),
 			Type:                   req.Type,
@@ -837,20 +1391,26 @@ func (r *SQLTransferRepo) createUserTransfers(userID string, requests []*transfe
 			StandardEntryClassCode: req.StandardEntryClassCode,
 			Status:                 status,
 			SameDay:                req.SameDay,
+			Gateway:                req.Gateway,
 			Created:                base.NewTime(now),
 		}
 		if err := xfer.validate(); err != nil {
+			tx.Rollback()
 			return nil, fmt.Errorf("validation failed for transfer Originator=%s, Receiver=%s, Description=%s %v", xfer.Originator, xfer.Receiver, xfer.Description, err)
 		}
 
 		// write transfer
-		_, err := stmt.Exec(transferId, userID, req.Type, req.Amount.String(), req.Originator, req.OriginatorDepository, req.Receiver, req.ReceiverDepository, req.Description, req.StandardEntryClassCode, status, req.SameDay, req.fileID, req.transactionID, now)
+		_, err := stmt.Exec(transferId, userID, req.Type, req.Amount.String(), req.Originator, req.OriginatorDepository, req.Receiver, req.ReceiverDepository, req.Description, req.StandardEntryClassCode, status, req.SameDay, req.fileID, req.transactionID, req.Gateway, now)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("createUserTransfers: exec error=%v rollback=%v", err, tx.Rollback())
+		}
+		if err := saveOFACScreenings(tx, xfer.ID, req.ofacScreenings, now); err != nil {
+			return nil, fmt.Errorf("createUserTransfers: error=%v rollback=%v", err, tx.Rollback())
 		}
+		xfer.OFACScreenings = req.ofacScreenings
 		transfers = append(transfers, xfer)
 	}
-	return transfers, nil
+	return transfers, tx.Commit()
 }
 
 func (r *SQLTransferRepo) deleteUserTransfer(id TransferID, userID string) error {
@@ -875,6 +1435,8 @@ type transferCursor struct {
 	// newerThan represents the minimum (oldest) created_at value to return in the batch.
 	// The value starts at today's first instant and progresses towards time.Now() with each
 	// batch by being set to the batch's newest time.
+	//
+	// Transfers which needed approval are read by when they were approved (reviewed_at) instead.
 	newerThan time.Time
 }
 
@@ -896,14 +1458,16 @@ type groupableTransfer struct {
 // TODO(adam): should we have a field on transfers for marking when the ACH file is uploaded?
 // "after the file is uploaded we mark the items in the DB with the batch number and upload time and update the status" -- Wade
 func (cur *transferCursor) Next() ([]*groupableTransfer, error) {
-	query := `select transfer_id, user_id, created_at from transfers where status = ? and merged_filename is null and created_at > ? and deleted_at is null order by created_at asc limit ?`
+	query := `select transfer_id, user_id, created_at, reviewed_at from transfers
+where status = ? and merged_filename is null and coalesce(reviewed_at, created_at) > ? and deleted_at is null
+order by coalesce(reviewed_at, created_at) asc limit ?`
 	stmt, err := cur.transferRepo.db.Prepare(query)
 	if err != nil {
 		return nil, fmt.Errorf("transferCursor.Next: prepare: %v", err)
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(TransferPending, cur.newerThan, cur.batchSize) // only Pending transfers
+	rows, err := stmt.Query(TransferPending, cur.newerThan, cur.batchSize) // only Pending (or approved) transfers
 	if err != nil {
 		return nil, fmt.Errorf("transferCursor.Next: query: %v", err)
 	}
@@ -916,9 +1480,13 @@ func (cur *transferCursor) Next() ([]*groupableTransfer, error) {
 	var xfers []xfer
 	for rows.Next() {
 		var xf xfer
-		if err := rows.Scan(&xf.transferId, &xf.userID, &xf.createdAt); err != nil {
+		var reviewedAt *time.Time
+		if err := rows.Scan(&xf.transferId, &xf.userID, &xf.createdAt, &reviewedAt); err != nil {
 			return nil, fmt.Errorf("transferCursor.Next: scan: %v", err)
 		}
+		if reviewedAt != nil {
+			xf.createdAt = *reviewedAt
+		}
 		if xf.transferId != "" {
 			xfers = append(xfers, xf)
 		}
@@ -976,6 +1544,56 @@ where status = ? and transfer_id = ? and (merged_filename is null or merged_file
 	return err
 }
 
+func (r *SQLTransferRepo) getMergedTransfers(filename string, traceNumbers []string) ([]*Transfer, error) {
+	if len(traceNumbers) == 0 {
+		return nil, nil
+	}
+	query := fmt.Sprintf(`select transfer_id, user_id, coalesce(transaction_id, '') from transfers where merged_filename = ? and trace_number in (?%s) and deleted_at is null`, strings.Repeat(", ?", len(traceNumbers)-1))
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	args := []interface{}{filename}
+	for i := range traceNumbers {
+		args = append(args, traceNumbers[i])
+	}
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type row struct {
+		id            TransferID
+		userID        string
+		transactionID string
+	}
+	var found []row
+	for rows.Next() {
+		var rr row
+		if err := rows.Scan(&rr.id, &rr.userID, &rr.transactionID); err != nil {
+			return nil, fmt.Errorf("getMergedTransfers: scan: %v", err)
+		}
+		found = append(found, rr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var transfers []*Transfer
+	for i := range found {
+		xfer, err := r.getUserTransfer(found[i].id, found[i].userID)
+		if err != nil {
+			return nil, fmt.Errorf("getMergedTransfers: transfer=%s: %v", found[i].id, err)
+		}
+		xfer.userID, xfer.transactionID = found[i].userID, found[i].transactionID
+		transfers = append(transfers, xfer)
+	}
+	return transfers, nil
+}
+
 // aba8 returns the first 8 digits of an ABA routing number.
 // If the input is invalid then an empty string is returned.
 func aba8(rtn string) string {
@@ -1034,6 +1652,9 @@ func getTransferObjects(req *transferRequest, userID string, depRepo DepositoryR
 	if err := orig.validate(); err != nil {
 		return nil, nil, nil, nil, fmt.Errorf("originator: %v", err)
 	}
+	if orig.Status == OriginatorSuspended {
+		return nil, nil, nil, nil, fmt.Errorf("originator %s is suspended", orig.ID)
+	}
 
 	origDep, err := depRepo.getUserDepository(req.OriginatorDepository, userID)
 	if err != nil {
@@ -1050,7 +1671,9 @@ func getTransferObjects(req *transferRequest, userID string, depRepo DepositoryR
 }
 
 // constructACHFile will take in a Transfer and metadata to build an ACH file which can be submitted against an ACH instance.
-func constructACHFile(id, idempotencyKey, userID string, transfer *Transfer, receiver *Receiver, receiverDep *Depository, orig *Originator, origDep *Depository) (*ach.File, error) {
+// constructACHFile returns an ACH file with a batch for transfer. The file header is written from gateway when it's
+// non-nil and otherwise from the Originator and Receiver Depositories.
+func constructACHFile(id, idempotencyKey, userID string, transfer *Transfer, receiver *Receiver, receiverDep *Depository, orig *Originator, origDep *Depository, gateway *Gateway) (*ach.File, error) {
 	if transfer.Type == PullTransfer && receiver.Status != ReceiverVerified {
 		// TODO(adam): "additional checks" - check Receiver.Status ???
 		// https://github.com/moov-io/paygate/issues/18#issuecomment-432066045
@@ -1071,6 +1694,9 @@ func constructACHFile(id, idempotencyKey, userID string, transfer *Transfer, rec
 	file.Header.ImmediateOriginName = origDep.BankName
 	file.Header.ImmediateDestination = receiverDep.RoutingNumber
 	file.Header.ImmediateDestinationName = receiverDep.BankName
+	if gateway != nil {
+		setGatewayFileHeader(&file.Header, gateway)
+	}
 	file.Header.FileCreationDate = now.Format("060102") // YYMMDD
 	file.Header.FileCreationTime = now.Format("1504")   // HHMM
 
@@ -1181,6 +1807,16 @@ func writeTransferEvent(userID string, req *transferRequest, eventRepo EventRepo
 		Topic:   fmt.Sprintf("%s transfer to %s", req.Type, req.Description),
 		Message: req.Description,
 		Type:    TransferEvent,
+		Payload: eventPayload(req.asTransfer(string(req.transferID))),
+		Metadata: map[string]string{
+			eventTransferID:   string(req.transferID),
+			eventOriginatorID: string(req.Originator),
+			eventReceiverID:   string(req.Receiver),
+			eventFileID:       req.fileID,
+
+			"originatorDepositoryId": string(req.OriginatorDepository),
+			"receiverDepositoryId":   string(req.ReceiverDepository),
+		},
 	})
 }
 
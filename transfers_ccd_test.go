@@ -72,7 +72,7 @@ func TestCCD__createCCDBatch(t *testing.T) {
 		t.Error("nil CCD Batch")
 	}
 
-	file, err := constructACHFile(id, "", userID, transfer, receiver, receiverDep, orig, origDep)
+	file, err := constructACHFile(id, "", userID, transfer, receiver, receiverDep, orig, origDep, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -5,12 +5,15 @@
 package paygate
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/moov-io/ach"
 	"github.com/moov-io/base"
+	ofac "github.com/moov-io/ofac/client"
 )
 
 type IATDetail struct {
@@ -74,6 +77,121 @@ func (iat *IATDetail) validate() error {
 	return nil
 }
 
+// OFACScreening is the OFAC result for one party of a Transfer.
+type OFACScreening struct {
+	// Party is which part of the Transfer was screened (e.g. receiver, RDFI or receiverAddress)
+	Party string `json:"party"`
+
+	// Name is the name or address which was searched
+	Name string `json:"name"`
+
+	EntityID string  `json:"entityId,omitempty"`
+	Match    float32 `json:"match,omitempty"`
+	Blocked  bool    `json:"blocked"`
+}
+
+// IATScreeningError is returned when parties of an IAT transfer match OFAC.
+type IATScreeningError struct {
+	Matches []*OFACScreening
+}
+
+func (e *IATScreeningError) Error() string {
+	var parties []string
+	for i := range e.Matches {
+		parties = append(parties, fmt.Sprintf("%s %q matched SDN=%s (match=%.2f)", e.Matches[i].Party, e.Matches[i].Name, e.Matches[i].EntityID, e.Matches[i].Match))
+	}
+	return fmt.Sprintf("IAT: blocked by OFAC: %s", strings.Join(parties, ", "))
+}
+
+// iatScreeningResponse is the HTTP response body for an IATScreeningError
+type iatScreeningResponse struct {
+	Error   string           `json:"error"`
+	Matches []*OFACScreening `json:"matches"`
+}
+
+// screenIATParties searches OFAC for every party of an IAT transfer: the originator and receiver names and
+// addresses along with the ODFI, RDFI and foreign correspondent bank names. Every result is returned and an
+// *IATScreeningError if any party is blocked. Blocked matches are recorded for review in reviewRepo.
+func screenIATParties(api OFACClient, reviewRepo ofacReviewRepository, iat *IATDetail, userID string, requestID string) ([]*OFACScreening, error) {
+	if iat == nil {
+		return nil, errors.New("IAT: missing IATDetail")
+	}
+	names := []struct {
+		party, name string
+	}{
+		{"originator", iat.OriginatorName},
+		{"receiver", iat.ReceiverName},
+		{"ODFI", iat.ODFIName},
+		{"RDFI", iat.RDFIName},
+		{"foreignCorrespondentBank", iat.ForeignCorrespondentBankName},
+	}
+	var screenings, matches []*OFACScreening
+	for i := range names {
+		sdn, blocked, err := screenOFAC(api, reviewRepo, names[i].name, userID, requestID)
+		if err != nil {
+			return screenings, fmt.Errorf("IAT: problem screening %s: %v", names[i].party, err)
+		}
+		screening := &OFACScreening{Party: names[i].party, Name: names[i].name, Blocked: blocked}
+		if sdn != nil {
+			screening.EntityID, screening.Match = sdn.EntityID, sdn.Match
+		}
+		screenings = append(screenings, screening)
+		if blocked {
+			matches = append(matches, screening)
+		}
+	}
+
+	addresses := []struct {
+		party                              string
+		address, city, state, zip, country string
+	}{
+		{"originatorAddress", iat.OriginatorAddress, iat.OriginatorCity, iat.OriginatorState, iat.OriginatorPostalCode, iat.OriginatorCountryCode},
+		{"receiverAddress", iat.ReceiverAddress, iat.ReceiverCity, iat.ReceiverState, iat.ReceiverPostalCode, iat.ReceiverCountryCode},
+	}
+	for i := range addresses {
+		a := addresses[i]
+		screening, err := screenOFACAddress(api, reviewRepo, a.party, a.address, a.city, a.state, a.zip, a.country, userID, requestID)
+		if err != nil {
+			return screenings, fmt.Errorf("IAT: problem screening %s: %v", a.party, err)
+		}
+		screenings = append(screenings, screening)
+		if screening.Blocked {
+			matches = append(matches, screening)
+		}
+	}
+
+	if len(matches) > 0 {
+		return screenings, &IATScreeningError{Matches: matches}
+	}
+	return screenings, nil
+}
+
+// screenOFACAddress searches OFAC's address data. Matches over OFACMatchThreshold are blocked unless the address and
+// SDN have been cleared.
+func screenOFACAddress(api OFACClient, reviewRepo ofacReviewRepository, party, address, city, state, zip, country string, userID string, requestID string) (*OFACScreening, error) {
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	screening := &OFACScreening{
+		Party: party,
+		Name:  fmt.Sprintf("%s, %s, %s %s, %s", address, city, state, zip, country),
+	}
+	addr, err := api.SearchAddress(ctx, address, city, state, zip, country, requestID)
+	if err != nil || addr == nil {
+		return screening, err
+	}
+	screening.EntityID, screening.Match = addr.EntityID, addr.Match
+	if addr.Match > OFACMatchThreshold {
+		sdn := &ofac.Sdn{EntityID: addr.EntityID, Match: addr.Match}
+		cleared, _, err := reviewOFACMatch(reviewRepo, sdn, "", screening.Name, userID, requestID)
+		if err != nil {
+			return screening, err
+		}
+		screening.Blocked = !cleared
+	}
+	return screening, nil
+}
+
 func createIATBatch(id, userId string, transfer *Transfer, receiver *Receiver, receiverDep *Depository, orig *Originator, origDep *Depository) (*ach.IATBatch, error) {
 	if transfer == nil {
 		return nil, errors.New("IAT: nil Transfer")
@@ -5,9 +5,21 @@
 package paygate
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/moov-io/base"
+	ofac "github.com/moov-io/ofac/client"
+	"github.com/moov-io/paygate/internal/database"
+	"github.com/moov-io/paygate/pkg/achclient"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
 )
 
 func TestIAT__validate(t *testing.T) {
@@ -141,7 +153,7 @@ func TestIAT__createIATBatch(t *testing.T) {
 		t.Error("nil IAT Batch")
 	}
 
-	file, err := constructACHFile(id, "", userID, transfer, receiver, receiverDep, orig, origDep)
+	file, err := constructACHFile(id, "", userID, transfer, receiver, receiverDep, orig, origDep, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -149,3 +161,200 @@ func TestIAT__createIATBatch(t *testing.T) {
 		t.Error("nil IAT ach.File")
 	}
 }
+
+func testIATDetail() *IATDetail {
+	return &IATDetail{
+		OriginatorName:               "john doe",
+		OriginatorAddress:            "123 1st st",
+		OriginatorCity:               "anytown",
+		OriginatorState:              "PA",
+		OriginatorPostalCode:         "12345",
+		OriginatorCountryCode:        "US",
+		ODFIName:                     "my bank",
+		ODFIIDNumberQualifier:        "01",
+		ODFIIdentification:           "2",
+		ODFIBranchCurrencyCode:       "USD",
+		ReceiverName:                 "jane doe",
+		ReceiverAddress:              "321 2nd st",
+		ReceiverCity:                 "othertown",
+		ReceiverState:                "GB",
+		ReceiverPostalCode:           "54321",
+		ReceiverCountryCode:          "GB",
+		RDFIName:                     "their bank",
+		RDFIIDNumberQualifier:        "01",
+		RDFIIdentification:           "4",
+		RDFIBranchCurrencyCode:       "GBP",
+		ForeignCorrespondentBankName: "correspondent bank",
+		ForeignCorrespondentBankIDNumberQualifier: "01",
+		ForeignCorrespondentBankIDNumber:          "6",
+		ForeignCorrespondentBankBranchCountryCode: "GB",
+	}
+}
+
+func TestIAT__screenIATParties(t *testing.T) {
+	client := &namedOFACClient{
+		testOFACClient: testOFACClient{customer: &ofac.OfacCustomer{}, company: &ofac.OfacCompany{}},
+		sdns: map[string]*ofac.Sdn{
+			"jane doe": {EntityID: "1", SdnType: "individual", Match: 0.5},
+		},
+	}
+	iat := testIATDetail()
+
+	screenings, err := screenIATParties(client, nil, iat, "userID", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(screenings) != 7 {
+		t.Fatalf("unexpected screenings: %#v", screenings)
+	}
+	if s := screenings[1]; s.Party != "receiver" || s.EntityID != "1" || s.Blocked {
+		t.Errorf("unexpected screening: %#v", s)
+	}
+	if s := screenings[6]; s.Party != "receiverAddress" || s.Name != "321 2nd st, othertown, GB 54321, GB" {
+		t.Errorf("unexpected screening: %#v", s)
+	}
+
+	// banks and addresses are screened
+	client.sdns["correspondent bank"] = &ofac.Sdn{EntityID: "2", SdnType: "entity", Match: 0.97}
+	client.address = &ofac.Address{EntityID: "3", Match: 0.92}
+	screenings, err = screenIATParties(client, nil, iat, "userID", "")
+	if len(screenings) != 7 {
+		t.Errorf("unexpected screenings: %#v", screenings)
+	}
+	e, ok := err.(*IATScreeningError)
+	if !ok {
+		t.Fatalf("unexpected error: %T %v", err, err)
+	}
+	if len(e.Matches) != 3 || e.Matches[0].Party != "foreignCorrespondentBank" || e.Matches[1].Party != "originatorAddress" || e.Matches[2].EntityID != "3" {
+		t.Errorf("unexpected matches: %v", e)
+	}
+
+	client.err = errors.New("bad error")
+	if _, err := screenIATParties(client, nil, iat, "userID", ""); err == nil {
+		t.Error("expected error")
+	} else if _, ok := err.(*IATScreeningError); ok {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := screenIATParties(client, nil, nil, "userID", ""); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestIAT__screenOFACAddressCleared(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	reviewRepo := NewOFACReviewRepo(log.NewNopLogger(), db.DB)
+	client := &testOFACClient{address: &ofac.Address{EntityID: "3", Match: 0.92}}
+
+	screening, err := screenOFACAddress(client, reviewRepo, "receiverAddress", "321 2nd st", "othertown", "GB", "54321", "GB", "userID", "")
+	if err != nil || !screening.Blocked {
+		t.Fatalf("screening=%#v error=%v", screening, err)
+	}
+	hits, err := reviewRepo.getOFACHits(OFACHitPending)
+	if err != nil || len(hits) != 1 || hits[0].Name != screening.Name {
+		t.Fatalf("hits=%#v error=%v", hits, err)
+	}
+	expires := time.Now().Add(time.Hour)
+	if err := reviewRepo.reviewOFACHit(hits[0].ID, OFACHitPending, OFACHitCleared, "different building", "", &expires); err != nil {
+		t.Fatal(err)
+	}
+	if screening, err := screenOFACAddress(client, reviewRepo, "receiverAddress", "321 2nd st", "othertown", "GB", "54321", "GB", "userID", ""); err != nil || screening.Blocked {
+		t.Errorf("screening=%#v error=%v", screening, err)
+	}
+}
+
+func TestIAT__createUserTransfers(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	depRepo := &mockDepositoryRepository{
+		depositories: []*Depository{
+			{
+				ID:            DepositoryID("dep"),
+				BankName:      "bank",
+				Holder:        "holder",
+				HolderType:    Individual,
+				Type:          Checking,
+				RoutingNumber: "121421212",
+				AccountNumber: "1321",
+				Status:        DepositoryVerified,
+			},
+		},
+	}
+	recRepo := &mockReceiverRepository{
+		receivers: []*Receiver{{ID: ReceiverID("receiver"), Email: "foo@moov.io", DefaultDepository: DepositoryID("dep"), Status: ReceiverVerified, Metadata: "jane doe"}},
+	}
+	origRepo := &mockOriginatorRepository{
+		originators: []*Originator{{ID: OriginatorID("originator"), DefaultDepository: DepositoryID("dep"), Identification: "id", Metadata: "john doe"}},
+	}
+	repo := &SQLTransferRepo{db.DB, log.NewNopLogger()}
+
+	router := createTestTransferRouter(depRepo, NewEventRepo(log.NewNopLogger(), db.DB, nil), recRepo, origRepo, repo, func(r *mux.Router) {
+		achclient.AddCreateRoute(nil, r)
+		achclient.AddValidateRoute(r)
+	})
+	defer router.close()
+	router.TransferRouter.accountsClient = nil
+
+	client := &namedOFACClient{
+		testOFACClient: testOFACClient{customer: &ofac.OfacCustomer{}, company: &ofac.OfacCompany{}},
+		sdns: map[string]*ofac.Sdn{
+			"their bank": {EntityID: "2", SdnType: "entity", Match: 0.97},
+		},
+	}
+	router.ofacClient = client
+
+	amt, _ := NewAmount("USD", "18.61")
+	create := func() *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		json.NewEncoder(&body).Encode(&transferRequest{
+			Type:                   PushTransfer,
+			Amount:                 *amt,
+			Originator:             OriginatorID("originator"),
+			OriginatorDepository:   DepositoryID("dep"),
+			Receiver:               ReceiverID("receiver"),
+			ReceiverDepository:     DepositoryID("dep"),
+			Description:            "money",
+			StandardEntryClassCode: "IAT",
+			IATDetail:              testIATDetail(),
+		})
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/transfers", &body)
+		req.Header.Set("x-user-id", "test")
+		router.createUserTransfers()(w, req)
+		w.Flush()
+		return w
+	}
+
+	// the RDFI matches OFAC
+	w := create()
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var resp iatScreeningResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error == "" || len(resp.Matches) != 1 || resp.Matches[0].Party != "RDFI" || resp.Matches[0].EntityID != "2" {
+		t.Errorf("unexpected response: %#v", resp)
+	}
+
+	// screening results are saved on the Transfer
+	delete(client.sdns, "their bank")
+	w = create()
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var xfer Transfer
+	if err := json.NewDecoder(w.Body).Decode(&xfer); err != nil {
+		t.Fatal(err)
+	}
+	found, err := repo.getUserTransfer(xfer.ID, "test")
+	if err != nil || found == nil {
+		t.Fatalf("transfer=%#v error=%v", found, err)
+	}
+	if len(found.OFACScreenings) != 7 || found.OFACScreenings[3].Party != "RDFI" || found.OFACScreenings[3].Blocked {
+		t.Errorf("unexpected screenings: %#v", found.OFACScreenings)
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/moov-io/ach"
+	"github.com/moov-io/base"
+	moovhttp "github.com/moov-io/base/http"
+	"github.com/moov-io/paygate/internal/filetransfer"
+)
+
+// TransferPreview is the dry run of creating a Transfer. Nothing is posted to Accounts, created in the ACH
+// service or saved for a preview.
+type TransferPreview struct {
+	// Status is what the Transfer would be created with, i.e. awaiting_approval over TRANSFER_APPROVAL_THRESHOLD
+	Status TransferStatus `json:"status"`
+
+	// Batch holds the NACHA entries the Transfer would produce, IATBatch is used instead for IAT transfers.
+	// Account numbers and the Originator's identification are masked.
+	Batch    *ach.Batch    `json:"batch,omitempty"`
+	IATBatch *ach.IATBatch `json:"IATBatch,omitempty"`
+
+	// EffectiveEntryDate is the date (YYYY-MM-DD) the entries would settle on
+	EffectiveEntryDate string `json:"effectiveEntryDate"`
+
+	// Cutoff is the next upload window of the file's ImmediateOrigin, it's nil if no cutoff is configured.
+	Cutoff *TransferPreviewCutoff `json:"cutoff,omitempty"`
+
+	// Errors are problems found validating the ACH file locally. The Transfer would be rejected if there are any.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// TransferPreviewCutoff is the next time files for RoutingNumber are uploaded.
+type TransferPreviewCutoff struct {
+	RoutingNumber string    `json:"routingNumber"`
+	Cutoff        time.Time `json:"cutoff"`
+	Location      string    `json:"location"`
+}
+
+// previewUserTransfers runs the same checks as createUserTransfers and builds the ACH file for each transfer
+// request, but only validates it locally. Requests which would be rejected get the same error as creating them.
+func (c *TransferRouter) previewUserTransfers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Previews are safe to repeat, so X-Idempotency-Key isn't checked
+		w, err := ensureHeaders(c.logger, w, r)
+		if err != nil {
+			return
+		}
+
+		requests, err := readTransferRequests(r)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		userID, requestID := moovhttp.GetUserID(r), moovhttp.GetRequestID(r)
+		if err := c.transferRepo.checkTransferLimits(userID, requests); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		cutoffs, err := c.fileTransferRepo.GetCutoffTimes()
+		if err != nil {
+			c.logger.Log("transfers", fmt.Sprintf("problem reading cutoff times: %v", err), "requestID", requestID, "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		var previews []*TransferPreview
+		for i := range requests {
+			preview, err := c.previewTransfer(requests[i], userID, requestID, cutoffs, time.Now())
+			if err != nil {
+				if e, ok := err.(*IATScreeningError); ok {
+					w.Header().Set("Content-Type", "application/json; charset=utf-8")
+					w.WriteHeader(http.StatusBadRequest)
+					json.NewEncoder(w).Encode(iatScreeningResponse{Error: e.Error(), Matches: e.Matches})
+					return
+				}
+				moovhttp.Problem(w, err)
+				return
+			}
+			previews = append(previews, preview)
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		if len(requests) == 1 {
+			json.NewEncoder(w).Encode(previews[0])
+		} else {
+			json.NewEncoder(w).Encode(previews)
+		}
+	}
+}
+
+func (c *TransferRouter) previewTransfer(req *transferRequest, userID, requestID string, cutoffs []*filetransfer.CutoffTime, now time.Time) (*TransferPreview, error) {
+	if err := req.missingFields(); err != nil {
+		return nil, err
+	}
+	receiver, receiverDep, orig, origDep, err := getTransferObjects(req, userID, c.depRepo, c.receiverRepository, c.origRepo)
+	if err != nil {
+		return nil, fmt.Errorf("missing data to create transfer: %s", err)
+	}
+	gateway, err := getTransferGateway(c.gatewayRepo, req.Gateway, userID)
+	if err != nil {
+		return nil, err
+	}
+	if gateway != nil {
+		req.Gateway = gateway.ID
+	}
+	if err := checkDebitAuthorization(c.authorizationRepo, userID, req); err != nil {
+		return nil, err
+	}
+	if req.StandardEntryClassCode == "IAT" {
+		var reviewRepo ofacReviewRepository
+		if c.ofacReviewRepo != nil {
+			reviewRepo = &previewOFACReviewRepo{c.ofacReviewRepo}
+		}
+		if _, err := screenIATParties(c.ofacClient, reviewRepo, req.IATDetail, userID, requestID); err != nil {
+			return nil, err
+		}
+	}
+
+	id := base.ID()
+	file, err := constructACHFile(id, base.ID(), userID, req.asTransfer(id), receiver, receiverDep, orig, origDep, gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &TransferPreview{Status: TransferPending}
+	if requiresTransferApproval(req.Amount) {
+		preview.Status = TransferAwaitingApproval
+	}
+
+	// Validate the file like the ACH service would, Create computes the batch and file controls
+	if err := file.Create(); err != nil {
+		preview.Errors = append(preview.Errors, err.Error())
+	} else if err := file.Validate(); err != nil {
+		preview.Errors = append(preview.Errors, err.Error())
+	}
+
+	var effectiveEntryDate string
+	if len(file.Batches) > 0 {
+		preview.Batch = maskBatch(file.Batches[0])
+		effectiveEntryDate = preview.Batch.Header.EffectiveEntryDate
+	}
+	if len(file.IATBatches) > 0 {
+		preview.IATBatch = maskIATBatch(file.IATBatches[0])
+		effectiveEntryDate = preview.IATBatch.Header.EffectiveEntryDate
+	}
+	if t, err := time.Parse("060102", effectiveEntryDate); err == nil { // YYMMDD
+		preview.EffectiveEntryDate = t.Format("2006-01-02")
+	}
+
+	// Merged files are uploaded at the cutoff matching their ImmediateOrigin
+	for i := range cutoffs {
+		if cutoffs[i].RoutingNumber == file.Header.ImmediateOrigin {
+			preview.Cutoff = &TransferPreviewCutoff{
+				RoutingNumber: cutoffs[i].RoutingNumber,
+				Cutoff:        nextCutoffTime(cutoffs[i], now),
+				Location:      cutoffs[i].Loc.String(),
+			}
+			break
+		}
+	}
+	if preview.Cutoff == nil {
+		preview.Errors = append(preview.Errors, fmt.Sprintf("no cutoff time for routing number %s", file.Header.ImmediateOrigin))
+	}
+	return preview, nil
+}
+
+// maskBatch returns a copy of batch with account numbers and the Originator's identification masked, like they are
+// when Depositories and Originators are rendered.
+func maskBatch(batch ach.Batcher) *ach.Batch {
+	header := *batch.GetHeader()
+	header.CompanyIdentification = maskAccountNumber(header.CompanyIdentification)
+
+	control := *batch.GetControl()
+	control.CompanyIdentification = maskAccountNumber(control.CompanyIdentification)
+
+	out := &ach.Batch{Header: &header, Control: &control}
+	for _, ed := range batch.GetEntries() {
+		entry := *ed
+		entry.DFIAccountNumber = maskAccountNumber(entry.DFIAccountNumber)
+		entry.IdentificationNumber = maskAccountNumber(entry.IdentificationNumber)
+		out.Entries = append(out.Entries, &entry)
+	}
+	return out
+}
+
+// maskIATBatch is maskBatch for IAT batches.
+func maskIATBatch(batch ach.IATBatch) *ach.IATBatch {
+	header := *batch.Header
+	header.OriginatorIdentification = maskAccountNumber(header.OriginatorIdentification)
+	batch.Header = &header
+	if batch.Control != nil {
+		control := *batch.Control
+		control.CompanyIdentification = maskAccountNumber(control.CompanyIdentification)
+		batch.Control = &control
+	}
+
+	entries := make([]*ach.IATEntryDetail, len(batch.Entries))
+	for i := range batch.Entries {
+		entry := *batch.Entries[i]
+		entry.DFIAccountNumber = maskAccountNumber(entry.DFIAccountNumber)
+		entries[i] = &entry
+	}
+	batch.Entries = entries
+	return &batch
+}
+
+// nextCutoffTime returns the first cutoff after now which falls on a banking day.
+func nextCutoffTime(cutoff *filetransfer.CutoffTime, now time.Time) time.Time {
+	now = now.In(cutoff.Loc)
+
+	day := base.Now()
+	day.Time = time.Date(now.Year(), now.Month(), now.Day(), cutoff.Cutoff/100, cutoff.Cutoff%100, 0, 0, cutoff.Loc)
+	if !day.IsBankingDay() || !day.Time.After(now) {
+		day = day.AddBankingDay(1)
+	}
+	return day.Time
+}
+
+// previewOFACReviewRepo honors existing OFAC clearances, but doesn't record new matches for review.
+type previewOFACReviewRepo struct {
+	ofacReviewRepository
+}
+
+func (r *previewOFACReviewRepo) recordOFACHit(hit *OFACHit) (*OFACHit, error) {
+	return hit, nil
+}
@@ -0,0 +1,204 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moov-io/ach"
+	"github.com/moov-io/base"
+	"github.com/moov-io/paygate/internal/database"
+	"github.com/moov-io/paygate/internal/filetransfer"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestTransfers__previewUserTransfers(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	now := base.NewTime(time.Now())
+	depRepo := &mockDepositoryRepository{
+		depositories: []*Depository{
+			{
+				ID:            DepositoryID("originator"),
+				BankName:      "orig bank",
+				Holder:        "orig",
+				HolderType:    Individual,
+				Type:          Checking,
+				RoutingNumber: "121042882",
+				AccountNumber: "987654321",
+				Status:        DepositoryVerified,
+				Created:       now,
+				Updated:       now,
+			},
+			{
+				ID:            DepositoryID("receiver"),
+				BankName:      "receiver bank",
+				Holder:        "receiver",
+				HolderType:    Individual,
+				Type:          Checking,
+				RoutingNumber: "231380104",
+				AccountNumber: "323431",
+				Status:        DepositoryVerified,
+				Created:       now,
+				Updated:       now,
+			},
+		},
+	}
+	recRepo := &mockReceiverRepository{
+		receivers: []*Receiver{
+			{
+				ID:                ReceiverID("receiver"),
+				Email:             "foo@moov.io",
+				DefaultDepository: DepositoryID("receiver"),
+				Status:            ReceiverVerified,
+				Metadata:          "other",
+				Created:           now,
+				Updated:           now,
+			},
+		},
+	}
+	origRepo := &mockOriginatorRepository{
+		originators: []*Originator{
+			{
+				ID:                OriginatorID("originator"),
+				DefaultDepository: DepositoryID("originator"),
+				Identification:    "123456789",
+				Metadata:          "other",
+				Created:           now,
+				Updated:           now,
+			},
+		},
+	}
+	eventRepo := NewEventRepo(log.NewNopLogger(), db.DB, nil)
+	transferRepo := &SQLTransferRepo{db.DB, log.NewNopLogger()}
+
+	// The ACH service has no routes, so any files created there would fail
+	xferRouter := createTestTransferRouter(depRepo, eventRepo, recRepo, origRepo, transferRepo)
+	defer xferRouter.close()
+	accountsClient := &testAccountsClient{}
+	xferRouter.TransferRouter.accountsClient = accountsClient
+
+	router := mux.NewRouter()
+	xferRouter.RegisterRoutes(router)
+
+	amt, _ := NewAmount("USD", "18.61")
+	request := &transferRequest{
+		Type:                   PushTransfer,
+		Amount:                 *amt,
+		Originator:             OriginatorID("originator"),
+		OriginatorDepository:   DepositoryID("originator"),
+		Receiver:               ReceiverID("receiver"),
+		ReceiverDepository:     DepositoryID("receiver"),
+		Description:            "money",
+		StandardEntryClassCode: "PPD",
+	}
+	preview := func(body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("POST", "/transfers/preview", &buf)
+		req.Header.Set("x-user-id", "test")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	w := preview(request)
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	// account numbers are masked
+	if body := w.Body.String(); strings.Contains(body, "323431") || strings.Contains(body, "987654321") || strings.Contains(body, "123456789") {
+		t.Errorf("account numbers weren't masked: %s", body)
+	}
+	var resp TransferPreview
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != TransferPending || len(resp.Errors) != 0 {
+		t.Errorf("unexpected preview: %#v", resp)
+	}
+	if resp.Batch == nil || len(resp.Batch.Entries) != 1 || resp.Batch.Entries[0].Amount != 1861 || resp.Batch.Control.TotalCreditEntryDollarAmount != 1861 {
+		t.Fatalf("unexpected batch: %#v", resp.Batch)
+	}
+	if v := resp.Batch.Entries[0].DFIAccountNumber; v != "*****4321" && v != "**3431" {
+		t.Errorf("unexpected batch: %#v", resp.Batch)
+	}
+	if expected := base.Now().AddBankingDay(1).Format("2006-01-02"); resp.EffectiveEntryDate != expected {
+		t.Errorf("effectiveEntryDate=%s expected %s", resp.EffectiveEntryDate, expected)
+	}
+	if resp.Cutoff == nil || resp.Cutoff.RoutingNumber != "121042882" || resp.Cutoff.Location != "America/New_York" || !resp.Cutoff.Cutoff.After(time.Now()) {
+		t.Errorf("unexpected cutoff: %#v", resp.Cutoff)
+	}
+
+	// Nothing was saved or posted to Accounts
+	if xfers, err := transferRepo.getUserTransfers("test"); err != nil || len(xfers) != 0 {
+		t.Errorf("transfers=%#v error=%v", xfers, err)
+	}
+	if events, err := eventRepo.getUserEvents("test"); err != nil || len(events) != 0 {
+		t.Errorf("events=%#v error=%v", events, err)
+	}
+	if len(accountsClient.postedTransactions) != 0 {
+		t.Errorf("posted transactions: %#v", accountsClient.postedTransactions)
+	}
+
+	// batches return an array, and origins without a cutoff are reported
+	depRepo.depositories[0].RoutingNumber = "231380104"
+	w = preview([]*transferRequest{request, request})
+	var previews []*TransferPreview
+	if err := json.NewDecoder(w.Body).Decode(&previews); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(previews) != 2 || len(previews[0].Errors) != 1 || !strings.Contains(previews[0].Errors[0], "no cutoff time") {
+		t.Errorf("got %d: %#v", w.Code, previews)
+	}
+
+	// transfers which would be rejected get the same error as creating them
+	request.Type = PullTransfer
+	if w := preview(request); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "no active authorization") {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestTransfers__maskIATBatch(t *testing.T) {
+	batch := ach.NewIATBatch(&ach.IATBatchHeader{OriginatorIdentification: "123456789"})
+	batch.AddEntry(&ach.IATEntryDetail{DFIAccountNumber: "987654321"})
+
+	masked := maskIATBatch(batch)
+	if masked.Header.OriginatorIdentification != "*****6789" || masked.Entries[0].DFIAccountNumber != "*****4321" {
+		t.Errorf("unexpected batch: %#v %#v", masked.Header, masked.Entries[0])
+	}
+	if batch.Header.OriginatorIdentification != "123456789" || batch.Entries[0].DFIAccountNumber != "987654321" {
+		t.Error("the file's batch was modified")
+	}
+}
+
+func TestTransfers__nextCutoffTime(t *testing.T) {
+	nyc, _ := time.LoadLocation("America/New_York")
+	cutoff := &filetransfer.CutoffTime{RoutingNumber: "121042882", Cutoff: 1700, Loc: nyc}
+
+	// before the cutoff on a Tuesday
+	when := nextCutoffTime(cutoff, time.Date(2019, time.November, 5, 10, 0, 0, 0, nyc))
+	if !when.Equal(time.Date(2019, time.November, 5, 17, 0, 0, 0, nyc)) {
+		t.Errorf("got %v", when)
+	}
+
+	// after the cutoff on a Friday
+	when = nextCutoffTime(cutoff, time.Date(2019, time.November, 1, 17, 30, 0, 0, nyc))
+	if !when.Equal(time.Date(2019, time.November, 4, 17, 0, 0, 0, nyc)) {
+		t.Errorf("got %v", when)
+	}
+}
@@ -90,7 +90,7 @@ func TestTEL__createTELBatch(t *testing.T) {
 		t.Error("nil TEL Batch")
 	}
 
-	file, err := constructACHFile(id, "", userID, transfer, receiver, receiverDep, orig, origDep)
+	file, err := constructACHFile(id, "", userID, transfer, receiver, receiverDep, orig, origDep, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -21,6 +21,7 @@ import (
 	"github.com/moov-io/ach"
 	"github.com/moov-io/base"
 	"github.com/moov-io/paygate/internal/database"
+	"github.com/moov-io/paygate/internal/filetransfer"
 	"github.com/moov-io/paygate/pkg/achclient"
 
 	"github.com/go-kit/kit/log"
@@ -62,6 +63,11 @@ func createTestTransferRouter(
 			receiverRepository: rec,
 			origRepo:           ori,
 			transferRepo:       xfr,
+			authorizationRepo:  &mockAuthorizationRepository{},
+			gatewayRepo:        &mockGatewayRepository{},
+			approverRepo:       &mockTransferApproverRepository{},
+			fileTransferRepo:   filetransfer.NewRepository(nil, ""),
+			ofacClient:         &testOFACClient{},
 			achClientFactory: func(_ string) *achclient.ACH {
 				return ach
 			},
@@ -84,6 +90,26 @@ type mockTransferRepository struct {
 	// Updated fields
 	returnCode string
 	status     TransferStatus
+	reversed   map[TransferID]bool
+}
+
+func (r *mockTransferRepository) failPendingDepositoryTransfers(id DepositoryID, userID string) ([]TransferID, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.xfer != nil {
+		r.status = TransferFailed
+		return []TransferID{r.xfer.ID}, nil
+	}
+	return nil, nil
+}
+
+func (r *mockTransferRepository) failPendingReceiverTransfers(id ReceiverID, userID string) ([]TransferID, error) {
+	return r.failPendingDepositoryTransfers("", userID)
+}
+
+func (r *mockTransferRepository) failPendingOriginatorTransfers(id OriginatorID, userID string) ([]TransferID, error) {
+	return r.failPendingDepositoryTransfers("", userID)
 }
 
 func (r *mockTransferRepository) getUserTransfers(userID string) ([]*Transfer, error) {
@@ -108,6 +134,52 @@ func (r *mockTransferRepository) updateTransferStatus(id TransferID, status Tran
 	return r.err
 }
 
+func (r *mockTransferRepository) getTransfer(id TransferID) (*Transfer, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.xfer, nil
+}
+
+func (r *mockTransferRepository) claimTransactionReversal(id TransferID) (bool, error) {
+	if r.err != nil {
+		return false, r.err
+	}
+	if r.reversed == nil {
+		r.reversed = make(map[TransferID]bool)
+	}
+	if r.reversed[id] {
+		return false, nil
+	}
+	r.reversed[id] = true
+	return true, nil
+}
+
+func (r *mockTransferRepository) releaseTransactionReversal(id TransferID) error {
+	delete(r.reversed, id)
+	return r.err
+}
+
+func (r *mockTransferRepository) setReversalTransactionID(id TransferID, transactionID string) error {
+	return r.err
+}
+
+func (r *mockTransferRepository) reviewTransfer(id TransferID, status TransferStatus, reviewedBy string) error {
+	r.status = status
+	return r.err
+}
+
+func (r *mockTransferRepository) cancelExpiredTransfers(createdBefore time.Time) ([]*Transfer, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.xfer != nil {
+		r.status = TransferCanceled
+		return []*Transfer{r.xfer}, nil
+	}
+	return nil, nil
+}
+
 func (r *mockTransferRepository) getFileIDForTransfer(id TransferID, userID string) (string, error) {
 	if r.err != nil {
 		return "", r.err
@@ -135,6 +207,20 @@ func (r *mockTransferRepository) markTransferAsMerged(id TransferID, filename st
 	return r.err
 }
 
+func (r *mockTransferRepository) getMergedTransfers(filename string, traceNumbers []string) ([]*Transfer, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.xfer != nil {
+		return []*Transfer{r.xfer}, nil
+	}
+	return nil, nil
+}
+
+func (r *mockTransferRepository) checkTransferLimits(userID string, requests []*transferRequest) error {
+	return r.err
+}
+
 func (r *mockTransferRepository) createUserTransfers(userID string, requests []*transferRequest) ([]*Transfer, error) {
 	if r.err != nil {
 		return nil, r.err
@@ -488,7 +574,7 @@ func TestTransfers__create(t *testing.T) {
 			},
 		},
 	}
-	eventRepo := NewEventRepo(logger, db.DB)
+	eventRepo := NewEventRepo(logger, db.DB, nil)
 	recRepo := &mockReceiverRepository{
 		receivers: []*Receiver{
 			{
@@ -538,7 +624,7 @@ func TestTransfers__create(t *testing.T) {
 
 	router := createTestTransferRouter(depRepo, eventRepo, recRepo, origRepo, repo, func(r *mux.Router) { achclient.AddCreateRoute(w, r) })
 	defer router.close()
-	router.accountsCallsDisabled = true // don't make Accounts calls
+	router.TransferRouter.accountsClient = nil // don't make Accounts calls
 
 	req, _ := http.NewRequest("POST", "/transfers", &body)
 	req.Header.Set("x-user-id", "test")
@@ -548,33 +634,79 @@ func TestTransfers__create(t *testing.T) {
 	if w.Code != http.StatusOK {
 		t.Errorf("bogus HTTP statu codes: %d: %s", w.Code, w.Body.String())
 	}
+
+	// Pull transfers need an active Authorization from the Receiver
+	router = createTestTransferRouter(depRepo, eventRepo, recRepo, origRepo, repo, func(r *mux.Router) {
+		achclient.AddCreateRoute(nil, r)
+		achclient.AddValidateRoute(r)
+	})
+	defer router.close()
+	router.TransferRouter.accountsClient = nil
+
+	request.Type = PullTransfer
+	createPull := func() *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		if err := json.NewEncoder(&body).Encode(request); err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/transfers", &body)
+		req.Header.Set("x-user-id", "test")
+		router.createUserTransfers()(w, req)
+		w.Flush()
+		return w
+	}
+	if w := createPull(); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "no active authorization") {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	router.authorizationRepo = &mockAuthorizationRepository{
+		auths: []*Authorization{testAuthorization(request.Receiver, request.ReceiverDepository)},
+	}
+	if w := createPull(); w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
 }
 
 func TestTransfers__idempotency(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
 	// The repositories aren't used, aka idempotency check needs to be first.
 	xferRouter := createTestTransferRouter(nil, nil, nil, nil, nil)
 	defer xferRouter.close()
 
+	repo := NewIdempotencyKeyRepo(log.NewNopLogger(), db.DB)
+	xferRouter.idempotencyRepo = repo
+
 	router := mux.NewRouter()
 	xferRouter.RegisterRoutes(router)
 
-	req := httptest.NewRequest("POST", "/transfers", nil)
-	req.Header.Set("x-idempotency-key", "key")
-	req.Header.Set("x-user-id", "user")
+	create := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/transfers", nil)
+		req.Header.Set("x-idempotency-key", "key")
+		req.Header.Set("x-user-id", "user")
 
-	// mark the key as seen
-	if seen := inmemIdempotentRecorder.SeenBefore("key"); seen {
-		t.Errorf("shouldn't have been seen before")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
 	}
 
-	// make our request
-	w := httptest.NewRecorder()
-	router.ServeHTTP(w, req)
-	w.Flush()
-
-	if w.Code != http.StatusPreconditionFailed {
+	// another request with the key is running
+	if existing, err := repo.startIdempotentRequest("user", "key", "other", "", time.Now()); existing != nil || err != nil {
+		t.Fatalf("existing=%#v error=%v", existing, err)
+	}
+	if w := create(); w.Code != http.StatusConflict {
 		t.Errorf("got %d", w.Code)
 	}
+
+	// the other request finished, so its response is replayed
+	if err := repo.completeIdempotentRequest("user", "key", "other", &idempotentRequest{Status: http.StatusOK, Body: []byte(`{"id":"xfer"}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if w := create(); w.Code != http.StatusOK || w.Body.String() != `{"id":"xfer"}` {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
 }
 
 func TestTransfers__getUserTransfer(t *testing.T) {
@@ -1381,6 +1513,61 @@ func TestTransfers__transactionID(t *testing.T) {
 	check(t, mysqlDB.DB)
 }
 
+func TestTransfers__getMergedTransfers(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo transferRepository) {
+		amt, _ := NewAmount("USD", "32.92")
+		userID := base.ID()
+		req := &transferRequest{
+			Type:                   PushTransfer,
+			Amount:                 *amt,
+			Originator:             OriginatorID("originator"),
+			OriginatorDepository:   DepositoryID("originator"),
+			Receiver:               ReceiverID("receiver"),
+			ReceiverDepository:     DepositoryID("receiver"),
+			Description:            "money",
+			StandardEntryClassCode: "PPD",
+			fileID:                 "test-file",
+		}
+		transfers, err := repo.createUserTransfers(userID, []*transferRequest{req, req})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.markTransferAsMerged(transfers[0].ID, "merged.ach", "111"); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.markTransferAsMerged(transfers[1].ID, "merged.ach", "222"); err != nil {
+			t.Fatal(err)
+		}
+
+		merged, err := repo.getMergedTransfers("merged.ach", []string{"222", "333"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(merged) != 1 || merged[0].ID != transfers[1].ID || merged[0].userID != userID {
+			t.Errorf("unexpected transfers: %#v", merged)
+		}
+
+		if merged, err := repo.getMergedTransfers("other.ach", []string{"111", "222"}); err != nil || len(merged) != 0 {
+			t.Errorf("unexpected transfers: %#v error=%v", merged, err)
+		}
+		if merged, err := repo.getMergedTransfers("merged.ach", nil); err != nil || len(merged) != 0 {
+			t.Errorf("unexpected transfers: %#v error=%v", merged, err)
+		}
+	}
+
+	// SQLite tests
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &SQLTransferRepo{sqliteDB.DB, log.NewNopLogger()})
+
+	// MySQL tests
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &SQLTransferRepo{mysqlDB.DB, log.NewNopLogger()})
+}
+
 func TestTransfers__lookupTransferFromReturn(t *testing.T) {
 	t.Parallel()
 
@@ -1574,7 +1761,7 @@ func TestTransfers__constructACHFile(t *testing.T) {
 		StandardEntryClassCode: "AAA", // invalid
 	}
 
-	file, err := constructACHFile("", "", "", transfer, receiver, receiverDep, orig, origDep)
+	file, err := constructACHFile("", "", "", transfer, receiver, receiverDep, orig, origDep, nil)
 	if err == nil || file != nil {
 		t.Fatalf("expected error, got file=%#v", file)
 	}
@@ -1582,3 +1769,99 @@ func TestTransfers__constructACHFile(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 }
+
+func TestTransfers__gateway(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	repo := &SQLTransferRepo{db.DB, log.NewNopLogger()}
+
+	amt, _ := NewAmount("USD", "18.61")
+	userID := base.ID()
+	req := &transferRequest{
+		Type:                   PushTransfer,
+		Amount:                 *amt,
+		Originator:             OriginatorID("originator"),
+		OriginatorDepository:   DepositoryID("originator"),
+		Receiver:               ReceiverID("receiver"),
+		ReceiverDepository:     DepositoryID("receiver"),
+		Description:            "money",
+		StandardEntryClassCode: "PPD",
+		Gateway:                GatewayID("gateway"),
+	}
+	xfers, err := repo.createUserTransfers(userID, []*transferRequest{req})
+	if err != nil {
+		t.Fatal(err)
+	}
+	xfer, err := repo.getUserTransfer(xfers[0].ID, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xfer.Gateway != req.Gateway {
+		t.Errorf("xfer.Gateway=%s", xfer.Gateway)
+	}
+}
+
+func TestTransfers__failPendingDepositoryTransfers(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	repo := &SQLTransferRepo{db.DB, log.NewNopLogger()}
+
+	amt, _ := NewAmount("USD", "18.61")
+	userID := base.ID()
+	newRequest := func(receiverDep DepositoryID) *transferRequest {
+		return &transferRequest{
+			Type:                   PushTransfer,
+			Amount:                 *amt,
+			Originator:             OriginatorID("originator"),
+			OriginatorDepository:   DepositoryID("originator"),
+			Receiver:               ReceiverID("receiver"),
+			ReceiverDepository:     receiverDep,
+			Description:            "money",
+			StandardEntryClassCode: "PPD",
+		}
+	}
+	xfers, err := repo.createUserTransfers(userID, []*transferRequest{newRequest("receiver"), newRequest("receiver"), newRequest("other")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// merged transfers are already on their way to the FED
+	if err := repo.markTransferAsMerged(xfers[1].ID, "merged.ach", "123"); err != nil {
+		t.Fatal(err)
+	}
+
+	transferIDs, err := repo.failPendingDepositoryTransfers(DepositoryID("receiver"), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transferIDs) != 1 || transferIDs[0] != xfers[0].ID {
+		t.Errorf("unexpected transfers: %v", transferIDs)
+	}
+	for i, status := range []TransferStatus{TransferFailed, TransferProcessed, TransferPending} {
+		xfer, err := repo.getUserTransfer(xfers[i].ID, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if xfer.Status != status {
+			t.Errorf("transfer %d: got %s", i, xfer.Status)
+		}
+	}
+
+	// other users aren't affected
+	if transferIDs, err := repo.failPendingDepositoryTransfers(DepositoryID("other"), base.ID()); err != nil || len(transferIDs) != 0 {
+		t.Errorf("transfers=%v error=%v", transferIDs, err)
+	}
+
+	// by Receiver and Originator
+	if transferIDs, err := repo.failPendingOriginatorTransfers(OriginatorID("other"), userID); err != nil || len(transferIDs) != 0 {
+		t.Errorf("transfers=%v error=%v", transferIDs, err)
+	}
+	if transferIDs, err := repo.failPendingReceiverTransfers(ReceiverID("receiver"), userID); err != nil || len(transferIDs) != 1 || transferIDs[0] != xfers[2].ID {
+		t.Errorf("transfers=%v error=%v", transferIDs, err)
+	}
+	xfers, _ = repo.createUserTransfers(userID, []*transferRequest{newRequest("receiver")})
+	if transferIDs, err := repo.failPendingOriginatorTransfers(OriginatorID("originator"), userID); err != nil || len(transferIDs) != 1 || transferIDs[0] != xfers[0].ID {
+		t.Errorf("transfers=%v error=%v", transferIDs, err)
+	}
+}
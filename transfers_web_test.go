@@ -91,13 +91,34 @@ func TestWEB__createWEBBatch(t *testing.T) {
 		t.Error("nil WEB Batch")
 	}
 
-	file, err := constructACHFile(id, "", userID, transfer, receiver, receiverDep, orig, origDep)
+	file, err := constructACHFile(id, "", userID, transfer, receiver, receiverDep, orig, origDep, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	if file == nil {
 		t.Error("nil WEB ach.File")
 	}
+	if file.Header.ImmediateOrigin != origDep.RoutingNumber || file.Header.ImmediateDestination != receiverDep.RoutingNumber {
+		t.Errorf("origin=%s destination=%s", file.Header.ImmediateOrigin, file.Header.ImmediateDestination)
+	}
+
+	// Gateways replace the file header
+	gateway := &Gateway{
+		Origin:          "076401251",
+		OriginName:      "gateway origin",
+		Destination:     "031300012",
+		DestinationName: "gateway destination",
+	}
+	file, err = constructACHFile(id, "", userID, transfer, receiver, receiverDep, orig, origDep, gateway)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Header.ImmediateOrigin != gateway.Origin || file.Header.ImmediateOriginName != gateway.OriginName {
+		t.Errorf("origin=%s name=%s", file.Header.ImmediateOrigin, file.Header.ImmediateOriginName)
+	}
+	if file.Header.ImmediateDestination != gateway.Destination || file.Header.ImmediateDestinationName != gateway.DestinationName {
+		t.Errorf("destination=%s name=%s", file.Header.ImmediateDestination, file.Header.ImmediateDestinationName)
+	}
 
 	// Make sure WEBReoccurring are rejected
 	transfer.WEBDetail.PaymentType = "reoccurring"
@@ -0,0 +1,1066 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/moov-io/base"
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/prometheus"
+	"github.com/gorilla/mux"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// webhookSignatureHeader is the hex encoded HMAC-SHA256 of the timestamp header, a '.' and the request body
+	// using the webhook's secret.
+	webhookSignatureHeader = "X-Paygate-Signature"
+	webhookTimestampHeader = "X-Paygate-Timestamp"
+	webhookDeliveryHeader  = "X-Paygate-Delivery"
+	webhookEventHeader     = "X-Paygate-Event"
+)
+
+var (
+	// webhookDeliveryInterval is how often pending webhook deliveries are checked for.
+	webhookDeliveryInterval = readWebhookDuration("WEBHOOK_DELIVERY_INTERVAL", 5*time.Second)
+
+	// webhookRetryBackoff is the delay after the first failed delivery, which doubles after each failure.
+	webhookRetryBackoff = readWebhookDuration("WEBHOOK_RETRY_BACKOFF", 30*time.Second)
+
+	// webhookMaxAttempts is how many times a delivery is attempted before it's dead-lettered.
+	webhookMaxAttempts = func() int {
+		if v := os.Getenv("WEBHOOK_MAX_ATTEMPTS"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				panic(fmt.Sprintf("invalid WEBHOOK_MAX_ATTEMPTS=%q", v))
+			}
+			return n
+		}
+		return 8
+	}()
+
+	webhookDeliveries = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Name: "webhook_deliveries",
+		Help: "Counter of webhook delivery attempts by result",
+	}, []string{"result"})
+)
+
+func readWebhookDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	dur, err := time.ParseDuration(v)
+	if err != nil || dur <= 0 {
+		panic(fmt.Sprintf("invalid %s=%q: %v", name, v, err))
+	}
+	return dur
+}
+
+type WebhookID string
+
+// Webhook is a URL which is sent every Event written for a user, optionally filtered by EventType.
+type Webhook struct {
+	ID  WebhookID `json:"id"`
+	URL string    `json:"url"`
+
+	// EventTypes limits which Events are delivered, all Events are delivered if empty.
+	EventTypes []EventType `json:"eventTypes,omitempty"`
+
+	// Secret signs each delivery and is only returned when the Webhook is created.
+	Secret string `json:"secret,omitempty"`
+
+	Created base.Time `json:"created"`
+	Updated base.Time `json:"updated"`
+
+	// secret is always populated from the database
+	secret string
+}
+
+func (wh *Webhook) validate() error {
+	u, err := url.Parse(wh.URL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook url: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("invalid webhook url: %q", wh.URL)
+	}
+	// Reject hosts which resolve to our own network, deliveries are checked again when they're sent
+	// since DNS can change after the Webhook is created.
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil {
+		return fmt.Errorf("invalid webhook url: %v", err)
+	}
+	for i := range ips {
+		if err := checkWebhookIP(ips[i]); err != nil {
+			return err
+		}
+	}
+	for i := range wh.EventTypes {
+		if err := wh.EventTypes[i].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webhookPrivateNetworks are the private, carrier-grade NAT (100.64.0.0/10) and "this network" (0.0.0.0/8)
+// address ranges webhooks can't be sent to.
+var webhookPrivateNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(fmt.Sprintf("invalid CIDR %s: %v", cidr, err))
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// checkWebhookIP returns an error if ip is a loopback, private, link-local or unspecified address. Webhooks are
+// sent from inside our network, so they can't be allowed to reach internal services.
+func checkWebhookIP(ip net.IP) error {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("webhook address %s is not allowed", ip)
+	}
+	for i := range webhookPrivateNetworks {
+		if webhookPrivateNetworks[i].Contains(ip) {
+			return fmt.Errorf("webhook address %s is not allowed", ip)
+		}
+	}
+	return nil
+}
+
+// newWebhookHTTPClient returns an http.Client which refuses to connect to addresses rejected by checkWebhookIP
+// and doesn't follow redirects.
+func newWebhookHTTPClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		// Control is called with the resolved address, so hostnames can't be pointed at internal services
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("webhook address %q is not an IP", host)
+			}
+			return checkWebhookIP(ip)
+		},
+	}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+		// The redirect response is returned, so it's recorded as a failed attempt
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// matches returns true if events of type t are delivered to this Webhook.
+func (wh *Webhook) matches(t EventType) bool {
+	if len(wh.EventTypes) == 0 {
+		return true
+	}
+	for i := range wh.EventTypes {
+		if wh.EventTypes[i] == t {
+			return true
+		}
+	}
+	return false
+}
+
+type webhookRequest struct {
+	URL        string      `json:"url"`
+	EventTypes []EventType `json:"eventTypes,omitempty"`
+
+	// Secret is optional, a random secret is generated otherwise
+	Secret string `json:"secret,omitempty"`
+}
+
+func (r webhookRequest) missingFields() error {
+	if r.URL == "" {
+		return errors.New("missing url")
+	}
+	return nil
+}
+
+func generateWebhookSecret() (string, error) {
+	bs := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, bs); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bs), nil
+}
+
+// signWebhook returns the signature of body sent at timestamp.
+func signWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type WebhookDeliveryID string
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending    WebhookDeliveryStatus = "pending"
+	WebhookDeliveryDelivered  WebhookDeliveryStatus = "delivered"
+	WebhookDeliveryDeadLetter WebhookDeliveryStatus = "dead_letter"
+)
+
+func (s WebhookDeliveryStatus) validate() error {
+	switch s {
+	case WebhookDeliveryPending, WebhookDeliveryDelivered, WebhookDeliveryDeadLetter:
+		return nil
+	default:
+		return fmt.Errorf("WebhookDeliveryStatus(%s) is invalid", s)
+	}
+}
+
+// WebhookDelivery is an Event sent to a Webhook. Failed deliveries are retried with exponential backoff until
+// WEBHOOK_MAX_ATTEMPTS, then they're dead-lettered and can only be replayed.
+type WebhookDelivery struct {
+	ID        WebhookDeliveryID     `json:"id"`
+	WebhookID WebhookID             `json:"webhookId"`
+	EventID   EventID               `json:"eventId"`
+	EventType EventType             `json:"eventType"`
+	Status    WebhookDeliveryStatus `json:"status"`
+	Attempts  int                   `json:"attempts"`
+
+	// NextAttempt is when a pending delivery will be tried again
+	NextAttempt *base.Time `json:"nextAttempt,omitempty"`
+
+	// Log is every attempt made, only included when reading a single delivery
+	Log []*WebhookAttempt `json:"log,omitempty"`
+
+	Created base.Time `json:"created"`
+	Updated base.Time `json:"updated"`
+
+	// payload is the exact body sent on each attempt
+	payload []byte
+	userID  string
+}
+
+// WebhookAttempt records one POST of a WebhookDelivery.
+type WebhookAttempt struct {
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Attempted  base.Time `json:"attempted"`
+}
+
+// webhookPayload is the JSON body of each delivery
+type webhookPayload struct {
+	WebhookID WebhookID `json:"webhookId"`
+	Event     *Event    `json:"event"`
+	Created   base.Time `json:"created"`
+}
+
+// webhookBackoff returns how long to wait after attempts failures.
+func webhookBackoff(attempts int) time.Duration {
+	if attempts <= 1 {
+		return webhookRetryBackoff
+	}
+	if attempts > 16 {
+		attempts = 16 // avoid overflow
+	}
+	return webhookRetryBackoff * time.Duration(1<<uint(attempts-1))
+}
+
+// WebhookDispatcher delivers pending WebhookDelivery records, retrying failures with exponential backoff.
+type WebhookDispatcher struct {
+	logger log.Logger
+	repo   webhookRepository
+
+	httpClient *http.Client
+}
+
+func NewWebhookDispatcher(logger log.Logger, repo webhookRepository, httpClient *http.Client) *WebhookDispatcher {
+	if httpClient == nil {
+		httpClient = newWebhookHTTPClient()
+	}
+	return &WebhookDispatcher{
+		logger:     logger,
+		repo:       repo,
+		httpClient: httpClient,
+	}
+}
+
+// Start delivers pending webhooks every WEBHOOK_DELIVERY_INTERVAL until ctx is finished.
+func (d *WebhookDispatcher) Start(ctx context.Context) {
+	d.logger.Log("webhooks", fmt.Sprintf("delivering webhooks every %v", webhookDeliveryInterval))
+
+	tick := time.NewTicker(webhookDeliveryInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			if err := d.deliverPending(); err != nil {
+				d.logger.Log("webhooks", fmt.Sprintf("ERROR: delivering webhooks: %v", err))
+			}
+
+		case <-ctx.Done():
+			d.logger.Log("webhooks", "Shutting down due to context.Done()")
+			return
+		}
+	}
+}
+
+// deliverPending attempts every delivery which is due. Each delivery is claimed first so multiple
+// paygate instances don't send it twice. Problems with one delivery are logged so the others are still sent.
+func (d *WebhookDispatcher) deliverPending() error {
+	now := time.Now()
+	deliveries, err := d.repo.getDueDeliveries(now, 100)
+	if err != nil {
+		return err
+	}
+	for i := range deliveries {
+		claimed, err := d.repo.claimDelivery(deliveries[i].ID, now, time.Now().Add(time.Minute))
+		if err != nil {
+			d.logger.Log("webhooks", fmt.Sprintf("ERROR: claiming delivery=%s: %v", deliveries[i].ID, err), "userID", deliveries[i].userID)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		if err := d.deliver(deliveries[i]); err != nil {
+			d.logger.Log("webhooks", fmt.Sprintf("ERROR: delivery=%s: %v", deliveries[i].ID, err), "userID", deliveries[i].userID)
+		}
+	}
+	return nil
+}
+
+// deliver makes one attempt of delivery and records the result.
+func (d *WebhookDispatcher) deliver(delivery *WebhookDelivery) error {
+	attempt := &WebhookAttempt{
+		Attempt:   delivery.Attempts + 1,
+		Attempted: base.NewTime(time.Now()),
+	}
+	webhook, err := d.repo.getUserWebhook(delivery.WebhookID, delivery.userID)
+	if err != nil {
+		return err
+	}
+	if webhook == nil {
+		attempt.Error = "webhook was deleted"
+		return d.repo.recordAttempt(delivery, attempt, WebhookDeliveryDeadLetter, nil)
+	}
+
+	attempt.StatusCode, err = d.post(webhook, delivery)
+	if err == nil && (attempt.StatusCode < 200 || attempt.StatusCode > 299) {
+		err = fmt.Errorf("unexpected HTTP status: %d", attempt.StatusCode)
+	}
+	if err == nil {
+		webhookDeliveries.With("result", "delivered").Add(1)
+		return d.repo.recordAttempt(delivery, attempt, WebhookDeliveryDelivered, nil)
+	}
+	attempt.Error = err.Error()
+
+	if attempt.Attempt >= webhookMaxAttempts {
+		webhookDeliveries.With("result", "dead_letter").Add(1)
+		d.logger.Log("webhooks", fmt.Sprintf("dead-lettering delivery=%s after %d attempts: %v", delivery.ID, attempt.Attempt, err), "userID", delivery.userID)
+		return d.repo.recordAttempt(delivery, attempt, WebhookDeliveryDeadLetter, nil)
+	}
+	webhookDeliveries.With("result", "failed").Add(1)
+	next := time.Now().Add(webhookBackoff(attempt.Attempt))
+	return d.repo.recordAttempt(delivery, attempt, WebhookDeliveryPending, &next)
+}
+
+func (d *WebhookDispatcher) post(webhook *Webhook, delivery *WebhookDelivery) (int, error) {
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(delivery.payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, signWebhook(webhook.secret, timestamp, delivery.payload))
+	req.Header.Set(webhookDeliveryHeader, string(delivery.ID))
+	req.Header.Set(webhookEventHeader, string(delivery.EventType))
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1024*1024))
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func AddWebhookRoutes(logger log.Logger, r *mux.Router, webhookRepo webhookRepository) {
+	r.Methods("GET").Path("/webhooks").HandlerFunc(getUserWebhooks(logger, webhookRepo))
+	r.Methods("POST").Path("/webhooks").HandlerFunc(createUserWebhook(logger, webhookRepo))
+
+	r.Methods("GET").Path("/webhooks/{webhookId}").HandlerFunc(getUserWebhook(logger, webhookRepo))
+	r.Methods("DELETE").Path("/webhooks/{webhookId}").HandlerFunc(deleteUserWebhook(logger, webhookRepo))
+
+	r.Methods("GET").Path("/webhooks/{webhookId}/deliveries").HandlerFunc(getWebhookDeliveries(logger, webhookRepo))
+	r.Methods("GET").Path("/webhooks/{webhookId}/deliveries/{deliveryId}").HandlerFunc(getWebhookDelivery(logger, webhookRepo))
+	r.Methods("POST").Path("/webhooks/{webhookId}/deliveries/{deliveryId}/replay").HandlerFunc(replayWebhookDelivery(logger, webhookRepo))
+}
+
+func getWebhookID(r *http.Request) WebhookID {
+	return WebhookID(mux.Vars(r)["webhookId"])
+}
+
+func getWebhookDeliveryID(r *http.Request) WebhookDeliveryID {
+	return WebhookDeliveryID(mux.Vars(r)["deliveryId"])
+}
+
+func getUserWebhooks(logger log.Logger, webhookRepo webhookRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		requestID, userID := moovhttp.GetRequestID(r), moovhttp.GetUserID(r)
+		webhooks, err := webhookRepo.getUserWebhooks(userID)
+		if err != nil {
+			logger.Log("webhooks", fmt.Sprintf("problem reading user webhooks: %v", err), "requestID", requestID, "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(webhooks)
+	}
+}
+
+func createUserWebhook(logger log.Logger, webhookRepo webhookRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		bs, err := read(r.Body)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		var req webhookRequest
+		if err := json.Unmarshal(bs, &req); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if err := req.missingFields(); err != nil {
+			moovhttp.Problem(w, fmt.Errorf("%v: %v", errMissingRequiredJson, err))
+			return
+		}
+
+		userID, requestID := moovhttp.GetUserID(r), moovhttp.GetRequestID(r)
+		webhook := &Webhook{
+			ID:         WebhookID(base.ID()),
+			URL:        req.URL,
+			EventTypes: req.EventTypes,
+			Secret:     req.Secret,
+			Created:    base.NewTime(time.Now()),
+		}
+		webhook.Updated = webhook.Created
+		if webhook.Secret == "" {
+			if webhook.Secret, err = generateWebhookSecret(); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+		}
+		webhook.secret = webhook.Secret
+		if err := webhook.validate(); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if err := webhookRepo.createUserWebhook(userID, webhook); err != nil {
+			logger.Log("webhooks", fmt.Sprintf("problem creating webhook: %v", err), "requestID", requestID, "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		logger.Log("webhooks", fmt.Sprintf("created webhook=%s for %s", webhook.ID, webhook.URL), "requestID", requestID, "userID", userID)
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(webhook)
+	}
+}
+
+func getUserWebhook(logger log.Logger, webhookRepo webhookRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		id, userID := getWebhookID(r), moovhttp.GetUserID(r)
+		webhook, err := webhookRepo.getUserWebhook(id, userID)
+		if err != nil {
+			logger.Log("webhooks", fmt.Sprintf("problem reading webhook=%s: %v", id, err), "requestID", moovhttp.GetRequestID(r), "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		if webhook == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(webhook)
+	}
+}
+
+func deleteUserWebhook(logger log.Logger, webhookRepo webhookRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		id, userID := getWebhookID(r), moovhttp.GetUserID(r)
+		if err := webhookRepo.deleteUserWebhook(id, userID); err != nil {
+			logger.Log("webhooks", fmt.Sprintf("problem deleting webhook=%s: %v", id, err), "requestID", moovhttp.GetRequestID(r), "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func getWebhookDeliveries(logger log.Logger, webhookRepo webhookRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		var status WebhookDeliveryStatus
+		if v := r.URL.Query().Get("status"); v != "" {
+			status = WebhookDeliveryStatus(strings.ToLower(v))
+			if err := status.validate(); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+		}
+		limit := 100
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				moovhttp.Problem(w, fmt.Errorf("invalid limit: %q", v))
+				return
+			}
+			limit = n
+		}
+
+		id, userID := getWebhookID(r), moovhttp.GetUserID(r)
+		deliveries, err := webhookRepo.getDeliveries(id, userID, status, limit)
+		if err != nil {
+			logger.Log("webhooks", fmt.Sprintf("problem reading webhook=%s deliveries: %v", id, err), "requestID", moovhttp.GetRequestID(r), "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(deliveries)
+	}
+}
+
+func getWebhookDelivery(logger log.Logger, webhookRepo webhookRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		id, userID := getWebhookDeliveryID(r), moovhttp.GetUserID(r)
+		delivery, err := webhookRepo.getDelivery(getWebhookID(r), id, userID)
+		if err != nil {
+			logger.Log("webhooks", fmt.Sprintf("problem reading delivery=%s: %v", id, err), "requestID", moovhttp.GetRequestID(r), "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		if delivery == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(delivery)
+	}
+}
+
+// replayWebhookDelivery sends a delivery again (whatever its status) with a full set of attempts. The delivery log is kept.
+func replayWebhookDelivery(logger log.Logger, webhookRepo webhookRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		webhookID, id, userID := getWebhookID(r), getWebhookDeliveryID(r), moovhttp.GetUserID(r)
+		requestID := moovhttp.GetRequestID(r)
+		delivery, err := webhookRepo.getDelivery(webhookID, id, userID)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if delivery == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err := webhookRepo.replayDelivery(delivery.ID); err != nil {
+			logger.Log("webhooks", fmt.Sprintf("problem replaying delivery=%s: %v", id, err), "requestID", requestID, "userID", userID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		logger.Log("webhooks", fmt.Sprintf("replaying delivery=%s (was %s)", id, delivery.Status), "requestID", requestID, "userID", userID)
+
+		if delivery, err = webhookRepo.getDelivery(webhookID, id, userID); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(delivery)
+	}
+}
+
+type webhookRepository interface {
+	getUserWebhooks(userID string) ([]*Webhook, error)
+	getUserWebhook(id WebhookID, userID string) (*Webhook, error)
+	createUserWebhook(userID string, webhook *Webhook) error
+	deleteUserWebhook(id WebhookID, userID string) error
+
+	// enqueueDeliveries creates a pending delivery of event for each of the user's Webhooks which matches it.
+	// Deliveries are created in tx, so they're only queued if the Event is written.
+	enqueueDeliveries(tx *sql.Tx, userID string, event *Event) error
+
+	getDeliveries(webhookID WebhookID, userID string, status WebhookDeliveryStatus, limit int) ([]*WebhookDelivery, error)
+	getDelivery(webhookID WebhookID, id WebhookDeliveryID, userID string) (*WebhookDelivery, error)
+
+	// getDueDeliveries returns pending deliveries whose next attempt is at or before now.
+	getDueDeliveries(now time.Time, limit int) ([]*WebhookDelivery, error)
+
+	// claimDelivery pushes back the next attempt of a due delivery to until, so no one else claims it while it's sent.
+	claimDelivery(id WebhookDeliveryID, now, until time.Time) (bool, error)
+
+	// recordAttempt logs attempt and updates the delivery's status and next attempt.
+	recordAttempt(delivery *WebhookDelivery, attempt *WebhookAttempt, status WebhookDeliveryStatus, next *time.Time) error
+
+	// replayDelivery marks a delivery as pending with no attempts, so it's sent again immediately.
+	replayDelivery(id WebhookDeliveryID) error
+}
+
+func NewWebhookRepo(logger log.Logger, db *sql.DB) *SQLWebhookRepo {
+	return &SQLWebhookRepo{log: logger, db: db}
+}
+
+type SQLWebhookRepo struct {
+	db  *sql.DB
+	log log.Logger
+}
+
+func (r *SQLWebhookRepo) Close() error {
+	return r.db.Close()
+}
+
+func (r *SQLWebhookRepo) getUserWebhooks(userID string) ([]*Webhook, error) {
+	query := `select webhook_id from webhooks where user_id = ? and deleted_at is null order by created_at asc;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []WebhookID
+	for rows.Next() {
+		var id WebhookID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("getUserWebhooks: scan: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var webhooks []*Webhook
+	for i := range ids {
+		webhook, err := r.getUserWebhook(ids[i], userID)
+		if err == nil && webhook != nil {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	return webhooks, nil
+}
+
+func (r *SQLWebhookRepo) getUserWebhook(id WebhookID, userID string) (*Webhook, error) {
+	query := `select webhook_id, url, event_types, encrypted_secret, created_at, last_updated_at from webhooks
+where webhook_id = ? and user_id = ? and deleted_at is null limit 1;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var (
+		webhook          Webhook
+		eventTypes       string
+		encryptedSecret  string
+		created, updated time.Time
+	)
+	err = stmt.QueryRow(id, userID).Scan(&webhook.ID, &webhook.URL, &eventTypes, &encryptedSecret, &created, &updated)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if eventTypes != "" {
+		for _, t := range strings.Split(eventTypes, ",") {
+			webhook.EventTypes = append(webhook.EventTypes, EventType(t))
+		}
+	}
+	if webhook.secret, err = accountNumberKeeper.Decrypt(encryptedSecret); err != nil {
+		return nil, fmt.Errorf("webhook=%s: problem decrypting secret: %v", id, err)
+	}
+	webhook.Created, webhook.Updated = base.NewTime(created), base.NewTime(updated)
+	return &webhook, nil
+}
+
+func (r *SQLWebhookRepo) createUserWebhook(userID string, webhook *Webhook) error {
+	encrypted, err := accountNumberKeeper.Encrypt(webhook.secret)
+	if err != nil {
+		return fmt.Errorf("problem encrypting webhook secret: %v", err)
+	}
+	var eventTypes []string
+	for i := range webhook.EventTypes {
+		eventTypes = append(eventTypes, string(webhook.EventTypes[i]))
+	}
+
+	query := `insert into webhooks (webhook_id, user_id, url, event_types, encrypted_secret, created_at, last_updated_at) values (?, ?, ?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(webhook.ID, userID, webhook.URL, strings.Join(eventTypes, ","), encrypted, webhook.Created.Time, webhook.Updated.Time)
+	return err
+}
+
+func (r *SQLWebhookRepo) deleteUserWebhook(id WebhookID, userID string) error {
+	query := `update webhooks set deleted_at = ? where webhook_id = ? and user_id = ? and deleted_at is null;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(time.Now(), id, userID)
+	return err
+}
+
+func (r *SQLWebhookRepo) enqueueDeliveries(tx *sql.Tx, userID string, event *Event) error {
+	// Only the ID and EventTypes are needed, so secrets aren't decrypted
+	query := `select webhook_id, event_types from webhooks where user_id = ? and deleted_at is null order by created_at asc;`
+	rows, err := tx.Query(query, userID)
+	if err != nil {
+		return err
+	}
+	var webhooks []*Webhook
+	for rows.Next() {
+		var (
+			webhook    Webhook
+			eventTypes string
+		)
+		if err := rows.Scan(&webhook.ID, &eventTypes); err != nil {
+			rows.Close()
+			return fmt.Errorf("enqueueDeliveries: scan: %v", err)
+		}
+		if eventTypes != "" {
+			for _, t := range strings.Split(eventTypes, ",") {
+				webhook.EventTypes = append(webhook.EventTypes, EventType(t))
+			}
+		}
+		webhooks = append(webhooks, &webhook)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	query = `insert into webhook_deliveries (delivery_id, webhook_id, user_id, event_id, event_type, payload, status, attempts, next_attempt_at, created_at, last_updated_at)
+values (?, ?, ?, ?, ?, ?, ?, 0, ?, ?, ?);`
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	for i := range webhooks {
+		if !webhooks[i].matches(event.Type) {
+			continue
+		}
+		payload, err := json.Marshal(webhookPayload{
+			WebhookID: webhooks[i].ID,
+			Event:     event,
+			Created:   base.NewTime(now),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = stmt.Exec(base.ID(), webhooks[i].ID, userID, event.ID, event.Type, string(payload), WebhookDeliveryPending, now, now, now)
+		if err != nil {
+			return fmt.Errorf("webhook=%s: %v", webhooks[i].ID, err)
+		}
+	}
+	return nil
+}
+
+const webhookDeliveryColumns = `delivery_id, webhook_id, user_id, event_id, event_type, payload, status, attempts, next_attempt_at, created_at, last_updated_at`
+
+func scanWebhookDelivery(row interface{ Scan(...interface{}) error }) (*WebhookDelivery, error) {
+	var (
+		delivery         WebhookDelivery
+		payload          string
+		next             *time.Time
+		created, updated time.Time
+	)
+	err := row.Scan(&delivery.ID, &delivery.WebhookID, &delivery.userID, &delivery.EventID, &delivery.EventType, &payload, &delivery.Status, &delivery.Attempts, &next, &created, &updated)
+	if err != nil {
+		return nil, err
+	}
+	delivery.payload = []byte(payload)
+	if next != nil && delivery.Status == WebhookDeliveryPending {
+		t := base.NewTime(*next)
+		delivery.NextAttempt = &t
+	}
+	delivery.Created, delivery.Updated = base.NewTime(created), base.NewTime(updated)
+	return &delivery, nil
+}
+
+func (r *SQLWebhookRepo) queryDeliveries(query string, args ...interface{}) ([]*WebhookDelivery, error) {
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*WebhookDelivery
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("queryDeliveries: scan: %v", err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func (r *SQLWebhookRepo) getDeliveries(webhookID WebhookID, userID string, status WebhookDeliveryStatus, limit int) ([]*WebhookDelivery, error) {
+	if status == "" {
+		query := `select ` + webhookDeliveryColumns + ` from webhook_deliveries where webhook_id = ? and user_id = ? order by created_at desc limit ?;`
+		return r.queryDeliveries(query, webhookID, userID, limit)
+	}
+	query := `select ` + webhookDeliveryColumns + ` from webhook_deliveries where webhook_id = ? and user_id = ? and status = ? order by created_at desc limit ?;`
+	return r.queryDeliveries(query, webhookID, userID, status, limit)
+}
+
+func (r *SQLWebhookRepo) getDelivery(webhookID WebhookID, id WebhookDeliveryID, userID string) (*WebhookDelivery, error) {
+	query := `select ` + webhookDeliveryColumns + ` from webhook_deliveries where delivery_id = ? and webhook_id = ? and user_id = ? limit 1;`
+	deliveries, err := r.queryDeliveries(query, id, webhookID, userID)
+	if err != nil || len(deliveries) == 0 {
+		return nil, err
+	}
+	delivery := deliveries[0]
+
+	query = `select attempt, status_code, error, attempted_at from webhook_delivery_attempts where delivery_id = ? order by attempted_at asc;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			attempt   WebhookAttempt
+			errMsg    *string
+			attempted time.Time
+		)
+		if err := rows.Scan(&attempt.Attempt, &attempt.StatusCode, &errMsg, &attempted); err != nil {
+			return nil, fmt.Errorf("getDelivery: scan: %v", err)
+		}
+		if errMsg != nil {
+			attempt.Error = *errMsg
+		}
+		attempt.Attempted = base.NewTime(attempted)
+		delivery.Log = append(delivery.Log, &attempt)
+	}
+	return delivery, rows.Err()
+}
+
+func (r *SQLWebhookRepo) getDueDeliveries(now time.Time, limit int) ([]*WebhookDelivery, error) {
+	query := `select ` + webhookDeliveryColumns + ` from webhook_deliveries where status = ? and next_attempt_at <= ? order by next_attempt_at asc limit ?;`
+	return r.queryDeliveries(query, WebhookDeliveryPending, now, limit)
+}
+
+func (r *SQLWebhookRepo) claimDelivery(id WebhookDeliveryID, now, until time.Time) (bool, error) {
+	query := `update webhook_deliveries set next_attempt_at = ? where delivery_id = ? and status = ? and next_attempt_at <= ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(until, id, WebhookDeliveryPending, now)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+func (r *SQLWebhookRepo) recordAttempt(delivery *WebhookDelivery, attempt *WebhookAttempt, status WebhookDeliveryStatus, next *time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	query := `insert into webhook_delivery_attempts (delivery_id, attempt, status_code, error, attempted_at) values (?, ?, ?, ?, ?);`
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("recordAttempt: prepare: %v: rollback=%v", err, tx.Rollback())
+	}
+	_, err = stmt.Exec(delivery.ID, attempt.Attempt, attempt.StatusCode, attempt.Error, attempt.Attempted.Time)
+	stmt.Close()
+	if err != nil {
+		return fmt.Errorf("recordAttempt: insert: %v: rollback=%v", err, tx.Rollback())
+	}
+
+	query = `update webhook_deliveries set status = ?, attempts = ?, next_attempt_at = ?, last_updated_at = ? where delivery_id = ?;`
+	stmt, err = tx.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("recordAttempt: prepare: %v: rollback=%v", err, tx.Rollback())
+	}
+	_, err = stmt.Exec(status, attempt.Attempt, next, time.Now(), delivery.ID)
+	stmt.Close()
+	if err != nil {
+		return fmt.Errorf("recordAttempt: update: %v: rollback=%v", err, tx.Rollback())
+	}
+	return tx.Commit()
+}
+
+func (r *SQLWebhookRepo) replayDelivery(id WebhookDeliveryID) error {
+	query := `update webhook_deliveries set status = ?, attempts = 0, next_attempt_at = ?, last_updated_at = ? where delivery_id = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	_, err = stmt.Exec(WebhookDeliveryPending, now, now, id)
+	return err
+}
+
+// rotateEncryptionKeys re-wraps every webhook secret with the primary key-encryption key.
+func (r *SQLWebhookRepo) rotateEncryptionKeys() (int, error) {
+	query := `select webhook_id, encrypted_secret from webhooks`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	updates := make(map[string]string) // webhook_id -> encrypted_secret
+	for rows.Next() {
+		var id, encrypted string
+		if err := rows.Scan(&id, &encrypted); err != nil {
+			return 0, err
+		}
+		rewrapped, changed, err := accountNumberKeeper.Rewrap(encrypted)
+		if err != nil {
+			return 0, fmt.Errorf("webhook=%s: %v", id, err)
+		}
+		if changed {
+			updates[id] = rewrapped
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	update, err := r.db.Prepare(`update webhooks set encrypted_secret = ? where webhook_id = ?`)
+	if err != nil {
+		return 0, err
+	}
+	defer update.Close()
+
+	for id, encrypted := range updates {
+		if _, err := update.Exec(encrypted, id); err != nil {
+			return 0, fmt.Errorf("webhook=%s: %v", id, err)
+		}
+	}
+	return len(updates), nil
+}
@@ -0,0 +1,481 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package paygate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/moov-io/base"
+	"github.com/moov-io/paygate/internal/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// webhookReceiver is an httptest server which verifies signatures and replies with the queued status codes.
+type webhookReceiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	secret   string
+	statuses []int
+	received []webhookPayload
+	invalid  int
+}
+
+func newWebhookReceiver(t *testing.T, secret string) *webhookReceiver {
+	recv := &webhookReceiver{secret: secret}
+	recv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recv.mu.Lock()
+		defer recv.mu.Unlock()
+
+		bs, _ := ioutil.ReadAll(r.Body)
+		timestamp := r.Header.Get(webhookTimestampHeader)
+		if timestamp == "" || r.Header.Get(webhookSignatureHeader) != signWebhook(recv.secret, timestamp, bs) {
+			recv.invalid++
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get(webhookDeliveryHeader) == "" || r.Header.Get(webhookEventHeader) == "" {
+			recv.invalid++
+		}
+		var payload webhookPayload
+		if err := json.Unmarshal(bs, &payload); err != nil {
+			t.Errorf("invalid payload: %v", err)
+		}
+		recv.received = append(recv.received, payload)
+
+		status := http.StatusOK
+		if len(recv.statuses) > 0 {
+			status, recv.statuses = recv.statuses[0], recv.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	return recv
+}
+
+// enqueueTestDeliveries queues deliveries of event without writing the Event.
+func enqueueTestDeliveries(repo *SQLWebhookRepo, userID string, event *Event) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := repo.enqueueDeliveries(tx, userID, event); err != nil {
+		return fmt.Errorf("%v: rollback=%v", err, tx.Rollback())
+	}
+	return tx.Commit()
+}
+
+func TestWebhooks__validate(t *testing.T) {
+	wh := &Webhook{URL: "https://203.0.113.10/hooks", EventTypes: []EventType{TransferEvent}}
+	if err := wh.validate(); err != nil {
+		t.Error(err)
+	}
+	if !wh.matches(TransferEvent) || wh.matches(ReceiverEvent) {
+		t.Error("unexpected event type filter")
+	}
+	wh.EventTypes = []EventType{"other"}
+	if err := wh.validate(); err == nil {
+		t.Error("expected error")
+	}
+	for _, u := range []string{"", "ftp://example.com", "http://", "example.com/hooks"} {
+		wh := &Webhook{URL: u}
+		if err := wh.validate(); err == nil {
+			t.Errorf("%q: expected error", u)
+		}
+	}
+
+	// internal addresses are rejected
+	for _, u := range []string{"http://localhost/hooks", "http://127.0.0.1:8080", "http://10.1.2.3", "https://172.16.0.1", "http://192.168.1.1", "http://169.254.169.254/latest/meta-data", "http://0.0.0.0", "http://0.1.2.3", "http://100.64.0.1", "http://100.127.255.254", "http://[::1]/hooks", "http://[fd00::1]", "http://[::ffff:127.0.0.1]"} {
+		wh := &Webhook{URL: u}
+		if err := wh.validate(); err == nil || !strings.Contains(err.Error(), "not allowed") {
+			t.Errorf("%q: expected error, got %v", u, err)
+		}
+	}
+}
+
+func TestWebhooks__httpClient(t *testing.T) {
+	recv := newWebhookReceiver(t, "secret")
+	defer recv.Close()
+
+	// the receiver listens on loopback, so it's refused when dialing
+	client := newWebhookHTTPClient()
+	if resp, err := client.Post(recv.URL, "application/json", strings.NewReader("{}")); err == nil {
+		resp.Body.Close()
+		t.Fatal("expected error")
+	} else if !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	recv.mu.Lock()
+	if len(recv.received) != 0 || recv.invalid != 0 {
+		t.Errorf("received=%d invalid=%d", len(recv.received), recv.invalid)
+	}
+	recv.mu.Unlock()
+
+	// redirects aren't followed
+	req := httptest.NewRequest("GET", "http://203.0.113.10/hooks", nil)
+	if err := client.CheckRedirect(req, []*http.Request{req}); err != http.ErrUseLastResponse {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestWebhooks__backoff(t *testing.T) {
+	if d := webhookBackoff(1); d != webhookRetryBackoff {
+		t.Errorf("got %v", d)
+	}
+	if d := webhookBackoff(4); d != 8*webhookRetryBackoff {
+		t.Errorf("got %v", d)
+	}
+	if d := webhookBackoff(100); d <= 0 {
+		t.Errorf("got %v", d)
+	}
+}
+
+func TestWebhooks__repository(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *SQLWebhookRepo) {
+		userID := base.ID()
+		webhook := &Webhook{
+			ID:         WebhookID(base.ID()),
+			URL:        "https://example.com/hooks",
+			EventTypes: []EventType{TransferEvent, ReceiverEvent},
+			Created:    base.NewTime(time.Now()),
+			secret:     "secret",
+		}
+		if err := repo.createUserWebhook(userID, webhook); err != nil {
+			t.Fatal(err)
+		}
+		found, err := repo.getUserWebhook(webhook.ID, userID)
+		if err != nil || found == nil {
+			t.Fatalf("webhook=%#v error=%v", found, err)
+		}
+		if found.secret != "secret" || found.Secret != "" || len(found.EventTypes) != 2 {
+			t.Errorf("unexpected webhook: %#v", found)
+		}
+		if found, err := repo.getUserWebhook(webhook.ID, base.ID()); err != nil || found != nil {
+			t.Errorf("other user: webhook=%#v error=%v", found, err)
+		}
+
+		// only matching events are queued
+		if err := enqueueTestDeliveries(repo, userID, &Event{ID: EventID(base.ID()), Type: DepositoryEvent}); err != nil {
+			t.Fatal(err)
+		}
+		event := &Event{ID: EventID(base.ID()), Topic: "transfer", Type: TransferEvent}
+		if err := enqueueTestDeliveries(repo, userID, event); err != nil {
+			t.Fatal(err)
+		}
+		deliveries, err := repo.getDeliveries(webhook.ID, userID, "", 10)
+		if err != nil || len(deliveries) != 1 {
+			t.Fatalf("deliveries=%#v error=%v", deliveries, err)
+		}
+		delivery := deliveries[0]
+		if delivery.EventID != event.ID || delivery.Status != WebhookDeliveryPending || delivery.NextAttempt == nil {
+			t.Errorf("unexpected delivery: %#v", delivery)
+		}
+
+		// claim the delivery, it can't be claimed twice
+		now := time.Now().Add(time.Second)
+		due, err := repo.getDueDeliveries(now, 10)
+		if err != nil || len(due) == 0 {
+			t.Fatalf("due=%#v error=%v", due, err)
+		}
+		if claimed, err := repo.claimDelivery(delivery.ID, now, now.Add(time.Minute)); err != nil || !claimed {
+			t.Fatalf("claimed=%v error=%v", claimed, err)
+		}
+		if claimed, err := repo.claimDelivery(delivery.ID, now, now.Add(time.Minute)); err != nil || claimed {
+			t.Errorf("claimed=%v error=%v", claimed, err)
+		}
+
+		attempt := &WebhookAttempt{Attempt: 1, StatusCode: 500, Error: "boom", Attempted: base.NewTime(time.Now())}
+		if err := repo.recordAttempt(delivery, attempt, WebhookDeliveryDeadLetter, nil); err != nil {
+			t.Fatal(err)
+		}
+		delivery, err = repo.getDelivery(webhook.ID, delivery.ID, userID)
+		if err != nil || delivery == nil {
+			t.Fatalf("delivery=%#v error=%v", delivery, err)
+		}
+		if delivery.Status != WebhookDeliveryDeadLetter || delivery.Attempts != 1 || len(delivery.Log) != 1 || delivery.Log[0].Error != "boom" {
+			t.Errorf("unexpected delivery: %#v", delivery)
+		}
+		if deliveries, err := repo.getDeliveries(webhook.ID, userID, WebhookDeliveryPending, 10); err != nil || len(deliveries) != 0 {
+			t.Errorf("deliveries=%#v error=%v", deliveries, err)
+		}
+
+		if err := repo.replayDelivery(delivery.ID); err != nil {
+			t.Fatal(err)
+		}
+		delivery, _ = repo.getDelivery(webhook.ID, delivery.ID, userID)
+		if delivery.Status != WebhookDeliveryPending || delivery.Attempts != 0 || len(delivery.Log) != 1 {
+			t.Errorf("unexpected delivery: %#v", delivery)
+		}
+
+		if n, err := repo.rotateEncryptionKeys(); err != nil || n != 0 {
+			t.Errorf("rotated=%d error=%v", n, err)
+		}
+
+		if err := repo.deleteUserWebhook(webhook.ID, userID); err != nil {
+			t.Fatal(err)
+		}
+		if webhooks, err := repo.getUserWebhooks(userID); err != nil || len(webhooks) != 0 {
+			t.Errorf("webhooks=%#v error=%v", webhooks, err)
+		}
+	}
+
+	// SQLite tests
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, NewWebhookRepo(log.NewNopLogger(), sqliteDB.DB))
+
+	// MySQL tests
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, NewWebhookRepo(log.NewNopLogger(), mysqlDB.DB))
+}
+
+func TestWebhooks__delivery(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	logger := log.NewNopLogger()
+	repo := NewWebhookRepo(logger, db.DB)
+	eventRepo := &SQLEventRepo{db.DB, logger, repo}
+
+	userID := base.ID()
+	recv := newWebhookReceiver(t, "secret")
+	defer recv.Close()
+	dispatcher := NewWebhookDispatcher(logger, repo, recv.Client())
+	recv.statuses = []int{http.StatusInternalServerError}
+
+	webhook := &Webhook{
+		ID:         WebhookID(base.ID()),
+		URL:        recv.URL,
+		EventTypes: []EventType{TransferEvent},
+		Created:    base.NewTime(time.Now()),
+		secret:     "secret",
+	}
+	if err := repo.createUserWebhook(userID, webhook); err != nil {
+		t.Fatal(err)
+	}
+
+	// writing an event queues a delivery
+	event := &Event{ID: EventID(base.ID()), Topic: "transfer created", Message: "created", Type: TransferEvent}
+	if err := eventRepo.writeEvent(userID, event); err != nil {
+		t.Fatal(err)
+	}
+	if err := eventRepo.writeEvent(userID, &Event{ID: EventID(base.ID()), Type: ReceiverEvent}); err != nil {
+		t.Fatal(err)
+	}
+	// events which fail to be written aren't queued
+	if err := eventRepo.writeEvent(userID, &Event{ID: event.ID, Type: TransferEvent}); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := dispatcher.deliverPending(); err != nil {
+		t.Fatal(err)
+	}
+
+	// first attempt failed, it's retried later
+	deliveries, err := repo.getDeliveries(webhook.ID, userID, "", 10)
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("deliveries=%#v error=%v", deliveries, err)
+	}
+	delivery, _ := repo.getDelivery(webhook.ID, deliveries[0].ID, userID)
+	if delivery.Status != WebhookDeliveryPending || delivery.Attempts != 1 || delivery.NextAttempt == nil || len(delivery.Log) != 1 {
+		t.Fatalf("unexpected delivery: %#v", delivery)
+	}
+	if delivery.Log[0].StatusCode != http.StatusInternalServerError || delivery.Log[0].Error == "" {
+		t.Errorf("unexpected attempt: %#v", delivery.Log[0])
+	}
+	if time.Until(delivery.NextAttempt.Time) < webhookRetryBackoff-time.Minute {
+		t.Errorf("unexpected next attempt: %v", delivery.NextAttempt)
+	}
+	if err := dispatcher.deliverPending(); err != nil { // not due yet
+		t.Fatal(err)
+	}
+
+	// retry succeeds
+	if err := dispatcher.deliver(delivery); err != nil {
+		t.Fatal(err)
+	}
+	delivery, _ = repo.getDelivery(webhook.ID, delivery.ID, userID)
+	if delivery.Status != WebhookDeliveryDelivered || delivery.Attempts != 2 || delivery.NextAttempt != nil || len(delivery.Log) != 2 {
+		t.Errorf("unexpected delivery: %#v", delivery)
+	}
+
+	recv.mu.Lock()
+	if recv.invalid != 0 || len(recv.received) != 2 {
+		t.Errorf("invalid=%d received=%d", recv.invalid, len(recv.received))
+	}
+	if p := recv.received[1]; p.WebhookID != webhook.ID || p.Event == nil || p.Event.ID != event.ID || p.Event.Topic != event.Topic {
+		t.Errorf("unexpected payload: %#v", p)
+	}
+	recv.mu.Unlock()
+}
+
+func TestWebhooks__deadLetter(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	logger := log.NewNopLogger()
+	repo := NewWebhookRepo(logger, db.DB)
+	userID := base.ID()
+	recv := newWebhookReceiver(t, "other") // signatures won't match
+	defer recv.Close()
+	dispatcher := NewWebhookDispatcher(logger, repo, recv.Client())
+
+	webhook := &Webhook{ID: WebhookID(base.ID()), URL: recv.URL, Created: base.NewTime(time.Now()), secret: "secret"}
+	if err := repo.createUserWebhook(userID, webhook); err != nil {
+		t.Fatal(err)
+	}
+	if err := enqueueTestDeliveries(repo, userID, &Event{ID: EventID(base.ID()), Type: DepositoryEvent}); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, _ := repo.getDeliveries(webhook.ID, userID, WebhookDeliveryPending, 10)
+	if len(deliveries) != 1 {
+		t.Fatalf("deliveries=%#v", deliveries)
+	}
+	delivery := deliveries[0]
+	for i := 0; i < webhookMaxAttempts; i++ {
+		if err := dispatcher.deliver(delivery); err != nil {
+			t.Fatal(err)
+		}
+		delivery, _ = repo.getDelivery(webhook.ID, delivery.ID, userID)
+	}
+	if delivery.Status != WebhookDeliveryDeadLetter || delivery.Attempts != webhookMaxAttempts || len(delivery.Log) != webhookMaxAttempts {
+		t.Fatalf("unexpected delivery: %#v", delivery)
+	}
+
+	// replay after the receiver is fixed
+	router := mux.NewRouter()
+	AddWebhookRoutes(logger, router, repo)
+
+	recv.mu.Lock()
+	recv.secret = "secret"
+	recv.mu.Unlock()
+
+	req := httptest.NewRequest("POST", "/webhooks/"+string(webhook.ID)+"/deliveries/"+string(delivery.ID)+"/replay", nil)
+	req.Header.Set("x-user-id", userID)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	w.Flush()
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if err := dispatcher.deliverPending(); err != nil {
+		t.Fatal(err)
+	}
+	delivery, _ = repo.getDelivery(webhook.ID, delivery.ID, userID)
+	if delivery.Status != WebhookDeliveryDelivered || delivery.Attempts != 1 || len(delivery.Log) != webhookMaxAttempts+1 {
+		t.Errorf("unexpected delivery: %#v", delivery)
+	}
+
+	// deliveries to deleted webhooks are dead-lettered
+	if err := enqueueTestDeliveries(repo, userID, &Event{ID: EventID(base.ID()), Type: DepositoryEvent}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.deleteUserWebhook(webhook.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := dispatcher.deliverPending(); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, _ = repo.getDeliveries(webhook.ID, userID, WebhookDeliveryDeadLetter, 10)
+	if len(deliveries) != 1 {
+		t.Errorf("deliveries=%#v", deliveries)
+	}
+}
+
+func TestWebhooks__HTTP(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	logger := log.NewNopLogger()
+	repo := NewWebhookRepo(logger, db.DB)
+
+	router := mux.NewRouter()
+	AddWebhookRoutes(logger, router, repo)
+	userID := base.ID()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("x-user-id", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	if w := do("POST", "/webhooks", `{"url": "ftp://example.com"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := do("POST", "/webhooks", `{"url": "https://203.0.113.10", "eventTypes": ["other"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := do("POST", "/webhooks", `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+
+	if w := do("POST", "/webhooks", `{"url": "http://169.254.169.254/latest/meta-data"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	w := do("POST", "/webhooks", `{"url": "https://203.0.113.10/hooks", "eventTypes": ["Transfer"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var webhook Webhook
+	if err := json.NewDecoder(w.Body).Decode(&webhook); err != nil {
+		t.Fatal(err)
+	}
+	if webhook.ID == "" || len(webhook.Secret) != 64 || len(webhook.EventTypes) != 1 {
+		t.Errorf("unexpected webhook: %#v", webhook)
+	}
+
+	// secret isn't returned after creation
+	w = do("GET", "/webhooks/"+string(webhook.ID), "")
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), webhook.Secret) {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	w = do("GET", "/webhooks", "")
+	var webhooks []*Webhook
+	if err := json.NewDecoder(w.Body).Decode(&webhooks); err != nil || len(webhooks) != 1 {
+		t.Errorf("webhooks=%#v error=%v", webhooks, err)
+	}
+
+	if err := enqueueTestDeliveries(repo, userID, &Event{ID: EventID(base.ID()), Type: TransferEvent}); err != nil {
+		t.Fatal(err)
+	}
+	w = do("GET", "/webhooks/"+string(webhook.ID)+"/deliveries?status=pending", "")
+	var deliveries []*WebhookDelivery
+	if err := json.NewDecoder(w.Body).Decode(&deliveries); err != nil || len(deliveries) != 1 {
+		t.Fatalf("deliveries=%#v error=%v", deliveries, err)
+	}
+	if w := do("GET", "/webhooks/"+string(webhook.ID)+"/deliveries?status=other", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := do("GET", "/webhooks/"+string(webhook.ID)+"/deliveries/"+string(deliveries[0].ID), ""); w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := do("GET", "/webhooks/"+string(webhook.ID)+"/deliveries/foo", ""); w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := do("POST", "/webhooks/"+string(webhook.ID)+"/deliveries/foo/replay", ""); w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+
+	if w := do("DELETE", "/webhooks/"+string(webhook.ID), ""); w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := do("GET", "/webhooks/"+string(webhook.ID), ""); w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}